@@ -0,0 +1,33 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// Hub marks v1alpha1 as the version the other HanaExpress versions are converted through. It
+// is the storage version and the version the controllers work with.
+func (*HanaExpress) Hub() {}
+
+// EffectiveDeletionPolicy returns the deletion policy of the instance, derived from
+// IsDataPersisted for resources that were stored before DeletionPolicy existed
+func (r *HanaExpress) EffectiveDeletionPolicy() DeletionPolicy {
+	switch {
+	case r.Spec.DeletionPolicy != "":
+		return r.Spec.DeletionPolicy
+	case r.Spec.IsDataPersisted:
+		return DeletionPolicyRetain
+	}
+	return DeletionPolicyDelete
+}
@@ -22,14 +22,22 @@ import (
 )
 
 // Credential contains the credential information intended to be used
+// +kubebuilder:validation:XValidation:rule="(has(self.generate) && self.generate) || has(self.secretKeyRef)",message="secretKeyRef is required unless generate is true"
 type Credential struct {
-	// +kubebuilder:validation:Required
-	// SecretKeyRef references a key within a Secret that contains the HANA master password
-	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef"`
+	// +kubebuilder:validation:Optional
+	// SecretKeyRef references a key within a Secret that contains the HANA master password.
+	// It is required unless Generate is set
+	SecretKeyRef corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
 
 	// +kubebuilder:validation:Optional
 	// Format specifies the format of the credential data (json or plain, defaults to plain)
 	Format string `json:"format,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Generate lets the operator create the Secret with a generated master password when it
+	// does not exist. SecretKeyRef defaults to the key password of the Secret
+	// <name>-master-password. Only supported for the credential of a HanaExpress
+	Generate bool `json:"generate,omitempty"`
 }
 
 // HanaExpressSpec defines the desired state of HanaExpress
@@ -50,6 +58,223 @@ type HanaExpressSpec struct {
 	// IsDataPersisted defines the if the Persistent volume attached to the Hana Express StatefulSet
 	// will be preserved after deleting CR Hana Express
 	IsDataPersisted bool `json:"isDataPersisted"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=Retain;Delete;Snapshot
+	// DeletionPolicy decides what happens to the data PVC when the HanaExpress is deleted. It takes
+	// precedence over IsDataPersisted, which must be true exactly when the policy is Retain
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Resources defines the compute resources of the hana-express container. When a memory
+	// limit is set, the operator derives the HANA global_allocation_limit from it so the
+	// database stays inside the memory cgroup of the container
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Version pins the HANA Express version (the image tag) of this instance. The image is taken
+	// from the repository of the operator default image. The version must be listed in the
+	// catalog of supported versions of the operator
+	Version string `json:"version,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Image overrides the full HANA Express image reference of this instance, e.g. to pull from a
+	// mirror registry. The image tag must be listed in the catalog of supported versions of the
+	// operator and must match Version when both are set
+	Image string `json:"image,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// UpgradeTimeout bounds how long an upgrade to a new image may take to come back healthy
+	// before the operator marks it as failed. Defaults to 30m
+	UpgradeTimeout *metav1.Duration `json:"upgradeTimeout,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^\d+Gi$`
+	// BackupPVCSize enables a dedicated backup volume of the given size, mounted at /hana/backup.
+	// HanaExpressBackup resources write their files to this volume
+	BackupPVCSize string `json:"backupPVCSize,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Source provisions the data PVC of a new instance from existing data instead of starting
+	// empty. It is only evaluated when the data PVC is created
+	Source *DataSource `json:"source,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// PasswordRotation rotates the master password on a schedule. The operator writes a generated
+	// password into the Secret referenced by Credential and applies it to the database
+	PasswordRotation *PasswordRotation `json:"passwordRotation,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// ServiceType is the type of the Service exposing the database ports. Defaults to ClusterIP
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ReadinessProbe tunes the readiness probe of the hana-express container on the SQL port of
+	// the HXE tenant
+	ReadinessProbe *ProbeTimings `json:"readinessProbe,omitempty"`
+}
+
+// ProbeTimings configures when and how often a probe runs
+type ProbeTimings struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// InitialDelaySeconds is the delay after the container started before the first probe. Defaults to 10
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// PeriodSeconds is how often the probe runs. Defaults to 5
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// TimeoutSeconds is how long a probe may take. Defaults to 1
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// FailureThreshold is the number of failed probes after which the pod is not ready. Defaults to 3
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// DeletionPolicy decides what happens to the data of a HanaExpress when it is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyRetain keeps the data PVC
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyDelete deletes the data PVC
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicySnapshot stops the database, takes a VolumeSnapshot of the data PVC that
+	// outlives the instance and deletes the PVC afterwards
+	DeletionPolicySnapshot DeletionPolicy = "Snapshot"
+)
+
+// PasswordRotation configures the scheduled rotation of the master password
+type PasswordRotation struct {
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// Schedule is the rotation schedule in Cron format, see https://en.wikipedia.org/wiki/Cron
+	Schedule string `json:"schedule"`
+}
+
+// DataSource selects the data a new instance is cloned from. Exactly one of HanaExpressName,
+// VolumeSnapshotName and BackupName must be set
+type DataSource struct {
+	// +kubebuilder:validation:Optional
+	// HanaExpressName clones the data of another HanaExpress in the same namespace. A running
+	// instance is cloned from a Snapshot backup, otherwise the CSI driver must support volume cloning
+	HanaExpressName string `json:"hanaExpressName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// VolumeSnapshotName provisions the data PVC from a VolumeSnapshot in the same namespace
+	VolumeSnapshotName string `json:"volumeSnapshotName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// BackupName provisions the data PVC from the VolumeSnapshot of a completed HanaExpressBackup
+	// with the Snapshot method in the same namespace
+	BackupName string `json:"backupName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Credential is the master password of the source database, which the operator replaces with
+	// the credential of this instance after the first start. Defaults to the credential of the
+	// source HanaExpress
+	Credential *Credential `json:"credential,omitempty"`
+}
+
+// UpgradePhase is the step an image upgrade of a HanaExpress instance is in
+type UpgradePhase string
+
+const (
+	// UpgradePhaseBackingUp takes the pre-upgrade data backup
+	UpgradePhaseBackingUp UpgradePhase = "BackingUp"
+	// UpgradePhaseUpdatingImage rolls out the new image and waits for HANA to become healthy
+	UpgradePhaseUpdatingImage UpgradePhase = "UpdatingImage"
+	// UpgradePhaseSucceeded means the new image is running and healthy
+	UpgradePhaseSucceeded UpgradePhase = "Succeeded"
+	// UpgradePhaseRollingBack puts the previous image back after the new one did not become
+	// healthy in time, and restores the pre-upgrade backup when HANA started on the new image
+	UpgradePhaseRollingBack UpgradePhase = "RollingBack"
+	// UpgradePhaseRolledBack means the previous image runs again on the data of before the upgrade
+	UpgradePhaseRolledBack UpgradePhase = "RolledBack"
+	// UpgradePhaseFailed means the pre-upgrade backup or the rollback failed. The previous
+	// image is put back.
+	UpgradePhaseFailed UpgradePhase = "Failed"
+)
+
+// UpgradeStatus records the last image upgrade of a HanaExpress instance
+type UpgradeStatus struct {
+	// FromImage is the image running before the upgrade
+	FromImage string `json:"fromImage"`
+
+	// ToImage is the image the upgrade rolls out
+	ToImage string `json:"toImage"`
+
+	// Phase is the current step of the upgrade
+	Phase UpgradePhase `json:"phase"`
+
+	// StartTime is the time the upgrade was started
+	StartTime metav1.Time `json:"startTime"`
+
+	// Backup is the file name prefix of the pre-upgrade data backup. It is recorded before
+	// the backup is started, so that the backup is started only once
+	// +optional
+	Backup string `json:"backup,omitempty"`
+
+	// BackupStartTime is the time the pre-upgrade data backup was started
+	// +optional
+	BackupStartTime *metav1.Time `json:"backupStartTime,omitempty"`
+
+	// BackupDatabases are the databases the pre-upgrade backup covers, the SystemDB first
+	// +optional
+	BackupDatabases []string `json:"backupDatabases,omitempty"`
+
+	// ImageUpdated is set once the new image is rolled out
+	// +optional
+	ImageUpdated bool `json:"imageUpdated,omitempty"`
+
+	// ImageStarted is set once HANA was started on the new image. It may have migrated the
+	// data volume then, so a rollback restores the pre-upgrade backup
+	// +optional
+	ImageStarted bool `json:"imageStarted,omitempty"`
+
+	// RollbackStartTime is the time the rollback was started
+	// +optional
+	RollbackStartTime *metav1.Time `json:"rollbackStartTime,omitempty"`
+
+	// Restores are the HanaExpressRestores of the pre-upgrade backup the rollback created, in
+	// the order they run
+	// +optional
+	Restores []string `json:"restores,omitempty"`
+}
+
+// HanaExpressPhase is the lifecycle phase of a HanaExpress instance
+type HanaExpressPhase string
+
+const (
+	// HanaExpressPhasePending waits for the StatefulSet and its pod to be scheduled
+	HanaExpressPhasePending HanaExpressPhase = "Pending"
+	// HanaExpressPhaseInitializing means the pod runs but the database is not ready yet
+	HanaExpressPhaseInitializing HanaExpressPhase = "Initializing"
+	// HanaExpressPhaseRunning means the database is ready to accept connections
+	HanaExpressPhaseRunning HanaExpressPhase = "Running"
+	// HanaExpressPhaseDegraded means the instance cannot become ready without an intervention
+	HanaExpressPhaseDegraded HanaExpressPhase = "Degraded"
+	// HanaExpressPhaseDeleting runs the deletion policy before the instance is removed
+	HanaExpressPhaseDeleting HanaExpressPhase = "Deleting"
+)
+
+// HanaExpressEndpoints are the in-cluster addresses of the instance
+type HanaExpressEndpoints struct {
+	// SQL is the host:port of the HXE tenant database
+	SQL string `json:"sql,omitempty"`
+
+	// SystemDBSQL is the host:port of the SystemDB
+	SystemDBSQL string `json:"systemDBSQL,omitempty"`
+
+	// XSA is the host:port of XS advanced
+	XSA string `json:"xsa,omitempty"`
 }
 
 // HanaExpressStatus defines the observed state of HanaExpress
@@ -65,10 +290,120 @@ type HanaExpressStatus struct {
 	// Conditions store the status conditions of the HanaExpress instances
 	// +operator-sdk:csv:customresourcedefinitions:type=status
 	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+
+	// Phase is the lifecycle phase of the instance
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase HanaExpressPhase `json:"phase,omitempty"`
+
+	// ObservedGeneration is the generation of the spec the status was last reconciled from
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Endpoints are the in-cluster addresses of the SQL and XSA ports
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Endpoints *HanaExpressEndpoints `json:"endpoints,omitempty"`
+
+	// DatabaseVersion is the version reported by the running database, e.g. 2.00.072.00.1690304772
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	DatabaseVersion string `json:"databaseVersion,omitempty"`
+
+	// PodName is the pod running the database
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	PodName string `json:"podName,omitempty"`
+
+	// PVCName is the PersistentVolumeClaim holding the data of the database
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	PVCName string `json:"pvcName,omitempty"`
+
+	// ReadyTime is when the database last became ready
+	// +optional
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	ReadyTime *metav1.Time `json:"readyTime,omitempty"`
+
+	// GlobalAllocationLimit is the global_allocation_limit in MB last applied to the database.
+	// It is empty while HANA uses its default limit
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	GlobalAllocationLimit string `json:"globalAllocationLimit,omitempty"`
+
+	// Image is the HANA Express image the instance is running
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Image string `json:"image,omitempty"`
+
+	// Version is the HANA Express version the instance is running
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Version string `json:"version,omitempty"`
+
+	// Upgrade records the progress of the last image upgrade
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+
+	// Source records where the data of a cloned instance came from
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Source *DataSourceStatus `json:"source,omitempty"`
+
+	// Credential records the master password applied to the database
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Credential *CredentialStatus `json:"credential,omitempty"`
+
+	// Binding is the Secret with the connection details of the HXE tenant, following the
+	// Service Binding for Kubernetes specification
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Binding *corev1.LocalObjectReference `json:"binding,omitempty"`
+}
+
+// CredentialStatus records the master password applied to the database and its rotations
+type CredentialStatus struct {
+	// PasswordHash is the salted SHA-256 hash of the master password last applied to the
+	// database. A Secret whose password has a different hash is applied with ALTER USER
+	PasswordHash string `json:"passwordHash,omitempty"`
+
+	// LastRotationTime is when a changed master password was last applied to the database
+	// +optional
+	LastRotationTime *metav1.Time `json:"lastRotationTime,omitempty"`
+
+	// LastScheduledRotationTime is when the operator last generated a password for the schedule
+	// +optional
+	LastScheduledRotationTime *metav1.Time `json:"lastScheduledRotationTime,omitempty"`
+
+	// NextScheduledRotationTime is when the operator generates the next password
+	// +optional
+	NextScheduledRotationTime *metav1.Time `json:"nextScheduledRotationTime,omitempty"`
+}
+
+// DataSourceStatus records the source of a cloned instance
+type DataSourceStatus struct {
+	// Kind is the kind of the source: HanaExpress, VolumeSnapshot or HanaExpressBackup
+	Kind string `json:"kind"`
+
+	// Name is the name of the source
+	Name string `json:"name"`
+
+	// DataSource is the object the data PVC was provisioned from
+	DataSource corev1.TypedLocalObjectReference `json:"dataSource"`
+
+	// SourceHanaExpressName is the instance the data originally belonged to, if known
+	SourceHanaExpressName string `json:"sourceHanaExpressName,omitempty"`
+
+	// Time is when the data PVC was requested from the source
+	Time metav1.Time `json:"time"`
+
+	// CredentialsReset is true once the SYSTEM passwords copied from the source were replaced
+	// with the credential of this instance
+	CredentialsReset bool `json:"credentialsReset,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.databaseVersion`
+//+kubebuilder:printcolumn:name="SQL Endpoint",type=string,JSONPath=`.status.endpoints.sql`
+//+kubebuilder:printcolumn:name="Available",type=string,JSONPath=`.status.conditions[?(@.type=="Available")].status`
+//+kubebuilder:printcolumn:name="Pod",type=string,JSONPath=`.status.podName`,priority=1
+//+kubebuilder:printcolumn:name="PVC",type=string,JSONPath=`.status.pvcName`,priority=1
+//+kubebuilder:printcolumn:name="XSA Endpoint",type=string,JSONPath=`.status.endpoints.xsa`,priority=1
+//+kubebuilder:printcolumn:name="Ready Since",type=date,JSONPath=`.status.readyTime`,priority=1
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // HanaExpress is the Schema for the hanaexpresses API
 type HanaExpress struct {
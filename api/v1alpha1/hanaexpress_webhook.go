@@ -0,0 +1,415 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var hanaexpresslog = logf.Log.WithName("hanaexpress-resource")
+
+// minPVCSize is the smallest data volume HANA Express can be installed on. The data and log
+// volumes of a fresh instance already take several gigabytes
+var minPVCSize = resource.MustParse("10Gi")
+
+// DefaultImageEnvVar names the environment variable of the operator with the default HANA
+// Express image, it is set in config/manager/manager.yaml
+const DefaultImageEnvVar = "HANAEXPRESS_IMAGE"
+
+// Defaults of the readiness probe of the hana-express container
+const (
+	DefaultProbeInitialDelaySeconds int32 = 10
+	DefaultProbePeriodSeconds       int32 = 5
+	DefaultProbeTimeoutSeconds      int32 = 1
+	DefaultProbeFailureThreshold    int32 = 3
+)
+
+// defaultResources are the compute resources of new instances that do not set any. They are
+// requests only, a memory limit would also cap the HANA global_allocation_limit
+var defaultResources = corev1.ResourceRequirements{
+	Requests: corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("1"),
+		corev1.ResourceMemory: resource.MustParse("8Gi"),
+	},
+}
+
+// SetupWebhookWithManager registers the webhooks of HanaExpress with the manager
+func (r *HanaExpress) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&hanaExpressDefaulter{}).
+		WithValidator(&hanaExpressValidator{Client: mgr.GetClient()}).
+		Complete()
+}
+
+// SplitImage splits an image reference into its repository and tag. The tag is empty for
+// untagged and digest references.
+func SplitImage(image string) (string, string) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], ""
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+//+kubebuilder:webhook:path=/mutate-db-sap-redhat-io-v1alpha1-hanaexpress,mutating=true,failurePolicy=fail,sideEffects=None,groups=db.sap-redhat.io,resources=hanaexpresses,verbs=create;update,versions=v1alpha1,name=mhanaexpress.kb.io,admissionReviewVersions=v1
+
+// hanaExpressDefaulter writes the effective defaults into the stored object, so the spec shows
+// what runs and a new operator version does not change existing instances behind their back.
+// +kubebuilder:object:generate=false
+type hanaExpressDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &hanaExpressDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *hanaExpressDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	hanaExpress, ok := obj.(*HanaExpress)
+	if !ok {
+		return fmt.Errorf("expected a HanaExpress but got a %T", obj)
+	}
+	hanaexpresslog.Info("default", "name", hanaExpress.Name)
+
+	if hanaExpress.GetDeletionTimestamp() != nil {
+		return nil
+	}
+
+	var old *HanaExpress
+	if req, err := admission.RequestFromContext(ctx); err == nil && req.Operation == admissionv1.Update {
+		old = &HanaExpress{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return fmt.Errorf("failed to decode the old HanaExpress: %w", err)
+		}
+	}
+
+	spec := &hanaExpress.Spec
+	if spec.Credential.Format == "" {
+		spec.Credential.Format = "plain"
+	}
+	if spec.ServiceType == "" {
+		spec.ServiceType = corev1.ServiceTypeClusterIP
+	}
+	if spec.ReadinessProbe == nil {
+		spec.ReadinessProbe = &ProbeTimings{}
+	}
+	if spec.ReadinessProbe.InitialDelaySeconds == 0 {
+		spec.ReadinessProbe.InitialDelaySeconds = DefaultProbeInitialDelaySeconds
+	}
+	if spec.ReadinessProbe.PeriodSeconds == 0 {
+		spec.ReadinessProbe.PeriodSeconds = DefaultProbePeriodSeconds
+	}
+	if spec.ReadinessProbe.TimeoutSeconds == 0 {
+		spec.ReadinessProbe.TimeoutSeconds = DefaultProbeTimeoutSeconds
+	}
+	if spec.ReadinessProbe.FailureThreshold == 0 {
+		spec.ReadinessProbe.FailureThreshold = DefaultProbeFailureThreshold
+	}
+
+	// Existing instances keep running without requests, adding them would restart the pod
+	if old == nil && len(spec.Resources.Requests) == 0 && len(spec.Resources.Limits) == 0 {
+		spec.Resources = *defaultResources.DeepCopy()
+	}
+
+	defaultImage(hanaExpress, old)
+	defaultDeletionPolicy(hanaExpress, old)
+	return nil
+}
+
+// defaultDeletionPolicy materializes spec.deletionPolicy and keeps spec.isDataPersisted in line
+// with it. Changing only one of them updates the other, the validator rejects conflicting changes.
+func defaultDeletionPolicy(hanaExpress, old *HanaExpress) {
+	spec := &hanaExpress.Spec
+	if old == nil && spec.DeletionPolicy == DeletionPolicyRetain {
+		spec.IsDataPersisted = true
+	}
+	if old != nil && old.Spec.DeletionPolicy != "" {
+		policyChanged := spec.DeletionPolicy != old.Spec.DeletionPolicy
+		persistedChanged := spec.IsDataPersisted != old.Spec.IsDataPersisted
+		switch {
+		case persistedChanged && !policyChanged:
+			spec.DeletionPolicy = ""
+		case policyChanged && !persistedChanged && spec.DeletionPolicy != "":
+			spec.IsDataPersisted = spec.DeletionPolicy == DeletionPolicyRetain
+		}
+	}
+
+	if spec.DeletionPolicy == "" {
+		spec.DeletionPolicy = hanaExpress.EffectiveDeletionPolicy()
+	}
+}
+
+// defaultImage materializes spec.image and spec.version. Once both are set, changing only one of
+// them updates the other, so an upgrade can still be requested through either field alone.
+func defaultImage(hanaExpress, old *HanaExpress) {
+	spec := &hanaExpress.Spec
+	if old != nil && old.Spec.Image != "" && old.Spec.Version != "" {
+		versionChanged := spec.Version != old.Spec.Version
+		imageChanged := spec.Image != old.Spec.Image
+		switch {
+		case versionChanged && !imageChanged && spec.Version != "":
+			repository, _ := SplitImage(spec.Image)
+			spec.Image = repository + ":" + spec.Version
+		case imageChanged && !versionChanged && spec.Image != "":
+			_, spec.Version = SplitImage(spec.Image)
+		}
+	}
+
+	// The controller reports a missing default image, the fields are left empty until then
+	operatorImage := os.Getenv(DefaultImageEnvVar)
+	if spec.Image == "" && operatorImage != "" {
+		spec.Image = operatorImage
+		if spec.Version != "" {
+			repository, _ := SplitImage(operatorImage)
+			spec.Image = repository + ":" + spec.Version
+		}
+	}
+	if spec.Version == "" {
+		_, spec.Version = SplitImage(spec.Image)
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-db-sap-redhat-io-v1alpha1-hanaexpress,mutating=false,failurePolicy=fail,sideEffects=None,groups=db.sap-redhat.io,resources=hanaexpresses,verbs=create;update,versions=v1alpha1,name=vhanaexpress.kb.io,admissionReviewVersions=v1
+
+// hanaExpressValidator rejects HanaExpress specs the operator cannot reconcile. It reads the
+// referenced Secrets, so it is a CustomValidator with a client instead of a Validator.
+// +kubebuilder:object:generate=false
+type hanaExpressValidator struct {
+	Client client.Reader
+}
+
+var _ webhook.CustomValidator = &hanaExpressValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *hanaExpressValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	hanaExpress, ok := obj.(*HanaExpress)
+	if !ok {
+		return nil, fmt.Errorf("expected a HanaExpress but got a %T", obj)
+	}
+	hanaexpresslog.Info("validate create", "name", hanaExpress.Name)
+
+	allErrs := validateHanaExpressSpec(hanaExpress, nil)
+	allErrs = append(allErrs, v.validateCredentialSecret(ctx, hanaExpress.Namespace, &hanaExpress.Spec.Credential,
+		field.NewPath("spec", "credential"))...)
+	if source := hanaExpress.Spec.Source; source != nil && source.Credential != nil {
+		allErrs = append(allErrs, v.validateCredentialSecret(ctx, hanaExpress.Namespace, source.Credential,
+			field.NewPath("spec", "source", "credential"))...)
+	}
+	return nil, invalidHanaExpress(hanaExpress, allErrs)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *hanaExpressValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	hanaExpress, ok := newObj.(*HanaExpress)
+	if !ok {
+		return nil, fmt.Errorf("expected a HanaExpress but got a %T", newObj)
+	}
+	old, ok := oldObj.(*HanaExpress)
+	if !ok {
+		return nil, fmt.Errorf("expected a HanaExpress but got a %T", oldObj)
+	}
+	hanaexpresslog.Info("validate update", "name", hanaExpress.Name)
+
+	// The finalizer must always be removable, even when the Secret is already gone
+	if hanaExpress.GetDeletionTimestamp() != nil {
+		return nil, nil
+	}
+
+	allErrs := validateHanaExpressSpec(hanaExpress, old)
+
+	specPath := field.NewPath("spec")
+	allErrs = append(allErrs, v.validateResize(ctx, hanaExpress.Namespace, "data-"+hanaExpress.Name+"-0",
+		old.Spec.PVCSize, hanaExpress.Spec.PVCSize, specPath.Child("pvcSize"))...)
+	if old.Spec.BackupPVCSize != "" && hanaExpress.Spec.BackupPVCSize != "" {
+		allErrs = append(allErrs, v.validateResize(ctx, hanaExpress.Namespace, hanaExpress.Name+"-backup",
+			old.Spec.BackupPVCSize, hanaExpress.Spec.BackupPVCSize, specPath.Child("backupPVCSize"))...)
+	}
+	if !equality.Semantic.DeepEqual(old.Spec.Source, hanaExpress.Spec.Source) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("source"),
+			"source is only used to provision the data PVC of a new instance and cannot be changed"))
+	}
+
+	// Only a changed reference is checked, so unrelated edits still pass while a Secret is being replaced
+	if !equality.Semantic.DeepEqual(old.Spec.Credential.SecretKeyRef, hanaExpress.Spec.Credential.SecretKeyRef) ||
+		old.Spec.Credential.Generate != hanaExpress.Spec.Credential.Generate {
+		allErrs = append(allErrs, v.validateCredentialSecret(ctx, hanaExpress.Namespace, &hanaExpress.Spec.Credential,
+			specPath.Child("credential"))...)
+	}
+	return nil, invalidHanaExpress(hanaExpress, allErrs)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *hanaExpressValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// validateHanaExpressSpec checks the fields that can be validated without the cluster state.
+// On update only changed fields are checked, so instances admitted before the webhook existed
+// can still be updated, e.g. when the operator adds its finalizer.
+func validateHanaExpressSpec(hanaExpress, old *HanaExpress) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if old == nil || old.Spec.Credential.Format != hanaExpress.Spec.Credential.Format {
+		allErrs = append(allErrs, validateCredentialFormat(hanaExpress.Spec.Credential.Format,
+			specPath.Child("credential", "format"))...)
+	}
+	if source := hanaExpress.Spec.Source; old == nil && source != nil && source.Credential != nil {
+		allErrs = append(allErrs, validateCredentialFormat(source.Credential.Format,
+			specPath.Child("source", "credential", "format"))...)
+		if source.Credential.Generate {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("source", "credential", "generate"),
+				"the master password of the source cannot be generated"))
+		}
+	}
+
+	policyChanged := old == nil || old.Spec.DeletionPolicy != hanaExpress.Spec.DeletionPolicy ||
+		old.Spec.IsDataPersisted != hanaExpress.Spec.IsDataPersisted
+	if policyChanged && hanaExpress.Spec.DeletionPolicy != "" &&
+		hanaExpress.Spec.IsDataPersisted != (hanaExpress.Spec.DeletionPolicy == DeletionPolicyRetain) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("isDataPersisted"), hanaExpress.Spec.IsDataPersisted,
+			fmt.Sprintf("must be true exactly when deletionPolicy is %s", DeletionPolicyRetain)))
+	}
+
+	if old != nil && old.Spec.PVCSize == hanaExpress.Spec.PVCSize {
+		return allErrs
+	}
+	pvcSizePath := specPath.Child("pvcSize")
+	size, err := resource.ParseQuantity(hanaExpress.Spec.PVCSize)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(pvcSizePath, hanaExpress.Spec.PVCSize, err.Error()))
+	} else if size.Cmp(minPVCSize) < 0 {
+		allErrs = append(allErrs, field.Invalid(pvcSizePath, hanaExpress.Spec.PVCSize,
+			fmt.Sprintf("must be at least %s, HANA Express does not fit on a smaller volume", minPVCSize.String())))
+	}
+	return allErrs
+}
+
+// validateCredentialFormat accepts the credential formats the operator can read
+func validateCredentialFormat(format string, fldPath *field.Path) field.ErrorList {
+	switch format {
+	case "", "plain", "json":
+		return nil
+	}
+	return field.ErrorList{field.NotSupported(fldPath, format, []string{"plain", "json"})}
+}
+
+// validateResize rejects a volume size below the previous one, volumes can only be expanded.
+// A larger size is rejected when the StorageClass of the existing PVC does not allow expansion,
+// a PVC that is not created yet gets the new size right away.
+func (v *hanaExpressValidator) validateResize(ctx context.Context, namespace, pvcName, oldSize, newSize string,
+	fldPath *field.Path) field.ErrorList {
+	oldQuantity, err := resource.ParseQuantity(oldSize)
+	if err != nil {
+		return nil
+	}
+	newQuantity, err := resource.ParseQuantity(newSize)
+	if err != nil {
+		return nil
+	}
+	switch newQuantity.Cmp(oldQuantity) {
+	case 0:
+		return nil
+	case -1:
+		return field.ErrorList{field.Invalid(fldPath, newSize,
+			fmt.Sprintf("cannot be decreased from %s, volumes can only be expanded", oldSize))}
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := v.Client.Get(ctx, types.NamespacedName{Name: pvcName, Namespace: namespace}, pvc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return field.ErrorList{field.InternalError(fldPath, err)}
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return field.ErrorList{field.Forbidden(fldPath,
+			fmt.Sprintf("PVC %s has no StorageClass and cannot be expanded", pvcName))}
+	}
+	storageClass := &storagev1.StorageClass{}
+	if err := v.Client.Get(ctx, types.NamespacedName{Name: *pvc.Spec.StorageClassName}, storageClass); err != nil {
+		if apierrors.IsNotFound(err) {
+			return field.ErrorList{field.Forbidden(fldPath,
+				fmt.Sprintf("StorageClass %s of PVC %s does not exist", *pvc.Spec.StorageClassName, pvcName))}
+		}
+		return field.ErrorList{field.InternalError(fldPath, err)}
+	}
+	if storageClass.AllowVolumeExpansion == nil || !*storageClass.AllowVolumeExpansion {
+		return field.ErrorList{field.Forbidden(fldPath,
+			fmt.Sprintf("StorageClass %s of PVC %s does not allow volume expansion", storageClass.Name, pvcName))}
+	}
+	return nil
+}
+
+// validateCredentialSecret checks that the Secret and key of a credential exist. A generated
+// credential is exempt, the operator creates its Secret after the resource is admitted.
+func (v *hanaExpressValidator) validateCredentialSecret(ctx context.Context, namespace string,
+	credential *Credential, fldPath *field.Path) field.ErrorList {
+	if credential.Generate {
+		return nil
+	}
+
+	refPath := fldPath.Child("secretKeyRef")
+	ref := credential.SecretKeyRef
+	if ref.Name == "" {
+		return field.ErrorList{field.Required(refPath.Child("name"), "the Secret with the master password is required")}
+	}
+	if ref.Key == "" {
+		return field.ErrorList{field.Required(refPath.Child("key"), "the key of the master password is required")}
+	}
+
+	secret := &corev1.Secret{}
+	if err := v.Client.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return field.ErrorList{field.NotFound(refPath.Child("name"),
+				fmt.Sprintf("Secret %s in namespace %s", ref.Name, namespace))}
+		}
+		return field.ErrorList{field.InternalError(refPath.Child("name"), err)}
+	}
+	if _, ok := secret.Data[ref.Key]; !ok {
+		return field.ErrorList{field.NotFound(refPath.Child("key"), fmt.Sprintf("key %s in Secret %s", ref.Key, ref.Name))}
+	}
+	return nil
+}
+
+// invalidHanaExpress returns the Invalid error reported to the client, or nil without errors
+func invalidHanaExpress(hanaExpress *HanaExpress, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("HanaExpress").GroupKind(), hanaExpress.Name, allErrs)
+}
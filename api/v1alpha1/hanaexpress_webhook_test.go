@@ -0,0 +1,569 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const testNamespace = "default"
+
+// newFakeClient returns a client backed by an in-memory object tracker
+func newFakeClient(objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = AddToScheme(scheme)
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
+
+// testHanaExpress returns a valid instance named hxe whose master password is in the Secret
+// returned by testSecret
+func testHanaExpress() *HanaExpress {
+	return &HanaExpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "hxe", Namespace: testNamespace},
+		Spec: HanaExpressSpec{
+			PVCSize:        "20Gi",
+			BackupPVCSize:  "40Gi",
+			DeletionPolicy: DeletionPolicyDelete,
+			Credential: Credential{
+				SecretKeyRef: corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "hxe-credential"},
+					Key:                  "password",
+				},
+				Format: "plain",
+			},
+		},
+	}
+}
+
+func testSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hxe-credential", Namespace: testNamespace},
+		Data:       map[string][]byte{"password": []byte("Manager1")},
+	}
+}
+
+// testVolumes returns the data and backup PVCs of hxe with a StorageClass that allows expansion
+// or not
+func testVolumes(expandable bool) []client.Object {
+	className := "standard"
+	return []client.Object{
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: className}, Provisioner: "csi.example.com",
+			AllowVolumeExpansion: &expandable},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-hxe-0", Namespace: testNamespace},
+			Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: &className}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "hxe-backup", Namespace: testNamespace},
+			Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: &className}},
+	}
+}
+
+// checkInvalid checks that err is nil without a field, or an Invalid error for exactly the field
+func checkInvalid(t *testing.T, err error, fieldPath string) {
+	t.Helper()
+	if fieldPath == "" {
+		if err != nil {
+			t.Fatalf("rejected: %v", err)
+		}
+		return
+	}
+	if !apierrors.IsInvalid(err) {
+		t.Fatalf("err = %v, want an Invalid error for %s", err, fieldPath)
+	}
+	causes := err.(apierrors.APIStatus).Status().Details.Causes
+	if len(causes) != 1 || causes[0].Field != fieldPath {
+		t.Fatalf("causes = %+v, want one for %s", causes, fieldPath)
+	}
+}
+
+func TestValidateCreate(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  func(spec *HanaExpressSpec)
+		field string
+	}{
+		{name: "valid instance"},
+		{
+			name:  "unknown credential format",
+			spec:  func(spec *HanaExpressSpec) { spec.Credential.Format = "yaml" },
+			field: "spec.credential.format",
+		},
+		{
+			name:  "data volume below the minimum",
+			spec:  func(spec *HanaExpressSpec) { spec.PVCSize = "5Gi" },
+			field: "spec.pvcSize",
+		},
+		{
+			name:  "data volume size that is not a quantity",
+			spec:  func(spec *HanaExpressSpec) { spec.PVCSize = "twenty gigabytes" },
+			field: "spec.pvcSize",
+		},
+		{
+			name: "isDataPersisted that does not match the deletion policy",
+			spec: func(spec *HanaExpressSpec) {
+				spec.DeletionPolicy, spec.IsDataPersisted = DeletionPolicySnapshot, true
+			},
+			field: "spec.isDataPersisted",
+		},
+		{
+			name:  "Secret without name",
+			spec:  func(spec *HanaExpressSpec) { spec.Credential.SecretKeyRef.Name = "" },
+			field: "spec.credential.secretKeyRef.name",
+		},
+		{
+			name:  "Secret without key",
+			spec:  func(spec *HanaExpressSpec) { spec.Credential.SecretKeyRef.Key = "" },
+			field: "spec.credential.secretKeyRef.key",
+		},
+		{
+			name:  "missing Secret",
+			spec:  func(spec *HanaExpressSpec) { spec.Credential.SecretKeyRef.Name = "missing" },
+			field: "spec.credential.secretKeyRef.name",
+		},
+		{
+			name:  "missing key",
+			spec:  func(spec *HanaExpressSpec) { spec.Credential.SecretKeyRef.Key = "master-password" },
+			field: "spec.credential.secretKeyRef.key",
+		},
+		{
+			name: "generated credential whose Secret does not exist yet",
+			spec: func(spec *HanaExpressSpec) {
+				spec.Credential = Credential{Generate: true}
+			},
+		},
+		{
+			name: "clone with the credential of its source",
+			spec: func(spec *HanaExpressSpec) {
+				spec.Source = &DataSource{HanaExpressName: "source", Credential: &Credential{
+					SecretKeyRef: corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "hxe-credential"}, Key: "password"}}}
+			},
+		},
+		{
+			name: "generated source credential",
+			spec: func(spec *HanaExpressSpec) {
+				spec.Source = &DataSource{HanaExpressName: "source", Credential: &Credential{Generate: true}}
+			},
+			field: "spec.source.credential.generate",
+		},
+		{
+			name: "unknown format of the source credential",
+			spec: func(spec *HanaExpressSpec) {
+				spec.Source = &DataSource{HanaExpressName: "source", Credential: &Credential{Format: "yaml",
+					SecretKeyRef: corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "hxe-credential"}, Key: "password"}}}
+			},
+			field: "spec.source.credential.format",
+		},
+		{
+			name: "missing Secret of the source credential",
+			spec: func(spec *HanaExpressSpec) {
+				spec.Source = &DataSource{HanaExpressName: "source", Credential: &Credential{
+					SecretKeyRef: corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "source-credential"}, Key: "password"}}}
+			},
+			field: "spec.source.credential.secretKeyRef.name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress := testHanaExpress()
+			if tt.spec != nil {
+				tt.spec(&hanaExpress.Spec)
+			}
+			v := &hanaExpressValidator{Client: newFakeClient(testSecret())}
+
+			_, err := v.ValidateCreate(context.Background(), hanaExpress)
+			checkInvalid(t, err, tt.field)
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     func(spec *HanaExpressSpec)
+		spec    func(spec *HanaExpressSpec)
+		objects []client.Object
+		field   string
+	}{
+		{
+			name: "new image",
+			spec: func(spec *HanaExpressSpec) { spec.Image = "saplabs/hanaexpress:2.00.072.00.20230728.1" },
+		},
+		{
+			name: "consistent change of the deletion policy",
+			spec: func(spec *HanaExpressSpec) {
+				spec.DeletionPolicy, spec.IsDataPersisted = DeletionPolicyRetain, true
+			},
+		},
+		{
+			name:  "inconsistent change of the deletion policy",
+			spec:  func(spec *HanaExpressSpec) { spec.IsDataPersisted = true },
+			field: "spec.isDataPersisted",
+		},
+		{
+			name: "instance admitted before the webhook",
+			old: func(spec *HanaExpressSpec) {
+				spec.PVCSize, spec.Credential.Format = "5Gi", "yaml"
+				spec.Credential.SecretKeyRef.Name = "missing"
+			},
+			spec: func(spec *HanaExpressSpec) { spec.ServiceType = corev1.ServiceTypeNodePort },
+		},
+		{
+			name:    "expanded data volume",
+			spec:    func(spec *HanaExpressSpec) { spec.PVCSize = "30Gi" },
+			objects: testVolumes(true),
+		},
+		{
+			name:    "data volume whose StorageClass does not allow expansion",
+			spec:    func(spec *HanaExpressSpec) { spec.PVCSize = "30Gi" },
+			objects: testVolumes(false),
+			field:   "spec.pvcSize",
+		},
+		{
+			name: "data volume that is not created yet",
+			spec: func(spec *HanaExpressSpec) { spec.PVCSize = "30Gi" },
+		},
+		{
+			name:    "shrunk data volume",
+			spec:    func(spec *HanaExpressSpec) { spec.PVCSize = "15Gi" },
+			objects: testVolumes(true),
+			field:   "spec.pvcSize",
+		},
+		{
+			name:    "expanded backup volume",
+			spec:    func(spec *HanaExpressSpec) { spec.BackupPVCSize = "50Gi" },
+			objects: testVolumes(true),
+		},
+		{
+			name:    "backup volume whose StorageClass does not allow expansion",
+			spec:    func(spec *HanaExpressSpec) { spec.BackupPVCSize = "50Gi" },
+			objects: testVolumes(false),
+			field:   "spec.backupPVCSize",
+		},
+		{
+			name:  "shrunk backup volume",
+			spec:  func(spec *HanaExpressSpec) { spec.BackupPVCSize = "30Gi" },
+			field: "spec.backupPVCSize",
+		},
+		{
+			name:  "source added to an existing instance",
+			spec:  func(spec *HanaExpressSpec) { spec.Source = &DataSource{HanaExpressName: "source"} },
+			field: "spec.source",
+		},
+		{
+			name:  "changed source",
+			old:   func(spec *HanaExpressSpec) { spec.Source = &DataSource{HanaExpressName: "source"} },
+			spec:  func(spec *HanaExpressSpec) { spec.Source = &DataSource{VolumeSnapshotName: "nightly"} },
+			field: "spec.source",
+		},
+		{
+			name:  "removed source",
+			old:   func(spec *HanaExpressSpec) { spec.Source = &DataSource{HanaExpressName: "source"} },
+			spec:  func(spec *HanaExpressSpec) { spec.Source = nil },
+			field: "spec.source",
+		},
+		{
+			name: "unchanged source",
+			old:  func(spec *HanaExpressSpec) { spec.Source = &DataSource{HanaExpressName: "source"} },
+			spec: func(spec *HanaExpressSpec) { spec.Source = &DataSource{HanaExpressName: "source"} },
+		},
+		{
+			name:  "credential moved to a missing Secret",
+			spec:  func(spec *HanaExpressSpec) { spec.Credential.SecretKeyRef.Name = "missing" },
+			field: "spec.credential.secretKeyRef.name",
+		},
+		{
+			name: "credential switched to a generated one",
+			spec: func(spec *HanaExpressSpec) {
+				spec.Credential.Generate = true
+				spec.Credential.SecretKeyRef.Name = "hxe-master-password"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := testHanaExpress()
+			if tt.old != nil {
+				tt.old(&old.Spec)
+			}
+			hanaExpress := old.DeepCopy()
+			if tt.spec != nil {
+				tt.spec(&hanaExpress.Spec)
+			}
+			v := &hanaExpressValidator{Client: newFakeClient(append(tt.objects, testSecret())...)}
+
+			_, err := v.ValidateUpdate(context.Background(), old, hanaExpress)
+			checkInvalid(t, err, tt.field)
+		})
+	}
+}
+
+func TestValidateUpdateOfADeletedInstance(t *testing.T) {
+	old := testHanaExpress()
+	old.Finalizers = []string{"db.sap-redhat.io/finalizer"}
+	now := metav1.Now()
+	old.DeletionTimestamp = &now
+	hanaExpress := old.DeepCopy()
+	hanaExpress.Finalizers = nil
+
+	// The Secret is already gone, the finalizer can still be removed
+	v := &hanaExpressValidator{Client: newFakeClient()}
+	if _, err := v.ValidateUpdate(context.Background(), old, hanaExpress); err != nil {
+		t.Errorf("removing the finalizer was rejected: %v", err)
+	}
+}
+
+const (
+	testDefaultImage = "saplabs/hanaexpress:2.00.061.00.20220519.1"
+	testNewImage     = "saplabs/hanaexpress:2.00.072.00.20230728.1"
+)
+
+// defaultedSpec returns the spec of testHanaExpress with all defaults of a new instance applied
+func defaultedSpec() HanaExpressSpec {
+	spec := testHanaExpress().Spec
+	spec.ServiceType = corev1.ServiceTypeClusterIP
+	spec.ReadinessProbe = &ProbeTimings{InitialDelaySeconds: DefaultProbeInitialDelaySeconds,
+		PeriodSeconds: DefaultProbePeriodSeconds, TimeoutSeconds: DefaultProbeTimeoutSeconds,
+		FailureThreshold: DefaultProbeFailureThreshold}
+	spec.Resources = *defaultResources.DeepCopy()
+	spec.Image = testDefaultImage
+	spec.Version = "2.00.061.00.20220519.1"
+	return spec
+}
+
+func TestDefaultCreate(t *testing.T) {
+	t.Setenv(DefaultImageEnvVar, testDefaultImage)
+
+	tests := []struct {
+		name string
+		spec func(spec *HanaExpressSpec)
+		want func(spec *HanaExpressSpec)
+	}{
+		{
+			name: "empty fields get the effective defaults",
+			spec: func(spec *HanaExpressSpec) { spec.Credential.Format, spec.DeletionPolicy = "", "" },
+		},
+		{
+			name: "user set values are kept",
+			spec: func(spec *HanaExpressSpec) {
+				spec.Credential.Format = "json"
+				spec.ServiceType = corev1.ServiceTypeNodePort
+				spec.ReadinessProbe = &ProbeTimings{InitialDelaySeconds: 60, PeriodSeconds: 20, TimeoutSeconds: 5,
+					FailureThreshold: 10}
+				spec.Resources = corev1.ResourceRequirements{Requests: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("16Gi")}}
+				spec.Image = testNewImage
+				spec.DeletionPolicy = DeletionPolicySnapshot
+			},
+			want: func(spec *HanaExpressSpec) {
+				spec.Credential.Format = "json"
+				spec.ServiceType = corev1.ServiceTypeNodePort
+				spec.ReadinessProbe = &ProbeTimings{InitialDelaySeconds: 60, PeriodSeconds: 20, TimeoutSeconds: 5,
+					FailureThreshold: 10}
+				spec.Resources = corev1.ResourceRequirements{Requests: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("16Gi")}}
+				spec.Image, spec.Version = testNewImage, "2.00.072.00.20230728.1"
+				spec.DeletionPolicy = DeletionPolicySnapshot
+			},
+		},
+		{
+			name: "partly set readiness probe",
+			spec: func(spec *HanaExpressSpec) { spec.ReadinessProbe = &ProbeTimings{PeriodSeconds: 20} },
+			want: func(spec *HanaExpressSpec) { spec.ReadinessProbe.PeriodSeconds = 20 },
+		},
+		{
+			name: "limits without requests get no default requests",
+			spec: func(spec *HanaExpressSpec) {
+				spec.Resources = corev1.ResourceRequirements{Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("32Gi")}}
+			},
+			want: func(spec *HanaExpressSpec) {
+				spec.Resources = corev1.ResourceRequirements{Limits: corev1.ResourceList{
+					corev1.ResourceMemory: resource.MustParse("32Gi")}}
+			},
+		},
+		{
+			name: "version selects the tag of the default image",
+			spec: func(spec *HanaExpressSpec) { spec.Version = "2.00.072.00.20230728.1" },
+			want: func(spec *HanaExpressSpec) { spec.Image, spec.Version = testNewImage, "2.00.072.00.20230728.1" },
+		},
+		{
+			name: "isDataPersisted of an older client",
+			spec: func(spec *HanaExpressSpec) { spec.DeletionPolicy, spec.IsDataPersisted = "", true },
+			want: func(spec *HanaExpressSpec) { spec.DeletionPolicy, spec.IsDataPersisted = DeletionPolicyRetain, true },
+		},
+		{
+			name: "Retain deletion policy",
+			spec: func(spec *HanaExpressSpec) { spec.DeletionPolicy = DeletionPolicyRetain },
+			want: func(spec *HanaExpressSpec) { spec.DeletionPolicy, spec.IsDataPersisted = DeletionPolicyRetain, true },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress := testHanaExpress()
+			if tt.spec != nil {
+				tt.spec(&hanaExpress.Spec)
+			}
+			want := defaultedSpec()
+			if tt.want != nil {
+				tt.want(&want)
+			}
+
+			if err := (&hanaExpressDefaulter{}).Default(context.Background(), hanaExpress); err != nil {
+				t.Fatal(err)
+			}
+			if !equality.Semantic.DeepEqual(hanaExpress.Spec, want) {
+				t.Errorf("spec = %+v\nwant %+v", hanaExpress.Spec, want)
+			}
+		})
+	}
+}
+
+func TestDefaultCreateWithoutDefaultImage(t *testing.T) {
+	t.Setenv(DefaultImageEnvVar, "")
+
+	hanaExpress := testHanaExpress()
+	hanaExpress.Spec.Version = "2.00.072.00.20230728.1"
+	if err := (&hanaExpressDefaulter{}).Default(context.Background(), hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	// The controller reports the missing image, the version of the user is kept
+	if hanaExpress.Spec.Image != "" || hanaExpress.Spec.Version != "2.00.072.00.20230728.1" {
+		t.Errorf("image = %q, version = %q", hanaExpress.Spec.Image, hanaExpress.Spec.Version)
+	}
+}
+
+func TestDefaultUpdate(t *testing.T) {
+	t.Setenv(DefaultImageEnvVar, testNewImage)
+
+	tests := []struct {
+		name string
+		old  func(spec *HanaExpressSpec)
+		spec func(spec *HanaExpressSpec)
+		want func(spec *HanaExpressSpec)
+	}{
+		{
+			name: "instance keeps its image when the operator default changes",
+		},
+		{
+			name: "instance created before the webhook gets no default requests",
+			old: func(spec *HanaExpressSpec) {
+				spec.Resources, spec.Image, spec.Version, spec.ReadinessProbe = corev1.ResourceRequirements{}, "", "", nil
+			},
+			want: func(spec *HanaExpressSpec) {
+				spec.Resources = corev1.ResourceRequirements{}
+				spec.Image, spec.Version = testNewImage, "2.00.072.00.20230728.1"
+			},
+		},
+		{
+			name: "changed version updates the image",
+			spec: func(spec *HanaExpressSpec) { spec.Version = "2.00.072.00.20230728.1" },
+			want: func(spec *HanaExpressSpec) { spec.Image, spec.Version = testNewImage, "2.00.072.00.20230728.1" },
+		},
+		{
+			name: "changed image updates the version",
+			spec: func(spec *HanaExpressSpec) { spec.Image = testNewImage },
+			want: func(spec *HanaExpressSpec) { spec.Image, spec.Version = testNewImage, "2.00.072.00.20230728.1" },
+		},
+		{
+			name: "image and version changed together are kept",
+			spec: func(spec *HanaExpressSpec) {
+				spec.Image, spec.Version = "registry.example.com/hanaexpress:2.00.072.00.20230728.1", "2.00.072.00.20230728.1"
+			},
+			want: func(spec *HanaExpressSpec) {
+				spec.Image, spec.Version = "registry.example.com/hanaexpress:2.00.072.00.20230728.1", "2.00.072.00.20230728.1"
+			},
+		},
+		{
+			name: "changed isDataPersisted updates the deletion policy",
+			spec: func(spec *HanaExpressSpec) { spec.IsDataPersisted = true },
+			want: func(spec *HanaExpressSpec) { spec.DeletionPolicy, spec.IsDataPersisted = DeletionPolicyRetain, true },
+		},
+		{
+			name: "changed deletion policy updates isDataPersisted",
+			old:  func(spec *HanaExpressSpec) { spec.DeletionPolicy, spec.IsDataPersisted = DeletionPolicyRetain, true },
+			spec: func(spec *HanaExpressSpec) { spec.DeletionPolicy = DeletionPolicySnapshot },
+			want: func(spec *HanaExpressSpec) { spec.DeletionPolicy, spec.IsDataPersisted = DeletionPolicySnapshot, false },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := testHanaExpress()
+			old.Spec = defaultedSpec()
+			if tt.old != nil {
+				tt.old(&old.Spec)
+			}
+			hanaExpress := old.DeepCopy()
+			if tt.spec != nil {
+				tt.spec(&hanaExpress.Spec)
+			}
+			want := defaultedSpec()
+			if tt.want != nil {
+				tt.want(&want)
+			}
+
+			raw, err := json.Marshal(old)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Update,
+					OldObject: runtime.RawExtension{Raw: raw}}})
+			if err := (&hanaExpressDefaulter{}).Default(ctx, hanaExpress); err != nil {
+				t.Fatal(err)
+			}
+			if !equality.Semantic.DeepEqual(hanaExpress.Spec, want) {
+				t.Errorf("spec = %+v\nwant %+v", hanaExpress.Spec, want)
+			}
+		})
+	}
+}
+
+func TestDefaultSkipsDeletedInstances(t *testing.T) {
+	hanaExpress := testHanaExpress()
+	hanaExpress.Spec.Credential.Format = ""
+	now := metav1.Now()
+	hanaExpress.DeletionTimestamp = &now
+	want := hanaExpress.Spec
+
+	if err := (&hanaExpressDefaulter{}).Default(context.Background(), hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	if !equality.Semantic.DeepEqual(hanaExpress.Spec, want) {
+		t.Errorf("the spec of a deleted instance changed to %+v", hanaExpress.Spec)
+	}
+}
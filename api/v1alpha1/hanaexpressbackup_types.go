@@ -0,0 +1,210 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ObjectStorage is an S3-compatible bucket the backup files are copied to, so that
+// backups outlive the instance, its PVCs and its namespace
+type ObjectStorage struct {
+	// +kubebuilder:validation:Required
+	// Endpoint is the host and optional port of the S3 API, e.g. s3.eu-central-1.amazonaws.com
+	// or minio.minio.svc:9000
+	Endpoint string `json:"endpoint"`
+
+	// +kubebuilder:validation:Optional
+	// Insecure uses plain HTTP instead of HTTPS to reach the endpoint
+	Insecure bool `json:"insecure,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Region is the region of the bucket. Most S3-compatible stores do not need it
+	Region string `json:"region,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// Bucket is the existing bucket to upload to
+	Bucket string `json:"bucket"`
+
+	// +kubebuilder:validation:Optional
+	// Prefix is prepended to the object keys
+	Prefix string `json:"prefix,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// CredentialsSecretRef names a Secret in the same namespace with the keys
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+	CredentialsSecretRef corev1.LocalObjectReference `json:"credentialsSecretRef"`
+}
+
+// BackupMethod selects how a backup is taken
+// +kubebuilder:validation:Enum=File;Snapshot
+type BackupMethod string
+
+const (
+	// BackupMethodFile writes a file backup of one database to the backup PVC
+	BackupMethodFile BackupMethod = "File"
+	// BackupMethodSnapshot takes a CSI VolumeSnapshot of the data PVC, wrapped in a HANA
+	// data snapshot of the full system
+	BackupMethodSnapshot BackupMethod = "Snapshot"
+)
+
+// HanaExpressBackupSpec defines the desired state of HanaExpressBackup
+type HanaExpressBackupSpec struct {
+	// +kubebuilder:validation:Required
+	// HanaExpressName is the name of the HanaExpress instance in the same namespace to back up
+	HanaExpressName string `json:"hanaExpressName"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=HXE
+	// DatabaseName is the database to back up, either SYSTEMDB or a tenant database
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=File
+	// Method is File for a file backup of DatabaseName on the backup PVC, or Snapshot for a
+	// VolumeSnapshot of the data PVC covering the full system
+	Method BackupMethod `json:"method,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// VolumeSnapshotClassName is the class of the VolumeSnapshot for the Snapshot method.
+	// Defaults to the default class of the CSI driver of the data PVC
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ObjectStorage copies the backup files to an S3-compatible bucket after the backup
+	ObjectStorage *ObjectStorage `json:"objectStorage,omitempty"`
+}
+
+// BackupPhase is the lifecycle phase of a HanaExpressBackup
+type BackupPhase string
+
+const (
+	// BackupPhasePending waits for the HanaExpress instance to become available
+	BackupPhasePending BackupPhase = "Pending"
+	// BackupPhaseRunning runs the backup
+	BackupPhaseRunning BackupPhase = "Running"
+	// BackupPhaseUploading copies the backup files to object storage
+	BackupPhaseUploading BackupPhase = "Uploading"
+	// BackupPhaseCompleted means the backup finished successfully
+	BackupPhaseCompleted BackupPhase = "Completed"
+	// BackupPhaseFailed means the backup did not finish successfully
+	BackupPhaseFailed BackupPhase = "Failed"
+)
+
+// BackupObject is a backup file copied to object storage
+type BackupObject struct {
+	// Key is the object key in the bucket
+	Key string `json:"key"`
+
+	// Size is the size of the object in bytes
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex encoded SHA-256 checksum of the object
+	SHA256 string `json:"sha256"`
+}
+
+// BackupSnapshotStatus describes the VolumeSnapshot taken by the Snapshot method
+type BackupSnapshotStatus struct {
+	// VolumeSnapshotName is the name of the VolumeSnapshot of the data PVC
+	VolumeSnapshotName string `json:"volumeSnapshotName"`
+
+	// VolumeSnapshotClassName is the class the VolumeSnapshot was created with
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+
+	// Confirmed is true once the HANA data snapshot was closed as successful
+	Confirmed bool `json:"confirmed,omitempty"`
+
+	// ReadyToUse mirrors the readiness of the VolumeSnapshot
+	ReadyToUse bool `json:"readyToUse,omitempty"`
+}
+
+// HanaExpressBackupStatus defines the observed state of HanaExpressBackup
+type HanaExpressBackupStatus struct {
+	// Phase is the lifecycle phase of the backup
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase BackupPhase `json:"phase,omitempty"`
+
+	// BackupID is the ID of the backup in the HANA backup catalog
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	BackupID int64 `json:"backupID,omitempty"`
+
+	// Destination is the file name prefix of the backup files on the backup PVC
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Destination string `json:"destination,omitempty"`
+
+	// Size is the total size of the backup files in bytes
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Size int64 `json:"size,omitempty"`
+
+	// Snapshot is the VolumeSnapshot taken by the Snapshot method
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Snapshot *BackupSnapshotStatus `json:"snapshot,omitempty"`
+
+	// Objects are the backup files copied to object storage
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Objects []BackupObject `json:"objects,omitempty"`
+
+	// StartTime is the time the backup was started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is the time the backup finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Duration is the time the backup took
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// Message is a human-readable result of the backup
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Message string `json:"message,omitempty"`
+
+	// Conditions store the status conditions of the HanaExpressBackup
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="HanaExpress",type=string,JSONPath=`.spec.hanaExpressName`
+//+kubebuilder:printcolumn:name="Method",type=string,JSONPath=`.spec.method`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Backup ID",type=integer,JSONPath=`.status.backupID`
+//+kubebuilder:printcolumn:name="Size",type=integer,JSONPath=`.status.size`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HanaExpressBackup is the Schema for the hanaexpressbackups API
+type HanaExpressBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HanaExpressBackupSpec   `json:"spec,omitempty"`
+	Status HanaExpressBackupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HanaExpressBackupList contains a list of HanaExpressBackup
+type HanaExpressBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HanaExpressBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HanaExpressBackup{}, &HanaExpressBackupList{})
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConcurrencyPolicy describes how a scheduled backup is handled while an earlier one still runs
+// +kubebuilder:validation:Enum=Allow;Forbid
+type ConcurrencyPolicy string
+
+const (
+	// ConcurrencyPolicyAllow starts scheduled backups even if earlier ones have not finished
+	ConcurrencyPolicyAllow ConcurrencyPolicy = "Allow"
+	// ConcurrencyPolicyForbid skips a scheduled backup while an earlier one has not finished
+	ConcurrencyPolicyForbid ConcurrencyPolicy = "Forbid"
+)
+
+// BackupRetention limits how many completed scheduled backups are kept. Older backups are
+// removed together with their files. The latest completed backup is always kept
+type BackupRetention struct {
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// Count is the number of completed backups to keep
+	Count *int32 `json:"count,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// MaxAge is the age after which completed backups are removed, e.g. 168h
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// HanaExpressBackupScheduleSpec defines the desired state of HanaExpressBackupSchedule
+type HanaExpressBackupScheduleSpec struct {
+	// +kubebuilder:validation:Required
+	// HanaExpressName is the name of the HanaExpress instance in the same namespace to back up
+	HanaExpressName string `json:"hanaExpressName"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=HXE
+	// DatabaseName is the database to back up, either SYSTEMDB or a tenant database
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=File
+	// Method is File for file backups on the backup PVC, or Snapshot for VolumeSnapshots of the data PVC
+	Method BackupMethod `json:"method,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// VolumeSnapshotClassName is the class of the VolumeSnapshots for the Snapshot method
+	VolumeSnapshotClassName string `json:"volumeSnapshotClassName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ObjectStorage copies the files of every backup to an S3-compatible bucket. Pruned
+	// backups are removed from the bucket as well
+	ObjectStorage *ObjectStorage `json:"objectStorage,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinLength=1
+	// Schedule is a cron expression in the standard five field format, e.g. "0 2 * * *"
+	Schedule string `json:"schedule"`
+
+	// +kubebuilder:validation:Optional
+	// Suspend stops scheduling new backups. Retention is still applied
+	Suspend bool `json:"suspend,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=Forbid
+	// ConcurrencyPolicy decides whether a backup starts while an earlier one has not finished
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Retention limits the completed backups that are kept. Without it all backups are kept
+	Retention BackupRetention `json:"retention,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default:=3
+	// FailedBackupsHistoryLimit is the number of failed backups to keep for troubleshooting
+	FailedBackupsHistoryLimit *int32 `json:"failedBackupsHistoryLimit,omitempty"`
+}
+
+// ScheduledBackupReference points to a HanaExpressBackup created by a schedule
+type ScheduledBackupReference struct {
+	// Name is the name of the HanaExpressBackup
+	Name string `json:"name"`
+
+	// Time is the time the backup finished
+	Time metav1.Time `json:"time"`
+
+	// Message is the result of the backup
+	Message string `json:"message,omitempty"`
+}
+
+// HanaExpressBackupScheduleStatus defines the observed state of HanaExpressBackupSchedule
+type HanaExpressBackupScheduleStatus struct {
+	// LastScheduleTime is the last time a backup was due
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// NextScheduleTime is the next time a backup is due
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+
+	// Active lists the backups of this schedule that have not finished
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Active []string `json:"active,omitempty"`
+
+	// LastSuccessfulBackup is the latest backup of this schedule that completed
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	LastSuccessfulBackup *ScheduledBackupReference `json:"lastSuccessfulBackup,omitempty"`
+
+	// LastFailedBackup is the latest backup of this schedule that failed
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	LastFailedBackup *ScheduledBackupReference `json:"lastFailedBackup,omitempty"`
+
+	// Conditions store the status conditions of the HanaExpressBackupSchedule
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="HanaExpress",type=string,JSONPath=`.spec.hanaExpressName`
+//+kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`
+//+kubebuilder:printcolumn:name="Suspend",type=boolean,JSONPath=`.spec.suspend`
+//+kubebuilder:printcolumn:name="Last Success",type=date,JSONPath=`.status.lastSuccessfulBackup.time`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HanaExpressBackupSchedule is the Schema for the hanaexpressbackupschedules API
+type HanaExpressBackupSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HanaExpressBackupScheduleSpec   `json:"spec,omitempty"`
+	Status HanaExpressBackupScheduleStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HanaExpressBackupScheduleList contains a list of HanaExpressBackupSchedule
+type HanaExpressBackupScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HanaExpressBackupSchedule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HanaExpressBackupSchedule{}, &HanaExpressBackupScheduleList{})
+}
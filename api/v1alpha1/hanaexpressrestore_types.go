@@ -0,0 +1,140 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestoreSource selects the backup to recover from. Exactly one field must be set
+type RestoreSource struct {
+	// +kubebuilder:validation:Optional
+	// BackupName is the name of a completed HanaExpressBackup in the same namespace
+	BackupName string `json:"backupName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Pattern=`^(/hana/backup/.+|[^/]+)$`
+	// Path is the file name prefix of a backup file set on the backup PVC of the instance,
+	// e.g. /hana/backup/nightly/hxe, or a prefix without a directory for a backup in the
+	// default backup location of the database, e.g. the pre-upgrade backup of the instance
+	Path string `json:"path,omitempty"`
+}
+
+// HanaExpressRestoreSpec defines the desired state of HanaExpressRestore
+type HanaExpressRestoreSpec struct {
+	// +kubebuilder:validation:Required
+	// HanaExpressName is the name of the HanaExpress instance in the same namespace to recover
+	HanaExpressName string `json:"hanaExpressName"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=HXE
+	// DatabaseName is the database to recover, either SYSTEMDB or a tenant database. The
+	// SystemDB is recovered with the instance stopped, its tenants stay down until they are
+	// recovered as well
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// Source is the backup to recover from
+	Source RestoreSource `json:"source"`
+
+	// +kubebuilder:validation:Optional
+	// Timeout bounds the restore from its start. A restore that has not finished by then
+	// fails with the reason DeadlineExceeded. Defaults to 6h
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// RestorePhase is the lifecycle phase of a HanaExpressRestore
+type RestorePhase string
+
+const (
+	// RestorePhasePending waits for the HanaExpress instance to become available
+	RestorePhasePending RestorePhase = "Pending"
+	// RestorePhaseStoppingDatabase stops the tenant database
+	RestorePhaseStoppingDatabase RestorePhase = "StoppingDatabase"
+	// RestorePhaseRecovering recovers the tenant database from the backup
+	RestorePhaseRecovering RestorePhase = "Recovering"
+	// RestorePhaseStartingDatabase brings the recovered tenant database back up
+	RestorePhaseStartingDatabase RestorePhase = "StartingDatabase"
+	// RestorePhaseStoppingInstance stops the instance for the recovery of the SystemDB
+	RestorePhaseStoppingInstance RestorePhase = "StoppingInstance"
+	// RestorePhaseRecoveringSystem recovers the SystemDB in a Job on the data volume
+	RestorePhaseRecoveringSystem RestorePhase = "RecoveringSystem"
+	// RestorePhaseStartingInstance starts the instance on the recovered SystemDB
+	RestorePhaseStartingInstance RestorePhase = "StartingInstance"
+	// RestorePhaseCompleted means the database was recovered and is running
+	RestorePhaseCompleted RestorePhase = "Completed"
+	// RestorePhaseFailed means the restore did not finish successfully
+	RestorePhaseFailed RestorePhase = "Failed"
+)
+
+// HanaExpressRestoreStatus defines the observed state of HanaExpressRestore
+type HanaExpressRestoreStatus struct {
+	// Phase is the lifecycle phase of the restore
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase RestorePhase `json:"phase,omitempty"`
+
+	// Source is the resolved file name prefix of the backup that is recovered
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Source string `json:"source,omitempty"`
+
+	// StartTime is the time the restore was started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// RecoveryStartTime is the time RECOVER DATA or the recovery Job of the SystemDB was
+	// started. It is recorded before the recovery starts, so that it is never started twice
+	RecoveryStartTime *metav1.Time `json:"recoveryStartTime,omitempty"`
+
+	// CompletionTime is the time the restore finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Message is a human-readable result of the restore
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Message string `json:"message,omitempty"`
+
+	// Conditions store the status conditions of the HanaExpressRestore
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="HanaExpress",type=string,JSONPath=`.spec.hanaExpressName`
+//+kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseName`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HanaExpressRestore is the Schema for the hanaexpressrestores API
+type HanaExpressRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HanaExpressRestoreSpec   `json:"spec,omitempty"`
+	Status HanaExpressRestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HanaExpressRestoreList contains a list of HanaExpressRestore
+type HanaExpressRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HanaExpressRestore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HanaExpressRestore{}, &HanaExpressRestoreList{})
+}
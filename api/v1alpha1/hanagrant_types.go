@@ -0,0 +1,165 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PrivilegeName is a system, schema or object privilege such as CATALOG READ or SELECT.
+// Privileges are SQL keywords and are used in upper case.
+// +kubebuilder:validation:Pattern=`^[A-Za-z][A-Za-z0-9_ ]*$`
+type PrivilegeName string
+
+// SchemaPrivilege declares privileges on all objects of a schema
+type SchemaPrivilege struct {
+	// +kubebuilder:validation:Required
+	// Schema is the case sensitive name of the schema
+	Schema string `json:"schema"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	// Privileges are the schema privileges, e.g. SELECT, INSERT, EXECUTE
+	Privileges []PrivilegeName `json:"privileges"`
+}
+
+// ObjectPrivilege declares privileges on a single table, view or procedure
+type ObjectPrivilege struct {
+	// +kubebuilder:validation:Required
+	// Schema is the case sensitive name of the schema of the object
+	Schema string `json:"schema"`
+
+	// +kubebuilder:validation:Required
+	// Object is the case sensitive name of the object
+	Object string `json:"object"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	// Privileges are the object privileges, e.g. SELECT, UPDATE. ALL PRIVILEGES is expanded
+	// by HANA and is reported as drift, list the single privileges instead
+	Privileges []PrivilegeName `json:"privileges"`
+}
+
+// Privileges declares the roles and privileges granted to a role or a grantee
+type Privileges struct {
+	// +kubebuilder:validation:Optional
+	// Roles are the case sensitive names of the roles to grant, e.g. MONITORING
+	Roles []string `json:"roles,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// SystemPrivileges are the system privileges to grant, e.g. CATALOG READ
+	SystemPrivileges []PrivilegeName `json:"systemPrivileges,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// SchemaPrivileges are the privileges to grant on schemas
+	SchemaPrivileges []SchemaPrivilege `json:"schemaPrivileges,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// ObjectPrivileges are the privileges to grant on single objects
+	ObjectPrivileges []ObjectPrivilege `json:"objectPrivileges,omitempty"`
+}
+
+// FailedStatement is a GRANT or REVOKE statement that HANA rejected
+type FailedStatement struct {
+	// Statement is the SQL statement
+	Statement string `json:"statement"`
+
+	// Error is the error returned by HANA
+	Error string `json:"error"`
+}
+
+// PrivilegeSyncStatus reports the result of the last synchronization with the catalog
+type PrivilegeSyncStatus struct {
+	// Applied lists the roles and privileges currently granted by this resource
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Applied []string `json:"applied,omitempty"`
+
+	// FailedStatements lists the statements of the last synchronization that failed
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	FailedStatements []FailedStatement `json:"failedStatements,omitempty"`
+
+	// LastSyncTime is when the privileges were last compared with the catalog
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+}
+
+// HanaGrantSpec defines the desired state of HanaGrant
+type HanaGrantSpec struct {
+	// +kubebuilder:validation:Required
+	// HanaExpressName is the name of the HanaExpress instance in the same namespace
+	HanaExpressName string `json:"hanaExpressName"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=HXE
+	// DatabaseName is the database of the grantee, SYSTEMDB or a tenant database
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[A-Za-z][A-Za-z0-9_]{0,126}$`
+	// Grantee is the user or role the privileges are granted to. HANA stores it in upper case
+	Grantee string `json:"grantee"`
+
+	// Privileges are the roles and privileges granted to the grantee
+	Privileges `json:",inline"`
+}
+
+// HanaGrantStatus defines the observed state of HanaGrant
+type HanaGrantStatus struct {
+	// Grantee is the name of the grantee as stored by HANA
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Grantee string `json:"grantee,omitempty"`
+
+	// DatabaseName is the database the privileges are granted in
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	PrivilegeSyncStatus `json:",inline"`
+
+	// Conditions store the status conditions of the HanaGrant
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="HanaExpress",type=string,JSONPath=`.spec.hanaExpressName`
+//+kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseName`
+//+kubebuilder:printcolumn:name="Grantee",type=string,JSONPath=`.status.grantee`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HanaGrant is the Schema for the hanagrants API
+type HanaGrant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HanaGrantSpec   `json:"spec,omitempty"`
+	Status HanaGrantStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HanaGrantList contains a list of HanaGrant
+type HanaGrantList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HanaGrant `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HanaGrant{}, &HanaGrantList{})
+}
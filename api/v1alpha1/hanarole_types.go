@@ -0,0 +1,89 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HanaRoleSpec defines the desired state of HanaRole
+type HanaRoleSpec struct {
+	// +kubebuilder:validation:Required
+	// HanaExpressName is the name of the HanaExpress instance in the same namespace
+	HanaExpressName string `json:"hanaExpressName"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=HXE
+	// DatabaseName is the database the role is created in, SYSTEMDB or a tenant database
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[A-Za-z][A-Za-z0-9_]{0,126}$`
+	// RoleName is the name of the role. HANA stores it in upper case
+	RoleName string `json:"roleName"`
+
+	// Privileges are the roles and privileges granted to the role. Privileges granted to
+	// the role by SYSTEM that are not listed are revoked
+	Privileges `json:",inline"`
+}
+
+// HanaRoleStatus defines the observed state of HanaRole
+type HanaRoleStatus struct {
+	// RoleName is the name of the role as stored by HANA
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	RoleName string `json:"roleName,omitempty"`
+
+	// DatabaseName is the database the role exists in
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	PrivilegeSyncStatus `json:",inline"`
+
+	// Conditions store the status conditions of the HanaRole
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="HanaExpress",type=string,JSONPath=`.spec.hanaExpressName`
+//+kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseName`
+//+kubebuilder:printcolumn:name="Role",type=string,JSONPath=`.status.roleName`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HanaRole is the Schema for the hanaroles API
+type HanaRole struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HanaRoleSpec   `json:"spec,omitempty"`
+	Status HanaRoleStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HanaRoleList contains a list of HanaRole
+type HanaRoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HanaRole `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HanaRole{}, &HanaRoleList{})
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TenantDeletionPolicy decides what happens to a tenant database when its resource is deleted
+// +kubebuilder:validation:Enum=Delete;Retain
+type TenantDeletionPolicy string
+
+const (
+	// TenantDeletionPolicyDelete drops the tenant database
+	TenantDeletionPolicyDelete TenantDeletionPolicy = "Delete"
+	// TenantDeletionPolicyRetain keeps the tenant database
+	TenantDeletionPolicyRetain TenantDeletionPolicy = "Retain"
+)
+
+// HanaTenantDatabaseSpec defines the desired state of HanaTenantDatabase
+type HanaTenantDatabaseSpec struct {
+	// +kubebuilder:validation:Required
+	// HanaExpressName is the name of the HanaExpress instance in the same namespace that hosts the tenant
+	HanaExpressName string `json:"hanaExpressName"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[A-Za-z][A-Za-z0-9_]{0,62}$`
+	// DatabaseName is the name of the tenant database. HANA stores it in upper case
+	DatabaseName string `json:"databaseName"`
+
+	// +kubebuilder:validation:Required
+	// Credential references the password of the SYSTEM user of the tenant. Changing the
+	// password in the Secret changes it in the tenant, which restarts the tenant
+	Credential Credential `json:"credential"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=Delete
+	// DeletionPolicy is Delete to drop the tenant database with this resource, or Retain to keep it
+	DeletionPolicy TenantDeletionPolicy `json:"deletionPolicy,omitempty"`
+}
+
+// TenantPhase is the lifecycle phase of a HanaTenantDatabase
+type TenantPhase string
+
+const (
+	// TenantPhasePending waits for the HanaExpress instance to become available
+	TenantPhasePending TenantPhase = "Pending"
+	// TenantPhaseCreating creates the tenant database
+	TenantPhaseCreating TenantPhase = "Creating"
+	// TenantPhaseReady means the tenant database is running
+	TenantPhaseReady TenantPhase = "Ready"
+	// TenantPhaseFailed means the last attempt to reconcile the tenant database failed
+	TenantPhaseFailed TenantPhase = "Failed"
+	// TenantPhaseDeleting drops the tenant database
+	TenantPhaseDeleting TenantPhase = "Deleting"
+)
+
+// HanaTenantDatabaseStatus defines the observed state of HanaTenantDatabase
+type HanaTenantDatabaseStatus struct {
+	// Phase is the lifecycle phase of the tenant database
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Phase TenantPhase `json:"phase,omitempty"`
+
+	// DatabaseName is the name of the tenant database as stored by HANA
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// SQLPort is the SQL port of the tenant database, exposed by the Service of the instance
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	SQLPort int32 `json:"sqlPort,omitempty"`
+
+	// Host is the host name clients connect to
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Host string `json:"host,omitempty"`
+
+	// CredentialVersion is the resource version of the credential Secret last applied to the tenant
+	CredentialVersion string `json:"credentialVersion,omitempty"`
+
+	// Conditions store the status conditions of the HanaTenantDatabase
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="HanaExpress",type=string,JSONPath=`.spec.hanaExpressName`
+//+kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.status.databaseName`
+//+kubebuilder:printcolumn:name="Port",type=integer,JSONPath=`.status.sqlPort`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HanaTenantDatabase is the Schema for the hanatenantdatabases API
+type HanaTenantDatabase struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HanaTenantDatabaseSpec   `json:"spec,omitempty"`
+	Status HanaTenantDatabaseStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HanaTenantDatabaseList contains a list of HanaTenantDatabase
+type HanaTenantDatabaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HanaTenantDatabase `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HanaTenantDatabase{}, &HanaTenantDatabaseList{})
+}
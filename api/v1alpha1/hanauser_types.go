@@ -0,0 +1,104 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HanaUserSpec defines the desired state of HanaUser
+type HanaUserSpec struct {
+	// +kubebuilder:validation:Required
+	// HanaExpressName is the name of the HanaExpress instance in the same namespace
+	HanaExpressName string `json:"hanaExpressName"`
+
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default:=HXE
+	// DatabaseName is the database the user is created in, SYSTEMDB or a tenant database
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[A-Za-z][A-Za-z0-9_]{0,126}$`
+	// UserName is the name of the database user. HANA stores it in upper case
+	UserName string `json:"userName"`
+
+	// +kubebuilder:validation:Optional
+	// SecretName is the Secret the operator generates the password into. Defaults to
+	// <name>-credentials. The Secret is owned by the HanaUser, deleting it rotates the password
+	SecretName string `json:"secretName,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// PasswordNeverExpires disables the password lifetime check of the user
+	PasswordNeverExpires bool `json:"passwordNeverExpires,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Disabled deactivates the user so it cannot log on
+	Disabled bool `json:"disabled,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	// Usergroup is the existing usergroup the user belongs to
+	Usergroup string `json:"usergroup,omitempty"`
+}
+
+// HanaUserStatus defines the observed state of HanaUser
+type HanaUserStatus struct {
+	// UserName is the name of the user as stored by HANA
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	UserName string `json:"userName,omitempty"`
+
+	// DatabaseName is the database the user exists in
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	DatabaseName string `json:"databaseName,omitempty"`
+
+	// SecretName is the Secret holding the credentials of the user
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	SecretName string `json:"secretName,omitempty"`
+
+	// Conditions store the status conditions of the HanaUser
+	// +operator-sdk:csv:customresourcedefinitions:type=status
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type" protobuf:"bytes,1,rep,name=conditions"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="HanaExpress",type=string,JSONPath=`.spec.hanaExpressName`
+//+kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseName`
+//+kubebuilder:printcolumn:name="User",type=string,JSONPath=`.status.userName`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// HanaUser is the Schema for the hanausers API
+type HanaUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   HanaUserSpec   `json:"spec,omitempty"`
+	Status HanaUserStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// HanaUserList contains a list of HanaUser
+type HanaUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HanaUser `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HanaUser{}, &HanaUserList{})
+}
@@ -0,0 +1,339 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+// conversionDataAnnotation keeps the v1alpha1 fields that v1beta1 cannot represent, so that a
+// v1alpha1 HanaExpress read and written back through v1beta1 does not change
+const conversionDataAnnotation = "db.sap-redhat.io/v1alpha1-conversion-data"
+
+// conversionData are the v1alpha1 fields kept in the conversionDataAnnotation
+type conversionData struct {
+	DeletionPolicy           v1alpha1.DeletionPolicy `json:"deletionPolicy,omitempty"`
+	IsDataPersisted          bool                    `json:"isDataPersisted,omitempty"`
+	SourceCredentialGenerate bool                    `json:"sourceCredentialGenerate,omitempty"`
+}
+
+// lostInConversion returns the fields of the v1alpha1 HanaExpress that ConvertTo cannot derive
+// from v1beta1, or nil when the conversion is lossless
+func lostInConversion(src *v1alpha1.HanaExpress) *conversionData {
+	data := &conversionData{DeletionPolicy: src.Spec.DeletionPolicy, IsDataPersisted: src.Spec.IsDataPersisted}
+	if source := src.Spec.Source; source != nil && source.Credential != nil {
+		data.SourceCredentialGenerate = source.Credential.Generate
+	}
+
+	policy := deletionPolicyFrom(src)
+	if data.DeletionPolicy == v1alpha1.DeletionPolicy(policy) && data.IsDataPersisted == (policy == DeletionPolicyRetain) &&
+		!data.SourceCredentialGenerate {
+		return nil
+	}
+	return data
+}
+
+// deletionPolicyFrom returns the v1beta1 deletion policy of a v1alpha1 HanaExpress. Resources
+// stored before v1alpha1 had a deletion policy get Retain when isDataPersisted is set, an unset
+// policy stays unset.
+func deletionPolicyFrom(src *v1alpha1.HanaExpress) DeletionPolicy {
+	if src.Spec.DeletionPolicy == "" && src.Spec.IsDataPersisted {
+		return DeletionPolicyRetain
+	}
+	return DeletionPolicy(src.Spec.DeletionPolicy)
+}
+
+// setConversionData records the lost fields in the annotation, or removes the annotation when
+// nothing is lost. The annotations are copied, they are shared with the source object.
+func setConversionData(objectMeta *metav1.ObjectMeta, data *conversionData) error {
+	if _, found := objectMeta.Annotations[conversionDataAnnotation]; data == nil && !found {
+		return nil
+	}
+
+	annotations := make(map[string]string, len(objectMeta.Annotations)+1)
+	for key, value := range objectMeta.Annotations {
+		annotations[key] = value
+	}
+	delete(annotations, conversionDataAnnotation)
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		annotations[conversionDataAnnotation] = string(raw)
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	objectMeta.Annotations = annotations
+	return nil
+}
+
+// takeConversionData removes the annotation and returns the fields recorded in it, or nil when
+// there is none. An annotation that was edited into something else is dropped.
+func takeConversionData(objectMeta *metav1.ObjectMeta) *conversionData {
+	raw, found := objectMeta.Annotations[conversionDataAnnotation]
+	if !found {
+		return nil
+	}
+	_ = setConversionData(objectMeta, nil)
+
+	data := &conversionData{}
+	if err := json.Unmarshal([]byte(raw), data); err != nil {
+		return nil
+	}
+	return data
+}
+
+// ConvertTo converts this HanaExpress to the Hub version (v1alpha1)
+func (src *HanaExpress) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1alpha1.HanaExpress)
+	if !ok {
+		return fmt.Errorf("expected a v1alpha1 HanaExpress but got a %T", dstRaw)
+	}
+	dst.ObjectMeta = src.ObjectMeta
+	data := takeConversionData(&dst.ObjectMeta)
+
+	spec := &src.Spec
+	dst.Spec = v1alpha1.HanaExpressSpec{
+		PVCSize: spec.Storage.Size,
+		Credential: v1alpha1.Credential{
+			SecretKeyRef: spec.Credentials.SecretKeyRef,
+			Format:       spec.Credentials.Format,
+			Generate:     spec.Credentials.Generate,
+		},
+		IsDataPersisted: spec.DeletionPolicy == DeletionPolicyRetain,
+		DeletionPolicy:  v1alpha1.DeletionPolicy(spec.DeletionPolicy),
+		Resources:       spec.Resources,
+		Version:         spec.Version,
+		Image:           spec.Image,
+		UpgradeTimeout:  spec.UpgradeTimeout,
+		BackupPVCSize:   spec.Storage.BackupSize,
+		ServiceType:     spec.Networking.ServiceType,
+	}
+	if source := spec.Storage.Source; source != nil {
+		dst.Spec.Source = &v1alpha1.DataSource{
+			HanaExpressName:    source.HanaExpressName,
+			VolumeSnapshotName: source.VolumeSnapshotName,
+			BackupName:         source.BackupName,
+		}
+		if credentials := source.Credentials; credentials != nil {
+			dst.Spec.Source.Credential = &v1alpha1.Credential{
+				SecretKeyRef: credentials.SecretKeyRef,
+				Format:       credentials.Format,
+			}
+		}
+	}
+	if rotation := spec.Credentials.Rotation; rotation != nil {
+		dst.Spec.PasswordRotation = &v1alpha1.PasswordRotation{Schedule: rotation.Schedule}
+	}
+	if probe := spec.ReadinessProbe; probe != nil {
+		dst.Spec.ReadinessProbe = &v1alpha1.ProbeTimings{
+			InitialDelaySeconds: probe.InitialDelaySeconds,
+			PeriodSeconds:       probe.PeriodSeconds,
+			TimeoutSeconds:      probe.TimeoutSeconds,
+			FailureThreshold:    probe.FailureThreshold,
+		}
+	}
+
+	// The kept v1alpha1 fields apply as long as the deletion policy was not changed in v1beta1
+	if data != nil {
+		kept := &v1alpha1.HanaExpress{Spec: v1alpha1.HanaExpressSpec{DeletionPolicy: data.DeletionPolicy,
+			IsDataPersisted: data.IsDataPersisted}}
+		if deletionPolicyFrom(kept) == spec.DeletionPolicy {
+			dst.Spec.DeletionPolicy = data.DeletionPolicy
+			dst.Spec.IsDataPersisted = data.IsDataPersisted
+		}
+		if source := dst.Spec.Source; source != nil && source.Credential != nil {
+			source.Credential.Generate = data.SourceCredentialGenerate
+		}
+	}
+
+	status := &src.Status
+	dst.Status = v1alpha1.HanaExpressStatus{
+		Conditions:            status.Conditions,
+		Phase:                 v1alpha1.HanaExpressPhase(status.Phase),
+		ObservedGeneration:    status.ObservedGeneration,
+		DatabaseVersion:       status.DatabaseVersion,
+		PodName:               status.PodName,
+		PVCName:               status.PVCName,
+		ReadyTime:             status.ReadyTime,
+		GlobalAllocationLimit: status.GlobalAllocationLimit,
+		Image:                 status.Image,
+		Version:               status.Version,
+		Binding:               status.Binding,
+	}
+	if endpoints := status.Endpoints; endpoints != nil {
+		dst.Status.Endpoints = &v1alpha1.HanaExpressEndpoints{
+			SQL:         endpoints.SQL,
+			SystemDBSQL: endpoints.SystemDBSQL,
+			XSA:         endpoints.XSA,
+		}
+	}
+	if upgrade := status.Upgrade; upgrade != nil {
+		dst.Status.Upgrade = &v1alpha1.UpgradeStatus{
+			FromImage:         upgrade.FromImage,
+			ToImage:           upgrade.ToImage,
+			Phase:             v1alpha1.UpgradePhase(upgrade.Phase),
+			StartTime:         upgrade.StartTime,
+			Backup:            upgrade.Backup,
+			BackupStartTime:   upgrade.BackupStartTime,
+			BackupDatabases:   upgrade.BackupDatabases,
+			ImageUpdated:      upgrade.ImageUpdated,
+			ImageStarted:      upgrade.ImageStarted,
+			RollbackStartTime: upgrade.RollbackStartTime,
+			Restores:          upgrade.Restores,
+		}
+	}
+	if source := status.Source; source != nil {
+		dst.Status.Source = &v1alpha1.DataSourceStatus{
+			Kind:                  source.Kind,
+			Name:                  source.Name,
+			DataSource:            source.DataSource,
+			SourceHanaExpressName: source.SourceHanaExpressName,
+			Time:                  source.Time,
+			CredentialsReset:      source.CredentialsReset,
+		}
+	}
+	if credentials := status.Credentials; credentials != nil {
+		dst.Status.Credential = &v1alpha1.CredentialStatus{
+			PasswordHash:              credentials.PasswordHash,
+			LastRotationTime:          credentials.LastRotationTime,
+			LastScheduledRotationTime: credentials.LastScheduledRotationTime,
+			NextScheduledRotationTime: credentials.NextScheduledRotationTime,
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1alpha1) to this version. The v1alpha1 fields that
+// v1beta1 cannot represent are kept in the conversionDataAnnotation.
+func (dst *HanaExpress) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1alpha1.HanaExpress)
+	if !ok {
+		return fmt.Errorf("expected a v1alpha1 HanaExpress but got a %T", srcRaw)
+	}
+	dst.ObjectMeta = src.ObjectMeta
+	if err := setConversionData(&dst.ObjectMeta, lostInConversion(src)); err != nil {
+		return err
+	}
+
+	spec := &src.Spec
+	dst.Spec = HanaExpressSpec{
+		Storage: Storage{
+			Size:       spec.PVCSize,
+			BackupSize: spec.BackupPVCSize,
+		},
+		Credentials: Credentials{
+			SecretKeyRef: spec.Credential.SecretKeyRef,
+			Format:       spec.Credential.Format,
+			Generate:     spec.Credential.Generate,
+		},
+		Resources:      spec.Resources,
+		Networking:     Networking{ServiceType: spec.ServiceType},
+		DeletionPolicy: deletionPolicyFrom(src),
+		Version:        spec.Version,
+		Image:          spec.Image,
+		UpgradeTimeout: spec.UpgradeTimeout,
+	}
+	if source := spec.Source; source != nil {
+		dst.Spec.Storage.Source = &DataSource{
+			HanaExpressName:    source.HanaExpressName,
+			VolumeSnapshotName: source.VolumeSnapshotName,
+			BackupName:         source.BackupName,
+		}
+		if credential := source.Credential; credential != nil {
+			dst.Spec.Storage.Source.Credentials = &SourceCredentials{
+				SecretKeyRef: credential.SecretKeyRef,
+				Format:       credential.Format,
+			}
+		}
+	}
+	if rotation := spec.PasswordRotation; rotation != nil {
+		dst.Spec.Credentials.Rotation = &PasswordRotation{Schedule: rotation.Schedule}
+	}
+	if probe := spec.ReadinessProbe; probe != nil {
+		dst.Spec.ReadinessProbe = &ProbeTimings{
+			InitialDelaySeconds: probe.InitialDelaySeconds,
+			PeriodSeconds:       probe.PeriodSeconds,
+			TimeoutSeconds:      probe.TimeoutSeconds,
+			FailureThreshold:    probe.FailureThreshold,
+		}
+	}
+
+	status := &src.Status
+	dst.Status = HanaExpressStatus{
+		Conditions:            status.Conditions,
+		Phase:                 HanaExpressPhase(status.Phase),
+		ObservedGeneration:    status.ObservedGeneration,
+		DatabaseVersion:       status.DatabaseVersion,
+		PodName:               status.PodName,
+		PVCName:               status.PVCName,
+		ReadyTime:             status.ReadyTime,
+		GlobalAllocationLimit: status.GlobalAllocationLimit,
+		Image:                 status.Image,
+		Version:               status.Version,
+		Binding:               status.Binding,
+	}
+	if endpoints := status.Endpoints; endpoints != nil {
+		dst.Status.Endpoints = &Endpoints{
+			SQL:         endpoints.SQL,
+			SystemDBSQL: endpoints.SystemDBSQL,
+			XSA:         endpoints.XSA,
+		}
+	}
+	if upgrade := status.Upgrade; upgrade != nil {
+		dst.Status.Upgrade = &UpgradeStatus{
+			FromImage:         upgrade.FromImage,
+			ToImage:           upgrade.ToImage,
+			Phase:             UpgradePhase(upgrade.Phase),
+			StartTime:         upgrade.StartTime,
+			Backup:            upgrade.Backup,
+			BackupStartTime:   upgrade.BackupStartTime,
+			BackupDatabases:   upgrade.BackupDatabases,
+			ImageUpdated:      upgrade.ImageUpdated,
+			ImageStarted:      upgrade.ImageStarted,
+			RollbackStartTime: upgrade.RollbackStartTime,
+			Restores:          upgrade.Restores,
+		}
+	}
+	if source := status.Source; source != nil {
+		dst.Status.Source = &DataSourceStatus{
+			Kind:                  source.Kind,
+			Name:                  source.Name,
+			DataSource:            source.DataSource,
+			SourceHanaExpressName: source.SourceHanaExpressName,
+			Time:                  source.Time,
+			CredentialsReset:      source.CredentialsReset,
+		}
+	}
+	if credential := status.Credential; credential != nil {
+		dst.Status.Credentials = &CredentialsStatus{
+			PasswordHash:              credential.PasswordHash,
+			LastRotationTime:          credential.LastRotationTime,
+			LastScheduledRotationTime: credential.LastScheduledRotationTime,
+			NextScheduledRotationTime: credential.NextScheduledRotationTime,
+		}
+	}
+	return nil
+}
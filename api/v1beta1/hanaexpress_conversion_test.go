@@ -0,0 +1,198 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func testHub() *v1alpha1.HanaExpress {
+	return &v1alpha1.HanaExpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "hxe", Namespace: "default"},
+		Spec:       v1alpha1.HanaExpressSpec{PVCSize: "20Gi"},
+	}
+}
+
+func TestConvertFromKeepsTheV1alpha1Fields(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       func(spec *v1alpha1.HanaExpressSpec)
+		policy     DeletionPolicy
+		annotation bool
+	}{
+		{
+			name:   "no deletion policy",
+			policy: "",
+		},
+		{
+			name:   "isDataPersisted of a resource created before the deletion policy",
+			spec:   func(spec *v1alpha1.HanaExpressSpec) { spec.IsDataPersisted = true },
+			policy: DeletionPolicyRetain, annotation: true,
+		},
+		{
+			name: "consistent deletion policy",
+			spec: func(spec *v1alpha1.HanaExpressSpec) {
+				spec.DeletionPolicy, spec.IsDataPersisted = v1alpha1.DeletionPolicyRetain, true
+			},
+			policy: DeletionPolicyRetain,
+		},
+		{
+			name:   "snapshot deletion policy",
+			spec:   func(spec *v1alpha1.HanaExpressSpec) { spec.DeletionPolicy = v1alpha1.DeletionPolicySnapshot },
+			policy: DeletionPolicySnapshot,
+		},
+		{
+			name: "deletion policy that overrides isDataPersisted",
+			spec: func(spec *v1alpha1.HanaExpressSpec) {
+				spec.DeletionPolicy, spec.IsDataPersisted = v1alpha1.DeletionPolicyDelete, true
+			},
+			policy: DeletionPolicyDelete, annotation: true,
+		},
+		{
+			name: "generate flag of the source credential",
+			spec: func(spec *v1alpha1.HanaExpressSpec) {
+				spec.Source = &v1alpha1.DataSource{HanaExpressName: "source",
+					Credential: &v1alpha1.Credential{Generate: true}}
+			},
+			annotation: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := testHub()
+			if tt.spec != nil {
+				tt.spec(&hub.Spec)
+			}
+			original := hub.DeepCopy()
+
+			spoke := &HanaExpress{}
+			if err := spoke.ConvertFrom(hub); err != nil {
+				t.Fatal(err)
+			}
+			if spoke.Spec.DeletionPolicy != tt.policy {
+				t.Errorf("deletion policy = %q, want %q", spoke.Spec.DeletionPolicy, tt.policy)
+			}
+			if _, found := spoke.Annotations[conversionDataAnnotation]; found != tt.annotation {
+				t.Errorf("annotation recorded = %t, want %t", found, tt.annotation)
+			}
+			if !apiequality.Semantic.DeepEqual(hub, original) {
+				t.Errorf("ConvertFrom changed the source: %+v", hub.ObjectMeta)
+			}
+
+			restored := &v1alpha1.HanaExpress{}
+			if err := spoke.ConvertTo(restored); err != nil {
+				t.Fatal(err)
+			}
+			if !apiequality.Semantic.DeepEqual(restored, original) {
+				t.Errorf("round trip changed the spec from %+v to %+v", original.Spec, restored.Spec)
+			}
+		})
+	}
+}
+
+func TestConvertToIgnoresTheKeptFieldsOfAChangedDeletionPolicy(t *testing.T) {
+	hub := testHub()
+	hub.Spec.IsDataPersisted = true
+
+	spoke := &HanaExpress{}
+	if err := spoke.ConvertFrom(hub); err != nil {
+		t.Fatal(err)
+	}
+	spoke.Spec.DeletionPolicy = DeletionPolicySnapshot
+
+	restored := &v1alpha1.HanaExpress{}
+	if err := spoke.ConvertTo(restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Spec.DeletionPolicy != v1alpha1.DeletionPolicySnapshot || restored.Spec.IsDataPersisted {
+		t.Errorf("deletion policy = %q, isDataPersisted = %t, want Snapshot without isDataPersisted",
+			restored.Spec.DeletionPolicy, restored.Spec.IsDataPersisted)
+	}
+	if _, found := restored.Annotations[conversionDataAnnotation]; found {
+		t.Error("the annotation is stored with the hub")
+	}
+}
+
+func TestConversionRoundTrip(t *testing.T) {
+	fuzzer := fuzz.New().NilChance(0.2).Funcs(
+		// The enums only hold the values the CRD schemas allow
+		func(policy *v1alpha1.DeletionPolicy, c fuzz.Continue) {
+			*policy = []v1alpha1.DeletionPolicy{"", v1alpha1.DeletionPolicyRetain, v1alpha1.DeletionPolicyDelete,
+				v1alpha1.DeletionPolicySnapshot}[c.Intn(4)]
+		},
+		func(policy *DeletionPolicy, c fuzz.Continue) {
+			*policy = []DeletionPolicy{"", DeletionPolicyRetain, DeletionPolicyDelete, DeletionPolicySnapshot}[c.Intn(4)]
+		},
+	)
+
+	t.Run("v1alpha1", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			hub := &v1alpha1.HanaExpress{}
+			fuzzer.Fuzz(hub)
+			// The API server sets the type of the converted object
+			hub.TypeMeta = metav1.TypeMeta{}
+			if delete(hub.Annotations, conversionDataAnnotation); len(hub.Annotations) == 0 {
+				hub.Annotations = nil
+			}
+			original := hub.DeepCopy()
+
+			spoke := &HanaExpress{}
+			if err := spoke.ConvertFrom(hub); err != nil {
+				t.Fatal(err)
+			}
+			restored := &v1alpha1.HanaExpress{}
+			if err := spoke.ConvertTo(restored); err != nil {
+				t.Fatal(err)
+			}
+			if !apiequality.Semantic.DeepEqual(restored, original) {
+				t.Fatalf("round trip changed\n%+v\nto\n%+v", original, restored)
+			}
+		}
+	})
+
+	t.Run("v1beta1", func(t *testing.T) {
+		for i := 0; i < 1000; i++ {
+			spoke := &HanaExpress{}
+			fuzzer.Fuzz(spoke)
+			// The API server sets the type of the converted object
+			spoke.TypeMeta = metav1.TypeMeta{}
+			if delete(spoke.Annotations, conversionDataAnnotation); len(spoke.Annotations) == 0 {
+				spoke.Annotations = nil
+			}
+			original := spoke.DeepCopy()
+
+			hub := &v1alpha1.HanaExpress{}
+			if err := spoke.ConvertTo(hub); err != nil {
+				t.Fatal(err)
+			}
+			restored := &HanaExpress{}
+			if err := restored.ConvertFrom(hub); err != nil {
+				t.Fatal(err)
+			}
+			if !apiequality.Semantic.DeepEqual(restored, original) {
+				t.Fatalf("round trip changed\n%+v\nto\n%+v", original, restored)
+			}
+		}
+	})
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// asyncTask is a long running operation started by a reconciler
+type asyncTask[T any] struct {
+	done   bool
+	result T
+	err    error
+}
+
+// asyncTasks runs operations that take longer than a reconcile, like uploads or recoveries,
+// in the background. Reconcile starts a task for an object once and polls its result when
+// it is requeued, so a worker is never blocked. Tasks are kept in memory only: after the
+// operator restarted, a reconciler finds no task and has to decide from the persisted status
+// whether the operation may be started again. The zero value is ready to use.
+type asyncTasks[T any] struct {
+	mu    sync.Mutex
+	tasks map[types.UID]*asyncTask[T]
+}
+
+// Start runs fn in the background for the object unless a task for it is already known.
+// fn gets a context with the logger of ctx that is not canceled when the reconcile returns,
+// only when the timeout expires.
+func (t *asyncTasks[T]) Start(ctx context.Context, uid types.UID, timeout time.Duration,
+	fn func(ctx context.Context) (T, error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tasks == nil {
+		t.tasks = map[types.UID]*asyncTask[T]{}
+	}
+	if _, ok := t.tasks[uid]; ok {
+		return
+	}
+
+	task := &asyncTask[T]{}
+	t.tasks[uid] = task
+	go func() {
+		taskCtx, cancel := context.WithTimeout(log.IntoContext(context.Background(), log.FromContext(ctx)), timeout)
+		defer cancel()
+
+		result, err := fn(taskCtx)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		task.done, task.result, task.err = true, result, err
+	}()
+}
+
+// Result reports whether a task for the object is known and whether it is done. The result
+// of a finished task is returned once and the task is forgotten.
+func (t *asyncTasks[T]) Result(uid types.UID) (started, done bool, result T, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	task, ok := t.tasks[uid]
+	if !ok {
+		return false, false, result, nil
+	}
+	if !task.done {
+		return true, false, result, nil
+	}
+	delete(t.tasks, uid)
+	return true, true, task.result, task.err
+}
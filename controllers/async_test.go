@@ -0,0 +1,101 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestAsyncTasks(t *testing.T) {
+	var tasks asyncTasks[int]
+
+	if started, _, _, _ := tasks.Result("a"); started {
+		t.Fatal("unknown task reported as started")
+	}
+
+	release := make(chan struct{})
+	runs := 0
+	for i := 0; i < 2; i++ {
+		tasks.Start(context.Background(), "a", time.Minute, func(ctx context.Context) (int, error) {
+			runs++
+			<-release
+			return 42, nil
+		})
+	}
+	if started, done, _, _ := tasks.Result("a"); !started || done {
+		t.Fatalf("started = %t, done = %t while running", started, done)
+	}
+
+	close(release)
+	var result int
+	if err := waitFor(func() bool {
+		var done bool
+		_, done, result, _ = tasks.Result("a")
+		return done
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if result != 42 || runs != 1 {
+		t.Errorf("result = %d after %d runs, want 42 after 1 run", result, runs)
+	}
+	if started, _, _, _ := tasks.Result("a"); started {
+		t.Error("the result of a finished task is returned more than once")
+	}
+}
+
+func TestAsyncTaskTimeout(t *testing.T) {
+	var tasks asyncTasks[int]
+	tasks.Start(context.Background(), "a", time.Millisecond, func(ctx context.Context) (int, error) {
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+
+	var err error
+	if waitErr := waitFor(func() bool {
+		var done bool
+		_, done, _, err = tasks.Result("a")
+		return done
+	}); waitErr != nil {
+		t.Fatal(waitErr)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// finished reports whether the task of the object is done without taking its result
+func (t *asyncTasks[T]) finished(uid types.UID) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	task, ok := t.tasks[uid]
+	return ok && task.done
+}
+
+// waitFor polls the condition until it holds or a second passed
+func waitFor(condition func() bool) error {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if condition() {
+			return nil
+		}
+	}
+	return errors.New("condition not met within a second")
+}
@@ -0,0 +1,766 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+const (
+	testNamespace = "default"
+	testPassword  = "Manager1"
+)
+
+// fakeConnector hands out the sqlmock connections set up with expectSQL in order, or fails
+// with err. The last connection is handed out again for every further Open. A logon with one
+// of the rejected passwords fails, on every port or only on the port of rejectedOn.
+type fakeConnector struct {
+	mu         sync.Mutex
+	queued     []*sql.DB
+	current    *sql.DB
+	err        error
+	rejected   []string
+	rejectedOn map[int32][]string
+}
+
+func (c *fakeConnector) Open(ctx context.Context, endpoint hdbclient.Endpoint) (*sql.DB, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return nil, c.err
+	}
+	for _, password := range append(c.rejectedOn[endpoint.Port], c.rejected...) {
+		if endpoint.Password == password {
+			return nil, sqlError{code: 10}
+		}
+	}
+	if len(c.queued) > 0 {
+		c.current, c.queued = c.queued[0], c.queued[1:]
+	}
+	return c.current, nil
+}
+
+// sqlError mimics the errors of the go-hdb driver carrying a HANA error code
+type sqlError struct {
+	code int
+}
+
+func (e sqlError) Error() string { return fmt.Sprintf("SQL Error %d", e.code) }
+func (e sqlError) Code() int     { return e.code }
+
+// sqlMatcher matches statements that are equal apart from their white space, so that the
+// expectations can be written on one line
+var sqlMatcher = sqlmock.QueryMatcherFunc(func(expected, actual string) error {
+	if strings.Join(strings.Fields(expected), " ") != strings.Join(strings.Fields(actual), " ") {
+		return fmt.Errorf("statement %q does not match %q", actual, expected)
+	}
+	return nil
+})
+
+// expectSQL queues a new sqlmock connection and returns the mock to set up its expectations
+func (c *fakeConnector) expectSQL(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlMatcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queued = append(c.queued, db)
+	return mock
+}
+
+// newFakeClient returns a client backed by an in-memory object tracker with the status
+// subresource of the operator's kinds and the VolumeSnapshot API
+func newFakeClient(objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = dbv1alpha1.AddToScheme(scheme)
+	_ = snapshotv1.AddToScheme(scheme)
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&dbv1alpha1.HanaExpress{}, &dbv1alpha1.HanaExpressBackup{},
+			&dbv1alpha1.HanaExpressBackupSchedule{}, &dbv1alpha1.HanaExpressRestore{},
+			&dbv1alpha1.HanaTenantDatabase{}, &dbv1alpha1.HanaUser{}, &dbv1alpha1.HanaRole{},
+			&dbv1alpha1.HanaGrant{}).
+		Build()
+}
+
+// testHanaExpress returns an available instance named hxe with a backup PVC that runs with the
+// master password of its Secret, and the Secret
+func testHanaExpress() (*dbv1alpha1.HanaExpress, *corev1.Secret) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hxe-credential", Namespace: testNamespace},
+		Data:       map[string][]byte{"password": []byte(testPassword)},
+	}
+	hanaExpress := &dbv1alpha1.HanaExpress{
+		ObjectMeta: metav1.ObjectMeta{Name: "hxe", Namespace: testNamespace, UID: "hxe-uid"},
+		Spec: dbv1alpha1.HanaExpressSpec{
+			PVCSize:       "20Gi",
+			BackupPVCSize: "40Gi",
+			Credential: dbv1alpha1.Credential{
+				SecretKeyRef: corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name},
+					Key:                  "password",
+				},
+			},
+		},
+	}
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
+		Status: metav1.ConditionTrue, Reason: "Running"})
+	hanaExpress.Status.Credential = &dbv1alpha1.CredentialStatus{PasswordHash: credentialHash(hanaExpress, testPassword)}
+	return hanaExpress, secret
+}
+
+// newTestRecorder returns a recorder that keeps the events for the test
+func newTestRecorder() *record.FakeRecorder {
+	return record.NewFakeRecorder(100)
+}
+
+// reconcileObject reconciles the object named name with r, checks that the statements expected
+// on mock, if any, were sent, and reads the object back into obj. It returns false once the
+// object is gone.
+func reconcileObject(t *testing.T, r reconcile.Reconciler, c client.Client, name string, obj client.Object,
+	mock sqlmock.Sqlmock) (ctrl.Result, bool) {
+	t.Helper()
+	key := types.NamespacedName{Name: name, Namespace: testNamespace}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if mock != nil {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Get(context.Background(), key, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return result, false
+		}
+		t.Fatal(err)
+	}
+	return result, true
+}
+
+const (
+	backupCatalogQuery = "SELECT TOP 1 C.BACKUP_ID, C.STATE_NAME FROM SYS_DATABASES.M_BACKUP_CATALOG C " +
+		"WHERE C.DATABASE_NAME = ? AND C.ENTRY_TYPE_NAME = 'complete data backup' " +
+		"AND EXISTS (SELECT 1 FROM SYS_DATABASES.M_BACKUP_CATALOG_FILES F " +
+		"WHERE F.DATABASE_NAME = C.DATABASE_NAME AND F.BACKUP_ID = C.BACKUP_ID AND F.DESTINATION_PATH LIKE ?) " +
+		"ORDER BY C.SYS_START_TIME DESC"
+	backupSizeQuery = "SELECT COALESCE(SUM(BACKUP_SIZE), 0) FROM SYS_DATABASES.M_BACKUP_CATALOG_FILES " +
+		"WHERE DATABASE_NAME = ? AND BACKUP_ID = ?"
+	backupStateQuery      = "SELECT STATE_NAME FROM SYS_DATABASES.M_BACKUP_CATALOG WHERE DATABASE_NAME = ? AND BACKUP_ID = ?"
+	testBackupDestination = "/hana/backup/nightly/hxe"
+)
+
+const (
+	testBucket     = "backups"
+	testBackupFile = "/hana/backup/nightly/hxe_databackup_0_1"
+	testBackupKey  = "hana/default/hxe/nightly/hxe_databackup_0_1"
+)
+
+// testBackup returns a file backup named nightly of the HXE tenant of the hxe instance
+func testBackup() *dbv1alpha1.HanaExpressBackup {
+	return &dbv1alpha1.HanaExpressBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: testNamespace, UID: "backup-uid"},
+		Spec:       dbv1alpha1.HanaExpressBackupSpec{HanaExpressName: "hxe", DatabaseName: "HXE"},
+	}
+}
+
+// testObjectStorageBackup returns a backup of hxe to the fake S3 endpoint and its credentials Secret
+func testObjectStorageBackup(endpoint string) (*dbv1alpha1.HanaExpressBackup, *corev1.Secret) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "s3-credentials", Namespace: testNamespace},
+		Data: map[string][]byte{
+			objectStorageAccessKeyIDKey:     []byte("minio"),
+			objectStorageSecretAccessKeyKey: []byte("minio123"),
+		},
+	}
+	backup := &dbv1alpha1.HanaExpressBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: testNamespace},
+		Spec: dbv1alpha1.HanaExpressBackupSpec{
+			HanaExpressName: "hxe",
+			ObjectStorage: &dbv1alpha1.ObjectStorage{
+				Endpoint:             endpoint,
+				Bucket:               testBucket,
+				Prefix:               "hana",
+				Region:               "us-east-1",
+				Insecure:             true,
+				CredentialsSecretRef: corev1.LocalObjectReference{Name: secret.Name},
+			},
+		},
+	}
+	return backup, secret
+}
+
+// newTestBackupReconciler returns a backup reconciler with a fake connector and a fake executor on
+// the objects and the instance, or on the available hxe instance when it is nil
+func newTestBackupReconciler(hanaExpress *dbv1alpha1.HanaExpress, objects ...client.Object) (*HanaExpressBackupReconciler, *fakeConnector) {
+	if hanaExpress == nil {
+		hanaExpress, _ = testHanaExpress()
+	}
+	_, secret := testHanaExpress()
+	c := newFakeClient(append(objects, hanaExpress, secret)...)
+	connector := &fakeConnector{}
+	return &HanaExpressBackupReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: connector,
+		Exec: &fakeExecutor{}}, connector
+}
+
+// reconcileBackup reconciles the nightly backup and returns the stored resource, or nil once it is gone
+func reconcileBackup(t *testing.T, r *HanaExpressBackupReconciler, mock sqlmock.Sqlmock) (ctrl.Result, *dbv1alpha1.HanaExpressBackup) {
+	t.Helper()
+	backup := &dbv1alpha1.HanaExpressBackup{}
+	result, found := reconcileObject(t, r, r.Client, "nightly", backup, mock)
+	if !found {
+		return result, nil
+	}
+	return result, backup
+}
+
+// deleteBackup deletes the backup that carries the finalizer, so the reconcile finalizes it
+func deleteBackup(t *testing.T, c client.Client) {
+	t.Helper()
+	backup := &dbv1alpha1.HanaExpressBackup{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "nightly", Namespace: testNamespace}, backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(context.Background(), backup); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectBackupInCatalog returns the entry of the nightly backup and its size from the backup catalog
+func expectBackupInCatalog(mock sqlmock.Sqlmock, id int64, state string, size int64) {
+	mock.ExpectQuery(backupCatalogQuery).WithArgs("HXE", testBackupDestination+"%").
+		WillReturnRows(sqlmock.NewRows([]string{"BACKUP_ID", "STATE_NAME"}).AddRow(id, state))
+	mock.ExpectQuery(backupSizeQuery).WithArgs("HXE", id).
+		WillReturnRows(sqlmock.NewRows([]string{"SIZE"}).AddRow(size))
+}
+
+// expectBackupNotInCatalog returns no entry of the nightly backup from the backup catalog
+func expectBackupNotInCatalog(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(backupCatalogQuery).WithArgs("HXE", testBackupDestination+"%").
+		WillReturnRows(sqlmock.NewRows([]string{"BACKUP_ID", "STATE_NAME"}))
+}
+
+// fakeS3 is an in-memory S3 endpoint with one bucket that accepts path-style PUT, HEAD and
+// DELETE requests without checking their signatures. A PUT can be set to fail after the
+// body was read, like an upload that broke off and left a partial object behind.
+type fakeS3 struct {
+	mu       sync.Mutex
+	objects  map[string][]byte
+	failPuts bool
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, string) {
+	t.Helper()
+	s3 := &fakeS3{objects: map[string][]byte{}}
+	server := httptest.NewServer(s3)
+	t.Cleanup(server.Close)
+	return s3, strings.TrimPrefix(server.URL, "http://")
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	if bucket != testBucket {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case req.Method == http.MethodHead && key == "":
+		w.WriteHeader(http.StatusOK)
+	case req.Method == http.MethodPut && key != "":
+		body, err := readS3Body(req)
+		if err != nil || s.failPuts {
+			// A client error is not retried
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		s.objects[key] = body
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+	case req.Method == http.MethodDelete && key != "":
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// readS3Body reads the payload of a PUT, which clients send in aws-chunked encoding over http
+func readS3Body(req *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(req.Body)
+	}
+
+	var body []byte
+	reader := bufio.NewReader(req.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(header), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, chunk); err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return body, nil
+		}
+		body = append(body, chunk[:size]...)
+	}
+}
+
+func (s *fakeS3) object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	object, ok := s.objects[key]
+	return object, ok
+}
+
+// fakeExecutor serves the stat and cat commands the upload runs in the HanaExpress pod from
+// in-memory files, and records the directories removed with rm. The checksum reported for a
+// file can differ from its content.
+type fakeExecutor struct {
+	files     map[string]string
+	checksums map[string]string
+	removed   []string
+}
+
+func (e *fakeExecutor) Exec(ctx context.Context, namespace, pod, container string, command []string, stdout io.Writer) error {
+	file := command[len(command)-1]
+	if command[0] == "rm" {
+		e.removed = append(e.removed, file)
+		return nil
+	}
+	content, ok := e.files[file]
+	if !ok {
+		return fmt.Errorf("%s: no such file", file)
+	}
+
+	if command[0] == "cat" {
+		_, err := io.WriteString(stdout, content)
+		return err
+	}
+	checksum, ok := e.checksums[file]
+	if !ok {
+		sum := sha256.Sum256([]byte(content))
+		checksum = hex.EncodeToString(sum[:])
+	}
+	_, err := fmt.Fprintf(stdout, "%d\n%s  %s\n", len(content), checksum, file)
+	return err
+}
+
+const (
+	databaseStatusQuery = "SELECT ACTIVE_STATUS FROM M_DATABASES WHERE DATABASE_NAME = ?"
+	recoverStatement    = `RECOVER DATA FOR "HXE" USING FILE ('/hana/backup/nightly/hxe') CLEAR LOG`
+)
+
+// testRestore returns a restore of the HXE tenant of the hxe instance from the nightly backup
+func testRestore(name string) *dbv1alpha1.HanaExpressRestore {
+	return &dbv1alpha1.HanaExpressRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, UID: types.UID(name + "-uid")},
+		Spec: dbv1alpha1.HanaExpressRestoreSpec{HanaExpressName: "hxe", DatabaseName: "HXE",
+			Source: dbv1alpha1.RestoreSource{Path: "/hana/backup/nightly/hxe"}},
+	}
+}
+
+// testSystemRestore returns a restore of the SystemDB from the pre-upgrade backup
+func testSystemRestore() *dbv1alpha1.HanaExpressRestore {
+	restore := testRestore("system")
+	restore.Spec.DatabaseName = "SYSTEMDB"
+	restore.Spec.Source = dbv1alpha1.RestoreSource{Path: "pre-upgrade-20231001"}
+	return restore
+}
+
+// newTestRestoreReconciler returns a restore reconciler with a fake connector on the objects and
+// the instance, or on the available hxe instance when it is nil
+func newTestRestoreReconciler(hanaExpress *dbv1alpha1.HanaExpress,
+	objects ...client.Object) (*HanaExpressRestoreReconciler, *fakeConnector) {
+	if hanaExpress == nil {
+		hanaExpress, _ = testHanaExpress()
+	}
+	_, secret := testHanaExpress()
+	c := newFakeClient(append(objects, hanaExpress, secret)...)
+	connector := &fakeConnector{}
+	return &HanaExpressRestoreReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: connector,
+		APIReader: c}, connector
+}
+
+// reconcileRestore reconciles the named restore and returns the stored resource, or nil once it is gone
+func reconcileRestore(t *testing.T, r *HanaExpressRestoreReconciler, name string) (ctrl.Result, *dbv1alpha1.HanaExpressRestore) {
+	t.Helper()
+	restore := &dbv1alpha1.HanaExpressRestore{}
+	result, found := reconcileObject(t, r, r.Client, name, restore, nil)
+	if !found {
+		return result, nil
+	}
+	return result, restore
+}
+
+// restoreLeaseHolder returns the restore holding the restore lease of the hxe instance
+func restoreLeaseHolder(t *testing.T, r *HanaExpressRestoreReconciler) string {
+	t.Helper()
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: "hxe", Namespace: testNamespace}, hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	return hanaExpress.Annotations[restoreLeaseAnnotation]
+}
+
+// setRecoveryJobCondition sets a condition on the recovery Job of the system restore
+func setRecoveryJobCondition(t *testing.T, r *HanaExpressRestoreReconciler, conditionType batchv1.JobConditionType) {
+	t.Helper()
+	job := &batchv1.Job{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: "system-recovery", Namespace: testNamespace}, job); err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{Type: conditionType,
+		Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"})
+	if err := r.Status().Update(context.Background(), job); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectDatabaseStatus returns the status of the HXE tenant from M_DATABASES
+func expectDatabaseStatus(mock sqlmock.Sqlmock, status string) {
+	mock.ExpectQuery(databaseStatusQuery).WithArgs("HXE").
+		WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}).AddRow(status))
+}
+
+// testSchedule returns an hourly backup schedule of the HXE tenant of the hxe instance created at the given time
+func testSchedule(created time.Time) *dbv1alpha1.HanaExpressBackupSchedule {
+	return &dbv1alpha1.HanaExpressBackupSchedule{
+		ObjectMeta: metav1.ObjectMeta{Name: "hourly", Namespace: testNamespace, UID: "schedule-uid",
+			CreationTimestamp: metav1.Time{Time: created}},
+		Spec: dbv1alpha1.HanaExpressBackupScheduleSpec{HanaExpressName: "hxe", DatabaseName: "HXE", Schedule: "0 * * * *",
+			ConcurrencyPolicy: dbv1alpha1.ConcurrencyPolicyForbid},
+	}
+}
+
+// scheduledBackup returns a backup of the schedule that finished at the given time
+func scheduledBackup(t *testing.T, schedule *dbv1alpha1.HanaExpressBackupSchedule, name string,
+	phase dbv1alpha1.BackupPhase, finished time.Time, id int64) *dbv1alpha1.HanaExpressBackup {
+	t.Helper()
+	backup := &dbv1alpha1.HanaExpressBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace,
+			Labels: map[string]string{backupScheduleLabel: schedule.Name}},
+		Spec: dbv1alpha1.HanaExpressBackupSpec{HanaExpressName: "hxe", DatabaseName: "HXE"},
+		Status: dbv1alpha1.HanaExpressBackupStatus{Phase: phase, BackupID: id,
+			CompletionTime: &metav1.Time{Time: finished}},
+	}
+	if err := ctrl.SetControllerReference(schedule, backup, newFakeClient().Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	return backup
+}
+
+// newTestScheduleReconciler returns a schedule reconciler on the schedule and the objects
+func newTestScheduleReconciler(schedule *dbv1alpha1.HanaExpressBackupSchedule,
+	objects ...client.Object) *HanaExpressBackupScheduleReconciler {
+	c := newFakeClient(append(objects, schedule)...)
+	return &HanaExpressBackupScheduleReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+}
+
+// reconcileSchedule reconciles the hourly schedule and returns the stored resource
+func reconcileSchedule(t *testing.T, r *HanaExpressBackupScheduleReconciler) (ctrl.Result, *dbv1alpha1.HanaExpressBackupSchedule) {
+	t.Helper()
+	schedule := &dbv1alpha1.HanaExpressBackupSchedule{}
+	result, found := reconcileObject(t, r, r.Client, "hourly", schedule, nil)
+	if !found {
+		t.Fatal("the schedule is gone")
+	}
+	return result, schedule
+}
+
+// backupNames returns the names of the backups in the namespace in order
+func backupNames(t *testing.T, c client.Client) []string {
+	t.Helper()
+	backups := &dbv1alpha1.HanaExpressBackupList{}
+	if err := c.List(context.Background(), backups, client.InNamespace(testNamespace)); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, backup := range backups.Items {
+		names = append(names, backup.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scheduleCondition returns the status and the reason of the Scheduled condition
+func scheduleCondition(schedule *dbv1alpha1.HanaExpressBackupSchedule) (metav1.ConditionStatus, string) {
+	condition := meta.FindStatusCondition(schedule.Status.Conditions, typeScheduledHanaExpressBackupSchedule)
+	if condition == nil {
+		return metav1.ConditionUnknown, ""
+	}
+	return condition.Status, condition.Reason
+}
+
+const testTenantPassword = "Tenant1"
+
+// testTenant returns a HanaTenantDatabase named sales on the hxe instance and its credential Secret
+func testTenant() (*dbv1alpha1.HanaTenantDatabase, *corev1.Secret) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "sales-credential", Namespace: testNamespace},
+		Data:       map[string][]byte{"password": []byte(testTenantPassword)},
+	}
+	tenant := &dbv1alpha1.HanaTenantDatabase{
+		ObjectMeta: metav1.ObjectMeta{Name: "sales", Namespace: testNamespace, UID: "sales-uid"},
+		Spec: dbv1alpha1.HanaTenantDatabaseSpec{
+			HanaExpressName: "hxe",
+			DatabaseName:    "sales",
+			Credential: dbv1alpha1.Credential{
+				SecretKeyRef: corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: secret.Name},
+					Key:                  "password",
+				},
+			},
+		},
+	}
+	return tenant, secret
+}
+
+// managedTenant marks the tenant as created by the resource with the current credential
+func managedTenant(tenant *dbv1alpha1.HanaTenantDatabase, secret *corev1.Secret) {
+	tenant.Finalizers = []string{hanaExpressFinalizer}
+	tenant.Status.DatabaseName = "SALES"
+	tenant.Status.Phase = dbv1alpha1.TenantPhaseReady
+	secret.ResourceVersion = "1"
+	tenant.Status.CredentialVersion = secret.ResourceVersion
+}
+
+// newTestTenantReconciler returns a tenant database reconciler with a fake connector on the objects
+func newTestTenantReconciler(objects ...client.Object) (*HanaTenantDatabaseReconciler, *fakeConnector) {
+	connector := &fakeConnector{}
+	c := newFakeClient(objects...)
+	return &HanaTenantDatabaseReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: connector}, connector
+}
+
+// reconcileTenant reconciles the sales tenant and returns the stored resource, or nil once it is gone
+func reconcileTenant(t *testing.T, r *HanaTenantDatabaseReconciler, mock sqlmock.Sqlmock) (ctrl.Result, *dbv1alpha1.HanaTenantDatabase) {
+	t.Helper()
+	tenant := &dbv1alpha1.HanaTenantDatabase{}
+	result, found := reconcileObject(t, r, r.Client, "sales", tenant, mock)
+	if !found {
+		return result, nil
+	}
+	return result, tenant
+}
+
+// expectTenantState returns the status of the SALES tenant from M_DATABASES, or no row when active is empty
+func expectTenantState(mock sqlmock.Sqlmock, active string) {
+	query := mock.ExpectQuery(`SELECT ACTIVE_STATUS FROM M_DATABASES WHERE DATABASE_NAME = ?`).WithArgs("SALES")
+	if active == "" {
+		query.WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}))
+		return
+	}
+	query.WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}).AddRow(active))
+}
+
+// expectTenantPort returns the SQL port of the indexserver of the SALES tenant
+func expectTenantPort(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT SQL_PORT FROM SYS_DATABASES.M_SERVICES
+		WHERE DATABASE_NAME = ? AND SERVICE_NAME = 'indexserver' AND SQL_PORT > 0`).
+		WithArgs("SALES").
+		WillReturnRows(sqlmock.NewRows([]string{"SQL_PORT"}).AddRow(30041))
+}
+
+// expectTenantExec expects the statement on the SystemDB
+func expectTenantExec(mock sqlmock.Sqlmock, stmt string) {
+	mock.ExpectExec(stmt).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// tenantReady returns the Ready condition of the tenant
+func tenantReady(tenant *dbv1alpha1.HanaTenantDatabase) *metav1.Condition {
+	return meta.FindStatusCondition(tenant.Status.Conditions, typeReadyHanaTenantDatabase)
+}
+
+const testUserPassword = "Generated1"
+
+// testUser returns a HanaUser named app in the HXE tenant of the hxe instance and the
+// credentials Secret it generated
+func testUser(t *testing.T) (*dbv1alpha1.HanaUser, *corev1.Secret) {
+	t.Helper()
+	user := &dbv1alpha1.HanaUser{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: testNamespace, UID: "app-uid"},
+		Spec:       dbv1alpha1.HanaUserSpec{HanaExpressName: "hxe", UserName: "app"},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-credentials", Namespace: testNamespace},
+		Data: map[string][]byte{
+			"username": []byte("APP"),
+			"password": []byte(testUserPassword),
+			"host":     []byte("hxe.default.svc"),
+			"port":     []byte("39017"),
+			"database": []byte("HXE"),
+		},
+	}
+	if err := ctrl.SetControllerReference(user, secret, newFakeClient().Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	return user, secret
+}
+
+// managedUser marks the user as created by the resource
+func managedUser(user *dbv1alpha1.HanaUser) {
+	user.Finalizers = []string{hanaExpressFinalizer}
+	user.Status.UserName = "APP"
+	user.Status.DatabaseName = "HXE"
+}
+
+// newTestUserReconciler returns a user reconciler with a fake connector on the objects
+func newTestUserReconciler(objects ...client.Object) (*HanaUserReconciler, *fakeConnector) {
+	connector := &fakeConnector{}
+	c := newFakeClient(objects...)
+	return &HanaUserReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: connector}, connector
+}
+
+// reconcileUser reconciles the app user and returns the stored resource, or nil once it is gone
+func reconcileUser(t *testing.T, r *HanaUserReconciler, mock sqlmock.Sqlmock) (ctrl.Result, *dbv1alpha1.HanaUser) {
+	t.Helper()
+	user := &dbv1alpha1.HanaUser{}
+	result, found := reconcileObject(t, r, r.Client, "app", user, mock)
+	if !found {
+		return result, nil
+	}
+	return result, user
+}
+
+// expectUserState returns the row of SYS.USERS for the user, or none when state is nil
+func expectUserState(mock sqlmock.Sqlmock, state *hanaUserState) {
+	rows := sqlmock.NewRows([]string{"USER_DEACTIVATED", "IS_PASSWORD_LIFETIME_CHECK_ENABLED", "USERGROUP_NAME"})
+	if state != nil {
+		usergroup := any(nil)
+		if state.usergroup != "" {
+			usergroup = state.usergroup
+		}
+		rows.AddRow(sqlBool(state.deactivated), sqlBool(state.passwordLifetimeCheck), usergroup)
+	}
+	mock.ExpectQuery(`SELECT USER_DEACTIVATED, IS_PASSWORD_LIFETIME_CHECK_ENABLED, USERGROUP_NAME
+		FROM SYS.USERS WHERE USER_NAME = ?`).WithArgs("APP").WillReturnRows(rows)
+}
+
+// sqlBool returns the value of a HANA BOOLEAN column
+func sqlBool(value bool) string {
+	if value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// userReady returns the Ready condition of the user
+func userReady(user *dbv1alpha1.HanaUser) *metav1.Condition {
+	return meta.FindStatusCondition(user.Status.Conditions, typeReadyHanaUser)
+}
+
+// testGrant returns a HanaGrant named app-reader of SELECT on the SALES schema to the app user
+func testGrant() *dbv1alpha1.HanaGrant {
+	return &dbv1alpha1.HanaGrant{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-reader", Namespace: testNamespace, UID: "grant-uid"},
+		Spec: dbv1alpha1.HanaGrantSpec{
+			HanaExpressName: "hxe",
+			Grantee:         "app",
+			Privileges: dbv1alpha1.Privileges{
+				SchemaPrivileges: []dbv1alpha1.SchemaPrivilege{{Schema: "SALES", Privileges: []dbv1alpha1.PrivilegeName{"SELECT"}}},
+			},
+		},
+	}
+}
+
+// newTestGrantReconciler returns a grant reconciler with a fake connector on the objects
+func newTestGrantReconciler(objects ...client.Object) (*HanaGrantReconciler, *fakeConnector) {
+	connector := &fakeConnector{}
+	c := newFakeClient(objects...)
+	return &HanaGrantReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: connector}, connector
+}
+
+// reconcileGrant reconciles the app-reader grant and returns the stored resource, or nil once it is gone
+func reconcileGrant(t *testing.T, r *HanaGrantReconciler, mock sqlmock.Sqlmock) (ctrl.Result, *dbv1alpha1.HanaGrant) {
+	t.Helper()
+	grant := &dbv1alpha1.HanaGrant{}
+	result, found := reconcileObject(t, r, r.Client, "app-reader", grant, mock)
+	if !found {
+		return result, nil
+	}
+	return result, grant
+}
+
+// expectGrantedPrivileges returns the roles and the privileges of the catalog views, a
+// privilege is a row of OBJECT_TYPE, SCHEMA_NAME, OBJECT_NAME and PRIVILEGE
+func expectGrantedPrivileges(mock sqlmock.Sqlmock, grantee string, roles []string, privileges [][]driver.Value) {
+	roleRows := sqlmock.NewRows([]string{"ROLE_NAME"})
+	for _, role := range roles {
+		roleRows.AddRow(role)
+	}
+	mock.ExpectQuery(`SELECT ROLE_NAME FROM GRANTED_ROLES WHERE GRANTEE = ? AND GRANTOR = ? AND ROLE_SCHEMA_NAME IS NULL`).
+		WithArgs(grantee, hanaSystemUser).WillReturnRows(roleRows)
+
+	privilegeRows := sqlmock.NewRows([]string{"OBJECT_TYPE", "SCHEMA_NAME", "OBJECT_NAME", "PRIVILEGE"})
+	for _, privilege := range privileges {
+		privilegeRows.AddRow(privilege...)
+	}
+	mock.ExpectQuery(`SELECT OBJECT_TYPE, SCHEMA_NAME, OBJECT_NAME, PRIVILEGE FROM GRANTED_PRIVILEGES
+		WHERE GRANTEE = ? AND GRANTOR = ?`).WithArgs(grantee, hanaSystemUser).WillReturnRows(privilegeRows)
+}
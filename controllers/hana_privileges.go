@@ -0,0 +1,253 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+// privilegeKind tells how a privilege is granted
+type privilegeKind string
+
+const (
+	privilegeKindRole   privilegeKind = "ROLE"
+	privilegeKindSystem privilegeKind = "SYSTEM"
+	privilegeKindSchema privilegeKind = "SCHEMA"
+	privilegeKindObject privilegeKind = "OBJECT"
+)
+
+// privilegeNamePattern matches the privilege keywords that are written into statements
+// unquoted, the CRD enforces the same pattern
+var privilegeNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_ ]*$`)
+
+// privilegeGrant is a single role or privilege granted to a grantee
+type privilegeGrant struct {
+	kind      privilegeKind
+	privilege string
+	schema    string
+	object    string
+}
+
+// String returns the form of the grant reported in the status, which also identifies it
+func (g privilegeGrant) String() string {
+	switch g.kind {
+	case privilegeKindRole:
+		return "ROLE " + g.privilege
+	case privilegeKindSchema:
+		return fmt.Sprintf("%s ON SCHEMA %s", g.privilege, g.schema)
+	case privilegeKindObject:
+		return fmt.Sprintf("%s ON %s.%s", g.privilege, g.schema, g.object)
+	}
+	return g.privilege
+}
+
+// target returns the privilege and object part of the GRANT and REVOKE statements
+func (g privilegeGrant) target() string {
+	switch g.kind {
+	case privilegeKindRole:
+		return hdbclient.QuoteIdentifier(g.privilege)
+	case privilegeKindSchema:
+		return fmt.Sprintf("%s ON SCHEMA %s", g.privilege, hdbclient.QuoteIdentifier(g.schema))
+	case privilegeKindObject:
+		return fmt.Sprintf("%s ON %s.%s", g.privilege, hdbclient.QuoteIdentifier(g.schema), hdbclient.QuoteIdentifier(g.object))
+	}
+	return g.privilege
+}
+
+// grantStatement returns the statement granting the privilege to the grantee
+func (g privilegeGrant) grantStatement(grantee string) string {
+	return fmt.Sprintf("GRANT %s TO %s", g.target(), hdbclient.QuoteIdentifier(grantee))
+}
+
+// revokeStatement returns the statement revoking the privilege from the grantee
+func (g privilegeGrant) revokeStatement(grantee string) string {
+	return fmt.Sprintf("REVOKE %s FROM %s", g.target(), hdbclient.QuoteIdentifier(grantee))
+}
+
+// desiredPrivilegeGrants flattens the declared privileges and rejects privilege names that
+// are not plain keywords
+func desiredPrivilegeGrants(privileges dbv1alpha1.Privileges) ([]privilegeGrant, error) {
+	grants := []privilegeGrant{}
+	add := func(grant privilegeGrant) error {
+		if grant.kind != privilegeKindRole {
+			grant.privilege = strings.Join(strings.Fields(strings.ToUpper(grant.privilege)), " ")
+			if !privilegeNamePattern.MatchString(grant.privilege) {
+				return fmt.Errorf("invalid privilege %q", grant.privilege)
+			}
+		}
+		grants = append(grants, grant)
+		return nil
+	}
+
+	for _, role := range privileges.Roles {
+		if err := add(privilegeGrant{kind: privilegeKindRole, privilege: role}); err != nil {
+			return nil, err
+		}
+	}
+	for _, privilege := range privileges.SystemPrivileges {
+		if err := add(privilegeGrant{kind: privilegeKindSystem, privilege: string(privilege)}); err != nil {
+			return nil, err
+		}
+	}
+	for _, schema := range privileges.SchemaPrivileges {
+		for _, privilege := range schema.Privileges {
+			if err := add(privilegeGrant{kind: privilegeKindSchema, privilege: string(privilege), schema: schema.Schema}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, object := range privileges.ObjectPrivileges {
+		for _, privilege := range object.Privileges {
+			if err := add(privilegeGrant{kind: privilegeKindObject, privilege: string(privilege),
+				schema: object.Schema, object: object.Object}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return grants, nil
+}
+
+// grantedPrivileges returns the roles and privileges SYSTEM granted to the grantee according
+// to the GRANTED_ROLES and GRANTED_PRIVILEGES catalog views. Privileges granted by other users,
+// such as those on the own schema of a user, are not managed by the operator.
+func grantedPrivileges(ctx context.Context, db *sql.DB, grantee string) (map[string]privilegeGrant, error) {
+	granted := map[string]privilegeGrant{}
+
+	rows, err := db.QueryContext(ctx, `SELECT ROLE_NAME FROM GRANTED_ROLES
+		WHERE GRANTEE = ? AND GRANTOR = ? AND ROLE_SCHEMA_NAME IS NULL`, grantee, hanaSystemUser)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the roles of %s: %w", grantee, err)
+	}
+	for rows.Next() {
+		grant := privilegeGrant{kind: privilegeKindRole}
+		if err := rows.Scan(&grant.privilege); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		granted[grant.String()] = grant
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.QueryContext(ctx, `SELECT OBJECT_TYPE, SCHEMA_NAME, OBJECT_NAME, PRIVILEGE FROM GRANTED_PRIVILEGES
+		WHERE GRANTEE = ? AND GRANTOR = ?`, grantee, hanaSystemUser)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the privileges of %s: %w", grantee, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var objectType string
+		var schema, object sql.NullString
+		grant := privilegeGrant{}
+		if err := rows.Scan(&objectType, &schema, &object, &grant.privilege); err != nil {
+			return nil, err
+		}
+		switch objectType {
+		case "SYSTEMPRIVILEGE":
+			grant.kind = privilegeKindSystem
+		case "SCHEMA":
+			grant.kind = privilegeKindSchema
+			grant.schema = schema.String
+		default:
+			grant.kind = privilegeKindObject
+			grant.schema = schema.String
+			grant.object = object.String
+		}
+		granted[grant.String()] = grant
+	}
+	return granted, rows.Err()
+}
+
+// syncPrivileges grants the desired privileges the grantee is missing and revokes the granted
+// ones that are not desired and for which revocable returns true. Failed statements do not stop
+// the synchronization, they are returned with the privileges granted by the resource afterwards.
+func syncPrivileges(ctx context.Context, db *sql.DB, grantee string, desired []privilegeGrant,
+	revocable func(key string) bool) (dbv1alpha1.PrivilegeSyncStatus, error) {
+	result := dbv1alpha1.PrivilegeSyncStatus{}
+
+	granted, err := grantedPrivileges(ctx, db, grantee)
+	if err != nil {
+		return result, err
+	}
+
+	applied := map[string]bool{}
+	wanted := map[string]bool{}
+	for _, grant := range desired {
+		key := grant.String()
+		wanted[key] = true
+		if _, ok := granted[key]; ok {
+			applied[key] = true
+			continue
+		}
+		stmt := grant.grantStatement(grantee)
+		log.FromContext(ctx).Info("Granting privilege", "Grantee", grantee, "Privilege", key)
+		if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+			result.FailedStatements = append(result.FailedStatements, dbv1alpha1.FailedStatement{Statement: stmt, Error: err.Error()})
+			continue
+		}
+		applied[key] = true
+	}
+
+	keys := make([]string, 0, len(granted))
+	for key := range granted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if wanted[key] || !revocable(key) {
+			continue
+		}
+		grant := granted[key]
+		stmt := grant.revokeStatement(grantee)
+		log.FromContext(ctx).Info("Revoking privilege", "Grantee", grantee, "Privilege", key)
+		if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+			// The privilege is still granted and is revoked again on the next synchronization
+			result.FailedStatements = append(result.FailedStatements, dbv1alpha1.FailedStatement{Statement: stmt, Error: err.Error()})
+			applied[key] = true
+		}
+	}
+
+	for key := range applied {
+		result.Applied = append(result.Applied, key)
+	}
+	sort.Strings(result.Applied)
+	return result, nil
+}
+
+// privilegeSyncCondition returns the condition reporting the result of a synchronization
+func privilegeSyncCondition(conditionType string, result dbv1alpha1.PrivilegeSyncStatus) metav1.Condition {
+	if len(result.FailedStatements) > 0 {
+		return metav1.Condition{Type: conditionType, Status: metav1.ConditionFalse, Reason: "StatementsFailed",
+			Message: fmt.Sprintf("%d statements failed, the first one: %s: %s", len(result.FailedStatements),
+				result.FailedStatements[0].Statement, result.FailedStatements[0].Error)}
+	}
+	return metav1.Condition{Type: conditionType, Status: metav1.ConditionTrue, Reason: "Synced",
+		Message: fmt.Sprintf("%d roles and privileges are granted", len(result.Applied))}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+func TestDesiredPrivilegeGrants(t *testing.T) {
+	tests := []struct {
+		name       string
+		privileges dbv1alpha1.Privileges
+		want       []string
+		statements []string
+		wantErr    bool
+	}{
+		{
+			name: "all kinds",
+			privileges: dbv1alpha1.Privileges{
+				Roles:            []string{"Monitoring"},
+				SystemPrivileges: []dbv1alpha1.PrivilegeName{"catalog  read"},
+				SchemaPrivileges: []dbv1alpha1.SchemaPrivilege{{Schema: "Sales", Privileges: []dbv1alpha1.PrivilegeName{"SELECT", "insert"}}},
+				ObjectPrivileges: []dbv1alpha1.ObjectPrivilege{{Schema: "SALES", Object: "Orders", Privileges: []dbv1alpha1.PrivilegeName{"UPDATE"}}},
+			},
+			want: []string{"ROLE Monitoring", "CATALOG READ", "SELECT ON SCHEMA Sales", "INSERT ON SCHEMA Sales",
+				"UPDATE ON SALES.Orders"},
+			statements: []string{
+				`GRANT "Monitoring" TO "APP"`,
+				`GRANT CATALOG READ TO "APP"`,
+				`GRANT SELECT ON SCHEMA "Sales" TO "APP"`,
+				`GRANT INSERT ON SCHEMA "Sales" TO "APP"`,
+				`GRANT UPDATE ON "SALES"."Orders" TO "APP"`,
+			},
+		},
+		{
+			name: "quoted identifiers",
+			privileges: dbv1alpha1.Privileges{
+				Roles:            []string{`A"B`},
+				SchemaPrivileges: []dbv1alpha1.SchemaPrivilege{{Schema: `S"1`, Privileges: []dbv1alpha1.PrivilegeName{"SELECT"}}},
+			},
+			want:       []string{`ROLE A"B`, `SELECT ON SCHEMA S"1`},
+			statements: []string{`GRANT "A""B" TO "APP"`, `GRANT SELECT ON SCHEMA "S""1" TO "APP"`},
+		},
+		{
+			name:       "nothing",
+			privileges: dbv1alpha1.Privileges{},
+			want:       []string{},
+			statements: []string{},
+		},
+		{
+			name:       "statement in a system privilege",
+			privileges: dbv1alpha1.Privileges{SystemPrivileges: []dbv1alpha1.PrivilegeName{"USER ADMIN TO PUBLIC;"}},
+			wantErr:    true,
+		},
+		{
+			name: "statement in an object privilege",
+			privileges: dbv1alpha1.Privileges{ObjectPrivileges: []dbv1alpha1.ObjectPrivilege{{Schema: "SALES", Object: "ORDERS",
+				Privileges: []dbv1alpha1.PrivilegeName{"SELECT ON X TO PUBLIC --"}}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			grants, err := desiredPrivilegeGrants(tt.privileges)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			keys := []string{}
+			statements := []string{}
+			for _, grant := range grants {
+				keys = append(keys, grant.String())
+				statements = append(statements, grant.grantStatement("APP"))
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("grants = %q, want %q", keys, tt.want)
+			}
+			if !reflect.DeepEqual(statements, tt.statements) {
+				t.Errorf("statements = %q, want %q", statements, tt.statements)
+			}
+		})
+	}
+}
+
+func TestSyncPrivileges(t *testing.T) {
+	desired, err := desiredPrivilegeGrants(dbv1alpha1.Privileges{
+		Roles:            []string{"MONITORING"},
+		SystemPrivileges: []dbv1alpha1.PrivilegeName{"CATALOG READ"},
+		ObjectPrivileges: []dbv1alpha1.ObjectPrivilege{{Schema: "SALES", Object: "ORDERS", Privileges: []dbv1alpha1.PrivilegeName{"SELECT"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted := [][]driver.Value{
+		{"SYSTEMPRIVILEGE", nil, nil, "CATALOG READ"},
+		{"SCHEMA", "SALES", nil, "INSERT"},
+		{"TABLE", "SALES", "CUSTOMERS", "DELETE"},
+	}
+
+	tests := []struct {
+		name      string
+		revocable func(key string) bool
+		expect    func(mock sqlmock.Sqlmock)
+		applied   []string
+		failed    []string
+	}{
+		{
+			name:      "all privileges managed",
+			revocable: func(string) bool { return true },
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`GRANT "MONITORING" TO "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`GRANT SELECT ON "SALES"."ORDERS" TO "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`REVOKE DELETE ON "SALES"."CUSTOMERS" FROM "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`REVOKE INSERT ON SCHEMA "SALES" FROM "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			applied: []string{"CATALOG READ", "ROLE MONITORING", "SELECT ON SALES.ORDERS"},
+		},
+		{
+			name:      "privileges granted in other ways are kept",
+			revocable: appliedBy([]string{"INSERT ON SCHEMA SALES"}),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`GRANT "MONITORING" TO "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`GRANT SELECT ON "SALES"."ORDERS" TO "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`REVOKE INSERT ON SCHEMA "SALES" FROM "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			applied: []string{"CATALOG READ", "ROLE MONITORING", "SELECT ON SALES.ORDERS"},
+		},
+		{
+			name:      "failed statements do not stop the synchronization",
+			revocable: func(string) bool { return true },
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`GRANT "MONITORING" TO "APP"`).WillReturnError(sqlError{code: 258})
+				mock.ExpectExec(`GRANT SELECT ON "SALES"."ORDERS" TO "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(`REVOKE DELETE ON "SALES"."CUSTOMERS" FROM "APP"`).WillReturnError(sqlError{code: 258})
+				mock.ExpectExec(`REVOKE INSERT ON SCHEMA "SALES" FROM "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			// A privilege that could not be revoked is still granted by the resource
+			applied: []string{"CATALOG READ", "DELETE ON SALES.CUSTOMERS", "SELECT ON SALES.ORDERS"},
+			failed:  []string{`GRANT "MONITORING" TO "APP"`, `REVOKE DELETE ON "SALES"."CUSTOMERS" FROM "APP"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connector := &fakeConnector{}
+			mock := connector.expectSQL(t)
+			expectGrantedPrivileges(mock, "APP", nil, granted)
+			tt.expect(mock)
+			db, err := connector.Open(context.Background(), hdbclient.Endpoint{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := syncPrivileges(context.Background(), db, "APP", desired, tt.revocable)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+			if !reflect.DeepEqual(result.Applied, tt.applied) {
+				t.Errorf("applied = %q, want %q", result.Applied, tt.applied)
+			}
+			var failed []string
+			for _, stmt := range result.FailedStatements {
+				failed = append(failed, stmt.Statement)
+			}
+			if !reflect.DeepEqual(failed, tt.failed) {
+				t.Errorf("failed statements = %q, want %q", failed, tt.failed)
+			}
+			condition := privilegeSyncCondition(typeReadyHanaGrant, result)
+			if (condition.Status == metav1.ConditionTrue) != (len(tt.failed) == 0) {
+				t.Errorf("condition = %v with %d failed statements", condition, len(tt.failed))
+			}
+		})
+	}
+}
+
+func TestGrantRevokesOnlyWhatItGranted(t *testing.T) {
+	hanaExpress, secret := testHanaExpress()
+	r, connector := newTestGrantReconciler(hanaExpress, secret, testGrant())
+	otherGrant := []driver.Value{"SYSTEMPRIVILEGE", nil, nil, "CATALOG READ"}
+
+	mock := connector.expectSQL(t)
+	expectGrantedPrivileges(mock, "APP", nil, [][]driver.Value{otherGrant})
+	mock.ExpectExec(`GRANT SELECT ON SCHEMA "SALES" TO "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	_, grant := reconcileGrant(t, r, mock)
+	if !reflect.DeepEqual(grant.Status.Applied, []string{"SELECT ON SCHEMA SALES"}) {
+		t.Fatalf("applied = %q after the first synchronization", grant.Status.Applied)
+	}
+	if ready := meta.FindStatusCondition(grant.Status.Conditions, typeReadyHanaGrant); ready == nil || ready.Status != metav1.ConditionTrue {
+		t.Errorf("Ready condition = %v", ready)
+	}
+
+	// Deleting the resource revokes its privilege and keeps the one granted in another way
+	if err := r.Delete(context.Background(), grant); err != nil {
+		t.Fatal(err)
+	}
+	mock = connector.expectSQL(t)
+	expectGrantedPrivileges(mock, "APP", nil, [][]driver.Value{otherGrant, {"SCHEMA", "SALES", nil, "SELECT"}})
+	mock.ExpectExec(`REVOKE SELECT ON SCHEMA "SALES" FROM "APP"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	if _, grant = reconcileGrant(t, r, mock); grant != nil {
+		t.Errorf("finalizers = %v, want the resource to be gone", grant.Finalizers)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+const (
+	// bindingType is the type entry of the binding Secret
+	bindingType = "hana"
+	// bindingProvider is the provider entry of the binding Secret
+	bindingProvider = "sap"
+	// bindingDatabase is the tenant database applications are bound to
+	bindingDatabase = "HXE"
+	// bindingUserName is the database user applications are bound as
+	bindingUserName = "BINDING"
+)
+
+// bindingSecretName returns the name of the binding Secret of the instance
+func bindingSecretName(hanaExpress *dbv1alpha1.HanaExpress) string {
+	return hanaExpress.Name + "-binding"
+}
+
+// bindingUserForHanaExpress returns the HanaUser applications are bound as. It has no privileges
+// besides its own schema, further privileges are granted with HanaGrant resources.
+func (r *HanaExpressReconciler) bindingUserForHanaExpress(hanaExpress *dbv1alpha1.HanaExpress) (*dbv1alpha1.HanaUser, error) {
+	user := &dbv1alpha1.HanaUser{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bindingSecretName(hanaExpress),
+			Namespace: hanaExpress.Namespace,
+			Labels:    selectorLabelsForHanaExpress(hanaExpress.Name),
+		},
+		Spec: dbv1alpha1.HanaUserSpec{
+			HanaExpressName:      hanaExpress.Name,
+			DatabaseName:         bindingDatabase,
+			UserName:             bindingUserName,
+			PasswordNeverExpires: true,
+		},
+	}
+	if err := ctrl.SetControllerReference(hanaExpress, user, r.Scheme); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// reconcileBindingUser creates the HanaUser applications are bound as and returns the user name
+// and password of its Secret, or empty strings while the user is not ready
+func (r *HanaExpressReconciler) reconcileBindingUser(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (string, string, error) {
+	desired, err := r.bindingUserForHanaExpress(hanaExpress)
+	if err != nil {
+		return "", "", err
+	}
+
+	user := &dbv1alpha1.HanaUser{}
+	err = r.Get(ctx, types.NamespacedName{Name: desired.Name, Namespace: desired.Namespace}, user)
+	if err != nil && apierrors.IsNotFound(err) {
+		log.FromContext(ctx).Info("Creating the binding user", "HanaUser.Namespace", desired.Namespace, "HanaUser.Name", desired.Name)
+		return "", "", r.Create(ctx, desired)
+	} else if err != nil {
+		return "", "", err
+	}
+	if !metav1.IsControlledBy(user, hanaExpress) {
+		return "", "", fmt.Errorf("HanaUser %s already exists and is not owned by HanaExpress %s", user.Name, hanaExpress.Name)
+	}
+	if !meta.IsStatusConditionTrue(user.Status.Conditions, typeReadyHanaUser) || user.Status.SecretName == "" {
+		return "", "", nil
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: user.Status.SecretName, Namespace: user.Namespace}, secret); err != nil {
+		return "", "", fmt.Errorf("failed to get the credentials of the binding user from secret %s: %w", user.Status.SecretName, err)
+	}
+	return string(secret.Data["username"]), string(secret.Data["password"]), nil
+}
+
+// bindingSecretForHanaExpress returns the Secret with the connection details of the HXE tenant.
+// Its entries follow the Service Binding for Kubernetes specification, so binding tools can
+// project it into application workloads.
+// More info: https://servicebinding.io/spec/core/1.0.0/#well-known-secret-entries
+func (r *HanaExpressReconciler) bindingSecretForHanaExpress(hanaExpress *dbv1alpha1.HanaExpress,
+	userName, password string) (*corev1.Secret, error) {
+	host := hostForHanaExpress(hanaExpress)
+	port := strconv.Itoa(int(hanaTenantSQLPort))
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bindingSecretName(hanaExpress),
+			Namespace: hanaExpress.Namespace,
+			Labels:    selectorLabelsForHanaExpress(hanaExpress.Name),
+		},
+		Type: corev1.SecretType("servicebinding.io/" + bindingType),
+		Data: map[string][]byte{
+			"type":     []byte(bindingType),
+			"provider": []byte(bindingProvider),
+			"host":     []byte(host),
+			"port":     []byte(port),
+			"database": []byte(bindingDatabase),
+			"username": []byte(userName),
+			"password": []byte(password),
+			"url":      []byte(fmt.Sprintf("jdbc:sap://%s:%s/?databaseName=%s", host, port, bindingDatabase)),
+		},
+	}
+	if err := ctrl.SetControllerReference(hanaExpress, secret, r.Scheme); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// reconcileBindingSecret publishes the binding Secret with the credentials of the binding user
+// once it is ready and records it in status.binding. The Secret is owned by the instance, so
+// changes to it are reverted and it is deleted with it.
+func (r *HanaExpressReconciler) reconcileBindingSecret(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) error {
+	userName, password, err := r.reconcileBindingUser(ctx, hanaExpress)
+	if err != nil {
+		return err
+	}
+
+	found := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: bindingSecretName(hanaExpress), Namespace: hanaExpress.Namespace}, found)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	if userName == "" {
+		// Earlier versions bound applications as SYSTEM, that Secret is not kept until the user is ready
+		if exists && metav1.IsControlledBy(found, hanaExpress) && string(found.Data["username"]) == hanaSystemUser {
+			log.FromContext(ctx).Info("Deleting the binding Secret with the SYSTEM credentials",
+				"Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+			if err := r.Delete(ctx, found); err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			hanaExpress.Status.Binding = nil
+		}
+		return nil
+	}
+
+	desired, err := r.bindingSecretForHanaExpress(hanaExpress, userName, password)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		log.FromContext(ctx).Info("Creating the binding Secret", "Secret.Namespace", desired.Namespace, "Secret.Name", desired.Name)
+		if err := r.Create(ctx, desired); err != nil {
+			return err
+		}
+	} else if !metav1.IsControlledBy(found, hanaExpress) {
+		return fmt.Errorf("secret %s already exists and is not owned by HanaExpress %s", found.Name, hanaExpress.Name)
+	} else {
+		if found.Type != desired.Type {
+			// The type of a Secret is immutable, so a Secret of another type is replaced
+			log.FromContext(ctx).Info("Replacing the binding Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+			if err := r.Delete(ctx, found); err != nil {
+				return err
+			}
+			if err := r.Create(ctx, desired); err != nil {
+				return err
+			}
+		} else if !equality.Semantic.DeepEqual(found.Data, desired.Data) {
+			log.FromContext(ctx).Info("Updating the binding Secret", "Secret.Namespace", found.Namespace, "Secret.Name", found.Name)
+			found.Data = desired.Data
+			if err := r.Update(ctx, found); err != nil {
+				return err
+			}
+		}
+	}
+
+	hanaExpress.Status.Binding = &corev1.LocalObjectReference{Name: desired.Name}
+	return nil
+}
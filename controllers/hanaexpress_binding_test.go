@@ -0,0 +1,98 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func TestBindingSecretWaitsForTheBindingUser(t *testing.T) {
+	hanaExpress, secret := testHanaExpress()
+	legacy := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: bindingSecretName(hanaExpress), Namespace: testNamespace},
+		Type:       corev1.SecretType("servicebinding.io/" + bindingType),
+		Data:       map[string][]byte{"username": []byte(hanaSystemUser), "password": []byte(testPassword)},
+	}
+	c := newFakeClient(hanaExpress, secret)
+	if err := ctrl.SetControllerReference(hanaExpress, legacy, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+	ctx := context.Background()
+
+	if err := r.reconcileBindingSecret(ctx, hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	key := types.NamespacedName{Name: bindingSecretName(hanaExpress), Namespace: testNamespace}
+	user := &dbv1alpha1.HanaUser{}
+	if err := c.Get(ctx, key, user); err != nil {
+		t.Fatalf("binding user not created: %v", err)
+	}
+	if user.Spec.UserName != bindingUserName || !metav1.IsControlledBy(user, hanaExpress) {
+		t.Errorf("binding user %s owned by %v, want %s owned by the instance", user.Spec.UserName,
+			metav1.GetControllerOf(user), bindingUserName)
+	}
+	if err := c.Get(ctx, key, &corev1.Secret{}); !apierrors.IsNotFound(err) {
+		t.Errorf("binding Secret with the SYSTEM credentials still exists: %v", err)
+	}
+	if hanaExpress.Status.Binding != nil {
+		t.Errorf("status.binding = %v before the user is ready", hanaExpress.Status.Binding)
+	}
+
+	// The HanaUser controller generates the credentials and reports the user ready
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hxe-binding-credentials", Namespace: testNamespace},
+		Data:       map[string][]byte{"username": []byte(bindingUserName), "password": []byte("Generated1")},
+	}
+	if err := c.Create(ctx, credentials); err != nil {
+		t.Fatal(err)
+	}
+	user.Status.SecretName = credentials.Name
+	meta.SetStatusCondition(&user.Status.Conditions, metav1.Condition{Type: typeReadyHanaUser,
+		Status: metav1.ConditionTrue, Reason: "Reconciled"})
+	if err := c.Status().Update(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.reconcileBindingSecret(ctx, hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	binding := &corev1.Secret{}
+	if err := c.Get(ctx, key, binding); err != nil {
+		t.Fatalf("binding Secret not published: %v", err)
+	}
+	if username, password := string(binding.Data["username"]), string(binding.Data["password"]); username != bindingUserName ||
+		password != "Generated1" {
+		t.Errorf("binding Secret holds %s/%s, want the credentials of the binding user", username, password)
+	}
+	if hanaExpress.Status.Binding == nil || hanaExpress.Status.Binding.Name != key.Name {
+		t.Errorf("status.binding = %v, want %s", hanaExpress.Status.Binding, key.Name)
+	}
+}
@@ -0,0 +1,346 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+// resolveDataSource resolves spec.source to the object the data PVC is provisioned from. It
+// returns nil while the HANA data snapshot of a running source instance is still being taken.
+func (r *HanaExpressReconciler) resolveDataSource(ctx context.Context,
+	hanaExpress *dbv1alpha1.HanaExpress) (*dbv1alpha1.DataSourceStatus, error) {
+	source := hanaExpress.Spec.Source
+	set := 0
+	for _, name := range []string{source.HanaExpressName, source.VolumeSnapshotName, source.BackupName} {
+		if name != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("exactly one of source.hanaExpressName, source.volumeSnapshotName and source.backupName must be set")
+	}
+
+	snapshotGroup := snapshotv1.GroupName
+	switch {
+	case source.HanaExpressName != "":
+		if source.HanaExpressName == hanaExpress.Name {
+			return nil, fmt.Errorf("an instance cannot be cloned from itself")
+		}
+		sourceHanaExpress := &dbv1alpha1.HanaExpress{}
+		err := r.Get(ctx, types.NamespacedName{Name: source.HanaExpressName, Namespace: hanaExpress.Namespace}, sourceHanaExpress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get HanaExpress %s: %w", source.HanaExpressName, err)
+		}
+		pvc := &corev1.PersistentVolumeClaim{}
+		pvcKey := types.NamespacedName{Name: dataVolumeClaimName(sourceHanaExpress), Namespace: hanaExpress.Namespace}
+		if err := r.Get(ctx, pvcKey, pvc); err != nil {
+			return nil, fmt.Errorf("failed to get the data PVC %s of HanaExpress %s: %w", pvcKey.Name, source.HanaExpressName, err)
+		}
+
+		// A running source keeps writing to its volume, it is cloned from a HANA data snapshot
+		// taken by a Snapshot backup so the clone does not need a crash recovery
+		message := fmt.Sprintf("HanaExpress %s is not available to take a HANA data snapshot", sourceHanaExpress.Name)
+		if meta.IsStatusConditionTrue(sourceHanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+			backup, err := r.reconcileSourceBackup(ctx, hanaExpress, sourceHanaExpress)
+			if err != nil {
+				return nil, err
+			}
+			switch backup.Status.Phase {
+			case dbv1alpha1.BackupPhaseCompleted:
+				meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeCloneConsistentHanaExpress,
+					Status: metav1.ConditionTrue, Reason: "DataSnapshot",
+					Message: fmt.Sprintf("Provisioned from the HANA data snapshot of HanaExpressBackup %s", backup.Name)})
+				return &dbv1alpha1.DataSourceStatus{
+					Kind: "HanaExpress",
+					Name: source.HanaExpressName,
+					DataSource: corev1.TypedLocalObjectReference{APIGroup: &snapshotGroup, Kind: "VolumeSnapshot",
+						Name: backup.Status.Snapshot.VolumeSnapshotName},
+					SourceHanaExpressName: source.HanaExpressName,
+					Time:                  metav1.Now(),
+				}, nil
+			case dbv1alpha1.BackupPhaseFailed:
+				if !volumeSnapshotsUnavailable(backup) {
+					return nil, fmt.Errorf("HanaExpressBackup %s of the source failed, delete it to retry: %s",
+						backup.Name, backup.Status.Message)
+				}
+				message = fmt.Sprintf("HanaExpressBackup %s could not take a VolumeSnapshot: %s", backup.Name, backup.Status.Message)
+			default:
+				return nil, nil
+			}
+		}
+
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeCloneConsistentHanaExpress,
+			Status: metav1.ConditionFalse, Reason: "CrashConsistent",
+			Message: fmt.Sprintf("Cloned the data PVC %s without a HANA data snapshot, HANA recovers it like after a crash. %s",
+				pvc.Name, message)})
+		return &dbv1alpha1.DataSourceStatus{
+			Kind:                  "HanaExpress",
+			Name:                  source.HanaExpressName,
+			DataSource:            corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: pvc.Name},
+			SourceHanaExpressName: source.HanaExpressName,
+			Time:                  metav1.Now(),
+		}, nil
+
+	case source.VolumeSnapshotName != "":
+		snapshot := &snapshotv1.VolumeSnapshot{}
+		err := r.Get(ctx, types.NamespacedName{Name: source.VolumeSnapshotName, Namespace: hanaExpress.Namespace}, snapshot)
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				return nil, fmt.Errorf("the cluster does not serve the snapshot.storage.k8s.io/v1 API")
+			}
+			return nil, fmt.Errorf("failed to get VolumeSnapshot %s: %w", source.VolumeSnapshotName, err)
+		}
+		if snapshot.Status == nil || snapshot.Status.ReadyToUse == nil || !*snapshot.Status.ReadyToUse {
+			return nil, fmt.Errorf("VolumeSnapshot %s is not ready to use", snapshot.Name)
+		}
+		return &dbv1alpha1.DataSourceStatus{
+			Kind:       "VolumeSnapshot",
+			Name:       snapshot.Name,
+			DataSource: corev1.TypedLocalObjectReference{APIGroup: &snapshotGroup, Kind: "VolumeSnapshot", Name: snapshot.Name},
+			// Snapshots taken by HanaExpressBackups carry the instance label
+			SourceHanaExpressName: snapshot.Labels["app.kubernetes.io/instance"],
+			Time:                  metav1.Now(),
+		}, nil
+
+	default:
+		backup := &dbv1alpha1.HanaExpressBackup{}
+		err := r.Get(ctx, types.NamespacedName{Name: source.BackupName, Namespace: hanaExpress.Namespace}, backup)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get HanaExpressBackup %s: %w", source.BackupName, err)
+		}
+		if backup.Spec.Method != dbv1alpha1.BackupMethodSnapshot || backup.Status.Snapshot == nil {
+			return nil, fmt.Errorf("HanaExpressBackup %s is a file backup, clone from a Snapshot backup or restore it with a HanaExpressRestore",
+				backup.Name)
+		}
+		if backup.Status.Phase != dbv1alpha1.BackupPhaseCompleted {
+			return nil, fmt.Errorf("HanaExpressBackup %s has not completed", backup.Name)
+		}
+		return &dbv1alpha1.DataSourceStatus{
+			Kind: "HanaExpressBackup",
+			Name: backup.Name,
+			DataSource: corev1.TypedLocalObjectReference{APIGroup: &snapshotGroup, Kind: "VolumeSnapshot",
+				Name: backup.Status.Snapshot.VolumeSnapshotName},
+			SourceHanaExpressName: backup.Spec.HanaExpressName,
+			Time:                  metav1.Now(),
+		}, nil
+	}
+}
+
+// sourceBackupName returns the name of the Snapshot backup a clone of a running instance is
+// provisioned from
+func sourceBackupName(hanaExpress *dbv1alpha1.HanaExpress) string {
+	return hanaExpress.Name + "-source"
+}
+
+// reconcileSourceBackup creates the Snapshot backup of the source instance that a clone is
+// provisioned from and returns it. The backup is owned by the clone, so its VolumeSnapshot is
+// kept for the data PVC template until the clone is deleted.
+func (r *HanaExpressReconciler) reconcileSourceBackup(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	sourceHanaExpress *dbv1alpha1.HanaExpress) (*dbv1alpha1.HanaExpressBackup, error) {
+	backup := &dbv1alpha1.HanaExpressBackup{}
+	name := sourceBackupName(hanaExpress)
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: hanaExpress.Namespace}, backup)
+	if err == nil {
+		if !metav1.IsControlledBy(backup, hanaExpress) {
+			return nil, fmt.Errorf("HanaExpressBackup %s already exists and is not owned by HanaExpress %s", name, hanaExpress.Name)
+		}
+		return backup, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	backup = &dbv1alpha1.HanaExpressBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: hanaExpress.Namespace,
+			Labels:    selectorLabelsForHanaExpress(hanaExpress.Name),
+		},
+		Spec: dbv1alpha1.HanaExpressBackupSpec{
+			HanaExpressName: sourceHanaExpress.Name,
+			Method:          dbv1alpha1.BackupMethodSnapshot,
+		},
+	}
+	if err := ctrl.SetControllerReference(hanaExpress, backup, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	log.FromContext(ctx).Info("Creating a Snapshot backup of the clone source", "HanaExpressBackup.Name", name)
+	if err := r.Create(ctx, backup); err != nil {
+		return nil, err
+	}
+	r.Recorder.Event(hanaExpress, "Normal", "SourceSnapshot",
+		fmt.Sprintf("Taking a HANA data snapshot of HanaExpress %s with HanaExpressBackup %s", sourceHanaExpress.Name, name))
+	return backup, nil
+}
+
+// volumeSnapshotsUnavailable reports whether a Snapshot backup failed because the cluster or
+// the CSI driver cannot take VolumeSnapshots, rather than in HANA
+func volumeSnapshotsUnavailable(backup *dbv1alpha1.HanaExpressBackup) bool {
+	condition := meta.FindStatusCondition(backup.Status.Conditions, typeCompletedHanaExpressBackup)
+	return condition != nil &&
+		(condition.Reason == "VolumeSnapshotClassNotFound" || condition.Reason == "VolumeSnapshotNotSupported")
+}
+
+// dataSourceForHanaExpress returns the dataSource of the data PVC template, or nil for an empty volume
+func dataSourceForHanaExpress(hanaExpress *dbv1alpha1.HanaExpress) *corev1.TypedLocalObjectReference {
+	if hanaExpress.Status.Source == nil {
+		return nil
+	}
+	dataSource := hanaExpress.Status.Source.DataSource
+	return &dataSource
+}
+
+// reconcileClonedCredentials replaces the SYSTEM passwords of the SystemDB and the tenant,
+// which a clone inherits with the data of its source, with the credential of the clone.
+func (r *HanaExpressReconciler) reconcileClonedCredentials(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) error {
+	source := hanaExpress.Status.Source
+	if source == nil || source.CredentialsReset {
+		return nil
+	}
+
+	password, err := masterPasswordForHanaExpress(ctx, r.Client, hanaExpress)
+	if err != nil {
+		return err
+	}
+	sourcePassword, err := r.sourcePasswordForClone(ctx, hanaExpress)
+	if err != nil {
+		return err
+	}
+
+	for _, port := range []int32{hanaSystemDBSQLPort, hanaTenantSQLPort} {
+		if err := r.resetSystemPassword(ctx, hanaExpress, port, sourcePassword, password); err != nil {
+			return err
+		}
+	}
+	if err := r.resetTenantPasswords(ctx, hanaExpress, password); err != nil {
+		return err
+	}
+
+	source.CredentialsReset = true
+	r.Recorder.Event(hanaExpress, "Normal", "CredentialsReset",
+		fmt.Sprintf("Replaced the SYSTEM passwords cloned from %s %s with the credential of this instance", source.Kind, source.Name))
+	return nil
+}
+
+// resetTenantPasswords sets the SYSTEM password of the tenants other than HXE, which the source
+// created through HanaTenantDatabases, to the master password of the clone. The clone has no
+// HanaTenantDatabases of its own, so these tenants are reached with the master password.
+func (r *HanaExpressReconciler) resetTenantPasswords(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	password string) error {
+	db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, "SELECT DATABASE_NAME FROM M_DATABASES WHERE DATABASE_NAME NOT IN ('SYSTEMDB', 'HXE')")
+	if err != nil {
+		return fmt.Errorf("failed to list the tenant databases: %w", err)
+	}
+	tenants := []string{}
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			rows.Close()
+			return err
+		}
+		tenants = append(tenants, tenant)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, tenant := range tenants {
+		active, _, err := tenantDatabaseState(ctx, db, tenant)
+		if err != nil {
+			return err
+		}
+		port := int32(0)
+		if active {
+			if port, err = tenantSQLPort(ctx, db, tenant); err != nil {
+				return err
+			}
+		}
+		if err := setTenantSystemPassword(ctx, r.SQL, db, hanaExpress, tenant, port, password); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sourcePasswordForClone returns the master password of the database the clone was taken from
+func (r *HanaExpressReconciler) sourcePasswordForClone(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (string, error) {
+	if hanaExpress.Spec.Source != nil && hanaExpress.Spec.Source.Credential != nil {
+		return passwordFromCredential(ctx, r.Client, hanaExpress.Namespace, *hanaExpress.Spec.Source.Credential)
+	}
+
+	name := hanaExpress.Status.Source.SourceHanaExpressName
+	if name == "" {
+		return "", fmt.Errorf("the source instance of %s %s is unknown, set source.credential",
+			hanaExpress.Status.Source.Kind, hanaExpress.Status.Source.Name)
+	}
+	sourceHanaExpress := &dbv1alpha1.HanaExpress{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: hanaExpress.Namespace}, sourceHanaExpress); err != nil {
+		return "", fmt.Errorf("failed to get the source HanaExpress %s, set source.credential: %w", name, err)
+	}
+	return masterPasswordForHanaExpress(ctx, r.Client, sourceHanaExpress)
+}
+
+// resetSystemPassword sets the SYSTEM password on one SQL port, logging on with the previous
+// password, of the clone source or the one a rotation replaces, unless it was already changed
+func (r *HanaExpressReconciler) resetSystemPassword(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	port int32, previous, password string) error {
+	endpoint := hdbclient.Endpoint{
+		Host:     hostForHanaExpress(hanaExpress),
+		Port:     port,
+		User:     hanaSystemUser,
+		Password: password,
+	}
+	if db, err := r.SQL.Open(ctx, endpoint); err == nil {
+		db.Close()
+		return nil
+	}
+
+	endpoint.Password = previous
+	db, err := r.SQL.Open(ctx, endpoint)
+	if err != nil {
+		return fmt.Errorf("failed to log on to port %d with the master password or the one it replaces: %w", port, err)
+	}
+	defer db.Close()
+
+	log.FromContext(ctx).Info("Replacing the SYSTEM password", "Port", port)
+	if _, err := hdbclient.ExecOnce(ctx, db, "ALTER USER SYSTEM PASSWORD "+hdbclient.QuoteIdentifier(password)); err != nil {
+		return fmt.Errorf("failed to change the SYSTEM password on port %d: %w", port, err)
+	}
+	return nil
+}
@@ -0,0 +1,379 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+const testClonePassword = "Clone1234"
+
+// testClone returns an instance named clone with the source and its credential Secret
+func testClone(source dbv1alpha1.DataSource) (*dbv1alpha1.HanaExpress, *corev1.Secret) {
+	clone, secret := testHanaExpress()
+	clone.Name, clone.UID = "clone", "clone-uid"
+	clone.Status = dbv1alpha1.HanaExpressStatus{}
+	clone.Spec.Source = &source
+	secret.Name = "clone-credential"
+	secret.Data["password"] = []byte(testClonePassword)
+	clone.Spec.Credential.SecretKeyRef.Name = secret.Name
+	return clone, secret
+}
+
+// testSourceBackup returns the Snapshot backup of hxe owned by the clone in the phase, failed
+// with the reason
+func testSourceBackup(t *testing.T, clone *dbv1alpha1.HanaExpress, phase dbv1alpha1.BackupPhase,
+	reason string) *dbv1alpha1.HanaExpressBackup {
+	t.Helper()
+	backup := &dbv1alpha1.HanaExpressBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: sourceBackupName(clone), Namespace: testNamespace},
+		Spec:       dbv1alpha1.HanaExpressBackupSpec{HanaExpressName: "hxe", Method: dbv1alpha1.BackupMethodSnapshot},
+		Status: dbv1alpha1.HanaExpressBackupStatus{Phase: phase, Message: "backup message",
+			Snapshot: &dbv1alpha1.BackupSnapshotStatus{VolumeSnapshotName: sourceBackupName(clone) + "-data"}},
+	}
+	if reason != "" {
+		meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{Type: typeCompletedHanaExpressBackup,
+			Status: metav1.ConditionFalse, Reason: reason})
+	}
+	if err := ctrl.SetControllerReference(clone, backup, newFakeClient().Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	return backup
+}
+
+func TestResolveDataSource(t *testing.T) {
+	snapshotGroup := snapshotv1.GroupName
+	running, _ := testHanaExpress()
+	stopped, _ := testHanaExpress()
+	meta.SetStatusCondition(&stopped.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
+		Status: metav1.ConditionFalse, Reason: "Unreachable"})
+	elsewhere, _ := testHanaExpress()
+	elsewhere.Namespace = "elsewhere"
+	dataPVC := func(namespace string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-hxe-0", Namespace: namespace}}
+	}
+	volumeSnapshot := func(namespace string, ready bool) *snapshotv1.VolumeSnapshot {
+		return &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: namespace,
+				Labels: map[string]string{"app.kubernetes.io/instance": "hxe"}},
+			Status: &snapshotv1.VolumeSnapshotStatus{ReadyToUse: &ready},
+		}
+	}
+	snapshotBackup := func(method dbv1alpha1.BackupMethod, phase dbv1alpha1.BackupPhase) *dbv1alpha1.HanaExpressBackup {
+		backup := &dbv1alpha1.HanaExpressBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: "weekly", Namespace: testNamespace},
+			Spec:       dbv1alpha1.HanaExpressBackupSpec{HanaExpressName: "hxe", Method: method},
+			Status:     dbv1alpha1.HanaExpressBackupStatus{Phase: phase},
+		}
+		if method == dbv1alpha1.BackupMethodSnapshot {
+			backup.Status.Snapshot = &dbv1alpha1.BackupSnapshotStatus{VolumeSnapshotName: "weekly-data"}
+		}
+		return backup
+	}
+	pvcSource := &corev1.TypedLocalObjectReference{Kind: "PersistentVolumeClaim", Name: "data-hxe-0"}
+
+	tests := []struct {
+		name       string
+		source     dbv1alpha1.DataSource
+		objects    func(clone *dbv1alpha1.HanaExpress) []client.Object
+		kind       string
+		dataSource *corev1.TypedLocalObjectReference
+		from       string
+		consistent metav1.ConditionStatus
+		waiting    bool
+		wantErr    string
+	}{
+		{
+			name:   "running HanaExpress is snapshotted first",
+			source: dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{running, dataPVC(testNamespace)}
+			},
+			waiting: true,
+		},
+		{
+			name:   "running HanaExpress whose snapshot is still taken",
+			source: dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{running, dataPVC(testNamespace),
+					testSourceBackup(t, clone, dbv1alpha1.BackupPhaseRunning, "")}
+			},
+			waiting: true,
+		},
+		{
+			name:   "running HanaExpress from its completed snapshot",
+			source: dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{running, dataPVC(testNamespace),
+					testSourceBackup(t, clone, dbv1alpha1.BackupPhaseCompleted, "")}
+			},
+			kind:       "HanaExpress",
+			dataSource: &corev1.TypedLocalObjectReference{APIGroup: &snapshotGroup, Kind: "VolumeSnapshot", Name: "clone-source-data"},
+			from:       "hxe",
+			consistent: metav1.ConditionTrue,
+		},
+		{
+			name:   "running HanaExpress without VolumeSnapshots is cloned crash-consistent",
+			source: dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{running, dataPVC(testNamespace),
+					testSourceBackup(t, clone, dbv1alpha1.BackupPhaseFailed, "VolumeSnapshotClassNotFound")}
+			},
+			kind:       "HanaExpress",
+			dataSource: pvcSource,
+			from:       "hxe",
+			consistent: metav1.ConditionFalse,
+		},
+		{
+			name:   "running HanaExpress whose snapshot failed in HANA",
+			source: dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{running, dataPVC(testNamespace),
+					testSourceBackup(t, clone, dbv1alpha1.BackupPhaseFailed, "SnapshotFailed")}
+			},
+			wantErr: "delete it to retry: backup message",
+		},
+		{
+			name:   "running HanaExpress with a backup of the same name",
+			source: dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				backup := testSourceBackup(t, clone, dbv1alpha1.BackupPhaseCompleted, "")
+				backup.OwnerReferences = nil
+				return []client.Object{running, dataPVC(testNamespace), backup}
+			},
+			wantErr: "is not owned by HanaExpress clone",
+		},
+		{
+			name:   "unavailable HanaExpress is cloned crash-consistent",
+			source: dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{stopped, dataPVC(testNamespace)}
+			},
+			kind:       "HanaExpress",
+			dataSource: pvcSource,
+			from:       "hxe",
+			consistent: metav1.ConditionFalse,
+		},
+		{
+			name:    "missing HanaExpress",
+			source:  dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			wantErr: "failed to get HanaExpress hxe",
+		},
+		{
+			name:   "HanaExpress without data PVC",
+			source: dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{stopped}
+			},
+			wantErr: "failed to get the data PVC data-hxe-0",
+		},
+		{
+			name:   "HanaExpress in another namespace",
+			source: dbv1alpha1.DataSource{HanaExpressName: "hxe"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{elsewhere, dataPVC("elsewhere")}
+			},
+			wantErr: "failed to get HanaExpress hxe",
+		},
+		{
+			name:    "the instance itself",
+			source:  dbv1alpha1.DataSource{HanaExpressName: "clone"},
+			wantErr: "cannot be cloned from itself",
+		},
+		{
+			name:    "more than one source",
+			source:  dbv1alpha1.DataSource{HanaExpressName: "hxe", VolumeSnapshotName: "nightly"},
+			wantErr: "exactly one of",
+		},
+		{
+			name:   "ready VolumeSnapshot",
+			source: dbv1alpha1.DataSource{VolumeSnapshotName: "nightly"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{volumeSnapshot(testNamespace, true)}
+			},
+			kind:       "VolumeSnapshot",
+			dataSource: &corev1.TypedLocalObjectReference{APIGroup: &snapshotGroup, Kind: "VolumeSnapshot", Name: "nightly"},
+			from:       "hxe",
+		},
+		{
+			name:   "VolumeSnapshot that is not ready",
+			source: dbv1alpha1.DataSource{VolumeSnapshotName: "nightly"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{volumeSnapshot(testNamespace, false)}
+			},
+			wantErr: "is not ready to use",
+		},
+		{
+			name:    "missing VolumeSnapshot",
+			source:  dbv1alpha1.DataSource{VolumeSnapshotName: "nightly"},
+			wantErr: "failed to get VolumeSnapshot nightly",
+		},
+		{
+			name:   "VolumeSnapshot in another namespace",
+			source: dbv1alpha1.DataSource{VolumeSnapshotName: "nightly"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{volumeSnapshot("elsewhere", true)}
+			},
+			wantErr: "failed to get VolumeSnapshot nightly",
+		},
+		{
+			name:   "completed Snapshot backup",
+			source: dbv1alpha1.DataSource{BackupName: "weekly"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{snapshotBackup(dbv1alpha1.BackupMethodSnapshot, dbv1alpha1.BackupPhaseCompleted)}
+			},
+			kind:       "HanaExpressBackup",
+			dataSource: &corev1.TypedLocalObjectReference{APIGroup: &snapshotGroup, Kind: "VolumeSnapshot", Name: "weekly-data"},
+			from:       "hxe",
+		},
+		{
+			name:   "Snapshot backup that has not completed",
+			source: dbv1alpha1.DataSource{BackupName: "weekly"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{snapshotBackup(dbv1alpha1.BackupMethodSnapshot, dbv1alpha1.BackupPhaseRunning)}
+			},
+			wantErr: "has not completed",
+		},
+		{
+			name:   "file backup",
+			source: dbv1alpha1.DataSource{BackupName: "weekly"},
+			objects: func(clone *dbv1alpha1.HanaExpress) []client.Object {
+				return []client.Object{snapshotBackup(dbv1alpha1.BackupMethodFile, dbv1alpha1.BackupPhaseCompleted)}
+			},
+			wantErr: "is a file backup",
+		},
+		{
+			name:    "missing backup",
+			source:  dbv1alpha1.DataSource{BackupName: "weekly"},
+			wantErr: "failed to get HanaExpressBackup weekly",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clone, secret := testClone(tt.source)
+			objects := []client.Object{clone, secret}
+			if tt.objects != nil {
+				objects = append(objects, tt.objects(clone)...)
+			}
+			c := newFakeClient(objects...)
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+
+			source, err := r.resolveDataSource(context.Background(), clone)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.waiting {
+				if source != nil {
+					t.Fatalf("source = %+v, want to wait for the snapshot of the running source", source)
+				}
+				backup := &dbv1alpha1.HanaExpressBackup{}
+				key := types.NamespacedName{Name: sourceBackupName(clone), Namespace: testNamespace}
+				if err := c.Get(context.Background(), key, backup); err != nil {
+					t.Fatal(err)
+				}
+				if backup.Spec.HanaExpressName != "hxe" || backup.Spec.Method != dbv1alpha1.BackupMethodSnapshot ||
+					!metav1.IsControlledBy(backup, clone) {
+					t.Errorf("source backup = %+v, want a Snapshot backup of hxe owned by the clone", backup)
+				}
+				return
+			}
+
+			if source == nil {
+				t.Fatal("no source resolved")
+			}
+			if source.Kind != tt.kind || source.SourceHanaExpressName != tt.from {
+				t.Errorf("source = %s from %q, want %s from %q", source.Kind, source.SourceHanaExpressName, tt.kind, tt.from)
+			}
+			if got, want := source.DataSource, *tt.dataSource; got.Kind != want.Kind || got.Name != want.Name ||
+				(got.APIGroup == nil) != (want.APIGroup == nil) {
+				t.Errorf("dataSource = %+v, want %+v", got, want)
+			}
+			condition := meta.FindStatusCondition(clone.Status.Conditions, typeCloneConsistentHanaExpress)
+			switch {
+			case tt.consistent == "" && condition != nil:
+				t.Errorf("CloneConsistent = %s, want no condition", condition.Status)
+			case tt.consistent != "" && (condition == nil || condition.Status != tt.consistent):
+				t.Errorf("CloneConsistent = %+v, want %s", condition, tt.consistent)
+			}
+		})
+	}
+}
+
+func TestReconcileClonedCredentialsResetsTheTenants(t *testing.T) {
+	clone, secret := testClone(dbv1alpha1.DataSource{HanaExpressName: "hxe"})
+	clone.Status.Source = &dbv1alpha1.DataSourceStatus{Kind: "HanaExpress", Name: "hxe", SourceHanaExpressName: "hxe"}
+	source, sourceSecret := testHanaExpress()
+	c := newFakeClient(clone, secret, source, sourceSecret)
+
+	// The SystemDB and HXE took the password of the clone before the tenants failed
+	connector := &fakeConnector{rejectedOn: map[int32][]string{39041: {testClonePassword}}}
+	connector.expectSQL(t)
+	connector.expectSQL(t)
+	mock := connector.expectSQL(t)
+	mock.ExpectQuery("SELECT DATABASE_NAME FROM M_DATABASES WHERE DATABASE_NAME NOT IN ('SYSTEMDB', 'HXE')").
+		WillReturnRows(sqlmock.NewRows([]string{"DATABASE_NAME"}).AddRow("SALES").AddRow("ARCHIVE"))
+	mock.ExpectQuery("SELECT ACTIVE_STATUS FROM M_DATABASES WHERE DATABASE_NAME = ?").WithArgs("SALES").
+		WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}).AddRow("YES"))
+	mock.ExpectQuery("SELECT SQL_PORT FROM SYS_DATABASES.M_SERVICES WHERE DATABASE_NAME = ? AND SERVICE_NAME = 'indexserver' AND SQL_PORT > 0").
+		WithArgs("SALES").WillReturnRows(sqlmock.NewRows([]string{"SQL_PORT"}).AddRow(39041))
+	mock.ExpectExec(`ALTER SYSTEM STOP DATABASE "SALES"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER DATABASE "SALES" SYSTEM USER PASSWORD "` + testClonePassword + `"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`ALTER SYSTEM START DATABASE "SALES"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	// A stopped tenant is not started
+	mock.ExpectQuery("SELECT ACTIVE_STATUS FROM M_DATABASES WHERE DATABASE_NAME = ?").WithArgs("ARCHIVE").
+		WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}).AddRow("NO"))
+	mock.ExpectExec(`ALTER DATABASE "ARCHIVE" SYSTEM USER PASSWORD "` + testClonePassword + `"`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	recorder := newTestRecorder()
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: recorder, SQL: connector}
+	if err := r.reconcileClonedCredentials(context.Background(), clone); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if !clone.Status.Source.CredentialsReset {
+		t.Error("credentialsReset was not recorded")
+	}
+	if !containsEvent(drainEvents(recorder), "CredentialsReset") {
+		t.Error("no CredentialsReset event")
+	}
+}
@@ -21,36 +21,54 @@ import (
 	"encoding/json"
 	"fmt"
 	"k8s.io/apimachinery/pkg/util/intstr"
-
-	"os"
+	"sort"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
 )
 
 const hanaExpressFinalizer = "db.sap-redhat.io/finalizer"
 
 // Definitions to manage status conditions
 const (
-	// typeAvailableHanaExpress represents the status of the StatefulSet reconciliation
+	// typeAvailableHanaExpress represents whether the database serves SQL, as checked over the SystemDB
 	typeAvailableHanaExpress = "Available"
-	// typeDegradedHanaExpress represents the status used when the custom resource is deleted and the finalizer operations must occur.
+	// typeProgressingHanaExpress represents the status of a rollout of the StatefulSet or Service and of the database startup
+	typeProgressingHanaExpress = "Progressing"
+	// typeStorageResizingHanaExpress represents the status of an expansion of the data PVC
+	typeStorageResizingHanaExpress = "StorageResizing"
+	// typeUpgradingHanaExpress represents the status of an image upgrade
+	typeUpgradingHanaExpress = "Upgrading"
+	// typeCredentialSyncedHanaExpress represents whether the database runs with the master password of the Secret
+	typeCredentialSyncedHanaExpress = "CredentialSynced"
+	// typePasswordRotationHanaExpress represents the result of the last change of the SYSTEM password, per SQL port
+	typePasswordRotationHanaExpress = "PasswordRotation"
+	// typeCloneConsistentHanaExpress represents whether a clone of another instance was provisioned from a HANA data snapshot
+	typeCloneConsistentHanaExpress = "CloneConsistent"
+	// typeDegradedHanaExpress represents a database that does not recover without an intervention, and the
+	// status used when the custom resource is deleted and the finalizer operations must occur.
 	typeDegradedHanaExpress = "Degraded"
 )
 
@@ -94,56 +112,31 @@ func (r *HanaExpressReconciler) validateSecret(ctx context.Context, hanaExpress
 	return nil
 }
 
-// getPasswordFilePath returns the file path for the password based on credential format
+// getPasswordFilePath returns the URL of the passwords JSON that the operator renders into
+// the passwords Secret, which is mounted read-only into the HANA Express container
 func (r *HanaExpressReconciler) getPasswordFilePath(hanaExpress *dbv1alpha1.HanaExpress) string {
-	key := hanaExpress.Spec.Credential.SecretKeyRef.Key
-
-	// Default to plain format if not specified
-	format := hanaExpress.Spec.Credential.Format
-	if format == "" {
-		format = "plain"
-	}
-
-	switch format {
-	case "json":
-		return "file:///hana/mounts/" + key
-	case "plain":
-		// For plain text, we need to create a JSON file dynamically in the init container
-		return "file:///hana/mounts/hxepasswd.json"
-	default:
-		return "file:///hana/mounts/" + key
-	}
+	return "file://" + passwordsMountPath + "/" + passwordsFileKey
 }
 
-// getInitContainerCommand returns the command for the init container based on credential format
+// getInitContainerCommand returns the command for the init container. Older operator versions
+// copied the credential and a shell-built passwords JSON onto the data volume, those plaintext
+// copies are removed when the pod starts with the passwords Secret mounted instead.
 func (r *HanaExpressReconciler) getInitContainerCommand(hanaExpress *dbv1alpha1.HanaExpress) []string {
+	copies := []string{"/hana/mounts/" + passwordsFileKey}
+
+	// Only valid Secret keys were copied, anything else is not passed to the shell
 	key := hanaExpress.Spec.Credential.SecretKeyRef.Key
+	if len(validation.IsConfigMapKey(key)) == 0 && key != passwordsFileKey {
+		copies = append(copies, "/hana/mounts/"+key)
+	}
+
+	return []string{"sh", "-c", fmt.Sprintf(`
+			# Remove the plaintext password copies of older operator versions
+			rm -f '%s'
 
-	// Default to plain format if not specified
-	format := hanaExpress.Spec.Credential.Format
-	if format == "" {
-		format = "plain"
-	}
-
-	switch format {
-	case "json":
-		// For JSON format, just copy the file as-is
-		return []string{"sh", "-c", "cp /tmp/mounts/* /hana/mounts && chown -R 12000:79 /hana/mounts"}
-	case "plain":
-		// For plain text format, create a JSON file with the password
-		return []string{"sh", "-c", fmt.Sprintf(`
-			# Copy all files first
-			cp /tmp/mounts/* /hana/mounts/
-			
-			# Create JSON credential file from plain text password
-			echo "{\"master_password\": \"$(cat /tmp/mounts/%s)\"}" > /hana/mounts/hxepasswd.json
-			
 			# Set proper ownership
 			chown -R 12000:79 /hana/mounts
-		`, key)}
-	default:
-		return []string{"sh", "-c", "cp /tmp/mounts/* /hana/mounts && chown -R 12000:79 /hana/mounts"}
-	}
+		`, strings.Join(copies, "' '"))}
 }
 
 // HanaExpressReconciler reconciles a HanaExpress object
@@ -151,6 +144,8 @@ type HanaExpressReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	SQL      hdbclient.Connector
+	Health   hdbclient.HealthChecker
 }
 
 //+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpresses,verbs=get;list;watch;create;update;patch;delete
@@ -159,8 +154,16 @@ type HanaExpressReconciler struct {
 //+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
-//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressbackups,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanatenantdatabases,verbs=get;list;watch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressrestores,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanausers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshotclasses,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -178,9 +181,13 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, err
 	}
 
+	// Every status update below reports on the current spec
+	observeHanaExpress(hanaExpress)
+
 	// Let's just set the status as Unknown when no status are available
 	if len(hanaExpress.Status.Conditions) == 0 {
 		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress, Status: metav1.ConditionUnknown, Reason: "Reconciling", Message: "Starting reconciliation"})
+		setPhase(hanaExpress, dbv1alpha1.HanaExpressPhasePending)
 		if err = r.Status().Update(ctx, hanaExpress); err != nil {
 			log.Error(err, "Failed to update HanaExpress status")
 			return ctrl.Result{}, err
@@ -212,29 +219,9 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		}
 	}
 
-	// Validate the referenced secret before proceeding
-	if err := r.validateSecret(ctx, hanaExpress); err != nil {
-		log.Error(err, "Secret validation failed")
-
-		// Update status to reflect validation failure
-		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{
-			Type:    typeAvailableHanaExpress,
-			Status:  metav1.ConditionFalse,
-			Reason:  "SecretValidationFailed",
-			Message: fmt.Sprintf("Secret validation failed: %s", err.Error()),
-		})
-
-		if statusErr := r.Status().Update(ctx, hanaExpress); statusErr != nil {
-			log.Error(statusErr, "Failed to update HanaExpress status")
-			return ctrl.Result{}, statusErr
-		}
-
-		// Requeue after 30 seconds to retry validation
-		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
-	}
-
 	// Check if the HanaExpress instance is marked to be deleted, which is
-	// indicated by the deletion timestamp being set.
+	// indicated by the deletion timestamp being set. The finalizer runs before the
+	// referenced Secret is validated, so deleting the Secret first does not block it.
 	isHanaExpressMarkedToBeDeleted := hanaExpress.GetDeletionTimestamp() != nil
 	if isHanaExpressMarkedToBeDeleted {
 		if controllerutil.ContainsFinalizer(hanaExpress, hanaExpressFinalizer) {
@@ -243,12 +230,26 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeDegradedHanaExpress,
 				Status: metav1.ConditionUnknown, Reason: "Finalizing",
 				Message: fmt.Sprintf("Performing finalizer operations for the custom resource: %s ", hanaExpress.Name)})
+			setPhase(hanaExpress, dbv1alpha1.HanaExpressPhaseDeleting)
 
 			if err := r.Status().Update(ctx, hanaExpress); err != nil {
 				log.Error(err, "Failed to update HanaExpress status")
 				return ctrl.Result{}, err
 			}
 
+			// Keep the data in a VolumeSnapshot before the PVC is deleted
+			if hanaExpress.EffectiveDeletionPolicy() == dbv1alpha1.DeletionPolicySnapshot {
+				ready, err := r.reconcileFinalSnapshot(ctx, hanaExpress)
+				if err != nil {
+					log.Error(err, "Failed to take the final snapshot")
+					r.Recorder.Event(hanaExpress, "Warning", "FinalSnapshotFailed", err.Error())
+					return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+				}
+				if !ready {
+					return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+				}
+			}
+
 			// Perform all operations required before remove the finalizer and allow
 			// the Kubernetes API to remove the custom resource.
 			if err := r.doFinalizerOperationsForHanaExpress(hanaExpress, ctx); err != nil {
@@ -283,10 +284,116 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{}, nil
 	}
 
+	// Create the Secret of a generated credential before it is validated
+	if updated, err := r.reconcileGeneratedCredential(ctx, hanaExpress); err != nil {
+		log.Error(err, "Failed to generate the master password")
+		return ctrl.Result{}, err
+	} else if updated {
+		return ctrl.Result{}, nil
+	}
+
+	// Validate the referenced secret before proceeding
+	if err := r.validateSecret(ctx, hanaExpress); err != nil {
+		log.Error(err, "Secret validation failed")
+
+		// Update status to reflect validation failure
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{
+			Type:    typeAvailableHanaExpress,
+			Status:  metav1.ConditionFalse,
+			Reason:  "SecretValidationFailed",
+			Message: fmt.Sprintf("Secret validation failed: %s", err.Error()),
+		})
+		setPhase(hanaExpress, dbv1alpha1.HanaExpressPhaseDegraded)
+
+		if statusErr := r.Status().Update(ctx, hanaExpress); statusErr != nil {
+			log.Error(statusErr, "Failed to update HanaExpress status")
+			return ctrl.Result{}, statusErr
+		}
+
+		// Creating or fixing the Secret triggers the next reconciliation through the Secret watch
+		return ctrl.Result{}, nil
+	}
+
+	// Render the passwords JSON the container reads from the referenced credential
+	if err := r.reconcilePasswordsSecret(ctx, hanaExpress); err != nil {
+		log.Error(err, "Failed to reconcile the passwords Secret")
+		return ctrl.Result{}, err
+	}
+
+	// Resolve the image before proceeding, an unsupported version is a spec error that
+	// is only retried once the custom resource or the operator configuration changes
+	if _, _, err := imageForHanaExpress(hanaExpress); err != nil {
+		log.Error(err, "Image validation failed")
+
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{
+			Type:    typeAvailableHanaExpress,
+			Status:  metav1.ConditionFalse,
+			Reason:  "UnsupportedVersion",
+			Message: fmt.Sprintf("Image validation failed: %s", err.Error()),
+		})
+		setPhase(hanaExpress, dbv1alpha1.HanaExpressPhaseDegraded)
+
+		if statusErr := r.Status().Update(ctx, hanaExpress); statusErr != nil {
+			log.Error(statusErr, "Failed to update HanaExpress status")
+			return ctrl.Result{}, statusErr
+		}
+
+		return ctrl.Result{}, nil
+	}
+
+	// The backup volume has to exist before the pod that mounts it is scheduled
+	if err := r.reconcileBackupVolumeClaim(ctx, hanaExpress); err != nil {
+		log.Error(err, "Failed to reconcile the backup PVC")
+		return ctrl.Result{}, err
+	}
+
 	// Check if the statefulset already exists, if not create a new one
 	found := &appsv1.StatefulSet{}
 	err = r.Get(ctx, types.NamespacedName{Name: hanaExpress.Name, Namespace: hanaExpress.Namespace}, found)
 	if err != nil && apierrors.IsNotFound(err) {
+		// Resolve the source of a clone once, the data PVC is provisioned from it
+		if hanaExpress.Spec.Source != nil && hanaExpress.Status.Source == nil {
+			source, err := r.resolveDataSource(ctx, hanaExpress)
+			if err != nil {
+				log.Error(err, "Failed to resolve the data source")
+
+				meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
+					Status: metav1.ConditionFalse, Reason: "InvalidSource",
+					Message: fmt.Sprintf("Failed to resolve the data source: %s", err)})
+				setPhase(hanaExpress, dbv1alpha1.HanaExpressPhaseDegraded)
+
+				if err := r.Status().Update(ctx, hanaExpress); err != nil {
+					log.Error(err, "Failed to update HanaExpress status")
+					return ctrl.Result{}, err
+				}
+
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+
+			if source == nil {
+				meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeProgressingHanaExpress,
+					Status: metav1.ConditionTrue, Reason: "SnapshottingSource",
+					Message: fmt.Sprintf("Waiting for HanaExpressBackup %s of the running source", sourceBackupName(hanaExpress))})
+
+				if err := r.Status().Update(ctx, hanaExpress); err != nil {
+					log.Error(err, "Failed to update HanaExpress status")
+					return ctrl.Result{}, err
+				}
+
+				// The owned backup triggers the next reconciliation when it completes
+				return ctrl.Result{}, nil
+			}
+
+			hanaExpress.Status.Source = source
+			if err := r.Status().Update(ctx, hanaExpress); err != nil {
+				log.Error(err, "Failed to update HanaExpress status")
+				return ctrl.Result{}, err
+			}
+
+			r.Recorder.Event(hanaExpress, "Normal", "Cloning",
+				fmt.Sprintf("Provisioning the data PVC from %s %s", source.Kind, source.Name))
+		}
+
 		// Define a new statefulset
 		sts, err := r.statefulSetForHanaExpress(hanaExpress)
 		if err != nil {
@@ -296,6 +403,7 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
 				Status: metav1.ConditionFalse, Reason: "Reconciling",
 				Message: fmt.Sprintf("Failed to create StatefulSet for the custom resource (%s): (%s)", hanaExpress.Name, err)})
+			setPhase(hanaExpress, dbv1alpha1.HanaExpressPhaseDegraded)
 
 			if err := r.Status().Update(ctx, hanaExpress); err != nil {
 				log.Error(err, "Failed to update HanaExpress status")
@@ -313,10 +421,9 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 
-		// StatefulSet created successfully
-		// We will requeue the reconciliation so that we can ensure the state
-		// and move forward for the next operations
-		return ctrl.Result{RequeueAfter: time.Minute}, nil
+		// StatefulSet created successfully, its status changes trigger the
+		// next reconciliation through the Owns watch
+		return ctrl.Result{}, nil
 	} else if err != nil {
 		log.Error(err, "Failed to get StatefulSet")
 		// Let's return the error for the reconciliation be re-trigged again
@@ -327,7 +434,7 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	err = r.Get(ctx, types.NamespacedName{Name: hanaExpress.Name, Namespace: hanaExpress.Namespace}, foundSvc)
 	if err != nil && apierrors.IsNotFound(err) {
 		// Define a new statefulset
-		svc, err := r.clusterServiceForHanaExpress(hanaExpress)
+		svc, err := r.clusterServiceForHanaExpress(hanaExpress, nil)
 		if err != nil {
 			log.Error(err, "Failed to define new Serivce for HanaExpress")
 
@@ -335,6 +442,7 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
 				Status: metav1.ConditionFalse, Reason: "Reconciling",
 				Message: fmt.Sprintf("Failed to create Service for the custom resource (%s): (%s)", hanaExpress.Name, err)})
+			setPhase(hanaExpress, dbv1alpha1.HanaExpressPhaseDegraded)
 
 			if err := r.Status().Update(ctx, hanaExpress); err != nil {
 				log.Error(err, "Failed to update HanaExpress status")
@@ -352,19 +460,83 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 
-		// Service created successfully
-		// We will requeue the reconciliation so that we can ensure the state
-		// and move forward for the next operations
-		return ctrl.Result{RequeueAfter: time.Minute}, nil
+		// Service created successfully, the next reconciliation is triggered
+		// through the Owns watch
+		return ctrl.Result{}, nil
 	} else if err != nil {
 		log.Error(err, "Failed to get Service")
 		// Let's return the error for the reconciliation be re-trigged again
 		return ctrl.Result{}, err
 	}
 
-	size := int32(1)
-	if *found.Spec.Replicas != size {
-		found.Spec.Replicas = &size
+	// StatefulSets created by older operator versions carry the image version in their
+	// immutable selector. They are deleted while orphaning the pod and the PVC, so the
+	// StatefulSet recreated with the stable selector adopts both without data loss.
+	if !equality.Semantic.DeepEqual(found.Spec.Selector.MatchLabels, selectorLabelsForHanaExpress(hanaExpress.Name)) {
+		// The orphan deletion waits for the garbage collector, it is not repeated meanwhile
+		if found.GetDeletionTimestamp() != nil {
+			log.Info("Waiting for the StatefulSet with the old selector to be deleted",
+				"StatefulSet.Namespace", found.Namespace, "StatefulSet.Name", found.Name)
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
+
+		log.Info("Migrating StatefulSet to the stable selector",
+			"StatefulSet.Namespace", found.Namespace, "StatefulSet.Name", found.Name)
+		if err := r.Delete(ctx, found, client.PropagationPolicy(metav1.DeletePropagationOrphan)); err != nil {
+			log.Error(err, "Failed to delete StatefulSet for the selector migration",
+				"StatefulSet.Namespace", found.Namespace, "StatefulSet.Name", found.Name)
+			return ctrl.Result{}, err
+		}
+
+		r.Recorder.Event(hanaExpress, "Normal", "SelectorMigrated",
+			fmt.Sprintf("StatefulSet %s is recreated with a stable selector, its pod and PVC are kept", found.Name))
+		return ctrl.Result{}, nil
+	}
+
+	// Drive image changes through the upgrade workflow before the drift reconciliation
+	// rolls out the image selected for the current upgrade phase
+	upgradeChanged, err := r.reconcileUpgrade(ctx, hanaExpress, found)
+	if err != nil {
+		// The upgrade phase is only advanced in the status once a step succeeded, it is
+		// retried with the error backoff
+		log.Error(err, "Failed to reconcile the upgrade")
+		r.Recorder.Event(hanaExpress, "Warning", "UpgradeError", fmt.Sprintf("Failed to reconcile the upgrade: %s", err))
+		return ctrl.Result{}, err
+	}
+	if upgradeChanged {
+		if err := r.Status().Update(ctx, hanaExpress); err != nil {
+			log.Error(err, "Failed to update HanaExpress status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// Compare the desired StatefulSet built from the spec against the one in the
+	// cluster so that CR changes and manual edits of the child are corrected.
+	desiredSts, err := r.statefulSetForHanaExpress(hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to define desired StatefulSet resource for HanaExpress")
+		return ctrl.Result{}, err
+	}
+
+	// The SystemDB is recovered offline, HANA is kept stopped while a restore recovers it
+	stoppedFor, err := r.instanceStoppedForRestore(ctx, hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to get the HanaExpressRestore holding the instance")
+		return ctrl.Result{}, err
+	}
+	if stoppedFor != "" {
+		desiredSts.Spec.Replicas = &[]int32{0}[0]
+	}
+
+	if statefulSetNeedsUpdate(desiredSts, found) {
+		log.Info("StatefulSet drifted from the desired state, updating",
+			"StatefulSet.Namespace", found.Namespace, "StatefulSet.Name", found.Name)
+
+		// Selector and VolumeClaimTemplates are immutable, so only the mutable
+		// parts of the spec are copied over.
+		found.Spec.Replicas = desiredSts.Spec.Replicas
+		found.Spec.Template = desiredSts.Spec.Template
 		if err = r.Update(ctx, found); err != nil {
 			log.Error(err, "Failed to update StatefulSet",
 				"StatefulSet.Namespace", found.Namespace, "StatefulSet.Name", found.Name)
@@ -373,11 +545,13 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 				log.Error(err, "Failed to re-fetch HanaExpress")
 				return ctrl.Result{}, err
 			}
+			observeHanaExpress(hanaExpress)
 
 			// The following implementation will update the status
 			meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
-				Status: metav1.ConditionFalse, Reason: "Resizing",
-				Message: fmt.Sprintf("Failed to update the size for the custom resource (%s): (%s)", hanaExpress.Name, err)})
+				Status: metav1.ConditionFalse, Reason: "UpdateFailed",
+				Message: fmt.Sprintf("Failed to update the StatefulSet for the custom resource (%s): (%s)", hanaExpress.Name, err)})
+			setPhase(hanaExpress, dbv1alpha1.HanaExpressPhaseDegraded)
 
 			if err := r.Status().Update(ctx, hanaExpress); err != nil {
 				log.Error(err, "Failed to update HanaExpress status")
@@ -387,20 +561,233 @@ func (r *HanaExpressReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 			return ctrl.Result{}, err
 		}
 
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeProgressingHanaExpress,
+			Status: metav1.ConditionTrue, Reason: "StatefulSetUpdated",
+			Message: fmt.Sprintf("StatefulSet for custom resource (%s) updated to match the desired state", hanaExpress.Name)})
+
+		if err := r.Status().Update(ctx, hanaExpress); err != nil {
+			log.Error(err, "Failed to update HanaExpress status")
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	tenantPorts, err := r.tenantPortsForHanaExpress(ctx, hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to list the tenant databases of HanaExpress")
+		return ctrl.Result{}, err
+	}
+
+	desiredSvc, err := r.clusterServiceForHanaExpress(hanaExpress, tenantPorts)
+	if err != nil {
+		log.Error(err, "Failed to define desired Service for HanaExpress")
+		return ctrl.Result{}, err
+	}
+
+	if serviceNeedsUpdate(desiredSvc, foundSvc) {
+		log.Info("Service drifted from the desired state, updating",
+			"Service.Namespace", foundSvc.Namespace, "Service.Name", foundSvc.Name)
+
+		// ClusterIP and the other allocated fields are kept as they are.
+		foundSvc.Labels = desiredSvc.Labels
+		foundSvc.Spec.Selector = desiredSvc.Spec.Selector
+		if desiredSvc.Spec.Type != "" && desiredSvc.Spec.Type == foundSvc.Spec.Type {
+			keepNodePorts(desiredSvc.Spec.Ports, foundSvc.Spec.Ports)
+		}
+		if desiredSvc.Spec.Type != "" {
+			foundSvc.Spec.Type = desiredSvc.Spec.Type
+		}
+		foundSvc.Spec.Ports = desiredSvc.Spec.Ports
+		if err = r.Update(ctx, foundSvc); err != nil {
+			log.Error(err, "Failed to update Service",
+				"Service.Namespace", foundSvc.Namespace, "Service.Name", foundSvc.Name)
+			return ctrl.Result{}, err
+		}
+
 		return ctrl.Result{Requeue: true}, nil
 	}
 
-	// The following implementation will update the status
-	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
-		Status: metav1.ConditionTrue, Reason: "Reconciling",
-		Message: fmt.Sprintf("StatefulSet for custom resource (%s) with %d replicas created successfully", hanaExpress.Name, size)})
+	// Expand the data volume when spec.pvcSize grows
+	if err := r.reconcileDataVolumeSize(ctx, hanaExpress); err != nil {
+		log.Error(err, "Failed to reconcile the data PVC size")
+
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeStorageResizingHanaExpress,
+			Status: metav1.ConditionFalse, Reason: "ResizeFailed",
+			Message: fmt.Sprintf("Failed to expand the data PVC for the custom resource (%s): (%s)", hanaExpress.Name, err)})
+
+		if err := r.Status().Update(ctx, hanaExpress); err != nil {
+			log.Error(err, "Failed to update HanaExpress status")
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	if stoppedFor != "" {
+		setAvailability(hanaExpress, dbv1alpha1.HanaExpressPhasePending, "Restoring",
+			fmt.Sprintf("HANA Express is stopped while HanaExpressRestore %s recovers the SystemDB", stoppedFor))
+
+		if err := r.Status().Update(ctx, hanaExpress); err != nil {
+			log.Error(err, "Failed to update HanaExpress status")
+			return ctrl.Result{}, err
+		}
+
+		// The phase changes of the restore trigger the next reconciliation
+		return ctrl.Result{}, nil
+	}
+
+	// Report whether the StatefulSet controller is still rolling out the last change
+	if rolloutInProgress(found) {
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeProgressingHanaExpress,
+			Status: metav1.ConditionTrue, Reason: "RollingUpdate",
+			Message: fmt.Sprintf("StatefulSet for custom resource (%s) is rolling out revision %s", hanaExpress.Name, found.Status.UpdateRevision)})
+
+		// The database stays available while the pod that is about to be replaced is ready
+		pod, err := r.podForHanaExpress(ctx, hanaExpress)
+		if err != nil {
+			log.Error(err, "Failed to get the HanaExpress pod")
+			return ctrl.Result{}, err
+		}
+		if pod == nil || !podReady(pod) {
+			phase, reason, message := podPhaseForHanaExpress(pod)
+			setAvailability(hanaExpress, phase, reason, message)
+		}
+
+		if err := r.Status().Update(ctx, hanaExpress); err != nil {
+			log.Error(err, "Failed to update HanaExpress status")
+			return ctrl.Result{}, err
+		}
+
+		// The status updates of the StatefulSet and its pod trigger the next reconciliation
+		return ctrl.Result{}, nil
+	}
+
+	return r.reconcileRunningDatabase(ctx, hanaExpress, found)
+}
+
+// reconcileRunningDatabase checks the health of the rolled out database and records it in the
+// status before the steps that log on to the database run, so a failing step never leaves the
+// conditions of an earlier check behind
+func (r *HanaExpressReconciler) reconcileRunningDatabase(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	found *appsv1.StatefulSet) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeProgressingHanaExpress,
+		Status: metav1.ConditionFalse, Reason: "RolloutComplete",
+		Message: fmt.Sprintf("StatefulSet for custom resource (%s) is up to date", hanaExpress.Name)})
+
+	// Record the image the rolled out pods are running
+	hanaExpress.Status.Image = found.Spec.Template.Spec.Containers[0].Image
+	_, hanaExpress.Status.Version = dbv1alpha1.SplitImage(hanaExpress.Status.Image)
+
+	// The readiness probe only opens a TCP connection, the database is available once the
+	// SystemDB accepts the master password and its services and the HXE tenant are active
+	health, err := r.checkDatabaseHealth(ctx, hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to check the database health")
+		return ctrl.Result{}, err
+	}
+
+	if err := r.Status().Update(ctx, hanaExpress); err != nil {
+		log.Error(err, "Failed to update HanaExpress status")
+		return ctrl.Result{}, err
+	}
+
+	// The steps below need a database that serves SQL. A rejected logon is expected while a
+	// clone still runs with the passwords of its source or a changed master password is not
+	// applied yet, which is what the credential steps fix.
+	if !health.Healthy() && health.Reason != hdbclient.HealthReasonAuthenticationFailed {
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	// A clone starts with the passwords of its source, replace them with its own credential
+	if err := r.reconcileClonedCredentials(ctx, hanaExpress); err != nil {
+		log.Error(err, "Failed to reset the credentials of the clone")
+		r.Recorder.Event(hanaExpress, "Warning", "CredentialsResetFailed",
+			fmt.Sprintf("Failed to replace the SYSTEM passwords cloned from the source: %s", err))
+
+		setAvailability(hanaExpress, dbv1alpha1.HanaExpressPhaseDegraded, "CredentialsResetFailed",
+			fmt.Sprintf("Failed to replace the SYSTEM passwords cloned from the source: %s", err))
+
+		if err := r.Status().Update(ctx, hanaExpress); err != nil {
+			log.Error(err, "Failed to update HanaExpress status")
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	// Apply a changed master password of the Secret, rotating it first when the schedule is due
+	nextRotation, err := r.reconcileMasterPassword(ctx, hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to rotate the master password")
+		r.Recorder.Event(hanaExpress, "Warning", "PasswordRotationFailed",
+			fmt.Sprintf("Failed to apply the master password: %s", err))
+
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeCredentialSyncedHanaExpress,
+			Status: metav1.ConditionFalse, Reason: "RotationFailed",
+			Message: fmt.Sprintf("Failed to apply the master password: %s", err)})
+		if !health.Healthy() {
+			setAvailability(hanaExpress, dbv1alpha1.HanaExpressPhaseDegraded, "RotationFailed",
+				fmt.Sprintf("The database rejects the master password and applying it failed: %s", err))
+		}
+
+		if err := r.Status().Update(ctx, hanaExpress); err != nil {
+			log.Error(err, "Failed to update HanaExpress status")
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeCredentialSyncedHanaExpress,
+		Status: metav1.ConditionTrue, Reason: "Synced",
+		Message: "The database runs with the master password of the Secret"})
+
+	// Publish the connection details of the binding user
+	if err := r.reconcileBindingSecret(ctx, hanaExpress); err != nil {
+		log.Error(err, "Failed to reconcile the binding Secret")
+		r.Recorder.Event(hanaExpress, "Warning", "BindingFailed",
+			fmt.Sprintf("Failed to publish the binding Secret: %s", err))
+
+		// Keep what the credential steps applied
+		if err := r.Status().Update(ctx, hanaExpress); err != nil {
+			log.Error(err, "Failed to update HanaExpress status")
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	// The database is up, make sure its memory manager is sized to the container memory limit
+	if err := r.reconcileGlobalAllocationLimit(ctx, hanaExpress); err != nil {
+		log.Error(err, "Failed to apply the global_allocation_limit")
+		r.Recorder.Event(hanaExpress, "Warning", "AllocationLimitFailed",
+			fmt.Sprintf("Failed to apply the global_allocation_limit: %s", err))
+
+		if err := r.Status().Update(ctx, hanaExpress); err != nil {
+			log.Error(err, "Failed to update HanaExpress status")
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
 
 	if err := r.Status().Update(ctx, hanaExpress); err != nil {
-		log.Error(err, "Failed to update StatefulSet status")
+		log.Error(err, "Failed to update HanaExpress status")
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	// A running expansion is followed through the PVC watch, the health checks and the
+	// rotation schedule are timed. A database that rejected the master password before the
+	// credential steps is checked again soon.
+	requeueAfter := healthCheckInterval
+	if !health.Healthy() {
+		requeueAfter = 30 * time.Second
+	}
+	if nextRotation > 0 && nextRotation < requeueAfter {
+		requeueAfter = nextRotation
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
 // doFinalizerOperationsForHanaExpress will perform the required operations before delete the CR.
@@ -411,8 +798,15 @@ func (r *HanaExpressReconciler) doFinalizerOperationsForHanaExpress(cr *dbv1alph
 			cr.Name,
 			cr.Namespace))
 
-	if cr.Spec.IsDataPersisted {
-		log.Info("Data Persistence is set to 'true'. No PVC cleanup will be performed")
+	policy := cr.EffectiveDeletionPolicy()
+	if policy != dbv1alpha1.DeletionPolicyDelete {
+		// The kept data cannot be used without its master password
+		if err := r.orphanGeneratedCredential(ctx, cr); err != nil {
+			return err
+		}
+	}
+	if policy == dbv1alpha1.DeletionPolicyRetain {
+		log.Info("Deletion policy is Retain. No PVC cleanup will be performed")
 		return nil
 	}
 
@@ -445,14 +839,22 @@ func (r *HanaExpressReconciler) doFinalizerOperationsForHanaExpress(cr *dbv1alph
 // statefulSetForHanaExpress returns a HanaExpress StatefulSet object
 func (r *HanaExpressReconciler) statefulSetForHanaExpress(
 	hanaExpress *dbv1alpha1.HanaExpress) (*appsv1.StatefulSet, error) {
-	ls := labelsForHanaExpress(hanaExpress.Name)
 	replicas := int32(1)
 
-	// Get the Operand image
-	image, err := imageForHanaExpress()
+	// Changes of the derived limit are made visible on the pod template
+	annotations := map[string]string{}
+	if limit := globalAllocationLimitForHanaExpress(hanaExpress); limit != "" {
+		annotations[globalAllocationLimitAnnotation] = limit
+	}
+
+	// Get the Operand image, a changed image is rolled out through the upgrade workflow
+	desiredImage, _, err := imageForHanaExpress(hanaExpress)
 	if err != nil {
 		return nil, err
 	}
+	image := templateImageForHanaExpress(hanaExpress, desiredImage)
+	_, version := dbv1alpha1.SplitImage(image)
+	ls := labelsForHanaExpress(hanaExpress.Name, version)
 
 	sts := &appsv1.StatefulSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -462,7 +864,7 @@ func (r *HanaExpressReconciler) statefulSetForHanaExpress(
 		Spec: appsv1.StatefulSetSpec{
 			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: ls,
+				MatchLabels: selectorLabelsForHanaExpress(hanaExpress.Name),
 			},
 			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
 				{
@@ -478,14 +880,20 @@ func (r *HanaExpressReconciler) statefulSetForHanaExpress(
 								corev1.ResourceStorage: resourceQuantity(hanaExpress.Spec.PVCSize),
 							},
 						},
+						// Clones are provisioned from their source instead of starting empty
+						DataSource: dataSourceForHanaExpress(hanaExpress),
 					},
 				},
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: ls,
+					Labels:      ls,
+					Annotations: annotations,
 				},
 				Spec: corev1.PodSpec{
+					// Give HANA time to shut down cleanly when a spec change rolls the pod
+					TerminationGracePeriodSeconds: &[]int64{300}[0],
+
 					// TODO(user): Uncomment the following code to configure the nodeAffinity expression
 					// according to the platforms which are supported by your solution. It is considered
 					// best practice to support multiple architectures. build your manager image using the
@@ -524,14 +932,21 @@ func (r *HanaExpressReconciler) statefulSetForHanaExpress(
 					//	},
 					//},
 
+					// The passwords Secret is only readable by its owner and the HANA group (sapsys).
+					// The data volume is only relabelled when its root does not belong to the group.
+					SecurityContext: &corev1.PodSecurityContext{
+						FSGroup:             &[]int64{79}[0],
+						FSGroupChangePolicy: &[]corev1.PodFSGroupChangePolicy{corev1.FSGroupChangeOnRootMismatch}[0],
+					},
+
 					Volumes: []corev1.Volume{
 						{
-							Name: "hxepasswd",
+							Name: "passwords",
 							VolumeSource: corev1.VolumeSource{
 								Secret: &corev1.SecretVolumeSource{
-									SecretName: hanaExpress.Spec.Credential.SecretKeyRef.Name,
+									SecretName: passwordsSecretName(hanaExpress),
 									DefaultMode: func() *int32 {
-										mode := int32(0511)
+										mode := int32(0440)
 										return &mode
 									}(),
 								},
@@ -545,10 +960,6 @@ func (r *HanaExpressReconciler) statefulSetForHanaExpress(
 							Name:    "set-data-dir-ownership",
 							Command: r.getInitContainerCommand(hanaExpress),
 							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "hxepasswd",
-									MountPath: "/tmp/mounts",
-								},
 								{
 									Name:      "data",
 									MountPath: "/hana/mounts",
@@ -598,26 +1009,27 @@ func (r *HanaExpressReconciler) statefulSetForHanaExpress(
 									Protocol:      corev1.ProtocolTCP,
 								},
 							},
-							Command: []string{"/run_hana", "--passwords-url", r.getPasswordFilePath(hanaExpress), "--agree-to-sap-license"},
+							Command: commandForHanaExpress("/run_hana", "--passwords-url", r.getPasswordFilePath(hanaExpress),
+								"--agree-to-sap-license"),
+							Env: []corev1.EnvVar{
+								{
+									Name:  globalAllocationLimitEnv,
+									Value: globalAllocationLimitForHanaExpress(hanaExpress),
+								},
+							},
+							Resources: hanaExpress.Spec.Resources,
 							VolumeMounts: []corev1.VolumeMount{
 								{
-									Name:      "hxepasswd",
-									MountPath: "/tmp/mounts",
+									Name:      "passwords",
+									MountPath: passwordsMountPath,
+									ReadOnly:  true,
 								},
 								{
 									Name:      "data",
 									MountPath: "/hana/mounts",
 								},
 							},
-							ReadinessProbe: &corev1.Probe{
-								ProbeHandler: corev1.ProbeHandler{
-									TCPSocket: &corev1.TCPSocketAction{
-										Port: intstr.FromInt(39017),
-									},
-								},
-								InitialDelaySeconds: 10,
-								PeriodSeconds:       5,
-							},
+							ReadinessProbe: readinessProbeForHanaExpress(hanaExpress),
 						},
 					},
 				},
@@ -625,6 +1037,10 @@ func (r *HanaExpressReconciler) statefulSetForHanaExpress(
 		},
 	}
 
+	if hanaExpress.Spec.BackupPVCSize != "" {
+		addBackupVolume(hanaExpress, &sts.Spec.Template.Spec)
+	}
+
 	// Set the ownerRef for the Deployment
 	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/owners-dependents/
 	if err := ctrl.SetControllerReference(hanaExpress, sts, r.Scheme); err != nil {
@@ -633,19 +1049,54 @@ func (r *HanaExpressReconciler) statefulSetForHanaExpress(
 	return sts, nil
 }
 
+// readinessProbeForHanaExpress returns the readiness probe on the SQL port of the HXE tenant.
+// Timings the defaulting webhook did not set fall back to the same defaults. The success
+// threshold is set to the value the API server defaults, so it is not reported as drift.
+func readinessProbeForHanaExpress(hanaExpress *dbv1alpha1.HanaExpress) *corev1.Probe {
+	timings := dbv1alpha1.ProbeTimings{}
+	if hanaExpress.Spec.ReadinessProbe != nil {
+		timings = *hanaExpress.Spec.ReadinessProbe
+	}
+	orDefault := func(value, fallback int32) int32 {
+		if value == 0 {
+			return fallback
+		}
+		return value
+	}
+
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{
+				Port: intstr.FromInt(int(hanaTenantSQLPort)),
+			},
+		},
+		InitialDelaySeconds: orDefault(timings.InitialDelaySeconds, dbv1alpha1.DefaultProbeInitialDelaySeconds),
+		PeriodSeconds:       orDefault(timings.PeriodSeconds, dbv1alpha1.DefaultProbePeriodSeconds),
+		TimeoutSeconds:      orDefault(timings.TimeoutSeconds, dbv1alpha1.DefaultProbeTimeoutSeconds),
+		FailureThreshold:    orDefault(timings.FailureThreshold, dbv1alpha1.DefaultProbeFailureThreshold),
+		SuccessThreshold:    1,
+	}
+}
+
 // clusterServiceForHanaExpress returns a HanaExpress cluster service object
 func (r *HanaExpressReconciler) clusterServiceForHanaExpress(
-	hanaExpress *dbv1alpha1.HanaExpress) (*corev1.Service, error) {
+	hanaExpress *dbv1alpha1.HanaExpress, tenantPorts []int32) (*corev1.Service, error) {
+
+	desiredImage, _, err := imageForHanaExpress(hanaExpress)
+	if err != nil {
+		return nil, err
+	}
+	_, version := dbv1alpha1.SplitImage(templateImageForHanaExpress(hanaExpress, desiredImage))
 
-	ls := labelsForHanaExpress(hanaExpress.Name)
 	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      hanaExpress.Name,
 			Namespace: hanaExpress.Namespace,
-			Labels:    ls,
+			Labels:    labelsForHanaExpress(hanaExpress.Name, version),
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: ls,
+			Type:     hanaExpress.Spec.ServiceType,
+			Selector: selectorLabelsForHanaExpress(hanaExpress.Name),
 			Ports: []corev1.ServicePort{
 				{
 					Name:       "port-1",
@@ -680,6 +1131,22 @@ func (r *HanaExpressReconciler) clusterServiceForHanaExpress(
 			},
 		},
 	}
+
+	// Expose the SQL ports of the tenants created through HanaTenantDatabase resources
+	for _, port := range tenantPorts {
+		exposed := false
+		for _, svcPort := range svc.Spec.Ports {
+			exposed = exposed || svcPort.Port == port
+		}
+		if !exposed {
+			svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+				Name:       fmt.Sprintf("tenant-%d", port),
+				Protocol:   corev1.ProtocolTCP,
+				Port:       port,
+				TargetPort: intstr.FromInt(int(port)),
+			})
+		}
+	}
 	if err := ctrl.SetControllerReference(hanaExpress, svc, r.Scheme); err != nil {
 		return nil, err
 	}
@@ -687,38 +1154,207 @@ func (r *HanaExpressReconciler) clusterServiceForHanaExpress(
 	return svc, nil
 }
 
-// labelsForHanaExpress returns the labels for selecting the resources
-// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
-func labelsForHanaExpress(name string) map[string]string {
-	var imageTag string
-	image, err := imageForHanaExpress()
-	if err == nil {
-		imageTag = strings.Split(image, ":")[1]
+// statefulSetNeedsUpdate reports whether the mutable parts of the found StatefulSet
+// differ from the desired one. DeepDerivative is used so that fields defaulted by the
+// API server are not reported as drift.
+func statefulSetNeedsUpdate(desired, found *appsv1.StatefulSet) bool {
+	if found.Spec.Replicas == nil || *found.Spec.Replicas != *desired.Spec.Replicas {
+		return true
 	}
-	return map[string]string{"app.kubernetes.io/name": "HanaExpress",
-		"app.kubernetes.io/instance":   name,
-		"app.kubernetes.io/version":    imageTag,
-		"app.kubernetes.io/part-of":    "hanaexpress-operator",
-		"app.kubernetes.io/created-by": "controller-manager",
+	if podSpecListsDiffer(&desired.Spec.Template.Spec, &found.Spec.Template.Spec) {
+		return true
 	}
+	return !equality.Semantic.DeepDerivative(desired.Spec.Template, found.Spec.Template)
 }
 
-// imageForHanaExpress gets the Operand image which is managed by this controller
-// from the HANAEXPRESS_IMAGE environment variable defined in the config/manager/manager.yaml
-func imageForHanaExpress() (string, error) {
-	var imageEnvVar = "HANAEXPRESS_IMAGE"
-	image, found := os.LookupEnv(imageEnvVar)
-	if !found {
-		return "", fmt.Errorf("Unable to find %s environment variable with the image", imageEnvVar)
+// podSpecListsDiffer detects entries that were added to the found pod spec manually.
+// DeepDerivative only walks the entries of the desired lists, so extra volumes,
+// containers, ports or mounts would otherwise go unnoticed. The environment is compared
+// exactly, DeepDerivative would also skip a value that was cleared in the desired one.
+func podSpecListsDiffer(desired, found *corev1.PodSpec) bool {
+	if len(desired.Volumes) != len(found.Volumes) ||
+		len(desired.InitContainers) != len(found.InitContainers) ||
+		len(desired.Containers) != len(found.Containers) {
+		return true
+	}
+	containersDiffer := func(desired, found []corev1.Container) bool {
+		for i := range desired {
+			if len(desired[i].Ports) != len(found[i].Ports) ||
+				len(desired[i].VolumeMounts) != len(found[i].VolumeMounts) ||
+				!equality.Semantic.DeepEqual(desired[i].Env, found[i].Env) {
+				return true
+			}
+		}
+		return false
+	}
+	return containersDiffer(desired.InitContainers, found.InitContainers) ||
+		containersDiffer(desired.Containers, found.Containers)
+}
+
+// serviceNeedsUpdate reports whether the found Service differs from the desired one
+func serviceNeedsUpdate(desired, found *corev1.Service) bool {
+	if !equality.Semantic.DeepDerivative(desired.Labels, found.Labels) {
+		return true
+	}
+	if !equality.Semantic.DeepEqual(desired.Spec.Selector, found.Spec.Selector) {
+		return true
+	}
+	if desired.Spec.Type != "" && desired.Spec.Type != found.Spec.Type {
+		return true
+	}
+	if len(desired.Spec.Ports) != len(found.Spec.Ports) {
+		return true
+	}
+	// DeepDerivative compares numbers exactly, the allocated node ports are not drift
+	ports := append([]corev1.ServicePort(nil), desired.Spec.Ports...)
+	keepNodePorts(ports, found.Spec.Ports)
+	return !equality.Semantic.DeepDerivative(ports, found.Spec.Ports)
+}
+
+// keepNodePorts copies the node ports allocated to the found ports into the desired ones, so
+// updating a NodePort or LoadBalancer Service does not move its ports
+func keepNodePorts(desired, found []corev1.ServicePort) {
+	for i := range desired {
+		for _, port := range found {
+			if port.Port == desired[i].Port && desired[i].NodePort == 0 {
+				desired[i].NodePort = port.NodePort
+			}
+		}
 	}
-	return image, nil
 }
 
+// rolloutInProgress reports whether the StatefulSet controller has not yet rolled
+// out the latest revision of the pod template to every replica.
+func rolloutInProgress(sts *appsv1.StatefulSet) bool {
+	if sts.Status.ObservedGeneration < sts.Generation {
+		return true
+	}
+	if sts.Status.UpdateRevision != "" && sts.Status.CurrentRevision != sts.Status.UpdateRevision {
+		return true
+	}
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+	return sts.Status.UpdatedReplicas < replicas || sts.Status.ReadyReplicas < replicas
+}
+
+// selectorLabelsForHanaExpress returns the labels for selecting the resources. They
+// are used in the immutable StatefulSet selector and must stay stable over the lifetime
+// of the instance, so nothing that can change, like the version, belongs in here.
+// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+func selectorLabelsForHanaExpress(name string) map[string]string {
+	return map[string]string{"app.kubernetes.io/name": "HanaExpress",
+		"app.kubernetes.io/instance": name,
+	}
+}
+
+// labelsForHanaExpress returns the selector labels plus the informational labels
+// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+func labelsForHanaExpress(name, version string) map[string]string {
+	ls := selectorLabelsForHanaExpress(name)
+	ls["app.kubernetes.io/version"] = version
+	ls["app.kubernetes.io/part-of"] = "hanaexpress-operator"
+	ls["app.kubernetes.io/created-by"] = "controller-manager"
+	return ls
+}
+
+// credentialSecretNameField indexes HanaExpress by the Secrets their credentials reference
+const credentialSecretNameField = ".spec.credential.secretKeyRef.name"
+
 // SetupWithManager sets up the controller with the Manager.
+// The StatefulSet, Service, binding user and binding Secret are owned by the instance. The PVCs
+// are not, so that the Retain deletion policy can keep them, and are mapped back through their
+// instance label.
+// Tenant databases are watched so their SQL ports are added to the Service, restores so the
+// instance is stopped and started again around the recovery of its SystemDB, and credential
+// Secrets so a fixed Secret or a changed master password is acted on immediately. Secrets are
+// watched by their metadata only, and only the labelled pods of the instances are cached.
 func (r *HanaExpressReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &dbv1alpha1.HanaExpress{},
+		credentialSecretNameField, credentialSecretNames); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&dbv1alpha1.HanaExpress{}).
-		Complete(r)
+		Owns(&appsv1.StatefulSet{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.Secret{}, builder.OnlyMetadata).
+		Owns(&dbv1alpha1.HanaUser{}).
+		Owns(&dbv1alpha1.HanaExpressBackup{}).
+		Watches(&corev1.PersistentVolumeClaim{}, handler.EnqueueRequestsFromMapFunc(hanaExpressForInstanceLabels)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(hanaExpressForInstanceLabels)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.hanaExpressesForSecret), builder.OnlyMetadata).
+		Watches(&dbv1alpha1.HanaTenantDatabase{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				tenant := obj.(*dbv1alpha1.HanaTenantDatabase)
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{
+					Name: tenant.Spec.HanaExpressName, Namespace: tenant.Namespace}}}
+			})).
+		Watches(&dbv1alpha1.HanaExpressRestore{}, handler.EnqueueRequestsFromMapFunc(
+			func(ctx context.Context, obj client.Object) []reconcile.Request {
+				restore := obj.(*dbv1alpha1.HanaExpressRestore)
+				return []reconcile.Request{{NamespacedName: types.NamespacedName{
+					Name: restore.Spec.HanaExpressName, Namespace: restore.Namespace}}}
+			})).
+		Complete(withReconcileTimeout(r))
+}
+
+// credentialSecretNames returns the names of the Secrets the credentials of an instance and
+// of its clone source reference, for the credentialSecretNameField index
+func credentialSecretNames(obj client.Object) []string {
+	hanaExpress := obj.(*dbv1alpha1.HanaExpress)
+	names := []string{hanaExpress.Spec.Credential.SecretKeyRef.Name}
+	if source := hanaExpress.Spec.Source; source != nil && source.Credential != nil {
+		names = append(names, source.Credential.SecretKeyRef.Name)
+	}
+	return names
+}
+
+// hanaExpressesForSecret returns the instances whose credentials reference the Secret
+func (r *HanaExpressReconciler) hanaExpressesForSecret(ctx context.Context, obj client.Object) []reconcile.Request {
+	hanaExpresses := &dbv1alpha1.HanaExpressList{}
+	if err := r.List(ctx, hanaExpresses, client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{credentialSecretNameField: obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list HanaExpress for Secret", "Secret.Name", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(hanaExpresses.Items))
+	for _, hanaExpress := range hanaExpresses.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Name: hanaExpress.Name, Namespace: hanaExpress.Namespace}})
+	}
+	return requests
+}
+
+// hanaExpressForInstanceLabels returns the instance a data or backup PVC or the pod belongs to
+func hanaExpressForInstanceLabels(ctx context.Context, obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	name, ok := labels["app.kubernetes.io/instance"]
+	if !ok || labels["app.kubernetes.io/name"] != "HanaExpress" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}}}
+}
+
+// tenantPortsForHanaExpress returns the SQL ports of the tenant databases of the instance in ascending order
+func (r *HanaExpressReconciler) tenantPortsForHanaExpress(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) ([]int32, error) {
+	tenants := &dbv1alpha1.HanaTenantDatabaseList{}
+	if err := r.List(ctx, tenants, client.InNamespace(hanaExpress.Namespace)); err != nil {
+		return nil, err
+	}
+
+	var ports []int32
+	for _, tenant := range tenants.Items {
+		if tenant.Spec.HanaExpressName == hanaExpress.Name && tenant.Status.SQLPort > 0 &&
+			tenant.GetDeletionTimestamp() == nil {
+			ports = append(ports, tenant.Status.SQLPort)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	return ports, nil
 }
 
 // Helper function to create resource quantity
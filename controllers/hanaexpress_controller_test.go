@@ -0,0 +1,634 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func TestDeletionDoesNotNeedTheSecret(t *testing.T) {
+	hanaExpress, _ := testHanaExpress()
+	hanaExpress.Finalizers = []string{hanaExpressFinalizer}
+	hanaExpress.DeletionTimestamp = &metav1.Time{Time: metav1.Now().Time}
+	hanaExpress.Spec.DeletionPolicy = dbv1alpha1.DeletionPolicyDelete
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-hxe-0", Namespace: testNamespace,
+		Labels: selectorLabelsForHanaExpress("hxe")}}
+	// The referenced Secret was deleted before the instance
+	c := newFakeClient(hanaExpress, pvc)
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+
+	key := types.NamespacedName{Name: "hxe", Namespace: testNamespace}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Get(context.Background(), key, &dbv1alpha1.HanaExpress{}); !apierrors.IsNotFound(err) {
+		t.Errorf("HanaExpress is still there after the finalizer ran: %v", err)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(pvc), &corev1.PersistentVolumeClaim{}); !apierrors.IsNotFound(err) {
+		t.Errorf("data PVC is still there: %v", err)
+	}
+}
+
+func TestHanaExpressesForSecret(t *testing.T) {
+	instance := func(name, secret string, source *dbv1alpha1.DataSource) *dbv1alpha1.HanaExpress {
+		hanaExpress, _ := testHanaExpress()
+		hanaExpress.Name = name
+		hanaExpress.Spec.Credential.SecretKeyRef.Name = secret
+		hanaExpress.Spec.Source = source
+		return hanaExpress
+	}
+	clone := instance("clone", "clone-credential", &dbv1alpha1.DataSource{HanaExpressName: "hxe",
+		Credential: &dbv1alpha1.Credential{SecretKeyRef: corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "hxe-credential"}, Key: "password"}}})
+	other := instance("other", "other-credential", nil)
+	elsewhere := instance("hxe", "hxe-credential", nil)
+	elsewhere.Namespace = "elsewhere"
+
+	base := newFakeClient()
+	c := fake.NewClientBuilder().
+		WithScheme(base.Scheme()).
+		WithObjects(instance("hxe", "hxe-credential", nil), clone, other, elsewhere).
+		WithIndex(&dbv1alpha1.HanaExpress{}, credentialSecretNameField, credentialSecretNames).
+		Build()
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme()}
+
+	tests := []struct {
+		secret string
+		want   []string
+	}{
+		{secret: "hxe-credential", want: []string{"clone", "hxe"}},
+		{secret: "clone-credential", want: []string{"clone"}},
+		{secret: "unreferenced"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.secret, func(t *testing.T) {
+			secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: tt.secret, Namespace: testNamespace}}
+			var got []string
+			for _, request := range r.hanaExpressesForSecret(context.Background(), secret) {
+				if request.Namespace != testNamespace {
+					t.Errorf("request for %s in namespace %s", request.Name, request.Namespace)
+				}
+				got = append(got, request.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("instances = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHanaExpressForInstanceLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []reconcile.Request
+	}{
+		{
+			name:   "labelled pod or PVC of an instance",
+			labels: labelsForHanaExpress("hxe", "2.00.072.00.20230728.1"),
+			want:   []reconcile.Request{{NamespacedName: types.NamespacedName{Name: "hxe", Namespace: testNamespace}}},
+		},
+		{
+			name:   "instance label of another application",
+			labels: map[string]string{"app.kubernetes.io/name": "postgres", "app.kubernetes.io/instance": "hxe"},
+		},
+		{
+			name:   "no instance label",
+			labels: map[string]string{"app.kubernetes.io/name": "HanaExpress"},
+		},
+		{
+			name: "no labels",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-hxe-0",
+				Namespace: testNamespace, Labels: tt.labels}}
+			if got := hanaExpressForInstanceLabels(context.Background(), pvc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requests = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectorMigrationDeletesTheStatefulSetOnce(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	hanaExpress, secret := testHanaExpress()
+	hanaExpress.Finalizers = []string{hanaExpressFinalizer}
+	legacyLabels := labelsForHanaExpress("hxe", "2.00.061.00.20220519.1")
+	sts := testStatefulSet(testFromImage)
+	sts.Spec.Selector = &metav1.LabelSelector{MatchLabels: legacyLabels}
+	// The finalizer keeps the StatefulSet terminating like the orphaning garbage collector does
+	sts.Finalizers = []string{metav1.FinalizerOrphanDependents}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "hxe-0", Namespace: testNamespace, Labels: legacyLabels}}
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-hxe-0", Namespace: testNamespace,
+		Labels: legacyLabels}}
+
+	base := newFakeClient(hanaExpress, secret, sts, pod, pvc)
+	r := &HanaExpressReconciler{Client: base, Scheme: base.Scheme(), Recorder: newTestRecorder()}
+	svc, err := r.clusterServiceForHanaExpress(hanaExpress, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := base.Create(context.Background(), svc); err != nil {
+		t.Fatal(err)
+	}
+
+	var deletes []client.DeleteOptions
+	r.Client = interceptor.NewClient(base.(client.WithWatch), interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			if _, ok := obj.(*appsv1.StatefulSet); ok {
+				options := client.DeleteOptions{}
+				options.ApplyOptions(opts)
+				deletes = append(deletes, options)
+			}
+			return c.Delete(ctx, obj, opts...)
+		},
+	})
+
+	key := types.NamespacedName{Name: "hxe", Namespace: testNamespace}
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(deletes) != 1 {
+		t.Fatalf("StatefulSet was deleted %d times, want once", len(deletes))
+	}
+	if deletes[0].PropagationPolicy == nil || *deletes[0].PropagationPolicy != metav1.DeletePropagationOrphan {
+		t.Errorf("propagation policy = %v, want %s", deletes[0].PropagationPolicy, metav1.DeletePropagationOrphan)
+	}
+	events := r.Recorder.(*record.FakeRecorder).Events
+	migrated := 0
+	for len(events) > 0 {
+		if strings.Contains(<-events, "SelectorMigrated") {
+			migrated++
+		}
+	}
+	if migrated != 1 {
+		t.Errorf("%d SelectorMigrated events, want 1", migrated)
+	}
+	if err := base.Get(context.Background(), client.ObjectKeyFromObject(pod), &corev1.Pod{}); err != nil {
+		t.Errorf("pod was not kept: %v", err)
+	}
+	if err := base.Get(context.Background(), client.ObjectKeyFromObject(pvc), &corev1.PersistentVolumeClaim{}); err != nil {
+		t.Errorf("data PVC was not kept: %v", err)
+	}
+}
+
+func TestStatefulSetForHanaExpressPasswordsVolume(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	hanaExpress, _ := testHanaExpress()
+	c := newFakeClient()
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme()}
+
+	sts, err := r.statefulSetForHanaExpress(hanaExpress)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := sts.Spec.Template.Spec
+	if security := spec.SecurityContext; security == nil || security.FSGroup == nil || *security.FSGroup != 79 ||
+		security.FSGroupChangePolicy == nil || *security.FSGroupChangePolicy != corev1.FSGroupChangeOnRootMismatch {
+		t.Errorf("pod security context = %+v, want fsGroup 79 changed on a root mismatch", security)
+	}
+	for _, volume := range spec.Volumes {
+		if volume.Name != "passwords" {
+			continue
+		}
+		if mode := volume.Secret.DefaultMode; mode == nil || *mode != 0440 {
+			t.Errorf("passwords mode = %v, want 0440", mode)
+		}
+	}
+	security := spec.Containers[0].SecurityContext
+	if *security.RunAsUser != 12000 || *security.RunAsGroup != 79 {
+		t.Errorf("container runs as %d:%d, want 12000:79", *security.RunAsUser, *security.RunAsGroup)
+	}
+
+	// A StatefulSet without the fsGroup gets it
+	found := sts.DeepCopy()
+	if statefulSetNeedsUpdate(sts, found) {
+		t.Error("an unchanged StatefulSet needs an update")
+	}
+	found.Spec.Template.Spec.SecurityContext = nil
+	if !statefulSetNeedsUpdate(sts, found) {
+		t.Error("a StatefulSet without the pod fsGroup does not need an update")
+	}
+}
+
+// withServerDefaults returns a copy of the StatefulSet with the fields the API server defaults
+func withServerDefaults(sts *appsv1.StatefulSet) *appsv1.StatefulSet {
+	found := sts.DeepCopy()
+	found.Spec.PodManagementPolicy = appsv1.OrderedReadyPodManagement
+	found.Spec.RevisionHistoryLimit = &[]int32{10}[0]
+	spec := &found.Spec.Template.Spec
+	spec.RestartPolicy = corev1.RestartPolicyAlways
+	spec.DNSPolicy = corev1.DNSClusterFirst
+	spec.SchedulerName = corev1.DefaultSchedulerName
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for i := range containers {
+			containers[i].TerminationMessagePath = corev1.TerminationMessagePathDefault
+			containers[i].TerminationMessagePolicy = corev1.TerminationMessageReadFile
+			if containers[i].ImagePullPolicy == "" {
+				containers[i].ImagePullPolicy = corev1.PullIfNotPresent
+			}
+			if probe := containers[i].ReadinessProbe; probe != nil {
+				probe.SuccessThreshold = 1
+			}
+		}
+	}
+	return found
+}
+
+func TestStatefulSetNeedsUpdate(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	hanaExpress, _ := testHanaExpress()
+	hanaExpress.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")}
+	c := newFakeClient()
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme()}
+	desired, err := r.statefulSetForHanaExpress(hanaExpress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hana := func(sts *appsv1.StatefulSet) *corev1.Container { return &sts.Spec.Template.Spec.Containers[0] }
+
+	tests := []struct {
+		name   string
+		edit   func(found *appsv1.StatefulSet)
+		update bool
+	}{
+		{
+			name: "unchanged",
+		},
+		{
+			name: "fields defaulted by the API server",
+			edit: func(found *appsv1.StatefulSet) {
+				*found = *withServerDefaults(found)
+			},
+		},
+		{
+			name: "added env",
+			edit: func(found *appsv1.StatefulSet) {
+				hana(found).Env = append(hana(found).Env, corev1.EnvVar{Name: "DEBUG", Value: "1"})
+			},
+			update: true,
+		},
+		{
+			name: "removed env",
+			edit: func(found *appsv1.StatefulSet) {
+				hana(found).Env = nil
+			},
+			update: true,
+		},
+		{
+			name: "cleared env value",
+			edit: func(found *appsv1.StatefulSet) {
+				hana(desired).Env[0].Value = ""
+			},
+			update: true,
+		},
+		{
+			name: "changed env value",
+			edit: func(found *appsv1.StatefulSet) {
+				hana(found).Env[0].Value = "1000"
+			},
+			update: true,
+		},
+		{
+			name: "removed port",
+			edit: func(found *appsv1.StatefulSet) {
+				hana(found).Ports = hana(found).Ports[1:]
+			},
+			update: true,
+		},
+		{
+			name: "added port",
+			edit: func(found *appsv1.StatefulSet) {
+				hana(found).Ports = append(hana(found).Ports, corev1.ContainerPort{ContainerPort: 22})
+			},
+			update: true,
+		},
+		{
+			name: "changed probe",
+			edit: func(found *appsv1.StatefulSet) {
+				hana(found).ReadinessProbe.PeriodSeconds++
+			},
+			update: true,
+		},
+		{
+			name: "removed probe",
+			edit: func(found *appsv1.StatefulSet) {
+				hana(found).ReadinessProbe = nil
+			},
+			update: true,
+		},
+		{
+			name: "added volume",
+			edit: func(found *appsv1.StatefulSet) {
+				found.Spec.Template.Spec.Volumes = append(found.Spec.Template.Spec.Volumes,
+					corev1.Volume{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}})
+			},
+			update: true,
+		},
+		{
+			name: "changed image",
+			edit: func(found *appsv1.StatefulSet) {
+				hana(found).Image = testToImage
+			},
+			update: true,
+		},
+		{
+			name: "scaled",
+			edit: func(found *appsv1.StatefulSet) {
+				found.Spec.Replicas = &[]int32{0}[0]
+			},
+			update: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired, err = r.statefulSetForHanaExpress(hanaExpress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := withServerDefaults(desired)
+			if tt.edit != nil {
+				tt.edit(found)
+			}
+			if got := statefulSetNeedsUpdate(desired, found); got != tt.update {
+				t.Errorf("statefulSetNeedsUpdate = %t, want %t", got, tt.update)
+			}
+		})
+	}
+}
+
+func TestServiceNeedsUpdate(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	hanaExpress, _ := testHanaExpress()
+	hanaExpress.Spec.ServiceType = corev1.ServiceTypeNodePort
+	c := newFakeClient()
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme()}
+
+	// found returns the Service as the API server stores it, with the allocated fields
+	found := func(desired *corev1.Service) *corev1.Service {
+		svc := desired.DeepCopy()
+		svc.Spec.ClusterIP = "172.30.0.10"
+		svc.Spec.SessionAffinity = corev1.ServiceAffinityNone
+		svc.Spec.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyTypeCluster
+		for i := range svc.Spec.Ports {
+			svc.Spec.Ports[i].NodePort = 30000 + int32(i)
+		}
+		return svc
+	}
+
+	tests := []struct {
+		name        string
+		tenantPorts []int32
+		edit        func(found *corev1.Service)
+		update      bool
+	}{
+		{
+			name: "allocated fields and node ports",
+		},
+		{
+			name:        "added tenant port",
+			tenantPorts: []int32{39044},
+			update:      true,
+		},
+		{
+			name: "removed port",
+			edit: func(found *corev1.Service) {
+				found.Spec.Ports = found.Spec.Ports[1:]
+			},
+			update: true,
+		},
+		{
+			name: "changed target port",
+			edit: func(found *corev1.Service) {
+				found.Spec.Ports[0].TargetPort = intstr.FromInt(1)
+			},
+			update: true,
+		},
+		{
+			name: "changed selector",
+			edit: func(found *corev1.Service) {
+				found.Spec.Selector = map[string]string{"app": "other"}
+			},
+			update: true,
+		},
+		{
+			name: "changed type",
+			edit: func(found *corev1.Service) {
+				found.Spec.Type = corev1.ServiceTypeClusterIP
+			},
+			update: true,
+		},
+		{
+			name: "added label",
+			edit: func(found *corev1.Service) {
+				found.Labels["team"] = "sap"
+			},
+		},
+		{
+			name: "changed label",
+			edit: func(found *corev1.Service) {
+				found.Labels["app.kubernetes.io/version"] = "1.00"
+			},
+			update: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, err := r.clusterServiceForHanaExpress(hanaExpress, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc := found(current)
+			if tt.edit != nil {
+				tt.edit(svc)
+			}
+			desired, err := r.clusterServiceForHanaExpress(hanaExpress, tt.tenantPorts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := serviceNeedsUpdate(desired, svc); got != tt.update {
+				t.Errorf("serviceNeedsUpdate = %t, want %t", got, tt.update)
+			}
+		})
+	}
+}
+
+func TestKeepNodePorts(t *testing.T) {
+	found := []corev1.ServicePort{{Port: 39013, NodePort: 30013}, {Port: 39017, NodePort: 30017}}
+	desired := []corev1.ServicePort{{Port: 39013}, {Port: 39017, NodePort: 31017}, {Port: 39041}}
+
+	keepNodePorts(desired, found)
+
+	want := []int32{30013, 31017, 0}
+	for i, port := range desired {
+		if port.NodePort != want[i] {
+			t.Errorf("node port of %d = %d, want %d", port.Port, port.NodePort, want[i])
+		}
+	}
+}
+
+func TestRolloutInProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		edit     func(sts *appsv1.StatefulSet)
+		progress bool
+	}{
+		{
+			name: "rolled out",
+		},
+		{
+			name: "spec not observed yet",
+			edit: func(sts *appsv1.StatefulSet) {
+				sts.Generation = 2
+			},
+			progress: true,
+		},
+		{
+			name: "revision not rolled out",
+			edit: func(sts *appsv1.StatefulSet) {
+				sts.Status.CurrentRevision, sts.Status.UpdateRevision = "hxe-1", "hxe-2"
+			},
+			progress: true,
+		},
+		{
+			name: "replica not ready",
+			edit: func(sts *appsv1.StatefulSet) {
+				sts.Status.ReadyReplicas = 0
+			},
+			progress: true,
+		},
+		{
+			name: "replica not updated",
+			edit: func(sts *appsv1.StatefulSet) {
+				sts.Status.UpdatedReplicas = 0
+			},
+			progress: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sts := testStatefulSet(testFromImage)
+			if tt.edit != nil {
+				tt.edit(sts)
+			}
+			if got := rolloutInProgress(sts); got != tt.progress {
+				t.Errorf("rolloutInProgress = %t, want %t", got, tt.progress)
+			}
+		})
+	}
+}
+
+func TestInstanceIsStoppedForASystemRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		phase    dbv1alpha1.RestorePhase
+		replicas int32
+	}{
+		{name: "stopping the instance", phase: dbv1alpha1.RestorePhaseStoppingInstance},
+		{name: "recovering the SystemDB", phase: dbv1alpha1.RestorePhaseRecoveringSystem},
+		{name: "starting the instance", phase: dbv1alpha1.RestorePhaseStartingInstance, replicas: 1},
+		{name: "recovering a tenant", phase: dbv1alpha1.RestorePhaseRecovering, replicas: 1},
+		{name: "lease of a deleted restore", replicas: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+			hanaExpress, secret := testHanaExpress()
+			hanaExpress.Finalizers = []string{hanaExpressFinalizer}
+			hanaExpress.Annotations = map[string]string{restoreLeaseAnnotation: "system"}
+			objects := []client.Object{hanaExpress, secret}
+			if tt.phase != "" {
+				objects = append(objects, &dbv1alpha1.HanaExpressRestore{
+					ObjectMeta: metav1.ObjectMeta{Name: "system", Namespace: testNamespace},
+					Spec:       dbv1alpha1.HanaExpressRestoreSpec{HanaExpressName: "hxe", DatabaseName: "SYSTEMDB"},
+					Status:     dbv1alpha1.HanaExpressRestoreStatus{Phase: tt.phase},
+				})
+			}
+			c := newFakeClient(objects...)
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+			sts, err := r.statefulSetForHanaExpress(hanaExpress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			svc, err := r.clusterServiceForHanaExpress(hanaExpress, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, obj := range []client.Object{sts, svc} {
+				if err := c.Create(context.Background(), obj); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			key := types.NamespacedName{Name: "hxe", Namespace: testNamespace}
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Get(context.Background(), key, sts); err != nil {
+				t.Fatal(err)
+			}
+			if *sts.Spec.Replicas != tt.replicas {
+				t.Fatalf("replicas = %d, want %d", *sts.Spec.Replicas, tt.replicas)
+			}
+			if tt.replicas != 0 {
+				return
+			}
+
+			// The stopped instance reports the restore instead of checking the database
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Get(context.Background(), key, hanaExpress); err != nil {
+				t.Fatal(err)
+			}
+			if status, reason := conditionStatus(hanaExpress, typeAvailableHanaExpress); status != metav1.ConditionFalse || reason != "Restoring" {
+				t.Errorf("Available = %s (%s), want False (Restoring)", status, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,434 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+// generatedCredentialLabel marks the Secrets the operator created for a generated credential,
+// its value is the name of the instance
+const generatedCredentialLabel = "db.sap-redhat.io/generated-credential"
+
+// reconcileGeneratedCredential fills in the default Secret reference of a generated credential
+// and creates the Secret with a generated master password when it does not exist. An existing
+// Secret is never overwritten, a Secret generated for a deleted instance of the same name whose
+// data was persisted is adopted again. It reports whether the spec was updated, the update
+// starts the next reconciliation which creates the Secret.
+func (r *HanaExpressReconciler) reconcileGeneratedCredential(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (bool, error) {
+	log := log.FromContext(ctx)
+
+	credential := &hanaExpress.Spec.Credential
+	if !credential.Generate {
+		return false, nil
+	}
+
+	if credential.SecretKeyRef.Name == "" || credential.SecretKeyRef.Key == "" {
+		if credential.SecretKeyRef.Name == "" {
+			credential.SecretKeyRef.Name = hanaExpress.Name + "-master-password"
+		}
+		if credential.SecretKeyRef.Key == "" {
+			credential.SecretKeyRef.Key = "password"
+		}
+		log.Info("Setting the Secret of the generated credential", "Secret.Name", credential.SecretKeyRef.Name)
+		return true, r.Update(ctx, hanaExpress)
+	}
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: credential.SecretKeyRef.Name, Namespace: hanaExpress.Namespace}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	if err == nil {
+		if secret.Labels[generatedCredentialLabel] == hanaExpress.Name && metav1.GetControllerOf(secret) == nil {
+			log.Info("Adopting the Secret of the generated credential", "Secret.Name", secret.Name)
+			if err := ctrl.SetControllerReference(hanaExpress, secret, r.Scheme); err != nil {
+				return false, err
+			}
+			return false, r.Update(ctx, secret)
+		}
+		return false, nil
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return false, err
+	}
+	data := []byte(password)
+	if credential.Format == "json" {
+		if data, err = json.Marshal(map[string]string{"master_password": password}); err != nil {
+			return false, err
+		}
+	}
+
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      credential.SecretKeyRef.Name,
+			Namespace: hanaExpress.Namespace,
+			Labels:    map[string]string{generatedCredentialLabel: hanaExpress.Name},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{credential.SecretKeyRef.Key: data},
+	}
+	if err := ctrl.SetControllerReference(hanaExpress, secret, r.Scheme); err != nil {
+		return false, err
+	}
+
+	log.Info("Creating the Secret of the generated credential", "Secret.Name", secret.Name)
+	if err := r.Create(ctx, secret); err != nil {
+		return false, err
+	}
+	r.Recorder.Event(hanaExpress, "Normal", "PasswordGenerated",
+		fmt.Sprintf("Created Secret %s with a generated master password", secret.Name))
+	return false, nil
+}
+
+// orphanGeneratedCredential removes the owner reference from the generated Secret, so it
+// is not garbage collected with an instance whose data is kept
+func (r *HanaExpressReconciler) orphanGeneratedCredential(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) error {
+	if !hanaExpress.Spec.Credential.Generate {
+		return nil
+	}
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: hanaExpress.Spec.Credential.SecretKeyRef.Name, Namespace: hanaExpress.Namespace}, secret)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(secret, hanaExpress) {
+		return nil
+	}
+
+	references := []metav1.OwnerReference{}
+	for _, reference := range secret.OwnerReferences {
+		if reference.UID != hanaExpress.UID {
+			references = append(references, reference)
+		}
+	}
+	secret.OwnerReferences = references
+
+	log.FromContext(ctx).Info("Keeping the Secret of the generated credential with the persisted data", "Secret.Name", secret.Name)
+	return r.Update(ctx, secret)
+}
+
+const (
+	// passwordsMountPath is where the passwords Secret is mounted into the HANA Express container
+	passwordsMountPath = "/hana/passwords"
+	// passwordsFileKey is the key of the passwords JSON in the passwords Secret
+	passwordsFileKey = "hxepasswd.json"
+)
+
+// passwordsSecretName returns the name of the Secret with the passwords JSON of the instance
+func passwordsSecretName(hanaExpress *dbv1alpha1.HanaExpress) string {
+	return hanaExpress.Name + "-passwords"
+}
+
+// passwordsJSON renders the passwords JSON that run_hana reads from the referenced credential.
+// A plain password becomes the master_password, a json credential is decoded and encoded again,
+// so quotes, backslashes and control characters are always escaped correctly.
+func passwordsJSON(ctx context.Context, c client.Client, hanaExpress *dbv1alpha1.HanaExpress) ([]byte, error) {
+	credential := hanaExpress.Spec.Credential
+	if credential.Format != "json" {
+		password, err := passwordFromCredential(ctx, c, hanaExpress.Namespace, credential)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]string{"master_password": password})
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Name: credential.SecretKeyRef.Name, Namespace: hanaExpress.Namespace}, secret); err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %w", credential.SecretKeyRef.Name, err)
+	}
+	passwords := map[string]interface{}{}
+	if err := json.Unmarshal(secret.Data[credential.SecretKeyRef.Key], &passwords); err != nil {
+		return nil, fmt.Errorf("credential data is not valid JSON: %w", err)
+	}
+	return json.Marshal(passwords)
+}
+
+// reconcilePasswordsSecret renders the passwords JSON into a Secret owned by the instance. The
+// pod mounts only this Secret, so the referenced credential is never copied onto the data volume.
+// An existing Secret keeps the master password the database runs with until a changed password
+// is applied, the rotation logs on with it.
+func (r *HanaExpressReconciler) reconcilePasswordsSecret(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) error {
+	data, err := passwordsJSON(ctx, r.Client, hanaExpress)
+	if err != nil {
+		return err
+	}
+	password, err := masterPasswordForHanaExpress(ctx, r.Client, hanaExpress)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	name := passwordsSecretName(hanaExpress)
+	err = r.Get(ctx, types.NamespacedName{Name: name, Namespace: hanaExpress.Namespace}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	if apierrors.IsNotFound(err) {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: hanaExpress.Namespace,
+				Labels:    selectorLabelsForHanaExpress(hanaExpress.Name),
+			},
+			Type: corev1.SecretTypeOpaque,
+			Data: map[string][]byte{passwordsFileKey: data},
+		}
+		if err := ctrl.SetControllerReference(hanaExpress, secret, r.Scheme); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Creating the passwords Secret", "Secret.Namespace", secret.Namespace, "Secret.Name", secret.Name)
+		return r.Create(ctx, secret)
+	}
+
+	if !metav1.IsControlledBy(secret, hanaExpress) {
+		return fmt.Errorf("secret %s already exists and is not owned by HanaExpress %s", name, hanaExpress.Name)
+	}
+	if !masterPasswordApplied(hanaExpress, password) {
+		return nil
+	}
+	if string(secret.Data[passwordsFileKey]) == string(data) && len(secret.Data) == 1 {
+		return nil
+	}
+	secret.Data = map[string][]byte{passwordsFileKey: data}
+	return r.Update(ctx, secret)
+}
+
+// legacyAppliedCredentialSecretName returns the name of the Secret in which earlier versions
+// kept the applied master password in plain text
+func legacyAppliedCredentialSecretName(hanaExpress *dbv1alpha1.HanaExpress) string {
+	return hanaExpress.Name + "-applied-credential"
+}
+
+// credentialHash returns the hash of the master password recorded in the status. The UID of
+// the instance salts it so equal passwords of different instances do not have equal hashes.
+func credentialHash(hanaExpress *dbv1alpha1.HanaExpress, password string) string {
+	sum := sha256.Sum256([]byte(string(hanaExpress.UID) + ":" + password))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// masterPasswordApplied reports whether the status records the password as applied to the database
+func masterPasswordApplied(hanaExpress *dbv1alpha1.HanaExpress, password string) bool {
+	status := hanaExpress.Status.Credential
+	return status != nil && status.PasswordHash == credentialHash(hanaExpress, password)
+}
+
+// reconcileMasterPassword applies a changed master password of the referenced Secret to the
+// SystemDB and the HXE tenant, logging on with the password the passwords Secret still holds.
+// A password whose hash is not recorded is verified with a logon before it counts as applied.
+// A scheduled rotation writes a generated password into the Secret first. It returns the time
+// until the next scheduled rotation, or zero when there is none.
+func (r *HanaExpressReconciler) reconcileMasterPassword(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (time.Duration, error) {
+	log := log.FromContext(ctx)
+
+	if hanaExpress.Status.Credential == nil {
+		hanaExpress.Status.Credential = &dbv1alpha1.CredentialStatus{}
+	}
+	status := hanaExpress.Status.Credential
+
+	nextRotation, err := r.reconcileScheduledRotation(ctx, hanaExpress)
+	if err != nil {
+		return 0, err
+	}
+
+	password, err := masterPasswordForHanaExpress(ctx, r.Client, hanaExpress)
+	if err != nil {
+		return 0, err
+	}
+	if masterPasswordApplied(hanaExpress, password) {
+		return nextRotation, r.deleteLegacyAppliedCredential(ctx, hanaExpress)
+	}
+
+	previous, err := r.appliedPassword(ctx, hanaExpress)
+	if err != nil {
+		return 0, err
+	}
+
+	if status.PasswordHash == "" {
+		log.Info("Applied master password is unknown, verifying it with a logon")
+	} else {
+		log.Info("Master password changed, applying it to the database")
+	}
+	rotated := previous != password
+	applied := []string{}
+	for _, port := range []int32{hanaSystemDBSQLPort, hanaTenantSQLPort} {
+		if err := r.resetSystemPassword(ctx, hanaExpress, port, previous, password); err != nil {
+			// The ports that already run with the new password log on with it on the retry
+			reason, message := "RotationFailed", fmt.Sprintf("Failed to apply the master password on port %d: %s", port, err)
+			if len(applied) > 0 {
+				reason = "PartiallyApplied"
+				message = fmt.Sprintf("Applied the master password on port %s, but not on port %d: %s",
+					strings.Join(applied, ", "), port, err)
+			}
+			meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typePasswordRotationHanaExpress,
+				Status: metav1.ConditionFalse, Reason: reason, Message: message})
+			return 0, err
+		}
+		applied = append(applied, strconv.Itoa(int(port)))
+	}
+
+	status.PasswordHash = credentialHash(hanaExpress, password)
+	if err := r.reconcilePasswordsSecret(ctx, hanaExpress); err != nil {
+		return 0, err
+	}
+	if !rotated {
+		return nextRotation, nil
+	}
+
+	now := metav1.Now()
+	status.LastRotationTime = &now
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typePasswordRotationHanaExpress,
+		Status: metav1.ConditionTrue, Reason: "Rotated",
+		Message: fmt.Sprintf("Applied the changed master password on ports %s", strings.Join(applied, ", "))})
+	r.Recorder.Event(hanaExpress, "Normal", "PasswordRotated",
+		"Applied the changed master password to the SystemDB and the HXE tenant")
+	return nextRotation, nil
+}
+
+// reconcileScheduledRotation writes a generated master password into the referenced Secret
+// when the rotation schedule is due and returns the time until the next rotation
+func (r *HanaExpressReconciler) reconcileScheduledRotation(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (time.Duration, error) {
+	status := hanaExpress.Status.Credential
+	rotation := hanaExpress.Spec.PasswordRotation
+	if rotation == nil {
+		status.NextScheduledRotationTime = nil
+		return 0, nil
+	}
+
+	schedule, err := cron.ParseStandard(rotation.Schedule)
+	if err != nil {
+		return 0, fmt.Errorf("invalid password rotation schedule %q: %w", rotation.Schedule, err)
+	}
+
+	last := hanaExpress.CreationTimestamp.Time
+	if status.LastScheduledRotationTime != nil {
+		last = status.LastScheduledRotationTime.Time
+	}
+	now := time.Now()
+	next := schedule.Next(last)
+	if next.After(now) {
+		status.NextScheduledRotationTime = &metav1.Time{Time: next}
+		return next.Sub(now), nil
+	}
+
+	password, err := generatePassword()
+	if err != nil {
+		return 0, err
+	}
+	if err := r.writeMasterPassword(ctx, hanaExpress, password); err != nil {
+		return 0, err
+	}
+	log.FromContext(ctx).Info("Generated a new master password for the rotation schedule")
+	r.Recorder.Event(hanaExpress, "Normal", "PasswordGenerated",
+		fmt.Sprintf("Wrote a generated master password into Secret %s", hanaExpress.Spec.Credential.SecretKeyRef.Name))
+
+	// Missed rotations are not caught up, the next one follows the schedule from now on
+	next = schedule.Next(now)
+	status.LastScheduledRotationTime = &metav1.Time{Time: now}
+	status.NextScheduledRotationTime = &metav1.Time{Time: next}
+	return next.Sub(now), nil
+}
+
+// writeMasterPassword replaces the master password in the referenced Secret, keeping the
+// other passwords of a json credential
+func (r *HanaExpressReconciler) writeMasterPassword(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress, password string) error {
+	credential := hanaExpress.Spec.Credential
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Name: credential.SecretKeyRef.Name, Namespace: hanaExpress.Namespace}, secret); err != nil {
+		return fmt.Errorf("failed to get secret %s: %w", credential.SecretKeyRef.Name, err)
+	}
+
+	data := []byte(password)
+	if credential.Format == "json" {
+		passwords := map[string]interface{}{}
+		if err := json.Unmarshal(secret.Data[credential.SecretKeyRef.Key], &passwords); err != nil {
+			return fmt.Errorf("credential data is not valid JSON: %w", err)
+		}
+		passwords["master_password"] = password
+		var err error
+		if data, err = json.Marshal(passwords); err != nil {
+			return err
+		}
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[credential.SecretKeyRef.Key] = data
+	if err := r.Update(ctx, secret); err != nil {
+		return fmt.Errorf("failed to write the generated password into secret %s: %w", secret.Name, err)
+	}
+	return nil
+}
+
+// appliedPassword returns the master password the passwords Secret holds, which is the one the
+// database runs with until a changed password is applied
+func (r *HanaExpressReconciler) appliedPassword(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (string, error) {
+	secret := &corev1.Secret{}
+	name := passwordsSecretName(hanaExpress)
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: hanaExpress.Namespace}, secret); err != nil {
+		return "", fmt.Errorf("failed to get the previous master password from secret %s: %w", name, err)
+	}
+
+	var passwords struct {
+		MasterPassword string `json:"master_password"`
+	}
+	if err := json.Unmarshal(secret.Data[passwordsFileKey], &passwords); err != nil {
+		return "", fmt.Errorf("passwords JSON of secret %s is not valid: %w", name, err)
+	}
+	return passwords.MasterPassword, nil
+}
+
+// deleteLegacyAppliedCredential deletes the plain text copy of the applied master password that
+// earlier versions kept
+func (r *HanaExpressReconciler) deleteLegacyAppliedCredential(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) error {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: legacyAppliedCredentialSecretName(hanaExpress), Namespace: hanaExpress.Namespace}, secret)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(secret, hanaExpress) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("Deleting the Secret with the applied master password in plain text", "Secret.Name", secret.Name)
+	return client.IgnoreNotFound(r.Delete(ctx, secret))
+}
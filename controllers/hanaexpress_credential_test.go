@@ -0,0 +1,390 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+const newTestPassword = "Manager2"
+
+// testPasswordsSecret returns the passwords Secret of the instance holding the master password
+func testPasswordsSecret(t *testing.T, hanaExpress *dbv1alpha1.HanaExpress, password string) *corev1.Secret {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{"master_password": password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: passwordsSecretName(hanaExpress), Namespace: testNamespace},
+		Data:       map[string][]byte{passwordsFileKey: data},
+	}
+	if err := ctrl.SetControllerReference(hanaExpress, secret, newFakeClient().Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// storedMasterPassword returns the master password of the passwords Secret
+func storedMasterPassword(t *testing.T, c client.Client, hanaExpress *dbv1alpha1.HanaExpress) string {
+	t.Helper()
+	r := &HanaExpressReconciler{Client: c}
+	password, err := r.appliedPassword(context.Background(), hanaExpress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return password
+}
+
+func expectPasswordChange(mock sqlmock.Sqlmock, password string) {
+	mock.ExpectExec(`ALTER USER SYSTEM PASSWORD "` + password + `"`).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestReconcileMasterPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		hash     func(hanaExpress *dbv1alpha1.HanaExpress) string
+		password string
+		rejected []string
+		expect   func(connector *fakeConnector, mock sqlmock.Sqlmock)
+		wantErr  bool
+		rotated  bool
+	}{
+		{
+			name:     "password in sync",
+			hash:     func(hanaExpress *dbv1alpha1.HanaExpress) string { return credentialHash(hanaExpress, testPassword) },
+			password: testPassword,
+		},
+		{
+			name:     "unknown password the database accepts",
+			password: testPassword,
+			expect: func(connector *fakeConnector, mock sqlmock.Sqlmock) {
+				connector.expectSQL(t)
+			},
+		},
+		{
+			name:     "unknown password the database rejects",
+			password: testPassword,
+			rejected: []string{testPassword},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown password changed before the upgrade",
+			password: newTestPassword,
+			rejected: []string{newTestPassword},
+			expect: func(connector *fakeConnector, mock sqlmock.Sqlmock) {
+				expectPasswordChange(mock, newTestPassword)
+				expectPasswordChange(connector.expectSQL(t), newTestPassword)
+			},
+			rotated: true,
+		},
+		{
+			name:     "changed password",
+			hash:     func(hanaExpress *dbv1alpha1.HanaExpress) string { return credentialHash(hanaExpress, testPassword) },
+			password: newTestPassword,
+			rejected: []string{newTestPassword},
+			expect: func(connector *fakeConnector, mock sqlmock.Sqlmock) {
+				expectPasswordChange(mock, newTestPassword)
+				expectPasswordChange(connector.expectSQL(t), newTestPassword)
+			},
+			rotated: true,
+		},
+		{
+			name:     "changed password already applied to the database",
+			hash:     func(hanaExpress *dbv1alpha1.HanaExpress) string { return credentialHash(hanaExpress, testPassword) },
+			password: newTestPassword,
+			rejected: []string{testPassword},
+			expect: func(connector *fakeConnector, mock sqlmock.Sqlmock) {
+				connector.expectSQL(t)
+			},
+			rotated: true,
+		},
+		{
+			name:     "changed password the database rejects with the previous one too",
+			hash:     func(hanaExpress *dbv1alpha1.HanaExpress) string { return credentialHash(hanaExpress, testPassword) },
+			password: newTestPassword,
+			rejected: []string{testPassword, newTestPassword},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, secret := testHanaExpress()
+			hanaExpress.Status.Credential = &dbv1alpha1.CredentialStatus{}
+			if tt.hash != nil {
+				hanaExpress.Status.Credential.PasswordHash = tt.hash(hanaExpress)
+			}
+			secret.Data["password"] = []byte(tt.password)
+			c := newFakeClient(hanaExpress, secret, testPasswordsSecret(t, hanaExpress, testPassword))
+			connector := &fakeConnector{rejected: tt.rejected}
+			mock := connector.expectSQL(t)
+			if tt.expect != nil {
+				tt.expect(connector, mock)
+			}
+			recorder := newTestRecorder()
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: recorder, SQL: connector}
+			previousHash := hanaExpress.Status.Credential.PasswordHash
+
+			_, err := r.reconcileMasterPassword(context.Background(), hanaExpress)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %t", err, tt.wantErr)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+
+			status := hanaExpress.Status.Credential
+			stored := storedMasterPassword(t, c, hanaExpress)
+			if tt.wantErr {
+				if status.PasswordHash != previousHash || stored != testPassword {
+					t.Errorf("failed rotation recorded hash %q and stored %q", status.PasswordHash, stored)
+				}
+				return
+			}
+			if status.PasswordHash != credentialHash(hanaExpress, tt.password) {
+				t.Errorf("hash = %q, want the hash of %q", status.PasswordHash, tt.password)
+			}
+			if stored != tt.password {
+				t.Errorf("passwords Secret holds %q, want %q", stored, tt.password)
+			}
+			if rotated := status.LastRotationTime != nil; rotated != tt.rotated {
+				t.Errorf("rotation recorded = %t, want %t", rotated, tt.rotated)
+			}
+			if rotated := containsEvent(drainEvents(recorder), "PasswordRotated"); rotated != tt.rotated {
+				t.Errorf("PasswordRotated event = %t, want %t", rotated, tt.rotated)
+			}
+		})
+	}
+}
+
+func TestPasswordsSecretKeepsThePasswordUntilItIsApplied(t *testing.T) {
+	hanaExpress, secret := testHanaExpress()
+	secret.Data["password"] = []byte(newTestPassword)
+	c := newFakeClient(hanaExpress, secret, testPasswordsSecret(t, hanaExpress, testPassword))
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+
+	if err := r.reconcilePasswordsSecret(context.Background(), hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedMasterPassword(t, c, hanaExpress); stored != testPassword {
+		t.Fatalf("passwords Secret holds %q before the password is applied, want %q", stored, testPassword)
+	}
+
+	hanaExpress.Status.Credential.PasswordHash = credentialHash(hanaExpress, newTestPassword)
+	if err := r.reconcilePasswordsSecret(context.Background(), hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedMasterPassword(t, c, hanaExpress); stored != newTestPassword {
+		t.Errorf("passwords Secret holds %q after the password is applied, want %q", stored, newTestPassword)
+	}
+}
+
+func TestLegacyAppliedCredentialIsDeleted(t *testing.T) {
+	hanaExpress, secret := testHanaExpress()
+	legacy := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: legacyAppliedCredentialSecretName(hanaExpress), Namespace: testNamespace},
+		Data:       map[string][]byte{"password": []byte(testPassword)},
+	}
+	c := newFakeClient(hanaExpress, secret, testPasswordsSecret(t, hanaExpress, testPassword))
+	if err := ctrl.SetControllerReference(hanaExpress, legacy, c.Scheme()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Create(context.Background(), legacy); err != nil {
+		t.Fatal(err)
+	}
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: &fakeConnector{}}
+
+	if _, err := r.reconcileMasterPassword(context.Background(), hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Get(context.Background(), types.NamespacedName{Name: legacy.Name, Namespace: testNamespace}, &corev1.Secret{})
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("plain text Secret %s still exists: %v", legacy.Name, err)
+	}
+}
+
+func TestPartiallyAppliedMasterPasswordIsReported(t *testing.T) {
+	hanaExpress, secret := testHanaExpress()
+	secret.Data["password"] = []byte(newTestPassword)
+	c := newFakeClient(hanaExpress, secret, testPasswordsSecret(t, hanaExpress, testPassword))
+	key := types.NamespacedName{Name: "hxe", Namespace: testNamespace}
+	reconcile := func(connector *fakeConnector) (*dbv1alpha1.HanaExpress, []string) {
+		t.Helper()
+		recorder := newTestRecorder()
+		r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: recorder, SQL: connector,
+			Health: fakeHealthChecker{hdbclient.Health{Reason: hdbclient.HealthReasonHealthy}}}
+		if err := c.Get(context.Background(), key, hanaExpress); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.reconcileRunningDatabase(context.Background(), hanaExpress, testStatefulSet(testFromImage)); err != nil {
+			t.Fatalf("reconcileRunningDatabase: %v", err)
+		}
+		stored := &dbv1alpha1.HanaExpress{}
+		if err := c.Get(context.Background(), key, stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored, drainEvents(recorder)
+	}
+
+	// The SystemDB takes the new password, the HXE tenant fails to
+	connector := &fakeConnector{rejected: []string{newTestPassword}}
+	expectPasswordChange(connector.expectSQL(t), newTestPassword)
+	connector.expectSQL(t).ExpectExec(`ALTER USER SYSTEM PASSWORD "` + newTestPassword + `"`).
+		WillReturnError(errors.New("password was used before"))
+	stored, events := reconcile(connector)
+
+	condition := meta.FindStatusCondition(stored.Status.Conditions, typePasswordRotationHanaExpress)
+	if condition == nil || condition.Status != metav1.ConditionFalse || condition.Reason != "PartiallyApplied" {
+		t.Fatalf("PasswordRotation = %+v, want False/PartiallyApplied", condition)
+	}
+	for _, want := range []string{"port 39013", "port 39017", "password was used before"} {
+		if !strings.Contains(condition.Message, want) {
+			t.Errorf("PasswordRotation message %q does not name %q", condition.Message, want)
+		}
+	}
+	if status, reason := conditionStatus(stored, typeCredentialSyncedHanaExpress); status != metav1.ConditionFalse || reason != "RotationFailed" {
+		t.Errorf("CredentialSynced = %s (%s), want False (RotationFailed)", status, reason)
+	}
+	if !containsEvent(events, "PasswordRotationFailed") {
+		t.Errorf("events %v do not contain PasswordRotationFailed", events)
+	}
+	if stored.Status.Credential.PasswordHash != credentialHash(stored, testPassword) ||
+		storedMasterPassword(t, c, stored) != testPassword {
+		t.Error("the partially applied password was recorded as applied")
+	}
+
+	// The retry logs on to the SystemDB with the new password and changes only the tenant
+	connector = &fakeConnector{rejectedOn: map[int32][]string{
+		hanaSystemDBSQLPort: {testPassword}, hanaTenantSQLPort: {newTestPassword}}}
+	connector.expectSQL(t)
+	expectPasswordChange(connector.expectSQL(t), newTestPassword)
+	stored, events = reconcile(connector)
+
+	if status, reason := conditionStatus(stored, typePasswordRotationHanaExpress); status != metav1.ConditionTrue || reason != "Rotated" {
+		t.Errorf("PasswordRotation = %s (%s) after the retry, want True (Rotated)", status, reason)
+	}
+	if !containsEvent(events, "PasswordRotated") {
+		t.Errorf("events %v do not contain PasswordRotated", events)
+	}
+	if stored := storedMasterPassword(t, c, stored); stored != newTestPassword {
+		t.Errorf("passwords Secret holds %q after the retry, want %q", stored, newTestPassword)
+	}
+}
+
+// testGeneratedCredential returns hxe with a generated credential in the format, without a Secret reference
+func testGeneratedCredential(format string) *dbv1alpha1.HanaExpress {
+	hanaExpress, _ := testHanaExpress()
+	hanaExpress.Spec.Credential = dbv1alpha1.Credential{Generate: true, Format: format}
+	return hanaExpress
+}
+
+func TestReconcileGeneratedCredential(t *testing.T) {
+	for _, format := range []string{"", "json"} {
+		t.Run("format "+format, func(t *testing.T) {
+			hanaExpress := testGeneratedCredential(format)
+			c := newFakeClient(hanaExpress)
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+			key := types.NamespacedName{Name: "hxe-master-password", Namespace: testNamespace}
+
+			// The reconciliation ends after the Secret reference is set in the spec
+			updated, err := r.reconcileGeneratedCredential(context.Background(), hanaExpress)
+			if err != nil || !updated {
+				t.Fatalf("reconcileGeneratedCredential = %t, %v, want the spec updated", updated, err)
+			}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(hanaExpress), hanaExpress); err != nil {
+				t.Fatal(err)
+			}
+			if ref := hanaExpress.Spec.Credential.SecretKeyRef; ref.Name != key.Name || ref.Key != "password" {
+				t.Fatalf("secretKeyRef = %s/%s, want %s/password", ref.Name, ref.Key, key.Name)
+			}
+			if err := c.Get(context.Background(), key, &corev1.Secret{}); !apierrors.IsNotFound(err) {
+				t.Fatalf("Secret was created with the spec update: %v", err)
+			}
+
+			// The next reconciliation creates the Secret
+			updated, err = r.reconcileGeneratedCredential(context.Background(), hanaExpress)
+			if err != nil || updated {
+				t.Fatalf("reconcileGeneratedCredential = %t, %v, want the Secret created", updated, err)
+			}
+			secret := &corev1.Secret{}
+			if err := c.Get(context.Background(), key, secret); err != nil {
+				t.Fatal(err)
+			}
+			if !metav1.IsControlledBy(secret, hanaExpress) || secret.Labels[generatedCredentialLabel] != hanaExpress.Name {
+				t.Errorf("Secret is not labelled and owned by the instance: %v %v", secret.Labels, secret.OwnerReferences)
+			}
+			password, err := masterPasswordForHanaExpress(context.Background(), c, hanaExpress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(password) != generatedPasswordLength {
+				t.Errorf("generated password %q has %d characters, want %d", password, len(password), generatedPasswordLength)
+			}
+
+			// An existing Secret is not overwritten
+			if _, err := r.reconcileGeneratedCredential(context.Background(), hanaExpress); err != nil {
+				t.Fatal(err)
+			}
+			if again, err := masterPasswordForHanaExpress(context.Background(), c, hanaExpress); err != nil || again != password {
+				t.Errorf("master password = %q, %v after the next reconciliation, want %q kept", again, err, password)
+			}
+		})
+	}
+}
+
+func TestReconcileGeneratedCredentialAdoptsTheSecretOfADeletedInstance(t *testing.T) {
+	hanaExpress := testGeneratedCredential("")
+	hanaExpress.Spec.Credential.SecretKeyRef = corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "hxe-master-password"}, Key: "password"}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hxe-master-password", Namespace: testNamespace,
+			Labels: map[string]string{generatedCredentialLabel: hanaExpress.Name}},
+		Data: map[string][]byte{"password": []byte(testPassword)},
+	}
+	c := newFakeClient(hanaExpress, secret)
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+
+	if updated, err := r.reconcileGeneratedCredential(context.Background(), hanaExpress); err != nil || updated {
+		t.Fatalf("reconcileGeneratedCredential = %t, %v", updated, err)
+	}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(secret), secret); err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(secret, hanaExpress) {
+		t.Errorf("Secret owners = %v, want the instance", secret.OwnerReferences)
+	}
+	if string(secret.Data["password"]) != testPassword {
+		t.Errorf("adopted Secret holds %q, want the password kept", secret.Data["password"])
+	}
+}
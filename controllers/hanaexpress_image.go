@@ -0,0 +1,96 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+// supportedVersionsEnvVar lists the HANA Express image tags known to work with this operator
+const supportedVersionsEnvVar = "HANAEXPRESS_SUPPORTED_VERSIONS"
+
+// defaultImageForHanaExpress gets the default Operand image which is managed by this controller
+// from the HANAEXPRESS_IMAGE environment variable defined in the config/manager/manager.yaml
+func defaultImageForHanaExpress() (string, error) {
+	image, found := os.LookupEnv(dbv1alpha1.DefaultImageEnvVar)
+	if !found {
+		return "", fmt.Errorf("Unable to find %s environment variable with the image", dbv1alpha1.DefaultImageEnvVar)
+	}
+	return image, nil
+}
+
+// supportedVersions returns the catalog of known-good HANA Express versions from the
+// comma separated HANAEXPRESS_SUPPORTED_VERSIONS environment variable. The version of
+// the default image is always part of the catalog.
+func supportedVersions() (map[string]bool, error) {
+	image, err := defaultImageForHanaExpress()
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := map[string]bool{}
+	if _, tag := dbv1alpha1.SplitImage(image); tag != "" {
+		catalog[tag] = true
+	}
+	for _, version := range strings.Split(os.Getenv(supportedVersionsEnvVar), ",") {
+		if version = strings.TrimSpace(version); version != "" {
+			catalog[version] = true
+		}
+	}
+	return catalog, nil
+}
+
+// imageForHanaExpress resolves the effective image and version of the instance from
+// spec.image, spec.version and the operator default image, and checks the version
+// against the catalog of supported versions.
+func imageForHanaExpress(hanaExpress *dbv1alpha1.HanaExpress) (string, string, error) {
+	defaultImage, err := defaultImageForHanaExpress()
+	if err != nil {
+		return "", "", err
+	}
+
+	image := defaultImage
+	switch {
+	case hanaExpress.Spec.Image != "":
+		image = hanaExpress.Spec.Image
+		if _, tag := dbv1alpha1.SplitImage(image); hanaExpress.Spec.Version != "" && tag != hanaExpress.Spec.Version {
+			return "", "", fmt.Errorf("image %s does not match version %s", image, hanaExpress.Spec.Version)
+		}
+	case hanaExpress.Spec.Version != "":
+		repository, _ := dbv1alpha1.SplitImage(defaultImage)
+		image = repository + ":" + hanaExpress.Spec.Version
+	}
+
+	_, version := dbv1alpha1.SplitImage(image)
+	if version == "" {
+		return "", "", fmt.Errorf("image %s has no tag, a tagged image is required to check the version", image)
+	}
+
+	catalog, err := supportedVersions()
+	if err != nil {
+		return "", "", err
+	}
+	if !catalog[version] {
+		return "", "", fmt.Errorf("version %s is not in the catalog of supported versions", version)
+	}
+
+	return image, version, nil
+}
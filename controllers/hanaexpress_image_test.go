@@ -0,0 +1,129 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func TestImageForHanaExpress(t *testing.T) {
+	tests := []struct {
+		name      string
+		unset     bool
+		supported string
+		image     string
+		version   string
+		want      string
+		err       string
+	}{
+		{
+			name: "the default image is the fallback",
+			want: testFromImage,
+		},
+		{
+			name:      "a supported version runs from the repository of the default image",
+			supported: "2.00.072.00.20230728.1",
+			version:   "2.00.072.00.20230728.1",
+			want:      testToImage,
+		},
+		{
+			name:      "spec.image overrides the default image",
+			supported: "2.00.072.00.20230728.1",
+			image:     "registry.example.com/hana/hanaexpress:2.00.072.00.20230728.1",
+			want:      "registry.example.com/hana/hanaexpress:2.00.072.00.20230728.1",
+		},
+		{
+			name:      "spec.image takes the place of spec.version when both name the same tag",
+			supported: "2.00.072.00.20230728.1",
+			image:     "registry.example.com/hana/hanaexpress:2.00.072.00.20230728.1",
+			version:   "2.00.072.00.20230728.1",
+			want:      "registry.example.com/hana/hanaexpress:2.00.072.00.20230728.1",
+		},
+		{
+			name:    "spec.image with another tag than spec.version",
+			image:   testToImage,
+			version: "2.00.061.00.20220519.1",
+			err:     "does not match version",
+		},
+		{
+			name:    "an unsupported version",
+			version: "2.00.072.00.20230728.1",
+			err:     "version 2.00.072.00.20230728.1 is not in the catalog",
+		},
+		{
+			name:  "an unsupported spec.image",
+			image: "registry.example.com/hana/hanaexpress:2.00.072.00.20230728.1",
+			err:   "version 2.00.072.00.20230728.1 is not in the catalog",
+		},
+		{
+			name:  "an untagged spec.image",
+			image: "registry.example.com/hana/hanaexpress@sha256:" + strings.Repeat("0", 64),
+			err:   "has no tag",
+		},
+		{
+			name:      "blanks and empty entries in the catalog are ignored",
+			supported: " , 2.00.072.00.20230728.1 ,,",
+			version:   "2.00.072.00.20230728.1",
+			want:      testToImage,
+		},
+		{
+			name:      "a catalog that is not comma separated does not match any version",
+			supported: "2.00.072.00.20230728.1;2.00.076.00.20240701.1",
+			version:   "2.00.072.00.20230728.1",
+			err:       "not in the catalog",
+		},
+		{
+			name:      "the version of the default image stays supported with a malformed catalog",
+			supported: "2.00.072.00.20230728.1;2.00.076.00.20240701.1",
+			want:      testFromImage,
+		},
+		{
+			name:  "no default image",
+			unset: true,
+			err:   "Unable to find " + dbv1alpha1.DefaultImageEnvVar,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+			if tt.unset {
+				os.Unsetenv(dbv1alpha1.DefaultImageEnvVar)
+			}
+			t.Setenv(supportedVersionsEnvVar, tt.supported)
+			hanaExpress := &dbv1alpha1.HanaExpress{Spec: dbv1alpha1.HanaExpressSpec{Image: tt.image, Version: tt.version}}
+
+			image, version, err := imageForHanaExpress(hanaExpress)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case image != tt.want:
+				t.Errorf("image = %s, want %s", image, tt.want)
+			case !strings.HasSuffix(image, ":"+version):
+				t.Errorf("version = %s, want the tag of %s", version, image)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+const (
+	// globalAllocationLimitPercent is the share of the container memory limit HANA may
+	// allocate. The rest is left to the processes in the container outside of the
+	// HANA memory manager.
+	globalAllocationLimitPercent = 90
+
+	// globalAllocationLimitAnnotation records the derived limit on the pod template
+	globalAllocationLimitAnnotation = "db.sap-redhat.io/global-allocation-limit"
+
+	// globalAllocationLimitEnv passes the derived limit to the start script of the container
+	globalAllocationLimitEnv = "HANA_GLOBAL_ALLOCATION_LIMIT"
+
+	// globalIniPath is the SYSTEM layer of global.ini, which ALTER SYSTEM ALTER CONFIGURATION
+	// ('global.ini', 'SYSTEM') writes as well
+	globalIniPath = "/usr/sap/HXE/SYS/global/hdb/custom/config/global.ini"
+
+	// hanaStartScript writes the global_allocation_limit from the environment into the
+	// [memorymanager] section of global.ini, or removes it when the variable is empty, and
+	// then runs the command it is given. HANA so starts with the limit of its container
+	// instead of allocating up to the memory of the node until the operator connects.
+	// Writing the limit is best effort: the configuration directory only exists after the
+	// first start of HANA, and HANA is started even when it cannot be written. The operator
+	// applies the limit over SQL once the database is up in that case.
+	hanaStartScript = `ini=` + globalIniPath + `
+if [ -d "$(dirname "$ini")" ] && [ -w "$(dirname "$ini")" ]; then
+	if { [ -f "$ini" ] || : > "$ini"; } && awk -v limit="$` + globalAllocationLimitEnv + `" '
+section == "[memorymanager]" && /^[ \t]*global_allocation_limit[ \t]*=/ { next }
+/^\[/ { section = $0 }
+{ print }
+section == "[memorymanager]" && limit != "" && !done { print "global_allocation_limit = " limit; done = 1 }
+END { if (limit != "" && !done) { print "[memorymanager]"; print "global_allocation_limit = " limit } }' "$ini" > "$ini.tmp" &&
+		mv "$ini.tmp" "$ini"; then
+		:
+	else
+		rm -f "$ini.tmp"
+		echo "hana-start: could not write global_allocation_limit to $ini" >&2
+	fi
+else
+	echo "hana-start: $(dirname "$ini") is not writable yet, global_allocation_limit is applied once HANA runs" >&2
+fi
+exec "$@"
+`
+)
+
+// commandForHanaExpress wraps the command of the HANA container in the start script that
+// applies the global_allocation_limit before HANA starts
+func commandForHanaExpress(command ...string) []string {
+	return append([]string{"/bin/sh", "-c", hanaStartScript, "hana-start"}, command...)
+}
+
+// globalAllocationLimitForHanaExpress derives the HANA global_allocation_limit in MB
+// from the memory limit of the container. It returns an empty string when no memory
+// limit is set and HANA should use its default.
+func globalAllocationLimitForHanaExpress(hanaExpress *dbv1alpha1.HanaExpress) string {
+	limit, ok := hanaExpress.Spec.Resources.Limits[corev1.ResourceMemory]
+	if !ok || limit.IsZero() {
+		return ""
+	}
+
+	// A limit of 0 lets HANA fall back to its default, which is bounded by the node instead
+	mb := limit.Value() / 100 * globalAllocationLimitPercent / (1024 * 1024)
+	if mb < 1 {
+		mb = 1
+	}
+	return strconv.FormatInt(mb, 10)
+}
+
+// reconcileGlobalAllocationLimit records the global_allocation_limit of the running database
+// in the status. The start script of the container already writes the limit before HANA
+// starts, so the limit is only changed online here when the running configuration differs,
+// e.g. after an administrator changed it.
+func (r *HanaExpressReconciler) reconcileGlobalAllocationLimit(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) error {
+	log := log.FromContext(ctx)
+
+	desired := globalAllocationLimitForHanaExpress(hanaExpress)
+	if desired == hanaExpress.Status.GlobalAllocationLimit {
+		return nil
+	}
+
+	db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var current string
+	err = db.QueryRowContext(ctx, "SELECT VALUE FROM SYS.M_INIFILE_CONTENTS WHERE FILE_NAME = 'global.ini' "+
+		"AND LAYER_NAME = 'SYSTEM' AND SECTION = 'memorymanager' AND KEY = 'global_allocation_limit'").Scan(&current)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to read global_allocation_limit: %w", err)
+	}
+	if current == desired {
+		hanaExpress.Status.GlobalAllocationLimit = desired
+		return nil
+	}
+
+	var stmt string
+	if desired == "" {
+		stmt = "ALTER SYSTEM ALTER CONFIGURATION ('global.ini', 'SYSTEM') UNSET ('memorymanager', 'global_allocation_limit') WITH RECONFIGURE"
+	} else {
+		stmt = fmt.Sprintf("ALTER SYSTEM ALTER CONFIGURATION ('global.ini', 'SYSTEM') SET ('memorymanager', 'global_allocation_limit') = %s WITH RECONFIGURE",
+			hdbclient.QuoteString(desired))
+	}
+
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to set global_allocation_limit: %w", err)
+	}
+
+	log.Info("Applied global_allocation_limit", "GlobalAllocationLimit", desired)
+	hanaExpress.Status.GlobalAllocationLimit = desired
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func TestGlobalAllocationLimitForHanaExpress(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit string
+		want  string
+	}{
+		{name: "no memory limit", want: ""},
+		{name: "zero memory limit", limit: "0", want: ""},
+		{name: "binary gigabytes", limit: "8Gi", want: "7372"},
+		{name: "binary megabytes", limit: "8192Mi", want: "7372"},
+		{name: "decimal gigabytes", limit: "8G", want: "6866"},
+		{name: "below one gigabyte", limit: "512Mi", want: "460"},
+		{name: "too small to be rounded down to zero", limit: "1Ki", want: "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress := &dbv1alpha1.HanaExpress{}
+			if tt.limit != "" {
+				hanaExpress.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse(tt.limit)}
+			}
+			if got := globalAllocationLimitForHanaExpress(hanaExpress); got != tt.want {
+				t.Errorf("globalAllocationLimitForHanaExpress(%q) = %q, want %q", tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+// runHanaStartScript runs the start script against a global.ini below root with the limit in
+// the environment, and returns whether the wrapped command ran
+func runHanaStartScript(t *testing.T, root, limit string) bool {
+	t.Helper()
+	marker := filepath.Join(t.TempDir(), "started")
+	command := commandForHanaExpress("touch", marker)
+	command[2] = strings.ReplaceAll(command[2], globalIniPath, filepath.Join(root, globalIniPath))
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), globalAllocationLimitEnv+"="+limit)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("start script failed: %v: %s", err, output)
+	}
+	_, err := os.Stat(marker)
+	return err == nil
+}
+
+func TestHanaStartScript(t *testing.T) {
+	if _, err := exec.LookPath("awk"); err != nil {
+		t.Skip("awk is not available")
+	}
+
+	tests := []struct {
+		name     string
+		existing *string
+		limit    string
+		want     *string
+	}{
+		{
+			name:  "the first start on a fresh volume runs HANA without the configuration directory",
+			limit: "7372",
+		},
+		{
+			name:     "a new global.ini gets the limit",
+			existing: stringPtr(""),
+			limit:    "7372",
+			want:     stringPtr("[memorymanager]\nglobal_allocation_limit = 7372\n"),
+		},
+		{
+			name:     "the limit is added to an existing memorymanager section",
+			existing: stringPtr("[persistence]\nbasepath_datavolumes = /hana/mounts/data\n[memorymanager]\nallocationlimit_x = 1\n"),
+			limit:    "7372",
+			want: stringPtr("[persistence]\nbasepath_datavolumes = /hana/mounts/data\n[memorymanager]\n" +
+				"global_allocation_limit = 7372\nallocationlimit_x = 1\n"),
+		},
+		{
+			name:     "a changed limit replaces the previous one",
+			existing: stringPtr("[memorymanager]\nglobal_allocation_limit = 1000\n[system_information]\nusage = test\n"),
+			limit:    "7372",
+			want:     stringPtr("[memorymanager]\nglobal_allocation_limit = 7372\n[system_information]\nusage = test\n"),
+		},
+		{
+			name:     "a removed limit is removed from global.ini",
+			existing: stringPtr("[memorymanager]\nglobal_allocation_limit = 1000\n"),
+			want:     stringPtr("[memorymanager]\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			ini := filepath.Join(root, globalIniPath)
+			if tt.existing != nil {
+				if err := os.MkdirAll(filepath.Dir(ini), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if *tt.existing != "" {
+					if err := os.WriteFile(ini, []byte(*tt.existing), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			if !runHanaStartScript(t, root, tt.limit) {
+				t.Fatal("the command was not started")
+			}
+
+			content, err := os.ReadFile(ini)
+			switch {
+			case tt.want == nil && !os.IsNotExist(err):
+				t.Errorf("global.ini was written on a fresh volume: %v", err)
+			case tt.want != nil && err != nil:
+				t.Fatal(err)
+			case tt.want != nil && string(content) != *tt.want:
+				t.Errorf("global.ini = %q, want %q", content, *tt.want)
+			}
+		})
+	}
+}
+
+func TestHanaStartScriptStartsHanaWhenGlobalIniCannotBeWritten(t *testing.T) {
+	root := t.TempDir()
+	ini := filepath.Join(root, globalIniPath)
+	existing := "[memorymanager]\nglobal_allocation_limit = 1000\n"
+	if err := os.MkdirAll(filepath.Dir(ini), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ini, []byte(existing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// The temporary file cannot be created, not even by root
+	if err := os.Mkdir(ini+".tmp", 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if !runHanaStartScript(t, root, "7372") {
+		t.Error("the command was not started")
+	}
+	if content, err := os.ReadFile(ini); err != nil || string(content) != existing {
+		t.Errorf("global.ini = %q (%v), want it unchanged", content, err)
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
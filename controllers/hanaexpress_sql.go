@@ -0,0 +1,179 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+const (
+	// hanaSystemDBSQLPort is the SQL port of the SystemDB of the HANA Express instance
+	hanaSystemDBSQLPort = int32(39013)
+	// hanaTenantSQLPort is the SQL port of the HXE tenant database
+	hanaTenantSQLPort = int32(39017)
+	// hanaSystemUser is the database superuser whose password is the master password
+	hanaSystemUser = "SYSTEM"
+	// reconcileTimeout bounds a reconcile and the SQL statements it runs. Backups and
+	// recoveries, which take longer, run outside of Reconcile.
+	reconcileTimeout = 10 * time.Minute
+)
+
+// withReconcileTimeout cancels the context of every reconcile of r after reconcileTimeout, so
+// that a database that stopped answering does not block a worker
+func withReconcileTimeout(r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+		ctx, cancel := context.WithTimeout(ctx, reconcileTimeout)
+		defer cancel()
+		return r.Reconcile(ctx, req)
+	})
+}
+
+// hostForHanaExpress returns the cluster DNS name of the Service in front of the instance
+func hostForHanaExpress(hanaExpress *dbv1alpha1.HanaExpress) string {
+	return fmt.Sprintf("%s.%s.svc", hanaExpress.Name, hanaExpress.Namespace)
+}
+
+// masterPasswordForHanaExpress reads the master password from the Secret referenced
+// in spec.credential, unwrapping it from the passwords JSON for the json format.
+func masterPasswordForHanaExpress(ctx context.Context, c client.Client, hanaExpress *dbv1alpha1.HanaExpress) (string, error) {
+	return passwordFromCredential(ctx, c, hanaExpress.Namespace, hanaExpress.Spec.Credential)
+}
+
+// passwordFromCredential reads the master password a Credential references
+func passwordFromCredential(ctx context.Context, c client.Client, namespace string, credential dbv1alpha1.Credential) (string, error) {
+	secret := &corev1.Secret{}
+	secretKey := types.NamespacedName{
+		Name:      credential.SecretKeyRef.Name,
+		Namespace: namespace,
+	}
+	if err := c.Get(ctx, secretKey, secret); err != nil {
+		return "", fmt.Errorf("failed to get secret %s: %w", secretKey.Name, err)
+	}
+
+	data, exists := secret.Data[credential.SecretKeyRef.Key]
+	if !exists {
+		return "", fmt.Errorf("key %s not found in secret %s", credential.SecretKeyRef.Key, secretKey.Name)
+	}
+
+	if credential.Format != "json" {
+		return string(data), nil
+	}
+
+	var passwords struct {
+		MasterPassword string `json:"master_password"`
+	}
+	if err := json.Unmarshal(data, &passwords); err != nil {
+		return "", fmt.Errorf("credential data is not valid JSON: %w", err)
+	}
+	return passwords.MasterPassword, nil
+}
+
+// openSystemDB connects to the SystemDB of the instance as SYSTEM with the master password
+func openSystemDB(ctx context.Context, c client.Client, connector hdbclient.Connector,
+	hanaExpress *dbv1alpha1.HanaExpress) (*sql.DB, error) {
+	password, err := masterPasswordForHanaExpress(ctx, c, hanaExpress)
+	if err != nil {
+		return nil, err
+	}
+
+	return connector.Open(ctx, hdbclient.Endpoint{
+		Host:     hostForHanaExpress(hanaExpress),
+		Port:     hanaSystemDBSQLPort,
+		User:     hanaSystemUser,
+		Password: password,
+	})
+}
+
+// openDatabase connects as SYSTEM to the SystemDB or to a tenant database of the instance
+func openDatabase(ctx context.Context, c client.Client, connector hdbclient.Connector,
+	hanaExpress *dbv1alpha1.HanaExpress, database string) (*sql.DB, error) {
+	endpoint, err := endpointForDatabase(ctx, c, connector, hanaExpress, database)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Open(ctx, endpoint)
+}
+
+// endpointForDatabase returns the SQL endpoint of the SystemDB or of a tenant database with
+// the SYSTEM user. Tenants managed by a HanaTenantDatabase are reached with the SYSTEM password
+// of its Secret, other tenants such as the default HXE tenant with the master password.
+func endpointForDatabase(ctx context.Context, c client.Client, connector hdbclient.Connector,
+	hanaExpress *dbv1alpha1.HanaExpress, database string) (hdbclient.Endpoint, error) {
+	endpoint := hdbclient.Endpoint{
+		Host: hostForHanaExpress(hanaExpress),
+		Port: hanaTenantSQLPort,
+		User: hanaSystemUser,
+	}
+
+	if database == "SYSTEMDB" {
+		password, err := masterPasswordForHanaExpress(ctx, c, hanaExpress)
+		endpoint.Port = hanaSystemDBSQLPort
+		endpoint.Password = password
+		return endpoint, err
+	}
+
+	tenants := &dbv1alpha1.HanaTenantDatabaseList{}
+	if err := c.List(ctx, tenants, client.InNamespace(hanaExpress.Namespace)); err != nil {
+		return endpoint, err
+	}
+	for _, tenant := range tenants.Items {
+		if tenant.Spec.HanaExpressName != hanaExpress.Name || tenant.Status.DatabaseName != database {
+			continue
+		}
+		if tenant.Status.SQLPort == 0 {
+			return endpoint, fmt.Errorf("tenant database %s is not ready", database)
+		}
+		password, err := passwordFromCredential(ctx, c, tenant.Namespace, tenant.Spec.Credential)
+		endpoint.Port = tenant.Status.SQLPort
+		endpoint.Password = password
+		return endpoint, err
+	}
+
+	password, err := masterPasswordForHanaExpress(ctx, c, hanaExpress)
+	if err != nil {
+		return endpoint, err
+	}
+	endpoint.Password = password
+	if database == "HXE" {
+		return endpoint, nil
+	}
+
+	systemDB, err := openSystemDB(ctx, c, connector, hanaExpress)
+	if err != nil {
+		return endpoint, err
+	}
+	defer systemDB.Close()
+	if endpoint.Port, err = tenantSQLPort(ctx, systemDB, database); err != nil {
+		return endpoint, err
+	}
+	if endpoint.Port == 0 {
+		return endpoint, fmt.Errorf("tenant database %s is not running", database)
+	}
+	return endpoint, nil
+}
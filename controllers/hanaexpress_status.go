@@ -0,0 +1,198 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+const (
+	// hanaXSAPort is the XS advanced port of the HANA Express instance
+	hanaXSAPort = int32(39041)
+	// healthCheckInterval is how often the health of a running database is checked
+	healthCheckInterval = time.Minute
+)
+
+// podNameForHanaExpress returns the name of the pod of the single replica StatefulSet
+func podNameForHanaExpress(hanaExpress *dbv1alpha1.HanaExpress) string {
+	return hanaExpress.Name + "-0"
+}
+
+// observeHanaExpress records the generation the status is reconciled from together with
+// the addresses and the names of the objects of the instance
+func observeHanaExpress(hanaExpress *dbv1alpha1.HanaExpress) {
+	host := hostForHanaExpress(hanaExpress)
+	status := &hanaExpress.Status
+	status.ObservedGeneration = hanaExpress.Generation
+	status.PodName = podNameForHanaExpress(hanaExpress)
+	status.PVCName = dataVolumeClaimName(hanaExpress)
+	status.Endpoints = &dbv1alpha1.HanaExpressEndpoints{
+		SQL:         net.JoinHostPort(host, strconv.Itoa(int(hanaTenantSQLPort))),
+		SystemDBSQL: net.JoinHostPort(host, strconv.Itoa(int(hanaSystemDBSQLPort))),
+		XSA:         net.JoinHostPort(host, strconv.Itoa(int(hanaXSAPort))),
+	}
+}
+
+// setPhase records the phase of the instance and when it last became ready
+func setPhase(hanaExpress *dbv1alpha1.HanaExpress, phase dbv1alpha1.HanaExpressPhase) {
+	if phase == dbv1alpha1.HanaExpressPhaseRunning &&
+		(hanaExpress.Status.Phase != phase || hanaExpress.Status.ReadyTime == nil) {
+		now := metav1.Now()
+		hanaExpress.Status.ReadyTime = &now
+	}
+	hanaExpress.Status.Phase = phase
+}
+
+// podForHanaExpress returns the pod of the instance, or nil while it does not exist
+func (r *HanaExpressReconciler) podForHanaExpress(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (*corev1.Pod, error) {
+	pod := &corev1.Pod{}
+	err := r.Get(ctx, types.NamespacedName{Name: podNameForHanaExpress(hanaExpress), Namespace: hanaExpress.Namespace}, pod)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// podReady reports whether the pod passes its readiness probe
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// stuckWaitingReasons are the waiting reasons of containers that do not resolve by themselves
+var stuckWaitingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// podPhaseForHanaExpress returns the phase of an instance whose pod is not ready, with the
+// reason and message for the Available condition
+func podPhaseForHanaExpress(pod *corev1.Pod) (dbv1alpha1.HanaExpressPhase, string, string) {
+	if pod == nil {
+		return dbv1alpha1.HanaExpressPhasePending, "PodNotFound", "Waiting for the StatefulSet to create the pod"
+	}
+	if pod.GetDeletionTimestamp() != nil {
+		return dbv1alpha1.HanaExpressPhasePending, "PodTerminating",
+			fmt.Sprintf("Waiting for pod %s to terminate before it is recreated", pod.Name)
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if waiting := status.State.Waiting; waiting != nil && stuckWaitingReasons[waiting.Reason] {
+			return dbv1alpha1.HanaExpressPhaseDegraded, waiting.Reason,
+				fmt.Sprintf("Container %s of pod %s is waiting: %s", status.Name, pod.Name, waiting.Message)
+		}
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodFailed:
+		return dbv1alpha1.HanaExpressPhaseDegraded, "PodFailed",
+			fmt.Sprintf("Pod %s failed: %s", pod.Name, pod.Status.Message)
+	case corev1.PodRunning:
+		return dbv1alpha1.HanaExpressPhaseInitializing, "StartingUp",
+			fmt.Sprintf("Waiting for the database in pod %s to pass its readiness probe", pod.Name)
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+			return dbv1alpha1.HanaExpressPhasePending, "Unschedulable",
+				fmt.Sprintf("Pod %s cannot be scheduled: %s", pod.Name, condition.Message)
+		}
+	}
+	return dbv1alpha1.HanaExpressPhasePending, "PodPending", fmt.Sprintf("Waiting for pod %s to start", pod.Name)
+}
+
+// setAvailability records the phase together with the Available and Degraded conditions
+func setAvailability(hanaExpress *dbv1alpha1.HanaExpress, phase dbv1alpha1.HanaExpressPhase, reason, message string) {
+	available := metav1.ConditionFalse
+	if phase == dbv1alpha1.HanaExpressPhaseRunning {
+		available = metav1.ConditionTrue
+	}
+	degraded := metav1.ConditionFalse
+	if phase == dbv1alpha1.HanaExpressPhaseDegraded {
+		degraded = metav1.ConditionTrue
+	}
+
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
+		Status: available, Reason: reason, Message: message})
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeDegradedHanaExpress,
+		Status: degraded, Reason: reason, Message: message})
+	setPhase(hanaExpress, phase)
+}
+
+// databaseHealth logs on to the SystemDB with the master password and checks its services and
+// the HXE tenant
+func (r *HanaExpressReconciler) databaseHealth(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (hdbclient.Health, error) {
+	password, err := masterPasswordForHanaExpress(ctx, r.Client, hanaExpress)
+	if err != nil {
+		return hdbclient.Health{}, err
+	}
+
+	return r.Health.Check(ctx, hdbclient.Endpoint{
+		Host:     hostForHanaExpress(hanaExpress),
+		Port:     hanaSystemDBSQLPort,
+		User:     hanaSystemUser,
+		Password: password,
+	}, "HXE"), nil
+}
+
+// checkDatabaseHealth checks the health of the database and derives the phase and the
+// conditions from the result, which it returns
+func (r *HanaExpressReconciler) checkDatabaseHealth(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (hdbclient.Health, error) {
+	health, err := r.databaseHealth(ctx, hanaExpress)
+	if err != nil {
+		return hdbclient.Health{}, err
+	}
+
+	switch health.Reason {
+	case hdbclient.HealthReasonHealthy:
+		hanaExpress.Status.DatabaseVersion = health.Version
+		setAvailability(hanaExpress, dbv1alpha1.HanaExpressPhaseRunning, "Running",
+			fmt.Sprintf("HANA Express %s is running in pod %s: %s", health.Version, hanaExpress.Status.PodName, health.Message))
+	case hdbclient.HealthReasonStartingUp:
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeProgressingHanaExpress,
+			Status: metav1.ConditionTrue, Reason: string(health.Reason), Message: health.Message})
+		setAvailability(hanaExpress, dbv1alpha1.HanaExpressPhaseInitializing, string(health.Reason), health.Message)
+	default:
+		setAvailability(hanaExpress, dbv1alpha1.HanaExpressPhaseDegraded, string(health.Reason), health.Message)
+	}
+	return health, nil
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+// fakeHealthChecker returns the same health for every check
+type fakeHealthChecker struct {
+	health hdbclient.Health
+}
+
+func (c fakeHealthChecker) Check(ctx context.Context, endpoint hdbclient.Endpoint, tenant string) hdbclient.Health {
+	return c.health
+}
+
+// conditionStatus returns the status and the reason of a condition, or Unknown when it is not set
+func conditionStatus(hanaExpress *dbv1alpha1.HanaExpress, conditionType string) (metav1.ConditionStatus, string) {
+	condition := meta.FindStatusCondition(hanaExpress.Status.Conditions, conditionType)
+	if condition == nil {
+		return metav1.ConditionUnknown, ""
+	}
+	return condition.Status, condition.Reason
+}
+
+func TestPodPhaseForHanaExpress(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name   string
+		pod    *corev1.Pod
+		phase  dbv1alpha1.HanaExpressPhase
+		reason string
+	}{
+		{name: "no pod", phase: dbv1alpha1.HanaExpressPhasePending, reason: "PodNotFound"},
+		{
+			name:   "terminating pod",
+			pod:    &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "hxe-0", DeletionTimestamp: &now}},
+			phase:  dbv1alpha1.HanaExpressPhasePending,
+			reason: "PodTerminating",
+		},
+		{
+			name: "crash looping container",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "hxe-0"}, Status: corev1.PodStatus{Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: hanaExpressContainerName,
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}}}}},
+			phase:  dbv1alpha1.HanaExpressPhaseDegraded,
+			reason: "CrashLoopBackOff",
+		},
+		{
+			name:   "failed pod",
+			pod:    &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "hxe-0"}, Status: corev1.PodStatus{Phase: corev1.PodFailed}},
+			phase:  dbv1alpha1.HanaExpressPhaseDegraded,
+			reason: "PodFailed",
+		},
+		{
+			name:   "running pod that is not ready",
+			pod:    &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "hxe-0"}, Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+			phase:  dbv1alpha1.HanaExpressPhaseInitializing,
+			reason: "StartingUp",
+		},
+		{
+			name: "unschedulable pod",
+			pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "hxe-0"}, Status: corev1.PodStatus{Phase: corev1.PodPending,
+				Conditions: []corev1.PodCondition{{Type: corev1.PodScheduled, Status: corev1.ConditionFalse}}}},
+			phase:  dbv1alpha1.HanaExpressPhasePending,
+			reason: "Unschedulable",
+		},
+		{
+			name:   "pending pod",
+			pod:    &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "hxe-0"}, Status: corev1.PodStatus{Phase: corev1.PodPending}},
+			phase:  dbv1alpha1.HanaExpressPhasePending,
+			reason: "PodPending",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			phase, reason, _ := podPhaseForHanaExpress(tt.pod)
+			if phase != tt.phase || reason != tt.reason {
+				t.Errorf("phase = %s (%s), want %s (%s)", phase, reason, tt.phase, tt.reason)
+			}
+		})
+	}
+}
+
+func TestCheckDatabaseHealth(t *testing.T) {
+	tests := []struct {
+		reason      hdbclient.HealthReason
+		phase       dbv1alpha1.HanaExpressPhase
+		available   metav1.ConditionStatus
+		degraded    metav1.ConditionStatus
+		progressing metav1.ConditionStatus
+	}{
+		{hdbclient.HealthReasonHealthy, dbv1alpha1.HanaExpressPhaseRunning,
+			metav1.ConditionTrue, metav1.ConditionFalse, metav1.ConditionUnknown},
+		{hdbclient.HealthReasonStartingUp, dbv1alpha1.HanaExpressPhaseInitializing,
+			metav1.ConditionFalse, metav1.ConditionFalse, metav1.ConditionTrue},
+		{hdbclient.HealthReasonServiceDown, dbv1alpha1.HanaExpressPhaseDegraded,
+			metav1.ConditionFalse, metav1.ConditionTrue, metav1.ConditionUnknown},
+		{hdbclient.HealthReasonTenantDown, dbv1alpha1.HanaExpressPhaseDegraded,
+			metav1.ConditionFalse, metav1.ConditionTrue, metav1.ConditionUnknown},
+		{hdbclient.HealthReasonAuthenticationFailed, dbv1alpha1.HanaExpressPhaseDegraded,
+			metav1.ConditionFalse, metav1.ConditionTrue, metav1.ConditionUnknown},
+		{hdbclient.HealthReasonUnreachable, dbv1alpha1.HanaExpressPhaseDegraded,
+			metav1.ConditionFalse, metav1.ConditionTrue, metav1.ConditionUnknown},
+		{hdbclient.HealthReasonCheckFailed, dbv1alpha1.HanaExpressPhaseDegraded,
+			metav1.ConditionFalse, metav1.ConditionTrue, metav1.ConditionUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.reason), func(t *testing.T) {
+			hanaExpress, secret := testHanaExpress()
+			c := newFakeClient(secret)
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(),
+				Health: fakeHealthChecker{hdbclient.Health{Reason: tt.reason, Version: "2.00.072.00"}}}
+
+			health, err := r.checkDatabaseHealth(context.Background(), hanaExpress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if health.Reason != tt.reason {
+				t.Errorf("health = %s, want %s", health.Reason, tt.reason)
+			}
+			if hanaExpress.Status.Phase != tt.phase {
+				t.Errorf("phase = %s, want %s", hanaExpress.Status.Phase, tt.phase)
+			}
+			for conditionType, want := range map[string]metav1.ConditionStatus{typeAvailableHanaExpress: tt.available,
+				typeDegradedHanaExpress: tt.degraded, typeProgressingHanaExpress: tt.progressing} {
+				status, reason := conditionStatus(hanaExpress, conditionType)
+				if status != want {
+					t.Errorf("%s = %s, want %s", conditionType, status, want)
+				}
+				if status != metav1.ConditionUnknown && conditionType != typeAvailableHanaExpress &&
+					tt.reason != hdbclient.HealthReasonHealthy && reason != string(tt.reason) {
+					t.Errorf("%s reason = %s, want %s", conditionType, reason, tt.reason)
+				}
+			}
+			if (hanaExpress.Status.ReadyTime != nil) != (tt.phase == dbv1alpha1.HanaExpressPhaseRunning) {
+				t.Errorf("ready time = %v in phase %s", hanaExpress.Status.ReadyTime, hanaExpress.Status.Phase)
+			}
+		})
+	}
+}
+
+func TestReconcileRunningDatabase(t *testing.T) {
+	tests := []struct {
+		name      string
+		health    hdbclient.HealthReason
+		prepare   func(hanaExpress *dbv1alpha1.HanaExpress)
+		phase     dbv1alpha1.HanaExpressPhase
+		available metav1.ConditionStatus
+		reason    string
+		event     string
+		requeue   time.Duration
+	}{
+		{
+			name:      "healthy database",
+			health:    hdbclient.HealthReasonHealthy,
+			phase:     dbv1alpha1.HanaExpressPhaseRunning,
+			available: metav1.ConditionTrue,
+			reason:    "Running",
+			requeue:   healthCheckInterval,
+		},
+		{
+			name:   "unreachable database skips the SQL steps",
+			health: hdbclient.HealthReasonUnreachable,
+			prepare: func(hanaExpress *dbv1alpha1.HanaExpress) {
+				hanaExpress.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("16Gi")}
+			},
+			phase:     dbv1alpha1.HanaExpressPhaseDegraded,
+			available: metav1.ConditionFalse,
+			reason:    string(hdbclient.HealthReasonUnreachable),
+			requeue:   30 * time.Second,
+		},
+		{
+			name:   "failing step keeps the health of the database",
+			health: hdbclient.HealthReasonHealthy,
+			prepare: func(hanaExpress *dbv1alpha1.HanaExpress) {
+				hanaExpress.Spec.Resources.Limits = corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("16Gi")}
+			},
+			phase:     dbv1alpha1.HanaExpressPhaseRunning,
+			available: metav1.ConditionTrue,
+			reason:    "Running",
+			event:     "AllocationLimitFailed",
+			requeue:   30 * time.Second,
+		},
+		{
+			name:   "clone that cannot log on with the passwords of its source",
+			health: hdbclient.HealthReasonAuthenticationFailed,
+			prepare: func(hanaExpress *dbv1alpha1.HanaExpress) {
+				hanaExpress.Status.Source = &dbv1alpha1.DataSourceStatus{Kind: "VolumeSnapshot", Name: "nightly"}
+			},
+			phase:     dbv1alpha1.HanaExpressPhaseDegraded,
+			available: metav1.ConditionFalse,
+			reason:    "CredentialsResetFailed",
+			event:     "CredentialsResetFailed",
+			requeue:   30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, secret := testHanaExpress()
+			meta.RemoveStatusCondition(&hanaExpress.Status.Conditions, typeAvailableHanaExpress)
+			if tt.prepare != nil {
+				tt.prepare(hanaExpress)
+			}
+			c := newFakeClient(hanaExpress, secret)
+			recorder := newTestRecorder()
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: recorder,
+				SQL: &fakeConnector{err: errors.New("connection refused")}, Health: fakeHealthChecker{hdbclient.Health{Reason: tt.health}}}
+
+			key := types.NamespacedName{Name: "hxe", Namespace: testNamespace}
+			if err := c.Get(context.Background(), key, hanaExpress); err != nil {
+				t.Fatal(err)
+			}
+			observeHanaExpress(hanaExpress)
+			result, err := r.reconcileRunningDatabase(context.Background(), hanaExpress, testStatefulSet(testFromImage))
+			if err != nil {
+				t.Fatalf("reconcileRunningDatabase: %v", err)
+			}
+			if result.RequeueAfter != tt.requeue {
+				t.Errorf("requeue after %s, want %s", result.RequeueAfter, tt.requeue)
+			}
+
+			stored := &dbv1alpha1.HanaExpress{}
+			if err := c.Get(context.Background(), key, stored); err != nil {
+				t.Fatal(err)
+			}
+			if stored.Status.Phase != tt.phase {
+				t.Errorf("stored phase = %s, want %s", stored.Status.Phase, tt.phase)
+			}
+			if status, reason := conditionStatus(stored, typeAvailableHanaExpress); status != tt.available || reason != tt.reason {
+				t.Errorf("stored Available = %s (%s), want %s (%s)", status, reason, tt.available, tt.reason)
+			}
+			if events := drainEvents(recorder); tt.event == "" && len(events) > 0 {
+				t.Errorf("unexpected events %v", events)
+			} else if tt.event != "" && !containsEvent(events, tt.event) {
+				t.Errorf("events %v do not contain %s", events, tt.event)
+			}
+		})
+	}
+}
+
+// drainEvents returns the events recorded so far
+func drainEvents(recorder *record.FakeRecorder) []string {
+	events := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// containsEvent reports whether an event with the reason was recorded
+func containsEvent(events []string, reason string) bool {
+	for _, event := range events {
+		if strings.Contains(event, " "+reason+" ") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+// backupMountPath is where the backup PVC is mounted in the HANA Express container
+const backupMountPath = "/hana/backup"
+
+// dataVolumeClaimName returns the name of the PVC created by the StatefulSet
+// VolumeClaimTemplates for the single HANA Express replica.
+func dataVolumeClaimName(hanaExpress *dbv1alpha1.HanaExpress) string {
+	return fmt.Sprintf("data-%s-0", hanaExpress.Name)
+}
+
+// reconcileDataVolumeSize expands the data PVC when spec.pvcSize grows. The
+// VolumeClaimTemplates of the StatefulSet cannot be changed after creation, so the
+// claim created from it is patched directly. The outcome is recorded in the
+// StorageResizing condition, a running resize is followed through the PVC watch.
+func (r *HanaExpressReconciler) reconcileDataVolumeSize(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) error {
+	log := log.FromContext(ctx)
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.Get(ctx, types.NamespacedName{Name: dataVolumeClaimName(hanaExpress), Namespace: hanaExpress.Namespace}, pvc)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			// The StatefulSet controller has not created the claim yet
+			return nil
+		}
+		return err
+	}
+
+	// An unbound claim has no capacity yet and cannot be expanded, the PVC watch
+	// triggers the next reconciliation once it is bound
+	if pvc.Status.Phase != corev1.ClaimBound {
+		return nil
+	}
+
+	desired := resourceQuantity(hanaExpress.Spec.PVCSize)
+	requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+
+	switch desired.Cmp(requested) {
+	case -1:
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeStorageResizingHanaExpress,
+			Status: metav1.ConditionFalse, Reason: "ShrinkNotSupported",
+			Message: fmt.Sprintf("Requested pvcSize %s is smaller than the current size %s of PVC %s; shrinking volumes is not supported",
+				desired.String(), requested.String(), pvc.Name)})
+		return nil
+
+	case 1:
+		expandable, err := r.storageClassAllowsExpansion(ctx, pvc)
+		if err != nil {
+			return err
+		}
+		if !expandable {
+			meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeStorageResizingHanaExpress,
+				Status: metav1.ConditionFalse, Reason: "ExpansionNotSupported",
+				Message: fmt.Sprintf("The StorageClass of PVC %s does not allow volume expansion", pvc.Name)})
+			return nil
+		}
+
+		log.Info("Expanding data PVC", "PVC.Name", pvc.Name, "From", requested.String(), "To", desired.String())
+		patch := client.MergeFrom(pvc.DeepCopy())
+		pvc.Spec.Resources.Requests[corev1.ResourceStorage] = desired
+		if err := r.Patch(ctx, pvc, patch); err != nil {
+			return err
+		}
+
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeStorageResizingHanaExpress,
+			Status: metav1.ConditionTrue, Reason: "Resizing",
+			Message: fmt.Sprintf("PVC %s is being expanded from %s to %s", pvc.Name, requested.String(), desired.String())})
+		return nil
+	}
+
+	// The request matches the spec, check whether the volume has caught up
+	for _, cond := range pvc.Status.Conditions {
+		if cond.Type == corev1.PersistentVolumeClaimFileSystemResizePending && cond.Status == corev1.ConditionTrue {
+			meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeStorageResizingHanaExpress,
+				Status: metav1.ConditionTrue, Reason: "FileSystemResizePending",
+				Message: fmt.Sprintf("PVC %s waits for the file system to be resized on the node", pvc.Name)})
+			return nil
+		}
+	}
+
+	if !capacity.IsZero() && capacity.Cmp(requested) < 0 {
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeStorageResizingHanaExpress,
+			Status: metav1.ConditionTrue, Reason: "Resizing",
+			Message: fmt.Sprintf("PVC %s is being expanded from %s to %s", pvc.Name, capacity.String(), requested.String())})
+		return nil
+	}
+
+	// Only report a completed resize when one was reported as running before
+	if meta.FindStatusCondition(hanaExpress.Status.Conditions, typeStorageResizingHanaExpress) != nil {
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeStorageResizingHanaExpress,
+			Status: metav1.ConditionFalse, Reason: "Resized",
+			Message: fmt.Sprintf("PVC %s has a capacity of %s", pvc.Name, capacity.String())})
+	}
+	return nil
+}
+
+// storageClassAllowsExpansion checks the AllowVolumeExpansion flag of the
+// StorageClass used by the given PVC.
+func (r *HanaExpressReconciler) storageClassAllowsExpansion(ctx context.Context, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false, nil
+	}
+
+	sc := &storagev1.StorageClass{}
+	if err := r.Get(ctx, types.NamespacedName{Name: *pvc.Spec.StorageClassName}, sc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion, nil
+}
+
+// backupVolumeClaimName returns the name of the dedicated backup PVC of the instance
+func backupVolumeClaimName(hanaExpress *dbv1alpha1.HanaExpress) string {
+	return hanaExpress.Name + "-backup"
+}
+
+// reconcileBackupVolumeClaim creates the backup PVC when spec.backupPVCSize is set and
+// expands it when the size grows. It is labelled like the data PVC, so the finalizer
+// removes it together with the data unless the deletion policy is Retain.
+func (r *HanaExpressReconciler) reconcileBackupVolumeClaim(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) error {
+	if hanaExpress.Spec.BackupPVCSize == "" {
+		return nil
+	}
+
+	size := resourceQuantity(hanaExpress.Spec.BackupPVCSize)
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.Get(ctx, types.NamespacedName{Name: backupVolumeClaimName(hanaExpress), Namespace: hanaExpress.Namespace}, pvc)
+	if err != nil && apierrors.IsNotFound(err) {
+		pvc = &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      backupVolumeClaimName(hanaExpress),
+				Namespace: hanaExpress.Namespace,
+				Labels:    selectorLabelsForHanaExpress(hanaExpress.Name),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{
+					corev1.ReadWriteOnce,
+				},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						corev1.ResourceStorage: size,
+					},
+				},
+			},
+		}
+
+		log.FromContext(ctx).Info("Creating backup PVC", "PVC.Namespace", pvc.Namespace, "PVC.Name", pvc.Name)
+		return r.Create(ctx, pvc)
+	} else if err != nil {
+		return err
+	}
+
+	requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if size.Cmp(requested) <= 0 {
+		return nil
+	}
+
+	expandable, err := r.storageClassAllowsExpansion(ctx, pvc)
+	if err != nil || !expandable {
+		return err
+	}
+
+	patch := client.MergeFrom(pvc.DeepCopy())
+	pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+	return r.Patch(ctx, pvc, patch)
+}
+
+// addBackupVolume mounts the backup PVC into the HANA Express container and hands the
+// mount point over to the HANA user with an additional init container.
+func addBackupVolume(hanaExpress *dbv1alpha1.HanaExpress, podSpec *corev1.PodSpec) {
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "backup",
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: backupVolumeClaimName(hanaExpress),
+			},
+		},
+	})
+
+	mount := corev1.VolumeMount{
+		Name:      "backup",
+		MountPath: backupMountPath,
+	}
+
+	podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+		Image:        podSpec.InitContainers[0].Image,
+		Name:         "set-backup-dir-ownership",
+		Command:      []string{"sh", "-c", "chown 12000:79 " + backupMountPath},
+		VolumeMounts: []corev1.VolumeMount{mount},
+	})
+
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == "hana-express" {
+			podSpec.Containers[i].VolumeMounts = append(podSpec.Containers[i].VolumeMounts, mount)
+		}
+	}
+}
+
+// finalSnapshotName returns the name of the VolumeSnapshot taken for the Snapshot deletion
+// policy. It contains the UID, so a recreated instance of the same name does not find it.
+func finalSnapshotName(hanaExpress *dbv1alpha1.HanaExpress) string {
+	return fmt.Sprintf("%s-final-%s", hanaExpress.Name, string(hanaExpress.UID)[:8])
+}
+
+// reconcileFinalSnapshot takes the VolumeSnapshot of the Snapshot deletion policy and reports
+// whether it is ready, so the data PVC can be deleted. HANA is stopped first by scaling the
+// StatefulSet to zero, which makes the snapshot consistent without preparing a HANA snapshot.
+// The VolumeSnapshot is not owned by the instance and outlives it.
+func (r *HanaExpressReconciler) reconcileFinalSnapshot(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (bool, error) {
+	log := log.FromContext(ctx)
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := r.Get(ctx, types.NamespacedName{Name: dataVolumeClaimName(hanaExpress), Namespace: hanaExpress.Namespace}, pvc)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("No data PVC to take the final snapshot of")
+			return true, nil
+		}
+		return false, err
+	}
+
+	sts := &appsv1.StatefulSet{}
+	err = r.Get(ctx, types.NamespacedName{Name: hanaExpress.Name, Namespace: hanaExpress.Namespace}, sts)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+	if err == nil {
+		if sts.Spec.Replicas == nil || *sts.Spec.Replicas != 0 {
+			log.Info("Stopping HANA Express before the final snapshot")
+			patch := client.MergeFrom(sts.DeepCopy())
+			sts.Spec.Replicas = &[]int32{0}[0]
+			if err := r.Patch(ctx, sts, patch); err != nil {
+				return false, err
+			}
+			return false, nil
+		}
+		if sts.Status.Replicas > 0 {
+			return false, nil
+		}
+	}
+
+	snapshot := &snapshotv1.VolumeSnapshot{}
+	name := finalSnapshotName(hanaExpress)
+	err = r.Get(ctx, types.NamespacedName{Name: name, Namespace: hanaExpress.Namespace}, snapshot)
+	if err != nil && apierrors.IsNotFound(err) {
+		className, err := defaultVolumeSnapshotClassForData(ctx, r.Client, hanaExpress)
+		if err != nil {
+			return false, err
+		}
+		snapshot = &snapshotv1.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: hanaExpress.Namespace,
+				Labels:    selectorLabelsForHanaExpress(hanaExpress.Name),
+			},
+			Spec: snapshotv1.VolumeSnapshotSpec{
+				Source: snapshotv1.VolumeSnapshotSource{
+					PersistentVolumeClaimName: &pvc.Name,
+				},
+				VolumeSnapshotClassName: &className,
+			},
+		}
+		log.Info("Creating the final VolumeSnapshot", "VolumeSnapshot.Name", name)
+		return false, r.Create(ctx, snapshot)
+	} else if err != nil {
+		return false, err
+	}
+
+	if snapshot.Status != nil && snapshot.Status.Error != nil && snapshot.Status.Error.Message != nil {
+		return false, fmt.Errorf("VolumeSnapshot %s failed: %s", name, *snapshot.Status.Error.Message)
+	}
+	if snapshot.Status == nil || snapshot.Status.ReadyToUse == nil || !*snapshot.Status.ReadyToUse {
+		return false, nil
+	}
+
+	r.Recorder.Event(hanaExpress, "Normal", "FinalSnapshotTaken",
+		fmt.Sprintf("Took VolumeSnapshot %s of the data PVC %s before deleting it", name, pvc.Name))
+	return true, nil
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// testDataVolumeClaim returns the data PVC of hxe with the requested size, in the phase and
+// with the capacity of its status
+func testDataVolumeClaim(requested string, phase corev1.PersistentVolumeClaimPhase, capacity string) *corev1.PersistentVolumeClaim {
+	storageClass := "expandable"
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data-hxe-0", Namespace: testNamespace},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: &storageClass,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(requested)},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{Phase: phase},
+	}
+	if capacity != "" {
+		pvc.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)}
+	}
+	return pvc
+}
+
+func TestReconcileDataVolumeSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		pvcSize    string
+		pvc        *corev1.PersistentVolumeClaim
+		expandable bool
+		resizing   bool
+		status     metav1.ConditionStatus
+		reason     string
+		requested  string
+	}{
+		{
+			name:      "a pending claim on the first start is not reported as resizing",
+			pvcSize:   "20Gi",
+			pvc:       testDataVolumeClaim("20Gi", corev1.ClaimPending, ""),
+			requested: "20Gi",
+		},
+		{
+			name:      "a bound claim without capacity is not reported as resizing",
+			pvcSize:   "20Gi",
+			pvc:       testDataVolumeClaim("20Gi", corev1.ClaimBound, ""),
+			requested: "20Gi",
+		},
+		{
+			name:      "a bound claim of the requested size needs nothing",
+			pvcSize:   "20Gi",
+			pvc:       testDataVolumeClaim("20Gi", corev1.ClaimBound, "20Gi"),
+			requested: "20Gi",
+		},
+		{
+			name:       "a grown pvcSize expands the claim",
+			pvcSize:    "40Gi",
+			pvc:        testDataVolumeClaim("20Gi", corev1.ClaimBound, "20Gi"),
+			expandable: true,
+			status:     metav1.ConditionTrue,
+			reason:     "Resizing",
+			requested:  "40Gi",
+		},
+		{
+			name:      "a StorageClass without expansion is reported",
+			pvcSize:   "40Gi",
+			pvc:       testDataVolumeClaim("20Gi", corev1.ClaimBound, "20Gi"),
+			status:    metav1.ConditionFalse,
+			reason:    "ExpansionNotSupported",
+			requested: "20Gi",
+		},
+		{
+			name:      "a smaller pvcSize is not applied",
+			pvcSize:   "10Gi",
+			pvc:       testDataVolumeClaim("20Gi", corev1.ClaimBound, "20Gi"),
+			status:    metav1.ConditionFalse,
+			reason:    "ShrinkNotSupported",
+			requested: "20Gi",
+		},
+		{
+			name:      "an expansion in progress is followed",
+			pvcSize:   "40Gi",
+			pvc:       testDataVolumeClaim("40Gi", corev1.ClaimBound, "20Gi"),
+			status:    metav1.ConditionTrue,
+			reason:    "Resizing",
+			requested: "40Gi",
+		},
+		{
+			name:    "a pending file system resize is reported",
+			pvcSize: "40Gi",
+			pvc: func() *corev1.PersistentVolumeClaim {
+				pvc := testDataVolumeClaim("40Gi", corev1.ClaimBound, "20Gi")
+				pvc.Status.Conditions = []corev1.PersistentVolumeClaimCondition{{
+					Type: corev1.PersistentVolumeClaimFileSystemResizePending, Status: corev1.ConditionTrue}}
+				return pvc
+			}(),
+			status:    metav1.ConditionTrue,
+			reason:    "FileSystemResizePending",
+			requested: "40Gi",
+		},
+		{
+			name:      "a completed expansion is reported",
+			pvcSize:   "40Gi",
+			pvc:       testDataVolumeClaim("40Gi", corev1.ClaimBound, "40Gi"),
+			resizing:  true,
+			status:    metav1.ConditionFalse,
+			reason:    "Resized",
+			requested: "40Gi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, _ := testHanaExpress()
+			hanaExpress.Spec.PVCSize = tt.pvcSize
+			if tt.resizing {
+				meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeStorageResizingHanaExpress,
+					Status: metav1.ConditionTrue, Reason: "Resizing"})
+			}
+			storageClass := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "expandable"},
+				AllowVolumeExpansion: &tt.expandable}
+			c := newFakeClient(tt.pvc, storageClass)
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+
+			if err := r.reconcileDataVolumeSize(context.Background(), hanaExpress); err != nil {
+				t.Fatal(err)
+			}
+
+			condition := meta.FindStatusCondition(hanaExpress.Status.Conditions, typeStorageResizingHanaExpress)
+			switch {
+			case tt.reason == "" && condition != nil:
+				t.Errorf("StorageResizing = %s/%s: %s, want no condition", condition.Status, condition.Reason, condition.Message)
+			case tt.reason != "" && condition == nil:
+				t.Errorf("no StorageResizing condition, want %s/%s", tt.status, tt.reason)
+			case tt.reason != "" && (condition.Status != tt.status || condition.Reason != tt.reason):
+				t.Errorf("StorageResizing = %s/%s, want %s/%s", condition.Status, condition.Reason, tt.status, tt.reason)
+			}
+
+			pvc := &corev1.PersistentVolumeClaim{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(tt.pvc), pvc); err != nil {
+				t.Fatal(err)
+			}
+			requested := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+			if requested.Cmp(resource.MustParse(tt.requested)) != 0 {
+				t.Errorf("requested storage = %s, want %s", requested.String(), tt.requested)
+			}
+		})
+	}
+}
+
+func TestReconcileDataVolumeSizeWithoutClaim(t *testing.T) {
+	hanaExpress, _ := testHanaExpress()
+	c := newFakeClient()
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder()}
+
+	if err := r.reconcileDataVolumeSize(context.Background(), hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	if condition := meta.FindStatusCondition(hanaExpress.Status.Conditions, typeStorageResizingHanaExpress); condition != nil {
+		t.Errorf("StorageResizing = %s/%s, want no condition before the claim exists", condition.Status, condition.Reason)
+	}
+}
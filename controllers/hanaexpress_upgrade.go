@@ -0,0 +1,490 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+// defaultUpgradeTimeout bounds an upgrade when spec.upgradeTimeout is not set
+const defaultUpgradeTimeout = 30 * time.Minute
+
+// upgradeFinished reports whether the upgrade reached a terminal phase
+func upgradeFinished(upgrade *dbv1alpha1.UpgradeStatus) bool {
+	switch upgrade.Phase {
+	case dbv1alpha1.UpgradePhaseSucceeded, dbv1alpha1.UpgradePhaseRolledBack, dbv1alpha1.UpgradePhaseFailed:
+		return true
+	}
+	return false
+}
+
+// templateImageForHanaExpress returns the image to put into the pod template. A change
+// of the desired image is not rolled out directly but through the upgrade workflow,
+// which decides whether the previous or the new image runs. Only an upgrade that rolls out
+// the new image or succeeded runs it, every other phase runs the previous image.
+func templateImageForHanaExpress(hanaExpress *dbv1alpha1.HanaExpress, desired string) string {
+	upgrade := hanaExpress.Status.Upgrade
+	if upgrade != nil && (upgrade.ToImage == desired || !upgradeFinished(upgrade)) {
+		switch upgrade.Phase {
+		case dbv1alpha1.UpgradePhaseUpdatingImage, dbv1alpha1.UpgradePhaseSucceeded:
+			return upgrade.ToImage
+		default:
+			return upgrade.FromImage
+		}
+	}
+
+	// The upgrade to the desired image has not been started yet
+	if hanaExpress.Status.Image != "" {
+		return hanaExpress.Status.Image
+	}
+	return desired
+}
+
+// reconcileUpgrade drives an image upgrade through its phases: a pre-upgrade data backup,
+// the image swap and a SQL health gate on the rolled out database. An upgrade that does not
+// pass the gate within spec.upgradeTimeout is rolled back to the previous image, and when HANA
+// started on the new image, which may have migrated the data volume, the pre-upgrade backup
+// is restored. The progress is recorded in status.upgrade and the Upgrading condition. It
+// returns true when the status changed.
+func (r *HanaExpressReconciler) reconcileUpgrade(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	sts *appsv1.StatefulSet) (bool, error) {
+	log := log.FromContext(ctx)
+
+	desired, _, err := imageForHanaExpress(hanaExpress)
+	if err != nil {
+		return false, err
+	}
+	running := sts.Spec.Template.Spec.Containers[0].Image
+	upgrade := hanaExpress.Status.Upgrade
+
+	if upgrade == nil || upgradeFinished(upgrade) {
+		// A failed attempt is not repeated until the desired image changes again
+		if desired == running || (upgrade != nil && upgrade.ToImage == desired) {
+			return false, nil
+		}
+
+		log.Info("Starting upgrade", "From", running, "To", desired)
+		hanaExpress.Status.Upgrade = &dbv1alpha1.UpgradeStatus{
+			FromImage: running,
+			ToImage:   desired,
+			Phase:     dbv1alpha1.UpgradePhaseBackingUp,
+			StartTime: metav1.Now(),
+		}
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeUpgradingHanaExpress,
+			Status: metav1.ConditionTrue, Reason: "BackingUp",
+			Message: fmt.Sprintf("Taking a data backup before upgrading from %s to %s", running, desired)})
+		return true, nil
+	}
+
+	timeout := defaultUpgradeTimeout
+	if hanaExpress.Spec.UpgradeTimeout != nil {
+		timeout = hanaExpress.Spec.UpgradeTimeout.Duration
+	}
+	timedOut := time.Since(upgrade.StartTime.Time) > timeout
+
+	switch upgrade.Phase {
+	case dbv1alpha1.UpgradePhaseBackingUp:
+		if upgrade.Backup == "" {
+			if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+				if timedOut {
+					return r.failUpgradeBackup(hanaExpress, "the instance did not become available"), nil
+				}
+				// HANA may still be starting, wait until the upgrade times out
+				return false, nil
+			}
+			return r.startBackupBeforeUpgrade(ctx, hanaExpress)
+		}
+
+		state, databases, err := r.preUpgradeBackupState(ctx, hanaExpress)
+		if err != nil {
+			return false, err
+		}
+		switch state {
+		case "":
+			if upgrade.BackupStartTime != nil && time.Since(upgrade.BackupStartTime.Time) < backupCatalogGracePeriod {
+				return false, nil
+			}
+			return r.failUpgradeBackup(hanaExpress, fmt.Sprintf("backup %s is not recorded in the backup catalog", upgrade.Backup)), nil
+		case "running":
+			// The backup is not aborted when the upgrade times out, the timeout applies
+			// to the rollout of the new image
+			return false, nil
+		case "successful":
+		default:
+			return r.failUpgradeBackup(hanaExpress, fmt.Sprintf("backup %s finished in state %s", upgrade.Backup, state)), nil
+		}
+
+		log.Info("Pre-upgrade backup completed", "Backup", upgrade.Backup, "Databases", databases)
+		upgrade.BackupDatabases = databases
+		upgrade.Phase = dbv1alpha1.UpgradePhaseUpdatingImage
+		meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeUpgradingHanaExpress,
+			Status: metav1.ConditionTrue, Reason: "UpdatingImage",
+			Message: fmt.Sprintf("Rolling out %s and waiting for HANA to become healthy", upgrade.ToImage)})
+		return true, nil
+
+	case dbv1alpha1.UpgradePhaseUpdatingImage:
+		if running == upgrade.ToImage && !upgrade.ImageUpdated {
+			upgrade.ImageUpdated = true
+			return true, nil
+		}
+
+		// A rollback of an image HANA never started on does not need the backup
+		changed := false
+		if running == upgrade.ToImage && !upgrade.ImageStarted {
+			started, err := r.imageStarted(ctx, hanaExpress, upgrade.ToImage)
+			if err != nil {
+				return false, err
+			}
+			if started {
+				log.Info("HANA started on the new image", "To", upgrade.ToImage)
+				upgrade.ImageStarted = true
+				changed = true
+			}
+		}
+
+		// The readiness probe only opens a TCP connection, the upgrade succeeds once the
+		// database serves SQL again
+		if running == upgrade.ToImage && !rolloutInProgress(sts) {
+			health, err := r.databaseHealth(ctx, hanaExpress)
+			if err != nil {
+				return false, err
+			}
+			if health.Healthy() {
+				upgrade.Phase = dbv1alpha1.UpgradePhaseSucceeded
+				meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeUpgradingHanaExpress,
+					Status: metav1.ConditionFalse, Reason: "UpgradeSucceeded",
+					Message: fmt.Sprintf("Upgraded from %s to %s", upgrade.FromImage, upgrade.ToImage)})
+				r.Recorder.Event(hanaExpress, "Normal", "Upgraded",
+					fmt.Sprintf("Upgraded from %s to %s", upgrade.FromImage, upgrade.ToImage))
+				return true, nil
+			}
+			log.Info("Upgraded database is not healthy yet", "Reason", health.Reason, "Message", health.Message)
+		}
+
+		if timedOut {
+			log.Info("Upgrade did not become healthy in time, rolling back", "To", upgrade.ToImage,
+				"Backup", upgrade.Backup, "Restore", upgrade.ImageStarted)
+			now := metav1.Now()
+			upgrade.Phase = dbv1alpha1.UpgradePhaseRollingBack
+			upgrade.RollbackStartTime = &now
+			message := fmt.Sprintf("%s did not become healthy within %s, rolling back to %s", upgrade.ToImage, timeout,
+				upgrade.FromImage)
+			if upgrade.ImageStarted {
+				message = fmt.Sprintf("%s and restoring the pre-upgrade backup %s", message, upgrade.Backup)
+			}
+			meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeUpgradingHanaExpress,
+				Status: metav1.ConditionTrue, Reason: "RollingBack", Message: message})
+			r.Recorder.Event(hanaExpress, "Warning", "UpgradeFailed", message)
+			return true, nil
+		}
+		return changed, nil
+
+	case dbv1alpha1.UpgradePhaseRollingBack:
+		return r.reconcileRollback(ctx, hanaExpress, sts, timeout)
+	}
+
+	return false, nil
+}
+
+// reconcileRollback puts the previous image back. When HANA never started on the new image the
+// data volume is unchanged, and the rollback completes once the previous image serves SQL
+// again within spec.upgradeTimeout. Otherwise the new version may have migrated the data
+// volume, which the previous one cannot start on, and the databases of the pre-upgrade backup
+// are restored one after the other, the SystemDB first, by HanaExpressRestores of the instance.
+func (r *HanaExpressReconciler) reconcileRollback(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	sts *appsv1.StatefulSet, timeout time.Duration) (bool, error) {
+	log := log.FromContext(ctx)
+	upgrade := hanaExpress.Status.Upgrade
+
+	// The drift reconciliation puts the previous image into the pod template first, a restore
+	// of the SystemDB runs its recovery with the image of the StatefulSet
+	if sts.Spec.Template.Spec.Containers[0].Image != upgrade.FromImage {
+		return false, nil
+	}
+
+	if upgrade.ImageStarted {
+		return r.restorePreUpgradeBackup(ctx, hanaExpress)
+	}
+
+	// A StatefulSet does not replace a pod of the previous revision that never became ready
+	pod, err := r.podForHanaExpress(ctx, hanaExpress)
+	if err != nil {
+		return false, err
+	}
+	if pod != nil && pod.GetDeletionTimestamp() == nil && podImage(pod) == upgrade.ToImage {
+		log.Info("Deleting the pod of the new image", "Pod.Name", pod.Name, "Image", upgrade.ToImage)
+		if err := r.Delete(ctx, pod); err != nil && !apierrors.IsNotFound(err) {
+			return false, err
+		}
+		return false, nil
+	}
+
+	if pod != nil && podImage(pod) == upgrade.FromImage && !rolloutInProgress(sts) {
+		health, err := r.databaseHealth(ctx, hanaExpress)
+		if err != nil {
+			return false, err
+		}
+		if health.Healthy() {
+			return r.completeRollback(hanaExpress, fmt.Sprintf("Rolled back from %s to %s", upgrade.ToImage,
+				upgrade.FromImage)), nil
+		}
+	}
+
+	if upgrade.RollbackStartTime != nil && time.Since(upgrade.RollbackStartTime.Time) > timeout {
+		return r.failRollback(hanaExpress, fmt.Sprintf("%s did not become healthy again within %s", upgrade.FromImage,
+			timeout)), nil
+	}
+	return false, nil
+}
+
+// restorePreUpgradeBackup restores the databases of the pre-upgrade backup one after the other.
+// The name of a restore is recorded in the status once it is created, and it is derived from
+// the start of the rollback, so a restore is never created twice.
+func (r *HanaExpressReconciler) restorePreUpgradeBackup(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (bool, error) {
+	log := log.FromContext(ctx)
+	upgrade := hanaExpress.Status.Upgrade
+
+	for i, database := range rollbackDatabases(upgrade) {
+		if i < len(upgrade.Restores) {
+			restore := &dbv1alpha1.HanaExpressRestore{}
+			err := r.Get(ctx, types.NamespacedName{Name: upgrade.Restores[i], Namespace: hanaExpress.Namespace}, restore)
+			if apierrors.IsNotFound(err) {
+				return r.failRollback(hanaExpress, fmt.Sprintf("HanaExpressRestore %s of database %s was deleted",
+					upgrade.Restores[i], database)), nil
+			}
+			if err != nil {
+				return false, err
+			}
+
+			switch restore.Status.Phase {
+			case dbv1alpha1.RestorePhaseCompleted:
+				continue
+			case dbv1alpha1.RestorePhaseFailed:
+				return r.failRollback(hanaExpress, fmt.Sprintf("HanaExpressRestore %s of database %s failed: %s",
+					restore.Name, database, restore.Status.Message)), nil
+			}
+			// The HanaExpressRestore watch triggers the next reconciliation
+			return false, nil
+		}
+
+		restore := &dbv1alpha1.HanaExpressRestore{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: fmt.Sprintf("%s-rollback-%s-%d", hanaExpress.Name,
+					upgrade.RollbackStartTime.UTC().Format("20060102150405"), i),
+				Namespace: hanaExpress.Namespace,
+			},
+			Spec: dbv1alpha1.HanaExpressRestoreSpec{
+				HanaExpressName: hanaExpress.Name,
+				DatabaseName:    database,
+				Source:          dbv1alpha1.RestoreSource{Path: upgrade.Backup},
+			},
+		}
+		if err := ctrl.SetControllerReference(hanaExpress, restore, r.Scheme); err != nil {
+			return false, err
+		}
+
+		log.Info("Restoring the pre-upgrade backup", "Database", database, "HanaExpressRestore.Name", restore.Name)
+		if err := r.Create(ctx, restore); err != nil && !apierrors.IsAlreadyExists(err) {
+			return false, err
+		}
+		upgrade.Restores = append(upgrade.Restores, restore.Name)
+		return true, nil
+	}
+
+	return r.completeRollback(hanaExpress, fmt.Sprintf("Rolled back from %s to %s and restored the pre-upgrade backup %s",
+		upgrade.ToImage, upgrade.FromImage, upgrade.Backup)), nil
+}
+
+// rollbackDatabases returns the databases the rollback restores. Upgrades started by earlier
+// operator versions did not record them, their backups cover the SystemDB and the HXE tenant.
+func rollbackDatabases(upgrade *dbv1alpha1.UpgradeStatus) []string {
+	if len(upgrade.BackupDatabases) == 0 {
+		return []string{"SYSTEMDB", "HXE"}
+	}
+	return upgrade.BackupDatabases
+}
+
+// imageStarted reports whether the HANA container of the instance pod was started on the image
+func (r *HanaExpressReconciler) imageStarted(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress, image string) (bool, error) {
+	pod, err := r.podForHanaExpress(ctx, hanaExpress)
+	if err != nil || pod == nil || podImage(pod) != image {
+		return false, err
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == hanaExpressContainerName {
+			return status.State.Running != nil || status.State.Terminated != nil || status.RestartCount > 0 ||
+				status.LastTerminationState.Terminated != nil, nil
+		}
+	}
+	return false, nil
+}
+
+// podImage returns the image of the HANA container of the pod
+func podImage(pod *corev1.Pod) string {
+	for _, container := range pod.Spec.Containers {
+		if container.Name == hanaExpressContainerName {
+			return container.Image
+		}
+	}
+	return ""
+}
+
+// completeRollback records that the previous image runs again
+func (r *HanaExpressReconciler) completeRollback(hanaExpress *dbv1alpha1.HanaExpress, message string) bool {
+	hanaExpress.Status.Upgrade.Phase = dbv1alpha1.UpgradePhaseRolledBack
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeUpgradingHanaExpress,
+		Status: metav1.ConditionFalse, Reason: "RolledBack", Message: message})
+	r.Recorder.Event(hanaExpress, "Warning", "RolledBack", message)
+	return true
+}
+
+// failRollback records that the rollback did not bring the previous image back. The previous
+// image stays in the pod template.
+func (r *HanaExpressReconciler) failRollback(hanaExpress *dbv1alpha1.HanaExpress, reason string) bool {
+	upgrade := hanaExpress.Status.Upgrade
+	upgrade.Phase = dbv1alpha1.UpgradePhaseFailed
+	message := fmt.Sprintf("The rollback of the upgrade to %s failed: %s", upgrade.ToImage, reason)
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeUpgradingHanaExpress,
+		Status: metav1.ConditionFalse, Reason: "RollbackFailed", Message: message})
+	r.Recorder.Event(hanaExpress, "Warning", "RollbackFailed", message)
+	return true
+}
+
+// startBackupBeforeUpgrade starts a complete data backup of the SystemDB and all tenants into
+// the default data backup location of the instance. The file name prefix is recorded in the
+// status before the backup is started, so that a backup is never started twice. HANA runs
+// the backup asynchronously, later reconciles follow it in the backup catalog.
+func (r *HanaExpressReconciler) startBackupBeforeUpgrade(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (bool, error) {
+	log := log.FromContext(ctx)
+	upgrade := hanaExpress.Status.Upgrade
+
+	db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	now := metav1.Now()
+	upgrade.Backup = fmt.Sprintf("pre-upgrade-%s", upgrade.StartTime.UTC().Format("20060102150405"))
+	upgrade.BackupStartTime = &now
+	if err := r.Status().Update(ctx, hanaExpress); err != nil {
+		return false, err
+	}
+
+	log.Info("Starting pre-upgrade backup", "Backup", upgrade.Backup)
+	stmt := fmt.Sprintf("BACKUP DATA FOR FULL SYSTEM USING FILE (%s) ASYNCHRONOUS", hdbclient.QuoteString(upgrade.Backup))
+	if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+		// The statement may have been accepted before the connection failed, the catalog tells
+		if state, _, catalogErr := backupStateInCatalog(ctx, db, upgrade.Backup); catalogErr == nil && state != "" {
+			log.Info("Pre-upgrade backup started despite an error", "Backup", upgrade.Backup, "Error", err.Error())
+			return false, nil
+		}
+
+		// The backup did not start, it is started again until the upgrade times out
+		log.Error(err, "Failed to start the pre-upgrade backup")
+		r.Recorder.Event(hanaExpress, "Warning", "BackupFailed", fmt.Sprintf("Failed to start the pre-upgrade backup: %s", err))
+		upgrade.Backup = ""
+		upgrade.BackupStartTime = nil
+		return true, nil
+	}
+	return false, nil
+}
+
+// preUpgradeBackupState returns the state of the pre-upgrade backup in the backup catalog and
+// the databases it covers
+func (r *HanaExpressReconciler) preUpgradeBackupState(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (string, []string, error) {
+	db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+	if err != nil {
+		return "", nil, err
+	}
+	defer db.Close()
+
+	return backupStateInCatalog(ctx, db, hanaExpress.Status.Upgrade.Backup)
+}
+
+// backupStateInCatalog returns the state of the complete data backups of all databases that
+// wrote files with the given file name prefix: running while one of them runs, the state of
+// a backup that did not succeed, successful, or empty when no backup is recorded. It also
+// returns the databases with a backup, the SystemDB first.
+func backupStateInCatalog(ctx context.Context, db *sql.DB, prefix string) (string, []string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT C.DATABASE_NAME, C.STATE_NAME
+		FROM SYS_DATABASES.M_BACKUP_CATALOG C
+		WHERE C.ENTRY_TYPE_NAME = 'complete data backup'
+		AND EXISTS (SELECT 1 FROM SYS_DATABASES.M_BACKUP_CATALOG_FILES F
+			WHERE F.DATABASE_NAME = C.DATABASE_NAME AND F.BACKUP_ID = C.BACKUP_ID AND F.DESTINATION_PATH LIKE ?)`,
+		"%/"+prefix+"_databackup_%")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to query the backup catalog: %w", err)
+	}
+	defer rows.Close()
+
+	state := ""
+	var databases []string
+	for rows.Next() {
+		var database, entryState string
+		if err := rows.Scan(&database, &entryState); err != nil {
+			return "", nil, fmt.Errorf("failed to read the backup catalog: %w", err)
+		}
+		switch {
+		case slices.Contains(databases, database):
+		case database == "SYSTEMDB":
+			databases = append([]string{database}, databases...)
+		default:
+			databases = append(databases, database)
+		}
+		switch {
+		case entryState == "running":
+			state = entryState
+		case entryState != "successful" && state != "running":
+			state = entryState
+		case state == "":
+			state = entryState
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", nil, fmt.Errorf("failed to read the backup catalog: %w", err)
+	}
+	return state, databases, nil
+}
+
+// failUpgradeBackup aborts the upgrade because the pre-upgrade backup failed
+func (r *HanaExpressReconciler) failUpgradeBackup(hanaExpress *dbv1alpha1.HanaExpress, reason string) bool {
+	upgrade := hanaExpress.Status.Upgrade
+	upgrade.Phase = dbv1alpha1.UpgradePhaseFailed
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeUpgradingHanaExpress,
+		Status: metav1.ConditionFalse, Reason: "BackupFailed",
+		Message: fmt.Sprintf("Upgrade to %s aborted, the pre-upgrade backup failed: %s", upgrade.ToImage, reason)})
+	r.Recorder.Event(hanaExpress, "Warning", "UpgradeFailed",
+		fmt.Sprintf("Upgrade to %s aborted, the pre-upgrade backup failed", upgrade.ToImage))
+	return true
+}
@@ -0,0 +1,580 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+const (
+	testFromImage = "saplabs/hanaexpress:2.00.061.00.20220519.1"
+	testToImage   = "saplabs/hanaexpress:2.00.072.00.20230728.1"
+
+	preUpgradeBackupQuery = "SELECT C.DATABASE_NAME, C.STATE_NAME FROM SYS_DATABASES.M_BACKUP_CATALOG C " +
+		"WHERE C.ENTRY_TYPE_NAME = 'complete data backup' " +
+		"AND EXISTS (SELECT 1 FROM SYS_DATABASES.M_BACKUP_CATALOG_FILES F " +
+		"WHERE F.DATABASE_NAME = C.DATABASE_NAME AND F.BACKUP_ID = C.BACKUP_ID AND F.DESTINATION_PATH LIKE ?)"
+	testPreUpgradeBackup = "pre-upgrade-20231001120000"
+)
+
+// testStatefulSet returns a rolled out StatefulSet running the image
+func testStatefulSet(image string) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "hxe", Namespace: testNamespace, Generation: 1},
+		Spec: appsv1.StatefulSetSpec{
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: hanaExpressContainerName, Image: image}},
+			}},
+		},
+		Status: appsv1.StatefulSetStatus{ObservedGeneration: 1, UpdatedReplicas: 1, ReadyReplicas: 1},
+	}
+}
+
+func expectPreUpgradeBackup(states ...string) func(mock sqlmock.Sqlmock) {
+	return func(mock sqlmock.Sqlmock) {
+		rows := sqlmock.NewRows([]string{"DATABASE_NAME", "STATE_NAME"})
+		for i, state := range states {
+			rows.AddRow([]string{"SYSTEMDB", "HXE"}[i], state)
+		}
+		mock.ExpectQuery(preUpgradeBackupQuery).WithArgs("%/" + testPreUpgradeBackup + "_databackup_%").WillReturnRows(rows)
+	}
+}
+
+func TestReconcileUpgrade(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	t.Setenv(supportedVersionsEnvVar, "2.00.072.00.20230728.1")
+
+	started := metav1.NewTime(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))
+	recent := metav1.NewTime(time.Now().Add(-30 * time.Second))
+	long := metav1.NewTime(time.Now().Add(-time.Hour))
+
+	tests := []struct {
+		name      string
+		upgrade   *dbv1alpha1.UpgradeStatus
+		available bool
+		image     string
+		expect    func(mock sqlmock.Sqlmock)
+		health    hdbclient.HealthReason
+		changed   bool
+		phase     dbv1alpha1.UpgradePhase
+		backup    string
+		databases []string
+		updated   bool
+	}{
+		{
+			name:      "new image starts an upgrade",
+			available: true,
+			image:     testFromImage,
+			changed:   true,
+			phase:     dbv1alpha1.UpgradePhaseBackingUp,
+		},
+		{
+			name:      "the pre-upgrade backup is started once",
+			upgrade:   &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseBackingUp, StartTime: started},
+			available: true,
+			image:     testFromImage,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("BACKUP DATA FOR FULL SYSTEM USING FILE ('" + testPreUpgradeBackup + "') ASYNCHRONOUS").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			phase:  dbv1alpha1.UpgradePhaseBackingUp,
+			backup: testPreUpgradeBackup,
+		},
+		{
+			name:    "the backup waits for the instance",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseBackingUp, StartTime: metav1.Now()},
+			image:   testFromImage,
+			phase:   dbv1alpha1.UpgradePhaseBackingUp,
+		},
+		{
+			name:    "an instance that does not become available aborts the upgrade",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseBackingUp, StartTime: long},
+			image:   testFromImage,
+			changed: true,
+			phase:   dbv1alpha1.UpgradePhaseFailed,
+		},
+		{
+			name: "a running backup is followed in the catalog",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseBackingUp, StartTime: long,
+				Backup: testPreUpgradeBackup, BackupStartTime: &long},
+			available: true,
+			image:     testFromImage,
+			expect:    expectPreUpgradeBackup("successful", "running"),
+			phase:     dbv1alpha1.UpgradePhaseBackingUp,
+			backup:    testPreUpgradeBackup,
+		},
+		{
+			name: "a successful backup rolls out the new image",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseBackingUp, StartTime: started,
+				Backup: testPreUpgradeBackup, BackupStartTime: &recent},
+			available: true,
+			image:     testFromImage,
+			expect:    expectPreUpgradeBackup("successful", "successful"),
+			changed:   true,
+			phase:     dbv1alpha1.UpgradePhaseUpdatingImage,
+			backup:    testPreUpgradeBackup,
+			databases: []string{"SYSTEMDB", "HXE"},
+		},
+		{
+			name: "a failed backup aborts the upgrade",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseBackingUp, StartTime: started,
+				Backup: testPreUpgradeBackup, BackupStartTime: &recent},
+			available: true,
+			image:     testFromImage,
+			expect:    expectPreUpgradeBackup("successful", "failed"),
+			changed:   true,
+			phase:     dbv1alpha1.UpgradePhaseFailed,
+			backup:    testPreUpgradeBackup,
+		},
+		{
+			name: "a just started backup may be missing from the catalog",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseBackingUp, StartTime: started,
+				Backup: testPreUpgradeBackup, BackupStartTime: &recent},
+			available: true,
+			image:     testFromImage,
+			expect:    expectPreUpgradeBackup(),
+			phase:     dbv1alpha1.UpgradePhaseBackingUp,
+			backup:    testPreUpgradeBackup,
+		},
+		{
+			name: "a lost backup is not started again",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseBackingUp, StartTime: started,
+				Backup: testPreUpgradeBackup, BackupStartTime: &long},
+			available: true,
+			image:     testFromImage,
+			expect:    expectPreUpgradeBackup(),
+			changed:   true,
+			phase:     dbv1alpha1.UpgradePhaseFailed,
+			backup:    testPreUpgradeBackup,
+		},
+		{
+			name:      "the rolled out image is recorded",
+			upgrade:   &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseUpdatingImage, StartTime: metav1.Now()},
+			available: true,
+			image:     testToImage,
+			changed:   true,
+			phase:     dbv1alpha1.UpgradePhaseUpdatingImage,
+			updated:   true,
+		},
+		{
+			name: "a healthy database completes the upgrade",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseUpdatingImage, StartTime: metav1.Now(),
+				ImageUpdated: true},
+			available: true,
+			image:     testToImage,
+			health:    hdbclient.HealthReasonHealthy,
+			changed:   true,
+			phase:     dbv1alpha1.UpgradePhaseSucceeded,
+			updated:   true,
+		},
+		{
+			name: "a ready pod whose database does not serve SQL does not complete the upgrade",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseUpdatingImage, StartTime: metav1.Now(),
+				ImageUpdated: true},
+			available: true,
+			image:     testToImage,
+			health:    hdbclient.HealthReasonStartingUp,
+			phase:     dbv1alpha1.UpgradePhaseUpdatingImage,
+			updated:   true,
+		},
+		{
+			name: "an image that does not become healthy is rolled back",
+			upgrade: &dbv1alpha1.UpgradeStatus{Phase: dbv1alpha1.UpgradePhaseUpdatingImage, StartTime: long,
+				Backup: testPreUpgradeBackup, ImageUpdated: true},
+			image:   testToImage,
+			health:  hdbclient.HealthReasonServiceDown,
+			changed: true,
+			phase:   dbv1alpha1.UpgradePhaseRollingBack,
+			backup:  testPreUpgradeBackup,
+			updated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, secret := testHanaExpress()
+			hanaExpress.Spec.Image = testToImage
+			if !tt.available {
+				meta.RemoveStatusCondition(&hanaExpress.Status.Conditions, typeAvailableHanaExpress)
+			}
+			if tt.upgrade != nil {
+				tt.upgrade.FromImage, tt.upgrade.ToImage = testFromImage, testToImage
+				hanaExpress.Status.Upgrade = tt.upgrade
+			}
+
+			c := newFakeClient(hanaExpress, secret)
+			connector := &fakeConnector{}
+			mock := connector.expectSQL(t)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: connector,
+				Health: fakeHealthChecker{hdbclient.Health{Reason: tt.health}}}
+
+			if err := c.Get(context.Background(), types.NamespacedName{Name: "hxe", Namespace: testNamespace}, hanaExpress); err != nil {
+				t.Fatal(err)
+			}
+			changed, err := r.reconcileUpgrade(context.Background(), hanaExpress, testStatefulSet(tt.image))
+			if err != nil {
+				t.Fatalf("reconcileUpgrade: %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+
+			if changed != tt.changed {
+				t.Errorf("changed = %t, want %t", changed, tt.changed)
+			}
+			upgrade := hanaExpress.Status.Upgrade
+			if upgrade == nil {
+				t.Fatal("no upgrade recorded")
+			}
+			if upgrade.Phase != tt.phase || upgrade.Backup != tt.backup || upgrade.ImageUpdated != tt.updated {
+				t.Errorf("phase = %s, backup = %q, image updated = %t, want %s, %q and %t", upgrade.Phase, upgrade.Backup,
+					upgrade.ImageUpdated, tt.phase, tt.backup, tt.updated)
+			}
+			if !equality.Semantic.DeepEqual(upgrade.BackupDatabases, tt.databases) {
+				t.Errorf("backup databases = %v, want %v", upgrade.BackupDatabases, tt.databases)
+			}
+		})
+	}
+}
+
+// testUpgradePod returns the instance pod running the image
+func testUpgradePod(image string, status corev1.ContainerStatus) *corev1.Pod {
+	status.Name = hanaExpressContainerName
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hxe-0", Namespace: testNamespace},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: hanaExpressContainerName, Image: image}}},
+		Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}},
+	}
+}
+
+// testRollback returns an instance whose upgrade to testToImage is rolled back
+func testRollback(started bool, rollbackStart time.Time) (*dbv1alpha1.HanaExpress, *corev1.Secret) {
+	hanaExpress, secret := testHanaExpress()
+	hanaExpress.Spec.Image = testToImage
+	rollbackTime := metav1.NewTime(rollbackStart)
+	hanaExpress.Status.Upgrade = &dbv1alpha1.UpgradeStatus{FromImage: testFromImage, ToImage: testToImage,
+		Phase: dbv1alpha1.UpgradePhaseRollingBack, StartTime: metav1.NewTime(rollbackStart.Add(-time.Hour)),
+		Backup: testPreUpgradeBackup, BackupDatabases: []string{"SYSTEMDB", "HXE"}, ImageUpdated: true,
+		ImageStarted: started, RollbackStartTime: &rollbackTime}
+	return hanaExpress, secret
+}
+
+func TestTimedOutUpgradeIsRolledBack(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	t.Setenv(supportedVersionsEnvVar, "2.00.072.00.20230728.1")
+
+	tests := []struct {
+		name    string
+		status  corev1.ContainerStatus
+		started bool
+	}{
+		{
+			name:   "an image that was never pulled is only reverted",
+			status: corev1.ContainerStatus{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}},
+		},
+		{
+			name: "an image HANA crashed on restores the backup",
+			status: corev1.ContainerStatus{RestartCount: 3,
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}},
+			started: true,
+		},
+		{
+			name:    "an image HANA runs on restores the backup",
+			status:  corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			started: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, secret := testHanaExpress()
+			hanaExpress.Spec.Image = testToImage
+			hanaExpress.Status.Upgrade = &dbv1alpha1.UpgradeStatus{FromImage: testFromImage, ToImage: testToImage,
+				Phase: dbv1alpha1.UpgradePhaseUpdatingImage, StartTime: metav1.NewTime(time.Now().Add(-time.Hour)),
+				Backup: testPreUpgradeBackup, ImageUpdated: true}
+			c := newFakeClient(hanaExpress, secret, testUpgradePod(testToImage, tt.status))
+			recorder := newTestRecorder()
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: recorder, SQL: &fakeConnector{},
+				Health: fakeHealthChecker{hdbclient.Health{Reason: hdbclient.HealthReasonUnreachable}}}
+
+			if _, err := r.reconcileUpgrade(context.Background(), hanaExpress, testStatefulSet(testToImage)); err != nil {
+				t.Fatal(err)
+			}
+
+			upgrade := hanaExpress.Status.Upgrade
+			if upgrade.Phase != dbv1alpha1.UpgradePhaseRollingBack || upgrade.RollbackStartTime == nil ||
+				upgrade.ImageStarted != tt.started {
+				t.Errorf("phase = %s, rollback start = %v, image started = %t, want %s with a start time and %t",
+					upgrade.Phase, upgrade.RollbackStartTime, upgrade.ImageStarted, dbv1alpha1.UpgradePhaseRollingBack, tt.started)
+			}
+			if status, reason := conditionStatus(hanaExpress, typeUpgradingHanaExpress); status != metav1.ConditionTrue ||
+				reason != "RollingBack" {
+				t.Errorf("Upgrading condition = %s/%s, want True/RollingBack", status, reason)
+			}
+			if image := templateImageForHanaExpress(hanaExpress, testToImage); image != testFromImage {
+				t.Errorf("template image = %s, want the previous image %s", image, testFromImage)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.HasPrefix(event, "Warning UpgradeFailed") {
+					t.Errorf("event = %q, want an UpgradeFailed warning", event)
+				}
+			default:
+				t.Error("no event recorded")
+			}
+		})
+	}
+}
+
+func TestRollbackRevertsTheImage(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	t.Setenv(supportedVersionsEnvVar, "2.00.072.00.20230728.1")
+
+	hanaExpress, secret := testRollback(false, time.Now())
+	waiting := corev1.ContainerStatus{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}}
+	c := newFakeClient(hanaExpress, secret, testUpgradePod(testToImage, waiting))
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: &fakeConnector{},
+		Health: fakeHealthChecker{hdbclient.Health{Reason: hdbclient.HealthReasonHealthy}}}
+	ctx := context.Background()
+	podKey := types.NamespacedName{Name: "hxe-0", Namespace: testNamespace}
+
+	// The pod template is put back first
+	changed, err := r.reconcileUpgrade(ctx, hanaExpress, testStatefulSet(testToImage))
+	if err != nil || changed {
+		t.Fatalf("reconcileUpgrade = %t, %v, want no change while the new image is in the pod template", changed, err)
+	}
+	if err := c.Get(ctx, podKey, &corev1.Pod{}); err != nil {
+		t.Fatalf("the pod was deleted before the previous image was put back: %v", err)
+	}
+
+	// The pod that never became ready on the new image is replaced
+	changed, err = r.reconcileUpgrade(ctx, hanaExpress, testStatefulSet(testFromImage))
+	if err != nil || changed {
+		t.Fatalf("reconcileUpgrade = %t, %v, want the pod deleted without a status change", changed, err)
+	}
+	if err := c.Get(ctx, podKey, &corev1.Pod{}); !apierrors.IsNotFound(err) {
+		t.Fatalf("pod of the new image: err = %v, want NotFound", err)
+	}
+
+	running := corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+	if err := c.Create(ctx, testUpgradePod(testFromImage, running)); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = r.reconcileUpgrade(ctx, hanaExpress, testStatefulSet(testFromImage))
+	if err != nil || !changed {
+		t.Fatalf("reconcileUpgrade = %t, %v, want the rollback completed", changed, err)
+	}
+	if phase := hanaExpress.Status.Upgrade.Phase; phase != dbv1alpha1.UpgradePhaseRolledBack {
+		t.Errorf("phase = %s, want %s", phase, dbv1alpha1.UpgradePhaseRolledBack)
+	}
+	if status, reason := conditionStatus(hanaExpress, typeUpgradingHanaExpress); status != metav1.ConditionFalse ||
+		reason != "RolledBack" {
+		t.Errorf("Upgrading condition = %s/%s, want False/RolledBack", status, reason)
+	}
+	if restores := (&dbv1alpha1.HanaExpressRestoreList{}); c.List(ctx, restores) != nil || len(restores.Items) != 0 {
+		t.Errorf("restores = %v, want none for an image HANA never started on", restores.Items)
+	}
+
+	// A rolled back upgrade is not started again for the same image
+	if changed, err := r.reconcileUpgrade(ctx, hanaExpress, testStatefulSet(testFromImage)); err != nil || changed {
+		t.Errorf("reconcileUpgrade = %t, %v, want the rolled back upgrade kept", changed, err)
+	}
+	if image := templateImageForHanaExpress(hanaExpress, testToImage); image != testFromImage {
+		t.Errorf("template image = %s, want the previous image %s", image, testFromImage)
+	}
+}
+
+func TestRollbackOfAnImageThatDoesNotBecomeHealthyFails(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	t.Setenv(supportedVersionsEnvVar, "2.00.072.00.20230728.1")
+
+	hanaExpress, secret := testRollback(false, time.Now().Add(-time.Hour))
+	running := corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+	c := newFakeClient(hanaExpress, secret, testUpgradePod(testFromImage, running))
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: &fakeConnector{},
+		Health: fakeHealthChecker{hdbclient.Health{Reason: hdbclient.HealthReasonServiceDown}}}
+
+	changed, err := r.reconcileUpgrade(context.Background(), hanaExpress, testStatefulSet(testFromImage))
+	if err != nil || !changed {
+		t.Fatalf("reconcileUpgrade = %t, %v, want the rollback failed", changed, err)
+	}
+	if phase := hanaExpress.Status.Upgrade.Phase; phase != dbv1alpha1.UpgradePhaseFailed {
+		t.Errorf("phase = %s, want %s", phase, dbv1alpha1.UpgradePhaseFailed)
+	}
+	if status, reason := conditionStatus(hanaExpress, typeUpgradingHanaExpress); status != metav1.ConditionFalse ||
+		reason != "RollbackFailed" {
+		t.Errorf("Upgrading condition = %s/%s, want False/RollbackFailed", status, reason)
+	}
+	if image := templateImageForHanaExpress(hanaExpress, testToImage); image != testFromImage {
+		t.Errorf("template image = %s, want the previous image %s", image, testFromImage)
+	}
+}
+
+// setRollbackRestorePhase sets the phase of a restore the rollback created
+func setRollbackRestorePhase(t *testing.T, c client.Client, name string, phase dbv1alpha1.RestorePhase) {
+	t.Helper()
+	restore := &dbv1alpha1.HanaExpressRestore{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: name, Namespace: testNamespace}, restore); err != nil {
+		t.Fatal(err)
+	}
+	restore.Status.Phase = phase
+	restore.Status.Message = "recovery failed"
+	if err := c.Status().Update(context.Background(), restore); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollbackRestoresThePreUpgradeBackup(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	t.Setenv(supportedVersionsEnvVar, "2.00.072.00.20230728.1")
+
+	rollbackStart := time.Date(2023, 10, 1, 13, 0, 0, 0, time.UTC)
+	systemRestore, tenantRestore := "hxe-rollback-20231001130000-0", "hxe-rollback-20231001130000-1"
+
+	tests := []struct {
+		name   string
+		tenant dbv1alpha1.RestorePhase
+		phase  dbv1alpha1.UpgradePhase
+		reason string
+	}{
+		{
+			name:   "restored databases complete the rollback",
+			tenant: dbv1alpha1.RestorePhaseCompleted,
+			phase:  dbv1alpha1.UpgradePhaseRolledBack,
+			reason: "RolledBack",
+		},
+		{
+			name:   "a failed restore fails the rollback",
+			tenant: dbv1alpha1.RestorePhaseFailed,
+			phase:  dbv1alpha1.UpgradePhaseFailed,
+			reason: "RollbackFailed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, secret := testRollback(true, rollbackStart)
+			c := newFakeClient(hanaExpress, secret)
+			r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: &fakeConnector{}}
+			ctx := context.Background()
+			reconcile := func(image string, wantChanged bool) {
+				t.Helper()
+				changed, err := r.reconcileUpgrade(ctx, hanaExpress, testStatefulSet(image))
+				if err != nil || changed != wantChanged {
+					t.Fatalf("reconcileUpgrade = %t, %v, want %t", changed, err, wantChanged)
+				}
+			}
+
+			// The SystemDB is recovered with the image of the StatefulSet, so it is put back first
+			reconcile(testToImage, false)
+			if restores := (&dbv1alpha1.HanaExpressRestoreList{}); c.List(ctx, restores) != nil || len(restores.Items) != 0 {
+				t.Fatalf("restores = %v, want none before the previous image is put back", restores.Items)
+			}
+
+			reconcile(testFromImage, true)
+			restore := &dbv1alpha1.HanaExpressRestore{}
+			if err := c.Get(ctx, types.NamespacedName{Name: systemRestore, Namespace: testNamespace}, restore); err != nil {
+				t.Fatal(err)
+			}
+			if spec := restore.Spec; spec.HanaExpressName != "hxe" || spec.DatabaseName != "SYSTEMDB" ||
+				spec.Source.Path != testPreUpgradeBackup {
+				t.Errorf("restore spec = %+v, want SYSTEMDB of hxe from %s", spec, testPreUpgradeBackup)
+			}
+			if owner := metav1.GetControllerOf(restore); owner == nil || owner.UID != hanaExpress.UID {
+				t.Errorf("restore controller = %v, want the HanaExpress", owner)
+			}
+
+			// The tenant is restored once the SystemDB is
+			reconcile(testFromImage, false)
+			setRollbackRestorePhase(t, c, systemRestore, dbv1alpha1.RestorePhaseCompleted)
+			reconcile(testFromImage, true)
+			restore = &dbv1alpha1.HanaExpressRestore{}
+			if err := c.Get(ctx, types.NamespacedName{Name: tenantRestore, Namespace: testNamespace}, restore); err != nil {
+				t.Fatal(err)
+			}
+			if restore.Spec.DatabaseName != "HXE" {
+				t.Errorf("database = %s, want HXE", restore.Spec.DatabaseName)
+			}
+			if restores := hanaExpress.Status.Upgrade.Restores; !equality.Semantic.DeepEqual(restores,
+				[]string{systemRestore, tenantRestore}) {
+				t.Errorf("restores = %v, want %s and %s", restores, systemRestore, tenantRestore)
+			}
+
+			setRollbackRestorePhase(t, c, tenantRestore, tt.tenant)
+			reconcile(testFromImage, true)
+			if phase := hanaExpress.Status.Upgrade.Phase; phase != tt.phase {
+				t.Errorf("phase = %s, want %s", phase, tt.phase)
+			}
+			if status, reason := conditionStatus(hanaExpress, typeUpgradingHanaExpress); status != metav1.ConditionFalse ||
+				reason != tt.reason {
+				t.Errorf("Upgrading condition = %s/%s, want False/%s", status, reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestPreUpgradeBackupPrefixIsPersistedBeforeTheStart(t *testing.T) {
+	t.Setenv(dbv1alpha1.DefaultImageEnvVar, testFromImage)
+	t.Setenv(supportedVersionsEnvVar, "2.00.072.00.20230728.1")
+
+	hanaExpress, secret := testHanaExpress()
+	hanaExpress.Spec.Image = testToImage
+	hanaExpress.Status.Upgrade = &dbv1alpha1.UpgradeStatus{FromImage: testFromImage, ToImage: testToImage,
+		Phase: dbv1alpha1.UpgradePhaseBackingUp, StartTime: metav1.NewTime(time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC))}
+	c := newFakeClient(hanaExpress, secret)
+	connector := &fakeConnector{}
+	mock := connector.expectSQL(t)
+	mock.ExpectExec("BACKUP DATA FOR FULL SYSTEM USING FILE ('" + testPreUpgradeBackup + "') ASYNCHRONOUS").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	r := &HanaExpressReconciler{Client: c, Scheme: c.Scheme(), Recorder: newTestRecorder(), SQL: connector}
+
+	key := types.NamespacedName{Name: "hxe", Namespace: testNamespace}
+	if err := c.Get(context.Background(), key, hanaExpress); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reconcileUpgrade(context.Background(), hanaExpress, testStatefulSet(testFromImage)); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &dbv1alpha1.HanaExpress{}
+	if err := c.Get(context.Background(), key, stored); err != nil {
+		t.Fatal(err)
+	}
+	if upgrade := stored.Status.Upgrade; upgrade.Backup != testPreUpgradeBackup || upgrade.BackupStartTime == nil {
+		t.Errorf("stored backup = %q started at %v, want %q with a start time", upgrade.Backup, upgrade.BackupStartTime,
+			testPreUpgradeBackup)
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+// reconcileRecorder records the instances the HanaExpress reconciler is started for and reports
+// every instance as not found, so the wiring is tested without running a reconcile
+type reconcileRecorder struct {
+	client.Client
+	mu         sync.Mutex
+	reconciled map[string]bool
+}
+
+func (c *reconcileRecorder) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if _, ok := obj.(*dbv1alpha1.HanaExpress); ok {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.reconciled[key.Name] = true
+		return apierrors.NewNotFound(dbv1alpha1.GroupVersion.WithResource("hanaexpresses").GroupResource(), key.Name)
+	}
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *reconcileRecorder) hasReconciled(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconciled[name]
+}
+
+func (c *reconcileRecorder) forget(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.reconciled, name)
+}
+
+// ownedBy returns the metadata of an object the instance is the controller of
+func ownedBy(name, owner string) metav1.ObjectMeta {
+	controller := true
+	return metav1.ObjectMeta{Name: name, Namespace: testNamespace, OwnerReferences: []metav1.OwnerReference{{
+		APIVersion: dbv1alpha1.GroupVersion.String(),
+		Kind:       "HanaExpress",
+		Name:       owner,
+		UID:        types.UID(owner + "-uid"),
+		Controller: &controller,
+	}}}
+}
+
+// labelledFor returns the metadata of an object carrying the instance labels of the instance
+func labelledFor(name, instance string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: testNamespace, Labels: map[string]string{
+		"app.kubernetes.io/name": "HanaExpress", "app.kubernetes.io/instance": instance}}
+}
+
+var _ = Describe("HanaExpress controller wiring", Ordered, func() {
+	var (
+		ctx      context.Context
+		recorder *reconcileRecorder
+		r        *HanaExpressReconciler
+	)
+
+	BeforeAll(func() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+
+		// The cache and client options of main.go
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:             scheme.Scheme,
+			MetricsBindAddress: "0",
+			Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+				&corev1.Pod{}: {Label: labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "HanaExpress"})},
+			}},
+			Client: client.Options{Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}}}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		recorder = &reconcileRecorder{Client: mgr.GetClient(), reconciled: map[string]bool{}}
+		r = &HanaExpressReconciler{Client: recorder, Scheme: mgr.GetScheme(), Recorder: mgr.GetEventRecorderFor("test")}
+		Expect(r.SetupWithManager(mgr)).To(Succeed())
+
+		go func() {
+			defer GinkgoRecover()
+			Expect(mgr.Start(ctx)).To(Succeed())
+		}()
+	})
+
+	DescribeTable("reconciles the instance on a change of",
+		func(object client.Object, instance string) {
+			Expect(k8sClient.Create(ctx, object)).To(Succeed())
+			DeferCleanup(func() { Expect(client.IgnoreNotFound(k8sClient.Delete(context.Background(), object))).To(Succeed()) })
+
+			Eventually(func() bool { return recorder.hasReconciled(instance) }).Should(BeTrue())
+		},
+		Entry("an owned StatefulSet", &appsv1.StatefulSet{
+			ObjectMeta: ownedBy("owned-statefulset", "statefulset-owner"),
+			Spec: appsv1.StatefulSetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "owned"}},
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "owned"}},
+					Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: hanaExpressContainerName, Image: testFromImage}}},
+				},
+			},
+		}, "statefulset-owner"),
+		Entry("an owned Service", &corev1.Service{
+			ObjectMeta: ownedBy("owned-service", "service-owner"),
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 39017}}},
+		}, "service-owner"),
+		Entry("an owned Secret", &corev1.Secret{
+			ObjectMeta: ownedBy("owned-secret", "secret-owner"),
+		}, "secret-owner"),
+		Entry("an owned HanaUser", &dbv1alpha1.HanaUser{
+			ObjectMeta: ownedBy("owned-user", "user-owner"),
+			Spec:       dbv1alpha1.HanaUserSpec{HanaExpressName: "user-owner", UserName: "BINDING"},
+		}, "user-owner"),
+		Entry("an owned HanaExpressBackup", &dbv1alpha1.HanaExpressBackup{
+			ObjectMeta: ownedBy("owned-backup", "backup-owner"),
+			Spec:       dbv1alpha1.HanaExpressBackupSpec{HanaExpressName: "backup-owner"},
+		}, "backup-owner"),
+		Entry("a labelled PVC", &corev1.PersistentVolumeClaim{
+			ObjectMeta: labelledFor("data-pvc-instance-0", "pvc-instance"),
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+				},
+			},
+		}, "pvc-instance"),
+		Entry("a labelled pod", &corev1.Pod{
+			ObjectMeta: labelledFor("pod-instance-0", "pod-instance"),
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: hanaExpressContainerName, Image: testFromImage}}},
+		}, "pod-instance"),
+		Entry("a tenant database", &dbv1alpha1.HanaTenantDatabase{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant", Namespace: testNamespace},
+			Spec: dbv1alpha1.HanaTenantDatabaseSpec{
+				HanaExpressName: "tenant-instance",
+				DatabaseName:    "TENANT",
+				Credential: dbv1alpha1.Credential{SecretKeyRef: corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "tenant-credential"}, Key: "password"}},
+			},
+		}, "tenant-instance"),
+		Entry("a restore", &dbv1alpha1.HanaExpressRestore{
+			ObjectMeta: metav1.ObjectMeta{Name: "restore", Namespace: testNamespace},
+			Spec: dbv1alpha1.HanaExpressRestoreSpec{HanaExpressName: "restore-instance", DatabaseName: "SYSTEMDB",
+				Source: dbv1alpha1.RestoreSource{Path: "pre-upgrade-20231001"}},
+		}, "restore-instance"),
+	)
+
+	It("maps a credential Secret to the instances referencing it through the field index", func() {
+		hanaExpress := &dbv1alpha1.HanaExpress{
+			ObjectMeta: metav1.ObjectMeta{Name: "indexed", Namespace: testNamespace},
+			Spec: dbv1alpha1.HanaExpressSpec{
+				PVCSize: "8Gi",
+				Credential: dbv1alpha1.Credential{SecretKeyRef: corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "indexed-credential"}, Key: "password"}},
+			},
+		}
+		Expect(k8sClient.Create(ctx, hanaExpress)).To(Succeed())
+		DeferCleanup(func() { Expect(k8sClient.Delete(context.Background(), hanaExpress)).To(Succeed()) })
+		Eventually(func() bool { return recorder.hasReconciled("indexed") }).Should(BeTrue())
+		recorder.forget("indexed")
+
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "indexed-credential", Namespace: testNamespace}}
+		Eventually(func() []reconcile.Request { return r.hanaExpressesForSecret(ctx, secret) }).Should(ConsistOf(
+			reconcile.Request{NamespacedName: types.NamespacedName{Name: "indexed", Namespace: testNamespace}}))
+
+		other := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "other-credential", Namespace: testNamespace}}
+		Expect(r.hanaExpressesForSecret(ctx, other)).To(BeEmpty())
+
+		// A change of the Secret is acted on without waiting for the next resync
+		Expect(recorder.hasReconciled("indexed")).To(BeFalse())
+		Expect(k8sClient.Create(ctx, &corev1.Secret{ObjectMeta: secret.ObjectMeta,
+			StringData: map[string]string{"password": "HXEHana1"}})).To(Succeed())
+		DeferCleanup(func() { Expect(k8sClient.Delete(context.Background(), secret)).To(Succeed()) })
+		Eventually(func() bool { return recorder.hasReconciled("indexed") }).Should(BeTrue())
+	})
+})
@@ -0,0 +1,527 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/podexec"
+)
+
+// Definitions to manage status conditions
+const (
+	// typeCompletedHanaExpressBackup represents the result of the backup
+	typeCompletedHanaExpressBackup = "Completed"
+)
+
+// HanaExpressBackupReconciler reconciles a HanaExpressBackup object
+type HanaExpressBackupReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	SQL      hdbclient.Connector
+	Exec     podexec.Executor
+
+	// uploads are the running uploads to object storage, by backup
+	uploads asyncTasks[[]dbv1alpha1.BackupObject]
+}
+
+const (
+	// backupPollInterval is how often a running backup or upload is checked
+	backupPollInterval = 15 * time.Second
+	// backupCatalogGracePeriod is how long a started backup may be missing from the catalog
+	// before it is considered lost
+	backupCatalogGracePeriod = 2 * time.Minute
+	// backupUploadTimeout bounds copying the files of a backup to object storage
+	backupUploadTimeout = 12 * time.Hour
+)
+
+// backupCatalogEntry is a data backup as recorded in the HANA backup catalog
+type backupCatalogEntry struct {
+	ID    int64
+	State string
+	Size  int64
+}
+
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressbackups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressbackups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressbackups/finalizers,verbs=update
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch
+//+kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshotclasses,verbs=get;list;watch
+
+// Reconcile starts the backup described by a HanaExpressBackup once, follows it in the
+// backup catalog, copies its files to object storage if requested, and records its result.
+// Neither the backup nor the upload block the reconcile. A deleted backup takes its objects,
+// its catalog entry and its files with it.
+func (r *HanaExpressBackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	backup := &dbv1alpha1.HanaExpressBackup{}
+	if err := r.Get(ctx, req.NamespacedName, backup); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("HanaExpressBackup resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get HanaExpressBackup")
+		return ctrl.Result{}, err
+	}
+
+	if backup.GetDeletionTimestamp() != nil {
+		if !controllerutil.ContainsFinalizer(backup, hanaExpressFinalizer) {
+			return ctrl.Result{}, nil
+		}
+
+		if result, err := r.finalizeBackup(ctx, backup); err != nil || !result.IsZero() {
+			return result, err
+		}
+
+		log.Info("Removing Finalizer for HanaExpressBackup")
+		controllerutil.RemoveFinalizer(backup, hanaExpressFinalizer)
+		if err := r.Update(ctx, backup); err != nil {
+			log.Error(err, "Failed to remove finalizer for HanaExpressBackup")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// The finalizer cleans up the files of a backup in any phase, so it is also added to
+	// finished backups
+	if !controllerutil.ContainsFinalizer(backup, hanaExpressFinalizer) {
+		log.Info("Adding Finalizer for HanaExpressBackup")
+		controllerutil.AddFinalizer(backup, hanaExpressFinalizer)
+		if err := r.Update(ctx, backup); err != nil {
+			log.Error(err, "Failed to update custom resource to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// A backup runs only once
+	if backup.Status.Phase == dbv1alpha1.BackupPhaseCompleted || backup.Status.Phase == dbv1alpha1.BackupPhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: backup.Spec.HanaExpressName, Namespace: backup.Namespace}, hanaExpress)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, r.failBackup(ctx, backup, "HanaExpressNotFound",
+				fmt.Sprintf("HanaExpress %s not found", backup.Spec.HanaExpressName))
+		}
+		log.Error(err, "Failed to get HanaExpress")
+		return ctrl.Result{}, err
+	}
+
+	if hanaExpress.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, r.failBackup(ctx, backup, "HanaExpressDeleting",
+			fmt.Sprintf("HanaExpress %s is being deleted", hanaExpress.Name))
+	}
+
+	if backup.Spec.Method == dbv1alpha1.BackupMethodSnapshot {
+		if backup.Spec.ObjectStorage != nil {
+			return ctrl.Result{}, r.failBackup(ctx, backup, "ObjectStorageNotSupported",
+				"Snapshot backups cannot be copied to object storage")
+		}
+	} else if hanaExpress.Spec.BackupPVCSize == "" {
+		return ctrl.Result{}, r.failBackup(ctx, backup, "BackupStorageNotConfigured",
+			fmt.Sprintf("HanaExpress %s has no backup PVC, set spec.backupPVCSize", hanaExpress.Name))
+	}
+
+	if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+		if backup.Status.Phase != dbv1alpha1.BackupPhasePending {
+			backup.Status.Phase = dbv1alpha1.BackupPhasePending
+			backup.Status.Message = fmt.Sprintf("Waiting for HanaExpress %s to become available", hanaExpress.Name)
+			if err := r.Status().Update(ctx, backup); err != nil {
+				log.Error(err, "Failed to update HanaExpressBackup status")
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	database := databaseNameForBackup(backup)
+
+	db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to connect to the SystemDB")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	defer db.Close()
+
+	if backup.Spec.Method == dbv1alpha1.BackupMethodSnapshot {
+		return r.reconcileSnapshotBackup(ctx, db, hanaExpress, backup)
+	}
+
+	switch backup.Status.Phase {
+	case dbv1alpha1.BackupPhaseUploading:
+		entry := &backupCatalogEntry{ID: backup.Status.BackupID, Size: backup.Status.Size}
+		return r.uploadBackup(ctx, db, hanaExpress, backup, entry)
+
+	case dbv1alpha1.BackupPhaseRunning:
+		// The backup runs asynchronously in HANA, the catalog records its progress and outcome
+		entry, err := findBackupInCatalog(ctx, db, database, backup.Status.Destination)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		switch {
+		case entry == nil:
+			if backup.Status.StartTime != nil && time.Since(backup.Status.StartTime.Time) < backupCatalogGracePeriod {
+				return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+			}
+			return ctrl.Result{}, r.failBackup(ctx, backup, "Interrupted",
+				"The backup was interrupted and is not recorded in the backup catalog")
+		case entry.State == "running":
+			if backup.Status.BackupID != entry.ID {
+				backup.Status.BackupID = entry.ID
+				backup.Status.Message = fmt.Sprintf("Backup %d of database %s is running", entry.ID, database)
+				if err := r.Status().Update(ctx, backup); err != nil {
+					log.Error(err, "Failed to update HanaExpressBackup status")
+					return ctrl.Result{}, err
+				}
+			}
+			return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+		case entry.State != "successful":
+			return ctrl.Result{}, r.failBackup(ctx, backup, "BackupFailed",
+				fmt.Sprintf("Backup %d finished in state %s", entry.ID, entry.State))
+		}
+		return r.uploadBackup(ctx, db, hanaExpress, backup, entry)
+	}
+
+	// The phase is persisted before the backup is started, so that it is never started twice
+	now := metav1.Now()
+	backup.Status.Phase = dbv1alpha1.BackupPhaseRunning
+	backup.Status.StartTime = &now
+	backup.Status.Destination = fmt.Sprintf("%s/%s/%s", backupMountPath, backup.Name, strings.ToLower(database))
+	backup.Status.Message = fmt.Sprintf("Backing up database %s", database)
+	if err := r.Status().Update(ctx, backup); err != nil {
+		log.Error(err, "Failed to update HanaExpressBackup status")
+		return ctrl.Result{}, err
+	}
+
+	log.Info("Starting backup", "Database", database, "Destination", backup.Status.Destination)
+	stmt := fmt.Sprintf("BACKUP DATA FOR %s USING FILE (%s) ASYNCHRONOUS",
+		hdbclient.QuoteIdentifier(database), hdbclient.QuoteString(backup.Status.Destination))
+	if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+		// The statement may have been accepted before the connection failed, the catalog tells
+		if entry, catalogErr := findBackupInCatalog(ctx, db, database, backup.Status.Destination); catalogErr == nil && entry != nil {
+			log.Info("Backup started despite an error", "BackupID", entry.ID, "Error", err.Error())
+			return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+		}
+		return ctrl.Result{}, r.failBackup(ctx, backup, "BackupFailed", fmt.Sprintf("Backup failed: %s", err))
+	}
+	return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+}
+
+// uploadBackup copies the files of a successful backup to object storage, if configured,
+// before recording the backup as completed. The upload runs in the background and is
+// polled on requeue. When the operator restarted during an upload, the upload is started
+// again and overwrites the objects.
+func (r *HanaExpressBackupReconciler) uploadBackup(ctx context.Context, db *sql.DB, hanaExpress *dbv1alpha1.HanaExpress,
+	backup *dbv1alpha1.HanaExpressBackup, entry *backupCatalogEntry) (ctrl.Result, error) {
+	if backup.Spec.ObjectStorage == nil {
+		return ctrl.Result{}, r.completeBackup(ctx, backup, entry)
+	}
+
+	log := log.FromContext(ctx)
+	if backup.Status.Phase != dbv1alpha1.BackupPhaseUploading {
+		backup.Status.Phase = dbv1alpha1.BackupPhaseUploading
+		backup.Status.BackupID = entry.ID
+		backup.Status.Size = entry.Size
+		backup.Status.Message = fmt.Sprintf("Uploading backup %d to bucket %s", entry.ID, backup.Spec.ObjectStorage.Bucket)
+		if err := r.Status().Update(ctx, backup); err != nil {
+			log.Error(err, "Failed to update HanaExpressBackup status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	started, done, objects, err := r.uploads.Result(backup.UID)
+	switch {
+	case !started:
+		files, err := backupFilesInCatalog(ctx, db, databaseNameForBackup(backup), entry.ID)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("Uploading backup", "BackupID", entry.ID, "Bucket", backup.Spec.ObjectStorage.Bucket, "Files", len(files))
+		instance, uploaded := hanaExpress.DeepCopy(), backup.DeepCopy()
+		r.uploads.Start(ctx, backup.UID, backupUploadTimeout, func(ctx context.Context) ([]dbv1alpha1.BackupObject, error) {
+			return uploadBackupFiles(ctx, r.Client, r.Exec, instance, uploaded, files)
+		})
+		return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+	case !done:
+		return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+	case err != nil:
+		return ctrl.Result{}, r.failBackup(ctx, backup, "UploadFailed", fmt.Sprintf("Backup %d could not be uploaded: %s", entry.ID, err))
+	}
+
+	backup.Status.Objects = objects
+	return ctrl.Result{}, r.completeBackup(ctx, backup, entry)
+}
+
+// finalizeBackup removes what a deleted backup left behind: the objects it uploaded, its entry
+// in the backup catalog and its files on the backup PVC, also those of a failed backup. A
+// running backup is canceled and a running upload is waited for first, so nothing is written
+// after the cleanup. The catalog entry and the files are left to the instance when it is gone.
+func (r *HanaExpressBackupReconciler) finalizeBackup(ctx context.Context, backup *dbv1alpha1.HanaExpressBackup) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	started, done, objects, err := r.uploads.Result(backup.UID)
+	if started && !done {
+		log.Info("Waiting for the upload to finish before deleting the backup")
+		return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+	}
+	if started && err == nil {
+		// The objects are recorded, so they are removed even when the removal is retried
+		backup.Status.Objects = objects
+		if err := r.Status().Update(ctx, backup); err != nil {
+			log.Error(err, "Failed to update HanaExpressBackup status")
+			return ctrl.Result{}, err
+		}
+	}
+	if err := removeBackupObjects(ctx, r.Client, backup); err != nil {
+		log.Error(err, "Failed to remove the objects of the backup")
+		return ctrl.Result{}, err
+	}
+
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	err = r.Get(ctx, types.NamespacedName{Name: backup.Spec.HanaExpressName, Namespace: backup.Namespace}, hanaExpress)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get HanaExpress")
+		return ctrl.Result{}, err
+	}
+	if apierrors.IsNotFound(err) || hanaExpress.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, nil
+	}
+	if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+		log.Info("Waiting for HanaExpress to become available to delete the backup files")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
+	db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to connect to the SystemDB")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	defer db.Close()
+
+	finished, err := deleteBackupFromCatalog(ctx, db, backup)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !finished {
+		return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+	}
+	if err := removeBackupFiles(ctx, r.Exec, hanaExpress, backup); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.Recorder.Event(backup, "Normal", "BackupDeleted", fmt.Sprintf("Deleted backup %s and its files", backup.Name))
+	return ctrl.Result{}, nil
+}
+
+// deleteBackupFromCatalog removes the backup from the HANA backup catalog, together with the
+// files of a successful file backup. A backup that is not recorded, e.g. because it was never
+// started, is looked up by its destination. It returns false while a running backup or a
+// prepared data snapshot is being stopped.
+func deleteBackupFromCatalog(ctx context.Context, db *sql.DB, backup *dbv1alpha1.HanaExpressBackup) (bool, error) {
+	database := databaseNameForBackup(backup)
+	id := backup.Status.BackupID
+	if id == 0 && backup.Spec.Method != dbv1alpha1.BackupMethodSnapshot && backup.Status.Destination != "" {
+		entry, err := findBackupInCatalog(ctx, db, database, backup.Status.Destination)
+		if err != nil {
+			return false, err
+		}
+		if entry != nil {
+			id = entry.ID
+		}
+	}
+	if id == 0 {
+		return true, nil
+	}
+
+	var state string
+	err := db.QueryRowContext(ctx, `SELECT STATE_NAME FROM SYS_DATABASES.M_BACKUP_CATALOG
+		WHERE DATABASE_NAME = ? AND BACKUP_ID = ?`, database, id).Scan(&state)
+	if err == sql.ErrNoRows {
+		// Already removed from the catalog
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query the backup catalog: %w", err)
+	}
+
+	switch state {
+	case "running":
+		stmt := fmt.Sprintf("BACKUP CANCEL FOR %s %d", hdbclient.QuoteIdentifier(database), id)
+		if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+			return false, fmt.Errorf("failed to cancel backup %d: %w", id, err)
+		}
+		return false, nil
+	case "prepared":
+		stmt := fmt.Sprintf("BACKUP DATA FOR FULL SYSTEM CLOSE SNAPSHOT BACKUP_ID %d UNSUCCESSFUL %s",
+			id, hdbclient.QuoteString("The HanaExpressBackup was deleted"))
+		if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+			return false, fmt.Errorf("failed to close the HANA data snapshot %d: %w", id, err)
+		}
+		return false, nil
+	}
+
+	stmt := fmt.Sprintf("BACKUP CATALOG DELETE FOR %s BACKUP_ID %d", hdbclient.QuoteIdentifier(database), id)
+	if state == "successful" && backup.Spec.Method != dbv1alpha1.BackupMethodSnapshot {
+		stmt += " COMPLETE"
+	}
+	if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+		return false, fmt.Errorf("failed to delete backup %d: %w", id, err)
+	}
+	return true, nil
+}
+
+// removeBackupFiles removes the directory a file backup wrote to on the backup PVC, with the
+// partial files of a backup that failed or never made it into the catalog
+func removeBackupFiles(ctx context.Context, executor podexec.Executor, hanaExpress *dbv1alpha1.HanaExpress,
+	backup *dbv1alpha1.HanaExpressBackup) error {
+	if backup.Spec.Method == dbv1alpha1.BackupMethodSnapshot || backup.Status.Destination == "" {
+		return nil
+	}
+
+	dir := path.Dir(backup.Status.Destination)
+	if !strings.HasPrefix(dir, backupMountPath+"/") {
+		return fmt.Errorf("backup destination %s is not on the backup PVC", backup.Status.Destination)
+	}
+	err := executor.Exec(ctx, backup.Namespace, podNameForHanaExpress(hanaExpress), hanaExpressContainerName,
+		[]string{"rm", "-rf", "--", dir}, io.Discard)
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %w", dir, err)
+	}
+	return nil
+}
+
+// databaseNameForBackup returns the database name in the upper case HANA uses in its catalog.
+// Snapshot backups cover the full system and are recorded in the SystemDB catalog.
+func databaseNameForBackup(backup *dbv1alpha1.HanaExpressBackup) string {
+	if backup.Spec.Method == dbv1alpha1.BackupMethodSnapshot {
+		return "SYSTEMDB"
+	}
+	if backup.Spec.DatabaseName == "" {
+		return "HXE"
+	}
+	return strings.ToUpper(backup.Spec.DatabaseName)
+}
+
+// findBackupInCatalog looks up the latest complete data backup of the database that
+// wrote files to the given destination prefix.
+func findBackupInCatalog(ctx context.Context, db *sql.DB, database, destination string) (*backupCatalogEntry, error) {
+	entry := &backupCatalogEntry{}
+	err := db.QueryRowContext(ctx, `SELECT TOP 1 C.BACKUP_ID, C.STATE_NAME
+		FROM SYS_DATABASES.M_BACKUP_CATALOG C
+		WHERE C.DATABASE_NAME = ? AND C.ENTRY_TYPE_NAME = 'complete data backup'
+		AND EXISTS (SELECT 1 FROM SYS_DATABASES.M_BACKUP_CATALOG_FILES F
+			WHERE F.DATABASE_NAME = C.DATABASE_NAME AND F.BACKUP_ID = C.BACKUP_ID AND F.DESTINATION_PATH LIKE ?)
+		ORDER BY C.SYS_START_TIME DESC`, database, destination+"%").Scan(&entry.ID, &entry.State)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the backup catalog: %w", err)
+	}
+
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(SUM(BACKUP_SIZE), 0)
+		FROM SYS_DATABASES.M_BACKUP_CATALOG_FILES
+		WHERE DATABASE_NAME = ? AND BACKUP_ID = ?`, database, entry.ID).Scan(&entry.Size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the backup size: %w", err)
+	}
+	return entry, nil
+}
+
+// completeBackup records a successful backup
+func (r *HanaExpressBackupReconciler) completeBackup(ctx context.Context, backup *dbv1alpha1.HanaExpressBackup,
+	entry *backupCatalogEntry) error {
+	now := metav1.Now()
+	backup.Status.Phase = dbv1alpha1.BackupPhaseCompleted
+	backup.Status.BackupID = entry.ID
+	backup.Status.Size = entry.Size
+	backup.Status.CompletionTime = &now
+	if backup.Status.StartTime != nil {
+		backup.Status.Duration = &metav1.Duration{Duration: now.Sub(backup.Status.StartTime.Time).Round(time.Second)}
+	}
+	backup.Status.Message = fmt.Sprintf("Backup %d completed", entry.ID)
+	meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{Type: typeCompletedHanaExpressBackup,
+		Status: metav1.ConditionTrue, Reason: "BackupSucceeded", Message: backup.Status.Message})
+
+	if err := r.Status().Update(ctx, backup); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update HanaExpressBackup status")
+		return err
+	}
+
+	r.Recorder.Event(backup, "Normal", "BackupCompleted",
+		fmt.Sprintf("Backup %d of %s completed with %d bytes", entry.ID, backup.Spec.HanaExpressName, entry.Size))
+	return nil
+}
+
+// failBackup records a failed backup with the given reason
+func (r *HanaExpressBackupReconciler) failBackup(ctx context.Context, backup *dbv1alpha1.HanaExpressBackup,
+	reason, message string) error {
+	now := metav1.Now()
+	backup.Status.Phase = dbv1alpha1.BackupPhaseFailed
+	backup.Status.CompletionTime = &now
+	if backup.Status.StartTime != nil {
+		backup.Status.Duration = &metav1.Duration{Duration: now.Sub(backup.Status.StartTime.Time).Round(time.Second)}
+	}
+	backup.Status.Message = message
+	meta.SetStatusCondition(&backup.Status.Conditions, metav1.Condition{Type: typeCompletedHanaExpressBackup,
+		Status: metav1.ConditionFalse, Reason: reason, Message: message})
+
+	if err := r.Status().Update(ctx, backup); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update HanaExpressBackup status")
+		return err
+	}
+
+	r.Recorder.Event(backup, "Warning", "BackupFailed", message)
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HanaExpressBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1alpha1.HanaExpressBackup{}).
+		Complete(withReconcileTimeout(r))
+}
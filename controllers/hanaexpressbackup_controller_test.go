@@ -0,0 +1,273 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func TestBackupIsStartedOnceAndFollowedInTheCatalog(t *testing.T) {
+	r, connector := newTestBackupReconciler(nil, testBackup())
+
+	mock := connector.expectSQL(t)
+	mock.ExpectExec(`BACKUP DATA FOR "HXE" USING FILE ('/hana/backup/nightly/hxe') ASYNCHRONOUS`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	result, backup := reconcileBackup(t, r, mock)
+	if backup.Status.Phase != dbv1alpha1.BackupPhaseRunning || backup.Status.Destination != testBackupDestination {
+		t.Fatalf("phase = %s, destination = %s after the start", backup.Status.Phase, backup.Status.Destination)
+	}
+	if result.RequeueAfter != backupPollInterval {
+		t.Errorf("requeue after %s, want %s", result.RequeueAfter, backupPollInterval)
+	}
+
+	// While HANA writes the backup, only the catalog is read
+	mock = connector.expectSQL(t)
+	expectBackupInCatalog(mock, 42, "running", 0)
+	result, backup = reconcileBackup(t, r, mock)
+	if backup.Status.Phase != dbv1alpha1.BackupPhaseRunning || backup.Status.BackupID != 42 {
+		t.Fatalf("phase = %s, backup ID = %d while running", backup.Status.Phase, backup.Status.BackupID)
+	}
+	if result.RequeueAfter != backupPollInterval {
+		t.Errorf("requeue after %s, want %s", result.RequeueAfter, backupPollInterval)
+	}
+
+	mock = connector.expectSQL(t)
+	expectBackupInCatalog(mock, 42, "successful", 4096)
+	_, backup = reconcileBackup(t, r, mock)
+	if backup.Status.Phase != dbv1alpha1.BackupPhaseCompleted || backup.Status.Size != 4096 {
+		t.Fatalf("phase = %s, size = %d after the backup finished", backup.Status.Phase, backup.Status.Size)
+	}
+}
+
+func TestBackupStartErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+		phase  dbv1alpha1.BackupPhase
+	}{
+		{
+			name: "connection lost after the backup was accepted",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectBackupInCatalog(mock, 42, "running", 0)
+			},
+			phase: dbv1alpha1.BackupPhaseRunning,
+		},
+		{
+			name:   "backup rejected",
+			expect: expectBackupNotInCatalog,
+			phase:  dbv1alpha1.BackupPhaseFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, connector := newTestBackupReconciler(nil, testBackup())
+
+			mock := connector.expectSQL(t)
+			mock.ExpectExec(`BACKUP DATA FOR "HXE" USING FILE ('/hana/backup/nightly/hxe') ASYNCHRONOUS`).
+				WillReturnError(errors.New("connection reset by peer"))
+			tt.expect(mock)
+			_, backup := reconcileBackup(t, r, mock)
+			if backup.Status.Phase != tt.phase {
+				t.Errorf("phase = %s, want %s", backup.Status.Phase, tt.phase)
+			}
+		})
+	}
+}
+
+func TestRunningBackupMissingFromTheCatalog(t *testing.T) {
+	tests := []struct {
+		name    string
+		started time.Duration
+		phase   dbv1alpha1.BackupPhase
+	}{
+		{name: "just started", started: 10 * time.Second, phase: dbv1alpha1.BackupPhaseRunning},
+		{name: "lost", started: backupCatalogGracePeriod + time.Minute, phase: dbv1alpha1.BackupPhaseFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup := testBackup()
+			start := metav1.NewTime(time.Now().Add(-tt.started))
+			backup.Status = dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseRunning,
+				Destination: testBackupDestination, StartTime: &start}
+			r, connector := newTestBackupReconciler(nil, backup)
+
+			// The backup is never started again
+			mock := connector.expectSQL(t)
+			expectBackupNotInCatalog(mock)
+			_, backup = reconcileBackup(t, r, mock)
+			if backup.Status.Phase != tt.phase {
+				t.Errorf("phase = %s, want %s", backup.Status.Phase, tt.phase)
+			}
+		})
+	}
+}
+
+func TestFailedBackupInTheCatalog(t *testing.T) {
+	backup := testBackup()
+	start := metav1.Now()
+	backup.Status = dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseRunning,
+		Destination: testBackupDestination, StartTime: &start}
+	r, connector := newTestBackupReconciler(nil, backup)
+
+	mock := connector.expectSQL(t)
+	expectBackupInCatalog(mock, 42, "failed", 0)
+	_, backup = reconcileBackup(t, r, mock)
+	if backup.Status.Phase != dbv1alpha1.BackupPhaseFailed {
+		t.Errorf("phase = %s, want %s", backup.Status.Phase, dbv1alpha1.BackupPhaseFailed)
+	}
+}
+
+func TestBackupFinalizerIsAddedToFinishedBackups(t *testing.T) {
+	backup := testBackup()
+	backup.Status.Phase = dbv1alpha1.BackupPhaseFailed
+	r, connector := newTestBackupReconciler(nil, backup)
+
+	_, backup = reconcileBackup(t, r, connector.expectSQL(t))
+	if !controllerutil.ContainsFinalizer(backup, hanaExpressFinalizer) {
+		t.Errorf("finalizers = %v, want %s", backup.Finalizers, hanaExpressFinalizer)
+	}
+}
+
+func TestDeletedBackupIsRemovedWithItsFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    dbv1alpha1.HanaExpressBackupStatus
+		instance  func(hanaExpress *dbv1alpha1.HanaExpress)
+		expect    func(mock sqlmock.Sqlmock)
+		removed   []string
+		finalized bool
+	}{
+		{
+			name: "a completed backup is deleted from the catalog with its files",
+			status: dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseCompleted, BackupID: 42,
+				Destination: testBackupDestination},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(backupStateQuery).WithArgs("HXE", 42).
+					WillReturnRows(sqlmock.NewRows([]string{"STATE_NAME"}).AddRow("successful"))
+				mock.ExpectExec(`BACKUP CATALOG DELETE FOR "HXE" BACKUP_ID 42 COMPLETE`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			removed:   []string{"/hana/backup/nightly"},
+			finalized: true,
+		},
+		{
+			name:   "a failed backup is looked up by its destination and its partial files are removed",
+			status: dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseFailed, Destination: testBackupDestination},
+			expect: func(mock sqlmock.Sqlmock) {
+				expectBackupInCatalog(mock, 42, "failed", 0)
+				mock.ExpectQuery(backupStateQuery).WithArgs("HXE", 42).
+					WillReturnRows(sqlmock.NewRows([]string{"STATE_NAME"}).AddRow("failed"))
+				mock.ExpectExec(`BACKUP CATALOG DELETE FOR "HXE" BACKUP_ID 42`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			removed:   []string{"/hana/backup/nightly"},
+			finalized: true,
+		},
+		{
+			name:      "a backup missing from the catalog only has its files removed",
+			status:    dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseFailed, Destination: testBackupDestination},
+			expect:    expectBackupNotInCatalog,
+			removed:   []string{"/hana/backup/nightly"},
+			finalized: true,
+		},
+		{
+			name: "a running backup is canceled first",
+			status: dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseRunning, BackupID: 42,
+				Destination: testBackupDestination},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(backupStateQuery).WithArgs("HXE", 42).
+					WillReturnRows(sqlmock.NewRows([]string{"STATE_NAME"}).AddRow("running"))
+				mock.ExpectExec(`BACKUP CANCEL FOR "HXE" 42`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
+		{
+			name: "the files wait for the instance to become available",
+			status: dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseCompleted, BackupID: 42,
+				Destination: testBackupDestination},
+			instance: func(hanaExpress *dbv1alpha1.HanaExpress) {
+				meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
+					Status: metav1.ConditionFalse, Reason: "Unreachable"})
+			},
+		},
+		{
+			name: "the files of a deleted instance are left to it",
+			status: dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseCompleted, BackupID: 42,
+				Destination: testBackupDestination},
+			instance:  func(hanaExpress *dbv1alpha1.HanaExpress) { hanaExpress.Name = "deleted" },
+			finalized: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup := testBackup()
+			backup.Finalizers = []string{hanaExpressFinalizer}
+			backup.Status = tt.status
+			hanaExpress, _ := testHanaExpress()
+			if tt.instance != nil {
+				tt.instance(hanaExpress)
+			}
+			r, connector := newTestBackupReconciler(hanaExpress, backup)
+			deleteBackup(t, r.Client)
+
+			mock := connector.expectSQL(t)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+			result, backup := reconcileBackup(t, r, mock)
+			if finalized := backup == nil; finalized != tt.finalized {
+				t.Errorf("finalized = %t, want %t", finalized, tt.finalized)
+			}
+			if !tt.finalized && result.IsZero() {
+				t.Error("the finalization is not retried")
+			}
+			if executor := r.Exec.(*fakeExecutor); fmt.Sprint(executor.removed) != fmt.Sprint(tt.removed) {
+				t.Errorf("removed = %v, want %v", executor.removed, tt.removed)
+			}
+		})
+	}
+}
+
+func TestDeletedBackupRemovesItsObjects(t *testing.T) {
+	s3, endpoint := newFakeS3(t)
+	s3.objects[testBackupKey] = []byte("hana backup")
+	backup, credentials := testObjectStorageBackup(endpoint)
+	backup.Finalizers = []string{hanaExpressFinalizer}
+	backup.Status = dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseFailed,
+		Objects: []dbv1alpha1.BackupObject{{Key: testBackupKey}}}
+	r, connector := newTestBackupReconciler(nil, credentials, backup)
+	deleteBackup(t, r.Client)
+
+	// The backup has no files and no catalog entry, only the objects are removed
+	_, backup = reconcileBackup(t, r, connector.expectSQL(t))
+	if _, ok := s3.object(testBackupKey); ok {
+		t.Error("the object of the deleted backup was kept in the bucket")
+	}
+	if backup != nil {
+		t.Errorf("backup = %v, want it to be finalized", backup.Finalizers)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/podexec"
+)
+
+const (
+	// Keys of the object storage credentials Secret, named like the AWS environment variables
+	objectStorageAccessKeyIDKey     = "AWS_ACCESS_KEY_ID"
+	objectStorageSecretAccessKeyKey = "AWS_SECRET_ACCESS_KEY"
+
+	// hanaExpressContainerName is the container of the HanaExpress pod that runs HANA
+	hanaExpressContainerName = "hana-express"
+)
+
+// objectStorageClient returns an S3 client for the bucket, authenticated with the credentials Secret
+func objectStorageClient(ctx context.Context, c client.Client, namespace string,
+	storage *dbv1alpha1.ObjectStorage) (*minio.Client, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, types.NamespacedName{Name: storage.CredentialsSecretRef.Name, Namespace: namespace}, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object storage credentials: %w", err)
+	}
+
+	accessKeyID := string(secret.Data[objectStorageAccessKeyIDKey])
+	secretAccessKey := string(secret.Data[objectStorageSecretAccessKeyKey])
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("secret %s must contain %s and %s", secret.Name,
+			objectStorageAccessKeyIDKey, objectStorageSecretAccessKeyKey)
+	}
+
+	return minio.New(storage.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKeyID, secretAccessKey, ""),
+		Secure: !storage.Insecure,
+		Region: storage.Region,
+	})
+}
+
+// objectKeyForBackupFile returns the object key of a backup file. Keys include the namespace
+// and instance so several instances can share a bucket.
+func objectKeyForBackupFile(storage *dbv1alpha1.ObjectStorage, backup *dbv1alpha1.HanaExpressBackup, file string) string {
+	return path.Join(storage.Prefix, backup.Namespace, backup.Spec.HanaExpressName, backup.Name, path.Base(file))
+}
+
+// backupFilesInCatalog returns the paths of the files written by a backup
+func backupFilesInCatalog(ctx context.Context, db *sql.DB, database string, backupID int64) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT DESTINATION_PATH FROM SYS_DATABASES.M_BACKUP_CATALOG_FILES
+		WHERE DATABASE_NAME = ? AND BACKUP_ID = ? AND DESTINATION_TYPE_NAME = 'file'`, database, backupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the backup files: %w", err)
+	}
+	defer rows.Close()
+
+	var files []string
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// uploadBackupFiles copies the files of a completed backup from the HanaExpress pod to object
+// storage. Every file is verified against a checksum computed inside the pod.
+func uploadBackupFiles(ctx context.Context, c client.Client, executor podexec.Executor,
+	hanaExpress *dbv1alpha1.HanaExpress, backup *dbv1alpha1.HanaExpressBackup, files []string) ([]dbv1alpha1.BackupObject, error) {
+	storage := backup.Spec.ObjectStorage
+	mc, err := objectStorageClient(ctx, c, backup.Namespace, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := mc.BucketExists(ctx, storage.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access bucket %s: %w", storage.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s does not exist", storage.Bucket)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("backup %d has no files in the backup catalog", backup.Status.BackupID)
+	}
+
+	pod := podNameForHanaExpress(hanaExpress)
+	objects := make([]dbv1alpha1.BackupObject, 0, len(files))
+	for _, file := range files {
+		object, err := uploadBackupFile(ctx, mc, executor, backup.Namespace, pod, storage.Bucket,
+			objectKeyForBackupFile(storage, backup, file), file)
+		if err != nil {
+			// A backup is only usable with all of its files, remove the ones already uploaded
+			for _, uploaded := range objects {
+				_ = mc.RemoveObject(ctx, storage.Bucket, uploaded.Key, minio.RemoveObjectOptions{})
+			}
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+// uploadBackupFile streams one file out of the pod into the bucket
+func uploadBackupFile(ctx context.Context, mc *minio.Client, executor podexec.Executor,
+	namespace, pod, bucket, key, file string) (dbv1alpha1.BackupObject, error) {
+	object := dbv1alpha1.BackupObject{Key: key}
+
+	var out strings.Builder
+	err := executor.Exec(ctx, namespace, pod, hanaExpressContainerName,
+		[]string{"sh", "-c", `stat -c %s "$1" && sha256sum "$1"`, "--", file}, &out)
+	if err != nil {
+		return object, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out.String()))
+	var fields []string
+	for scanner.Scan() {
+		fields = append(fields, strings.Fields(scanner.Text())...)
+	}
+	if len(fields) < 2 {
+		return object, fmt.Errorf("unexpected output reading %s: %q", file, out.String())
+	}
+	if object.Size, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return object, fmt.Errorf("unexpected size of %s: %w", file, err)
+	}
+	expected := fields[1]
+
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := executor.Exec(ctx, namespace, pod, hanaExpressContainerName, []string{"cat", "--", file}, writer)
+		writer.CloseWithError(err)
+		done <- err
+	}()
+
+	hash := sha256.New()
+	info, err := mc.PutObject(ctx, bucket, key, io.TeeReader(reader, hash), object.Size, minio.PutObjectOptions{
+		ContentType:    "application/octet-stream",
+		SendContentMd5: true,
+		UserMetadata:   map[string]string{"sha256": expected},
+	})
+	// Unblock the exec stream if the upload stopped early
+	reader.CloseWithError(io.ErrClosedPipe)
+	if execErr := <-done; execErr != nil && err == nil {
+		err = execErr
+	}
+	if err != nil {
+		// Do not leave a partial object behind that looks like a complete backup file
+		_ = mc.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{})
+		return object, fmt.Errorf("failed to upload %s to %s: %w", file, key, err)
+	}
+
+	object.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if object.SHA256 != expected || info.Size != object.Size {
+		_ = mc.RemoveObject(ctx, bucket, key, minio.RemoveObjectOptions{})
+		return object, fmt.Errorf("checksum mismatch uploading %s: expected %s with %d bytes, uploaded %s with %d bytes",
+			file, expected, object.Size, object.SHA256, info.Size)
+	}
+	return object, nil
+}
+
+// removeBackupObjects deletes the objects a backup copied to object storage
+func removeBackupObjects(ctx context.Context, c client.Client, backup *dbv1alpha1.HanaExpressBackup) error {
+	if backup.Spec.ObjectStorage == nil || len(backup.Status.Objects) == 0 {
+		return nil
+	}
+
+	storage := backup.Spec.ObjectStorage
+	mc, err := objectStorageClient(ctx, c, backup.Namespace, storage)
+	if err != nil {
+		return err
+	}
+	for _, object := range backup.Status.Objects {
+		if err := mc.RemoveObject(ctx, storage.Bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to remove %s from bucket %s: %w", object.Key, storage.Bucket, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func TestUploadBackupFiles(t *testing.T) {
+	s3, endpoint := newFakeS3(t)
+	hanaExpress, _ := testHanaExpress()
+	backup, secret := testObjectStorageBackup(endpoint)
+	c := newFakeClient(secret)
+	content := strings.Repeat("hana backup page ", 1000)
+	executor := &fakeExecutor{files: map[string]string{testBackupFile: content}}
+
+	objects, err := uploadBackupFiles(context.Background(), c, executor, hanaExpress, backup, []string{testBackupFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	want := dbv1alpha1.BackupObject{Key: testBackupKey, Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])}
+	if len(objects) != 1 || objects[0] != want {
+		t.Errorf("objects = %+v, want %+v", objects, want)
+	}
+	if uploaded, ok := s3.object(testBackupKey); !ok || string(uploaded) != content {
+		t.Errorf("uploaded object has %d bytes, want the %d bytes of the file", len(uploaded), len(content))
+	}
+}
+
+func TestUploadBackupFilesRemovesAMismatchingObject(t *testing.T) {
+	s3, endpoint := newFakeS3(t)
+	hanaExpress, _ := testHanaExpress()
+	backup, secret := testObjectStorageBackup(endpoint)
+	c := newFakeClient(secret)
+	executor := &fakeExecutor{
+		files:     map[string]string{testBackupFile: "changed while it was read"},
+		checksums: map[string]string{testBackupFile: strings.Repeat("0", 64)},
+	}
+
+	_, err := uploadBackupFiles(context.Background(), c, executor, hanaExpress, backup, []string{testBackupFile})
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("err = %v, want a checksum mismatch", err)
+	}
+	if _, ok := s3.object(testBackupKey); ok {
+		t.Error("the mismatching object was kept in the bucket")
+	}
+}
+
+func TestUploadBackupFilesRemovesAFailedUpload(t *testing.T) {
+	s3, endpoint := newFakeS3(t)
+	s3.objects[testBackupKey] = []byte("partial")
+	s3.failPuts = true
+	hanaExpress, _ := testHanaExpress()
+	backup, secret := testObjectStorageBackup(endpoint)
+	c := newFakeClient(secret)
+	executor := &fakeExecutor{files: map[string]string{testBackupFile: "hana backup"}}
+
+	if _, err := uploadBackupFiles(context.Background(), c, executor, hanaExpress, backup, []string{testBackupFile}); err == nil {
+		t.Fatal("a failed upload did not fail the backup")
+	}
+	if _, ok := s3.object(testBackupKey); ok {
+		t.Error("the partial object was kept in the bucket")
+	}
+}
+
+func TestUploadBackupFilesRemovesTheUploadedFilesOfAFailedBackup(t *testing.T) {
+	s3, endpoint := newFakeS3(t)
+	hanaExpress, _ := testHanaExpress()
+	backup, secret := testObjectStorageBackup(endpoint)
+	c := newFakeClient(secret)
+	missing := "/hana/backup/nightly/hxe_databackup_2_1"
+	executor := &fakeExecutor{files: map[string]string{testBackupFile: "hana backup"}}
+
+	_, err := uploadBackupFiles(context.Background(), c, executor, hanaExpress, backup, []string{testBackupFile, missing})
+	if err == nil {
+		t.Fatal("a file that cannot be read did not fail the backup")
+	}
+	if _, ok := s3.object(testBackupKey); ok {
+		t.Error("the uploaded file of the failed backup was kept in the bucket")
+	}
+}
+
+func TestUploadBackupFilesFailures(t *testing.T) {
+	_, endpoint := newFakeS3(t)
+
+	tests := []struct {
+		name   string
+		mutate func(backup *dbv1alpha1.HanaExpressBackup, secret *corev1.Secret)
+		want   string
+	}{
+		{
+			name:   "missing credentials Secret",
+			mutate: func(backup *dbv1alpha1.HanaExpressBackup, secret *corev1.Secret) { secret.Name = "other" },
+			want:   "failed to get object storage credentials",
+		},
+		{
+			name: "incomplete credentials Secret",
+			mutate: func(backup *dbv1alpha1.HanaExpressBackup, secret *corev1.Secret) {
+				delete(secret.Data, objectStorageSecretAccessKeyKey)
+			},
+			want: "must contain " + objectStorageAccessKeyIDKey + " and " + objectStorageSecretAccessKeyKey,
+		},
+		{
+			name: "missing bucket",
+			mutate: func(backup *dbv1alpha1.HanaExpressBackup, secret *corev1.Secret) {
+				backup.Spec.ObjectStorage.Bucket = "missing"
+			},
+			want: "bucket missing does not exist",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, _ := testHanaExpress()
+			backup, secret := testObjectStorageBackup(endpoint)
+			tt.mutate(backup, secret)
+			c := newFakeClient(secret)
+			executor := &fakeExecutor{files: map[string]string{testBackupFile: "hana backup"}}
+
+			_, err := uploadBackupFiles(context.Background(), c, executor, hanaExpress, backup, []string{testBackupFile})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveBackupObjects(t *testing.T) {
+	s3, endpoint := newFakeS3(t)
+	other := "hana/default/hxe/weekly/hxe_databackup_0_1"
+	s3.objects[testBackupKey] = []byte("hana backup")
+	s3.objects[other] = []byte("hana backup")
+	backup, secret := testObjectStorageBackup(endpoint)
+	backup.Status.Objects = []dbv1alpha1.BackupObject{{Key: testBackupKey}}
+	c := newFakeClient(secret)
+
+	if err := removeBackupObjects(context.Background(), c, backup); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s3.object(testBackupKey); ok {
+		t.Error("the object of the backup was not removed")
+	}
+	if _, ok := s3.object(other); !ok {
+		t.Error("the object of another backup was removed")
+	}
+
+	// A backup without objects does not need the credentials
+	backup.Status.Objects = nil
+	if err := removeBackupObjects(context.Background(), newFakeClient(), backup); err != nil {
+		t.Errorf("removing a backup without objects: %v", err)
+	}
+}
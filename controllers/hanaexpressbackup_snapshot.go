@@ -0,0 +1,330 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+const (
+	// defaultSnapshotClassAnnotation marks the default VolumeSnapshotClass of a CSI driver
+	defaultSnapshotClassAnnotation = "snapshot.storage.kubernetes.io/is-default-class"
+
+	// snapshotCutTimeout bounds how long HANA is kept in the prepared snapshot state
+	// waiting for the storage to cut the VolumeSnapshot
+	snapshotCutTimeout = 10 * time.Minute
+)
+
+// reconcileSnapshotBackup takes a consistent VolumeSnapshot of the data PVC. HANA prepares
+// a data snapshot of the full system, the VolumeSnapshot is cut while it is prepared, and
+// the HANA snapshot is then closed as successful so it is recorded in the backup catalog.
+// Each step is persisted in the status so the sequence survives operator restarts.
+func (r *HanaExpressBackupReconciler) reconcileSnapshotBackup(ctx context.Context, db *sql.DB,
+	hanaExpress *dbv1alpha1.HanaExpress, backup *dbv1alpha1.HanaExpressBackup) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if backup.Status.Snapshot == nil {
+		className, err := r.volumeSnapshotClassForBackup(ctx, hanaExpress, backup)
+		if err != nil {
+			return ctrl.Result{}, r.failBackup(ctx, backup, "VolumeSnapshotClassNotFound", err.Error())
+		}
+
+		now := metav1.Now()
+		backup.Status.Phase = dbv1alpha1.BackupPhaseRunning
+		backup.Status.StartTime = &now
+		backup.Status.Snapshot = &dbv1alpha1.BackupSnapshotStatus{
+			VolumeSnapshotName:      backup.Name + "-data",
+			VolumeSnapshotClassName: className,
+		}
+		backup.Status.Message = "Preparing HANA data snapshot"
+		if err := r.Status().Update(ctx, backup); err != nil {
+			log.Error(err, "Failed to update HanaExpressBackup status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if backup.Status.BackupID == 0 {
+		// Look for a snapshot prepared before an interruption first, HANA allows only one
+		id, err := preparedSnapshotID(ctx, db, backup.Name)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if id == 0 {
+			log.Info("Preparing HANA data snapshot")
+			stmt := "BACKUP DATA FOR FULL SYSTEM CREATE SNAPSHOT COMMENT " + hdbclient.QuoteString(backup.Name)
+			if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+				return ctrl.Result{}, r.failBackup(ctx, backup, "SnapshotFailed",
+					fmt.Sprintf("Failed to prepare the HANA data snapshot: %s", err))
+			}
+			if id, err = preparedSnapshotID(ctx, db, backup.Name); err != nil {
+				return ctrl.Result{}, err
+			}
+			if id == 0 {
+				return ctrl.Result{}, r.failBackup(ctx, backup, "SnapshotFailed",
+					"The prepared HANA data snapshot is not recorded in the backup catalog")
+			}
+		}
+
+		backup.Status.BackupID = id
+		backup.Status.Message = fmt.Sprintf("Taking VolumeSnapshot %s", backup.Status.Snapshot.VolumeSnapshotName)
+		if err := r.Status().Update(ctx, backup); err != nil {
+			log.Error(err, "Failed to update HanaExpressBackup status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	snapshot := &snapshotv1.VolumeSnapshot{}
+	err := r.Get(ctx, types.NamespacedName{Name: backup.Status.Snapshot.VolumeSnapshotName, Namespace: backup.Namespace}, snapshot)
+	if err != nil && apierrors.IsNotFound(err) {
+		if backup.Status.Snapshot.Confirmed {
+			return ctrl.Result{}, r.failBackup(ctx, backup, "VolumeSnapshotDeleted",
+				fmt.Sprintf("VolumeSnapshot %s was deleted before it became ready", backup.Status.Snapshot.VolumeSnapshotName))
+		}
+
+		snapshot, err = r.volumeSnapshotForBackup(hanaExpress, backup)
+		if err != nil {
+			log.Error(err, "Failed to define new VolumeSnapshot resource for HanaExpressBackup")
+			return ctrl.Result{}, err
+		}
+		log.Info("Creating a new VolumeSnapshot", "VolumeSnapshot.Name", snapshot.Name)
+		if err := r.Create(ctx, snapshot); err != nil {
+			if meta.IsNoMatchError(err) {
+				return ctrl.Result{}, r.abortSnapshotBackup(ctx, db, backup, "VolumeSnapshotNotSupported",
+					"The cluster does not serve the snapshot.storage.k8s.io/v1 API")
+			}
+			return ctrl.Result{}, r.abortSnapshotBackup(ctx, db, backup, "VolumeSnapshotFailed",
+				fmt.Sprintf("Failed to create VolumeSnapshot %s: %s", snapshot.Name, err))
+		}
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	} else if err != nil && meta.IsNoMatchError(err) {
+		return ctrl.Result{}, r.abortSnapshotBackup(ctx, db, backup, "VolumeSnapshotNotSupported",
+			"The cluster does not serve the snapshot.storage.k8s.io/v1 API")
+	} else if err != nil {
+		log.Error(err, "Failed to get VolumeSnapshot")
+		return ctrl.Result{}, err
+	}
+
+	if snapshot.Status != nil && snapshot.Status.Error != nil && snapshot.Status.Error.Message != nil {
+		return ctrl.Result{}, r.abortSnapshotBackup(ctx, db, backup, "VolumeSnapshotFailed",
+			fmt.Sprintf("VolumeSnapshot %s failed: %s", snapshot.Name, *snapshot.Status.Error.Message))
+	}
+
+	if !backup.Status.Snapshot.Confirmed {
+		// The snapshot is cut once the storage reports its creation time
+		if snapshot.Status == nil || snapshot.Status.CreationTime == nil {
+			if backup.Status.StartTime != nil && time.Since(backup.Status.StartTime.Time) > snapshotCutTimeout {
+				return ctrl.Result{}, r.abortSnapshotBackup(ctx, db, backup, "Timeout",
+					fmt.Sprintf("VolumeSnapshot %s was not taken within %s", snapshot.Name, snapshotCutTimeout))
+			}
+			return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+		}
+
+		state, err := snapshotState(ctx, db, backup.Status.BackupID)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		switch state {
+		case "prepared":
+			log.Info("Closing HANA data snapshot", "BackupID", backup.Status.BackupID)
+			stmt := fmt.Sprintf("BACKUP DATA FOR FULL SYSTEM CLOSE SNAPSHOT BACKUP_ID %d SUCCESSFUL %s",
+				backup.Status.BackupID, hdbclient.QuoteString(snapshot.Name))
+			if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+				return ctrl.Result{}, r.abortSnapshotBackup(ctx, db, backup, "SnapshotFailed",
+					fmt.Sprintf("Failed to close the HANA data snapshot: %s", err))
+			}
+		case "successful":
+			// Closed before an interruption
+		default:
+			return ctrl.Result{}, r.abortSnapshotBackup(ctx, db, backup, "SnapshotFailed",
+				fmt.Sprintf("HANA data snapshot %d is in state %q", backup.Status.BackupID, state))
+		}
+
+		backup.Status.Snapshot.Confirmed = true
+		backup.Status.Message = fmt.Sprintf("Waiting for VolumeSnapshot %s to become ready", snapshot.Name)
+		if err := r.Status().Update(ctx, backup); err != nil {
+			log.Error(err, "Failed to update HanaExpressBackup status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if snapshot.Status == nil || snapshot.Status.ReadyToUse == nil || !*snapshot.Status.ReadyToUse {
+		return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
+	}
+
+	backup.Status.Snapshot.ReadyToUse = true
+	entry := &backupCatalogEntry{ID: backup.Status.BackupID, State: "successful"}
+	if snapshot.Status.RestoreSize != nil {
+		entry.Size = snapshot.Status.RestoreSize.Value()
+	}
+	return ctrl.Result{}, r.completeBackup(ctx, backup, entry)
+}
+
+// volumeSnapshotForBackup returns a VolumeSnapshot object of the data PVC
+func (r *HanaExpressBackupReconciler) volumeSnapshotForBackup(hanaExpress *dbv1alpha1.HanaExpress,
+	backup *dbv1alpha1.HanaExpressBackup) (*snapshotv1.VolumeSnapshot, error) {
+	pvcName := dataVolumeClaimName(hanaExpress)
+	className := backup.Status.Snapshot.VolumeSnapshotClassName
+	snapshot := &snapshotv1.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      backup.Status.Snapshot.VolumeSnapshotName,
+			Namespace: backup.Namespace,
+			Labels:    selectorLabelsForHanaExpress(hanaExpress.Name),
+		},
+		Spec: snapshotv1.VolumeSnapshotSpec{
+			Source:                  snapshotv1.VolumeSnapshotSource{PersistentVolumeClaimName: &pvcName},
+			VolumeSnapshotClassName: &className,
+		},
+	}
+
+	// The snapshot is deleted together with the HanaExpressBackup
+	if err := ctrl.SetControllerReference(backup, snapshot, r.Scheme); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// volumeSnapshotClassForBackup returns the requested VolumeSnapshotClass, or the default
+// class of the CSI driver that provisioned the data PVC
+func (r *HanaExpressBackupReconciler) volumeSnapshotClassForBackup(ctx context.Context,
+	hanaExpress *dbv1alpha1.HanaExpress, backup *dbv1alpha1.HanaExpressBackup) (string, error) {
+	if name := backup.Spec.VolumeSnapshotClassName; name != "" {
+		class := &snapshotv1.VolumeSnapshotClass{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name}, class); err != nil {
+			if meta.IsNoMatchError(err) {
+				return "", fmt.Errorf("the cluster does not serve the snapshot.storage.k8s.io/v1 API")
+			}
+			return "", fmt.Errorf("failed to get VolumeSnapshotClass %s: %w", name, err)
+		}
+		return name, nil
+	}
+
+	return defaultVolumeSnapshotClassForData(ctx, r.Client, hanaExpress)
+}
+
+// defaultVolumeSnapshotClassForData returns the default VolumeSnapshotClass of the CSI driver
+// that provisioned the data PVC, or its only VolumeSnapshotClass
+func defaultVolumeSnapshotClassForData(ctx context.Context, c client.Reader, hanaExpress *dbv1alpha1.HanaExpress) (string, error) {
+	pvc := &corev1.PersistentVolumeClaim{}
+	err := c.Get(ctx, types.NamespacedName{Name: dataVolumeClaimName(hanaExpress), Namespace: hanaExpress.Namespace}, pvc)
+	if err != nil {
+		return "", fmt.Errorf("failed to get PVC %s: %w", dataVolumeClaimName(hanaExpress), err)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return "", fmt.Errorf("PVC %s has no storage class, set spec.volumeSnapshotClassName", pvc.Name)
+	}
+	storageClass := &storagev1.StorageClass{}
+	if err := c.Get(ctx, types.NamespacedName{Name: *pvc.Spec.StorageClassName}, storageClass); err != nil {
+		return "", fmt.Errorf("failed to get StorageClass %s: %w", *pvc.Spec.StorageClassName, err)
+	}
+
+	classes := &snapshotv1.VolumeSnapshotClassList{}
+	if err := c.List(ctx, classes); err != nil {
+		if meta.IsNoMatchError(err) {
+			return "", fmt.Errorf("the cluster does not serve the snapshot.storage.k8s.io/v1 API")
+		}
+		return "", fmt.Errorf("failed to list VolumeSnapshotClasses: %w", err)
+	}
+
+	var candidates []string
+	for _, class := range classes.Items {
+		if class.Driver != storageClass.Provisioner {
+			continue
+		}
+		if class.Annotations[defaultSnapshotClassAnnotation] == "true" {
+			return class.Name, nil
+		}
+		candidates = append(candidates, class.Name)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return "", fmt.Errorf("no default VolumeSnapshotClass for CSI driver %s, set spec.volumeSnapshotClassName",
+		storageClass.Provisioner)
+}
+
+// abortSnapshotBackup releases a prepared HANA data snapshot and removes the VolumeSnapshot
+// that was not confirmed, then records the failed backup
+func (r *HanaExpressBackupReconciler) abortSnapshotBackup(ctx context.Context, db *sql.DB,
+	backup *dbv1alpha1.HanaExpressBackup, reason, message string) error {
+	log := log.FromContext(ctx)
+
+	if backup.Status.BackupID != 0 {
+		stmt := fmt.Sprintf("BACKUP DATA FOR FULL SYSTEM CLOSE SNAPSHOT BACKUP_ID %d UNSUCCESSFUL %s",
+			backup.Status.BackupID, hdbclient.QuoteString(message))
+		if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+			log.Error(err, "Failed to close the HANA data snapshot as unsuccessful", "BackupID", backup.Status.BackupID)
+		}
+	}
+
+	if backup.Status.Snapshot != nil {
+		snapshot := &snapshotv1.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{
+			Name: backup.Status.Snapshot.VolumeSnapshotName, Namespace: backup.Namespace}}
+		if err := r.Delete(ctx, snapshot); err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			log.Error(err, "Failed to delete VolumeSnapshot", "VolumeSnapshot.Name", snapshot.Name)
+		}
+	}
+
+	return r.failBackup(ctx, backup, reason, message)
+}
+
+// preparedSnapshotID returns the ID of the prepared HANA data snapshot with the given
+// comment, or 0 if there is none
+func preparedSnapshotID(ctx context.Context, db *sql.DB, comment string) (int64, error) {
+	var id int64
+	err := db.QueryRowContext(ctx, `SELECT TOP 1 BACKUP_ID FROM M_BACKUP_CATALOG
+		WHERE ENTRY_TYPE_NAME = 'data snapshot' AND STATE_NAME = 'prepared' AND COMMENT = ?`, comment).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query the backup catalog: %w", err)
+	}
+	return id, nil
+}
+
+// snapshotState returns the state of a HANA data snapshot in the backup catalog
+func snapshotState(ctx context.Context, db *sql.DB, id int64) (string, error) {
+	var state string
+	err := db.QueryRowContext(ctx, `SELECT STATE_NAME FROM M_BACKUP_CATALOG
+		WHERE ENTRY_TYPE_NAME = 'data snapshot' AND BACKUP_ID = ?`, id).Scan(&state)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query the backup catalog: %w", err)
+	}
+	return state, nil
+}
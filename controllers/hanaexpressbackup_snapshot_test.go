@@ -0,0 +1,299 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+const (
+	preparedSnapshotQuery = "SELECT TOP 1 BACKUP_ID FROM M_BACKUP_CATALOG " +
+		"WHERE ENTRY_TYPE_NAME = 'data snapshot' AND STATE_NAME = 'prepared' AND COMMENT = ?"
+	snapshotStateQuery = "SELECT STATE_NAME FROM M_BACKUP_CATALOG WHERE ENTRY_TYPE_NAME = 'data snapshot' AND BACKUP_ID = ?"
+	testSnapshotClass  = "csi-snapshots"
+	testCSIDriver      = "csi.example.com"
+)
+
+func testSnapshotBackup() *dbv1alpha1.HanaExpressBackup {
+	backup := testBackup()
+	backup.Spec.Method = dbv1alpha1.BackupMethodSnapshot
+	backup.Spec.VolumeSnapshotClassName = testSnapshotClass
+	return backup
+}
+
+func testSnapshotClassObject(name string, isDefault bool) *snapshotv1.VolumeSnapshotClass {
+	class := &snapshotv1.VolumeSnapshotClass{ObjectMeta: metav1.ObjectMeta{Name: name}, Driver: testCSIDriver,
+		DeletionPolicy: snapshotv1.VolumeSnapshotContentDelete}
+	if isDefault {
+		class.Annotations = map[string]string{defaultSnapshotClassAnnotation: "true"}
+	}
+	return class
+}
+
+func expectPreparedSnapshot(mock sqlmock.Sqlmock, id int64) {
+	rows := sqlmock.NewRows([]string{"BACKUP_ID"})
+	if id != 0 {
+		rows.AddRow(id)
+	}
+	mock.ExpectQuery(preparedSnapshotQuery).WithArgs("nightly").WillReturnRows(rows)
+}
+
+// updateVolumeSnapshot lets the storage report progress on the VolumeSnapshot of the backup
+func updateVolumeSnapshot(t *testing.T, c client.Client, update func(status *snapshotv1.VolumeSnapshotStatus)) {
+	t.Helper()
+	snapshot := &snapshotv1.VolumeSnapshot{}
+	if err := c.Get(context.Background(), types.NamespacedName{Name: "nightly-data", Namespace: testNamespace}, snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Status == nil {
+		snapshot.Status = &snapshotv1.VolumeSnapshotStatus{}
+	}
+	update(snapshot.Status)
+	if err := c.Update(context.Background(), snapshot); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// completedReason returns the reason of the Completed condition of the backup
+func completedReason(backup *dbv1alpha1.HanaExpressBackup) string {
+	condition := meta.FindStatusCondition(backup.Status.Conditions, typeCompletedHanaExpressBackup)
+	if condition == nil {
+		return ""
+	}
+	return condition.Reason
+}
+
+func TestSnapshotBackup(t *testing.T) {
+	r, connector := newTestBackupReconciler(nil, testSnapshotBackup())
+	if err := r.Create(context.Background(), testSnapshotClassObject(testSnapshotClass, false)); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := connector.expectSQL(t)
+	_, backup := reconcileBackup(t, r, mock)
+	if backup.Status.Phase != dbv1alpha1.BackupPhaseRunning || backup.Status.Snapshot == nil ||
+		backup.Status.Snapshot.VolumeSnapshotClassName != testSnapshotClass {
+		t.Fatalf("phase = %s, snapshot = %+v after the start", backup.Status.Phase, backup.Status.Snapshot)
+	}
+
+	mock = connector.expectSQL(t)
+	expectPreparedSnapshot(mock, 0)
+	mock.ExpectExec("BACKUP DATA FOR FULL SYSTEM CREATE SNAPSHOT COMMENT 'nightly'").WillReturnResult(sqlmock.NewResult(0, 0))
+	expectPreparedSnapshot(mock, 7)
+	_, backup = reconcileBackup(t, r, mock)
+	if backup.Status.BackupID != 7 {
+		t.Fatalf("backup ID = %d after preparing the HANA snapshot, want 7", backup.Status.BackupID)
+	}
+
+	mock = connector.expectSQL(t)
+	result, _ := reconcileBackup(t, r, mock)
+	snapshot := &snapshotv1.VolumeSnapshot{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: "nightly-data", Namespace: testNamespace}, snapshot); err != nil {
+		t.Fatalf("VolumeSnapshot not created: %v", err)
+	}
+	if source := snapshot.Spec.Source.PersistentVolumeClaimName; source == nil || *source != "data-hxe-0" {
+		t.Errorf("VolumeSnapshot of %v, want data-hxe-0", source)
+	}
+	if result.RequeueAfter == 0 {
+		t.Error("not requeued to follow the VolumeSnapshot")
+	}
+
+	// HANA stays prepared until the storage cut the snapshot
+	mock = connector.expectSQL(t)
+	_, backup = reconcileBackup(t, r, mock)
+	if backup.Status.Snapshot.Confirmed {
+		t.Fatal("snapshot confirmed before the storage reported its creation time")
+	}
+
+	updateVolumeSnapshot(t, r.Client, func(status *snapshotv1.VolumeSnapshotStatus) {
+		status.CreationTime = &metav1.Time{Time: time.Now()}
+	})
+	mock = connector.expectSQL(t)
+	mock.ExpectQuery(snapshotStateQuery).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"STATE_NAME"}).AddRow("prepared"))
+	mock.ExpectExec("BACKUP DATA FOR FULL SYSTEM CLOSE SNAPSHOT BACKUP_ID 7 SUCCESSFUL 'nightly-data'").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	_, backup = reconcileBackup(t, r, mock)
+	if !backup.Status.Snapshot.Confirmed || backup.Status.Phase != dbv1alpha1.BackupPhaseRunning {
+		t.Fatalf("phase = %s, confirmed = %t after the cut", backup.Status.Phase, backup.Status.Snapshot.Confirmed)
+	}
+
+	size := resource.MustParse("20Gi")
+	updateVolumeSnapshot(t, r.Client, func(status *snapshotv1.VolumeSnapshotStatus) {
+		status.ReadyToUse = pointer.Bool(true)
+		status.RestoreSize = &size
+	})
+	mock = connector.expectSQL(t)
+	_, backup = reconcileBackup(t, r, mock)
+	if backup.Status.Phase != dbv1alpha1.BackupPhaseCompleted || backup.Status.Size != size.Value() {
+		t.Errorf("phase = %s with %d bytes, want Completed with %d bytes", backup.Status.Phase, backup.Status.Size, size.Value())
+	}
+}
+
+func TestSnapshotBackupFailures(t *testing.T) {
+	tests := []struct {
+		name      string
+		confirmed bool
+		snapshot  *snapshotv1.VolumeSnapshotStatus
+		expect    func(mock sqlmock.Sqlmock)
+		reason    string
+	}{
+		{
+			name:     "storage fails to take the snapshot",
+			snapshot: &snapshotv1.VolumeSnapshotStatus{Error: &snapshotv1.VolumeSnapshotError{Message: pointer.String("quota exceeded")}},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("BACKUP DATA FOR FULL SYSTEM CLOSE SNAPSHOT BACKUP_ID 7 UNSUCCESSFUL " +
+					"'VolumeSnapshot nightly-data failed: quota exceeded'").WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			reason: "VolumeSnapshotFailed",
+		},
+		{
+			name:     "HANA snapshot closed by someone else",
+			snapshot: &snapshotv1.VolumeSnapshotStatus{CreationTime: &metav1.Time{Time: time.Now()}},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(snapshotStateQuery).WithArgs(int64(7)).
+					WillReturnRows(sqlmock.NewRows([]string{"STATE_NAME"}).AddRow("unsuccessful"))
+				mock.ExpectExec("BACKUP DATA FOR FULL SYSTEM CLOSE SNAPSHOT BACKUP_ID 7 UNSUCCESSFUL " +
+					`'HANA data snapshot 7 is in state "unsuccessful"'`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			reason: "SnapshotFailed",
+		},
+		{
+			name:      "confirmed VolumeSnapshot deleted before it became ready",
+			confirmed: true,
+			reason:    "VolumeSnapshotDeleted",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup := testSnapshotBackup()
+			start := metav1.Now()
+			backup.Status = dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseRunning, StartTime: &start,
+				BackupID: 7, Snapshot: &dbv1alpha1.BackupSnapshotStatus{VolumeSnapshotName: "nightly-data",
+					VolumeSnapshotClassName: testSnapshotClass, Confirmed: tt.confirmed}}
+			r, connector := newTestBackupReconciler(nil, backup)
+			if tt.snapshot != nil {
+				hanaExpress, _ := testHanaExpress()
+				snapshot, err := r.volumeSnapshotForBackup(hanaExpress, backup)
+				if err != nil {
+					t.Fatal(err)
+				}
+				snapshot.Status = tt.snapshot
+				if err := r.Create(context.Background(), snapshot); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mock := connector.expectSQL(t)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+			_, backup = reconcileBackup(t, r, mock)
+			if backup.Status.Phase != dbv1alpha1.BackupPhaseFailed {
+				t.Fatalf("phase = %s (%s), want Failed", backup.Status.Phase, backup.Status.Message)
+			}
+			if reason := completedReason(backup); reason != tt.reason {
+				t.Errorf("reason = %s, want %s", reason, tt.reason)
+			}
+			err := r.Get(context.Background(), types.NamespacedName{Name: "nightly-data", Namespace: testNamespace},
+				&snapshotv1.VolumeSnapshot{})
+			if !apierrors.IsNotFound(err) {
+				t.Errorf("VolumeSnapshot of the failed backup is kept: %v", err)
+			}
+		})
+	}
+}
+
+func TestDefaultVolumeSnapshotClassForData(t *testing.T) {
+	tests := []struct {
+		name         string
+		storageClass *string
+		classes      []*snapshotv1.VolumeSnapshotClass
+		want         string
+	}{
+		{
+			name:         "default class of the driver",
+			storageClass: pointer.String("fast"),
+			classes: []*snapshotv1.VolumeSnapshotClass{testSnapshotClassObject("other", false),
+				testSnapshotClassObject("default", true)},
+			want: "default",
+		},
+		{
+			name:         "only class of the driver",
+			storageClass: pointer.String("fast"),
+			classes:      []*snapshotv1.VolumeSnapshotClass{testSnapshotClassObject("only", false)},
+			want:         "only",
+		},
+		{
+			name:         "several classes without a default",
+			storageClass: pointer.String("fast"),
+			classes: []*snapshotv1.VolumeSnapshotClass{testSnapshotClassObject("first", false),
+				testSnapshotClassObject("second", false)},
+		},
+		{
+			name:    "PVC without a storage class",
+			classes: []*snapshotv1.VolumeSnapshotClass{testSnapshotClassObject("only", false)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, _ := testHanaExpress()
+			objects := []client.Object{
+				&corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "data-hxe-0", Namespace: testNamespace},
+					Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: tt.storageClass},
+				},
+				&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "fast"}, Provisioner: testCSIDriver},
+				&snapshotv1.VolumeSnapshotClass{ObjectMeta: metav1.ObjectMeta{Name: "foreign"}, Driver: "csi.other.com",
+					DeletionPolicy: snapshotv1.VolumeSnapshotContentDelete},
+			}
+			for _, class := range tt.classes {
+				objects = append(objects, class)
+			}
+			c := newFakeClient(objects...)
+
+			got, err := defaultVolumeSnapshotClassForData(context.Background(), c, hanaExpress)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("got %s, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("got %s (%v), want %s", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+const (
+	// backupScheduleLabel marks the backups created by a HanaExpressBackupSchedule
+	backupScheduleLabel = "db.sap-redhat.io/backup-schedule"
+
+	// typeScheduledHanaExpressBackupSchedule represents whether backups are being scheduled
+	typeScheduledHanaExpressBackupSchedule = "Scheduled"
+)
+
+// maxMissedSchedules is how many missed runs are walked one by one. Like the CronJob controller,
+// a schedule that missed more of them, e.g. while the operator was down for long or after a
+// clock skew, reports it and only looks for the latest run.
+const maxMissedSchedules = 100
+
+// HanaExpressBackupScheduleReconciler reconciles a HanaExpressBackupSchedule object
+type HanaExpressBackupScheduleReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressbackupschedules,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressbackupschedules/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressbackupschedules/finalizers,verbs=update
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressbackups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+
+// Reconcile creates a HanaExpressBackup whenever the schedule is due and deletes the backups
+// that fall out of the retention policy, whose finalizer removes their files.
+func (r *HanaExpressBackupScheduleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	schedule := &dbv1alpha1.HanaExpressBackupSchedule{}
+	if err := r.Get(ctx, req.NamespacedName, schedule); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("HanaExpressBackupSchedule resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get HanaExpressBackupSchedule")
+		return ctrl.Result{}, err
+	}
+
+	cronSchedule, err := cron.ParseStandard(schedule.Spec.Schedule)
+	if err != nil {
+		meta.SetStatusCondition(&schedule.Status.Conditions, metav1.Condition{Type: typeScheduledHanaExpressBackupSchedule,
+			Status: metav1.ConditionFalse, Reason: "InvalidSchedule",
+			Message: fmt.Sprintf("Failed to parse schedule %q: %s", schedule.Spec.Schedule, err)})
+		schedule.Status.NextScheduleTime = nil
+		if err := r.Status().Update(ctx, schedule); err != nil {
+			log.Error(err, "Failed to update HanaExpressBackupSchedule status")
+			return ctrl.Result{}, err
+		}
+		// Retrying does not help until the spec changes
+		return ctrl.Result{}, nil
+	}
+
+	backups := &dbv1alpha1.HanaExpressBackupList{}
+	if err := r.List(ctx, backups, client.InNamespace(schedule.Namespace),
+		client.MatchingLabels{backupScheduleLabel: schedule.Name}); err != nil {
+		log.Error(err, "Failed to list HanaExpressBackups")
+		return ctrl.Result{}, err
+	}
+
+	var active []string
+	var completed, failed []dbv1alpha1.HanaExpressBackup
+	for _, backup := range backups.Items {
+		// A pruned backup whose files are being removed is not kept anymore
+		if !metav1.IsControlledBy(&backup, schedule) || backup.GetDeletionTimestamp() != nil {
+			continue
+		}
+		switch backup.Status.Phase {
+		case dbv1alpha1.BackupPhaseCompleted:
+			completed = append(completed, backup)
+		case dbv1alpha1.BackupPhaseFailed:
+			failed = append(failed, backup)
+		default:
+			active = append(active, backup.Name)
+		}
+	}
+	sortBackupsNewestFirst(completed)
+	sortBackupsNewestFirst(failed)
+
+	if len(completed) > 0 {
+		schedule.Status.LastSuccessfulBackup = scheduledBackupReference(&completed[0])
+	}
+	if len(failed) > 0 {
+		schedule.Status.LastFailedBackup = scheduledBackupReference(&failed[0])
+	}
+
+	pruned, pruneErr := r.pruneBackups(ctx, schedule, completed, failed)
+	if pruneErr != nil {
+		// Pruning is retried later, it must not keep new backups from being taken
+		log.Error(pruneErr, "Failed to prune backups")
+	}
+	if pruned > 0 {
+		log.Info("Pruned backups", "Count", pruned)
+	}
+
+	now := time.Now()
+	if !schedule.Spec.Suspend {
+		missed, tooMany := mostRecentScheduleTime(cronSchedule, schedule, now)
+		if tooMany {
+			log.Info("Too many missed backups, only the latest is taken", "Limit", maxMissedSchedules)
+			r.Recorder.Event(schedule, "Warning", "TooManyMissedBackups",
+				fmt.Sprintf("More than %d backups were missed, only the one due at %s is taken", maxMissedSchedules,
+					missed.Format(time.RFC3339)))
+		}
+		if !missed.IsZero() {
+			if schedule.Spec.ConcurrencyPolicy != dbv1alpha1.ConcurrencyPolicyAllow && len(active) > 0 {
+				log.Info("Skipping scheduled backup, an earlier backup has not finished", "Active", active)
+				r.Recorder.Event(schedule, "Normal", "BackupSkipped",
+					fmt.Sprintf("Skipped the backup due at %s, %s has not finished", missed.Format(time.RFC3339), active[0]))
+			} else {
+				backup, err := r.backupForSchedule(schedule, missed)
+				if err != nil {
+					log.Error(err, "Failed to define new HanaExpressBackup resource for HanaExpressBackupSchedule")
+					return ctrl.Result{}, err
+				}
+				log.Info("Creating scheduled backup", "HanaExpressBackup.Name", backup.Name)
+				if err := r.Create(ctx, backup); err != nil && !apierrors.IsAlreadyExists(err) {
+					log.Error(err, "Failed to create HanaExpressBackup", "HanaExpressBackup.Name", backup.Name)
+					return ctrl.Result{}, err
+				}
+				active = append(active, backup.Name)
+				r.Recorder.Event(schedule, "Normal", "BackupCreated", fmt.Sprintf("Created backup %s", backup.Name))
+			}
+			schedule.Status.LastScheduleTime = &metav1.Time{Time: missed}
+		}
+	}
+	schedule.Status.Active = active
+
+	if schedule.Spec.Suspend {
+		schedule.Status.NextScheduleTime = nil
+		meta.SetStatusCondition(&schedule.Status.Conditions, metav1.Condition{Type: typeScheduledHanaExpressBackupSchedule,
+			Status: metav1.ConditionFalse, Reason: "Suspended", Message: "The schedule is suspended"})
+	} else {
+		next := cronSchedule.Next(now)
+		schedule.Status.NextScheduleTime = &metav1.Time{Time: next}
+		meta.SetStatusCondition(&schedule.Status.Conditions, metav1.Condition{Type: typeScheduledHanaExpressBackupSchedule,
+			Status: metav1.ConditionTrue, Reason: "Scheduled",
+			Message: fmt.Sprintf("The next backup is due at %s", next.Format(time.RFC3339))})
+	}
+
+	if err := r.Status().Update(ctx, schedule); err != nil {
+		log.Error(err, "Failed to update HanaExpressBackupSchedule status")
+		return ctrl.Result{}, err
+	}
+
+	if pruneErr != nil {
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	if schedule.Spec.Suspend {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: schedule.Status.NextScheduleTime.Sub(now)}, nil
+}
+
+// mostRecentScheduleTime returns the latest time the schedule was due since the last
+// backup was scheduled, or the zero time if it has not been due. Runs missed while the
+// operator was down are collapsed into one backup. It also reports whether more than
+// maxMissedSchedules runs were missed.
+func mostRecentScheduleTime(cronSchedule cron.Schedule, schedule *dbv1alpha1.HanaExpressBackupSchedule,
+	now time.Time) (time.Time, bool) {
+	last := schedule.CreationTimestamp.Time
+	if schedule.Status.LastScheduleTime != nil {
+		last = schedule.Status.LastScheduleTime.Time
+	}
+
+	var missed time.Time
+	count := 0
+	for t := cronSchedule.Next(last); !t.IsZero() && !t.After(now); t = cronSchedule.Next(t) {
+		if count == maxMissedSchedules {
+			return latestScheduleTime(cronSchedule, missed, t, now), true
+		}
+		missed = t
+		count++
+	}
+	return missed, false
+}
+
+// latestScheduleTime returns the latest run of the schedule until now, after the missed run
+// earliest and its successor next. It walks the runs of a window before now, starting with the
+// gap between the two runs and doubling it until the window holds a run, so only a few runs are
+// walked.
+func latestScheduleTime(cronSchedule cron.Schedule, earliest, next, now time.Time) time.Time {
+	for window := next.Sub(earliest); ; window *= 2 {
+		from := now.Add(-window)
+		if from.Before(earliest) {
+			from = earliest
+		}
+
+		var latest time.Time
+		for t := cronSchedule.Next(from); !t.IsZero() && !t.After(now); t = cronSchedule.Next(t) {
+			latest = t
+		}
+		if !latest.IsZero() || from.Equal(earliest) {
+			return latest
+		}
+	}
+}
+
+// backupForSchedule returns a HanaExpressBackup object for the run due at the given time
+func (r *HanaExpressBackupScheduleReconciler) backupForSchedule(schedule *dbv1alpha1.HanaExpressBackupSchedule,
+	scheduledTime time.Time) (*dbv1alpha1.HanaExpressBackup, error) {
+	backup := &dbv1alpha1.HanaExpressBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			// The name is derived from the scheduled time so a run is never created twice
+			Name:      fmt.Sprintf("%s-%d", schedule.Name, scheduledTime.Unix()),
+			Namespace: schedule.Namespace,
+			Labels:    map[string]string{backupScheduleLabel: schedule.Name},
+		},
+		Spec: dbv1alpha1.HanaExpressBackupSpec{
+			HanaExpressName:         schedule.Spec.HanaExpressName,
+			DatabaseName:            schedule.Spec.DatabaseName,
+			Method:                  schedule.Spec.Method,
+			VolumeSnapshotClassName: schedule.Spec.VolumeSnapshotClassName,
+			ObjectStorage:           schedule.Spec.ObjectStorage,
+		},
+	}
+
+	if err := ctrl.SetControllerReference(schedule, backup, r.Scheme); err != nil {
+		return nil, err
+	}
+	return backup, nil
+}
+
+// pruneBackups deletes the completed backups outside of the retention policy and the failed
+// backups beyond the history limit. Both lists are newest first. The finalizer of a deleted
+// backup removes its objects, its catalog entry and its files, also those of a failed one.
+func (r *HanaExpressBackupScheduleReconciler) pruneBackups(ctx context.Context, schedule *dbv1alpha1.HanaExpressBackupSchedule,
+	completed, failed []dbv1alpha1.HanaExpressBackup) (int, error) {
+	var expired []dbv1alpha1.HanaExpressBackup
+	retention := schedule.Spec.Retention
+	// The latest completed backup is always kept
+	for i := 1; i < len(completed); i++ {
+		if retention.Count != nil && i >= int(*retention.Count) {
+			expired = append(expired, completed[i])
+			continue
+		}
+		if retention.MaxAge != nil && time.Since(backupFinishTime(&completed[i])) > retention.MaxAge.Duration {
+			expired = append(expired, completed[i])
+		}
+	}
+
+	failedLimit := 3
+	if schedule.Spec.FailedBackupsHistoryLimit != nil {
+		failedLimit = int(*schedule.Spec.FailedBackupsHistoryLimit)
+	}
+	if len(failed) > failedLimit {
+		expired = append(expired, failed[failedLimit:]...)
+	}
+
+	pruned := 0
+	for i := range expired {
+		backup := &expired[i]
+		if err := r.Delete(ctx, backup); err != nil && !apierrors.IsNotFound(err) {
+			return pruned, err
+		}
+		r.Recorder.Event(schedule, "Normal", "BackupPruned", fmt.Sprintf("Deleted backup %s", backup.Name))
+		pruned++
+	}
+	return pruned, nil
+}
+
+// backupFinishTime returns the time a backup finished, falling back to its creation time
+func backupFinishTime(backup *dbv1alpha1.HanaExpressBackup) time.Time {
+	if backup.Status.CompletionTime != nil {
+		return backup.Status.CompletionTime.Time
+	}
+	return backup.CreationTimestamp.Time
+}
+
+// sortBackupsNewestFirst orders backups by the time they finished, newest first
+func sortBackupsNewestFirst(backups []dbv1alpha1.HanaExpressBackup) {
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backupFinishTime(&backups[i]).After(backupFinishTime(&backups[j]))
+	})
+}
+
+// scheduledBackupReference returns the status reference to a finished backup
+func scheduledBackupReference(backup *dbv1alpha1.HanaExpressBackup) *dbv1alpha1.ScheduledBackupReference {
+	return &dbv1alpha1.ScheduledBackupReference{
+		Name:    backup.Name,
+		Time:    metav1.Time{Time: backupFinishTime(backup)},
+		Message: backup.Status.Message,
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+// The schedule owns its backups so it is reconciled whenever one of them finishes.
+func (r *HanaExpressBackupScheduleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1alpha1.HanaExpressBackupSchedule{}).
+		Owns(&dbv1alpha1.HanaExpressBackup{}).
+		Complete(withReconcileTimeout(r))
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func TestMostRecentScheduleTime(t *testing.T) {
+	cronSchedule, err := cron.ParseStandard("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2023, 6, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		last    *time.Time
+		now     time.Time
+		want    time.Time
+		tooMany bool
+	}{
+		{
+			name: "not due since the creation",
+			now:  created.Add(20 * time.Minute),
+		},
+		{
+			name: "due once since the creation",
+			now:  created.Add(40 * time.Minute),
+			want: time.Date(2023, 6, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "missed runs are collapsed into the latest",
+			now:  created.Add(3 * time.Hour),
+			want: time.Date(2023, 6, 1, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "not due since the last backup",
+			last: &[]time.Time{time.Date(2023, 6, 1, 13, 0, 0, 0, time.UTC)}[0],
+			now:  time.Date(2023, 6, 1, 13, 59, 0, 0, time.UTC),
+		},
+		{
+			name: "due since the last backup",
+			last: &[]time.Time{time.Date(2023, 6, 1, 13, 0, 0, 0, time.UTC)}[0],
+			now:  time.Date(2023, 6, 1, 14, 0, 0, 0, time.UTC),
+			want: time.Date(2023, 6, 1, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "up to the limit of missed runs",
+			now:  created.Add(100 * time.Hour),
+			want: time.Date(2023, 6, 5, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "more missed runs than the limit",
+			now:     created.Add(100*time.Hour + 30*time.Minute),
+			want:    time.Date(2023, 6, 5, 15, 0, 0, 0, time.UTC),
+			tooMany: true,
+		},
+		{
+			name:    "a year of missed runs",
+			now:     created.Add(365 * 24 * time.Hour),
+			want:    time.Date(2024, 5, 31, 10, 0, 0, 0, time.UTC),
+			tooMany: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := testSchedule(created)
+			if tt.last != nil {
+				schedule.Status.LastScheduleTime = &metav1.Time{Time: *tt.last}
+			}
+			got, tooMany := mostRecentScheduleTime(cronSchedule, schedule, tt.now)
+			if !got.Equal(tt.want) {
+				t.Errorf("mostRecentScheduleTime = %s, want %s", got, tt.want)
+			}
+			if tooMany != tt.tooMany {
+				t.Errorf("too many missed = %t, want %t", tooMany, tt.tooMany)
+			}
+		})
+	}
+}
+
+func TestScheduleCreatesTheDueBackupOnce(t *testing.T) {
+	schedule := testSchedule(time.Now().Add(-90 * time.Minute))
+	r := newTestScheduleReconciler(schedule)
+
+	result, schedule := reconcileSchedule(t, r)
+	due := schedule.Status.LastScheduleTime
+	if due == nil {
+		t.Fatal("no backup scheduled")
+	}
+	name := fmt.Sprintf("hourly-%d", due.Unix())
+	if names := backupNames(t, r.Client); len(names) != 1 || names[0] != name {
+		t.Fatalf("backups = %v, want [%s]", names, name)
+	}
+	if len(schedule.Status.Active) != 1 || schedule.Status.Active[0] != name {
+		t.Errorf("active = %v, want [%s]", schedule.Status.Active, name)
+	}
+	if schedule.Status.NextScheduleTime == nil || result.RequeueAfter <= 0 || result.RequeueAfter > time.Hour {
+		t.Errorf("next backup at %v, requeue after %s", schedule.Status.NextScheduleTime, result.RequeueAfter)
+	}
+
+	// The backup due at the same time is not created again
+	_, schedule = reconcileSchedule(t, r)
+	if names := backupNames(t, r.Client); len(names) != 1 {
+		t.Errorf("backups = %v after the second reconcile, want one", names)
+	}
+	if !schedule.Status.LastScheduleTime.Equal(due) {
+		t.Errorf("last schedule time moved from %s to %s", due, schedule.Status.LastScheduleTime)
+	}
+}
+
+func TestScheduleSkipsBackups(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(schedule *dbv1alpha1.HanaExpressBackupSchedule)
+		active  bool
+		reason  string
+		event   string
+		backups int
+	}{
+		{
+			name:    "earlier backup still running",
+			active:  true,
+			reason:  "Scheduled",
+			event:   "BackupSkipped",
+			backups: 1,
+		},
+		{
+			name: "earlier backup running with concurrent backups allowed",
+			prepare: func(schedule *dbv1alpha1.HanaExpressBackupSchedule) {
+				schedule.Spec.ConcurrencyPolicy = dbv1alpha1.ConcurrencyPolicyAllow
+			},
+			active:  true,
+			reason:  "Scheduled",
+			event:   "BackupCreated",
+			backups: 2,
+		},
+		{
+			name:    "suspended schedule",
+			prepare: func(schedule *dbv1alpha1.HanaExpressBackupSchedule) { schedule.Spec.Suspend = true },
+			reason:  "Suspended",
+		},
+		{
+			name:    "invalid schedule",
+			prepare: func(schedule *dbv1alpha1.HanaExpressBackupSchedule) { schedule.Spec.Schedule = "every hour" },
+			reason:  "InvalidSchedule",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := testSchedule(time.Now().Add(-90 * time.Minute))
+			if tt.prepare != nil {
+				tt.prepare(schedule)
+			}
+			objects := []client.Object{}
+			if tt.active {
+				objects = append(objects, scheduledBackup(t, schedule, "hourly-running", dbv1alpha1.BackupPhaseRunning,
+					time.Now(), 0))
+			}
+			r := newTestScheduleReconciler(schedule, objects...)
+
+			_, schedule = reconcileSchedule(t, r)
+			if status, reason := scheduleCondition(schedule); reason != tt.reason {
+				t.Errorf("Scheduled = %s (%s), want reason %s", status, reason, tt.reason)
+			}
+			if names := backupNames(t, r.Client); len(names) != tt.backups {
+				t.Errorf("backups = %v, want %d", names, tt.backups)
+			}
+			if tt.reason != "Scheduled" && schedule.Status.NextScheduleTime != nil {
+				t.Errorf("next backup at %s for reason %s", schedule.Status.NextScheduleTime, tt.reason)
+			}
+			events := drainEvents(r.Recorder.(*record.FakeRecorder))
+			if tt.event != "" && !containsEvent(events, tt.event) {
+				t.Errorf("events %v do not contain %s", events, tt.event)
+			}
+		})
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		retention dbv1alpha1.BackupRetention
+		failed    *int32
+		remaining []string
+	}{
+		{
+			name:      "no retention keeps the completed backups",
+			remaining: []string{"completed-1", "completed-2", "completed-3", "failed-1", "failed-2"},
+		},
+		{
+			name:      "count",
+			retention: dbv1alpha1.BackupRetention{Count: pointer.Int32(2)},
+			remaining: []string{"completed-1", "completed-2", "failed-1", "failed-2"},
+		},
+		{
+			name:      "max age",
+			retention: dbv1alpha1.BackupRetention{MaxAge: &metav1.Duration{Duration: 90 * time.Minute}},
+			remaining: []string{"completed-1", "failed-1", "failed-2"},
+		},
+		{
+			name:      "max age keeps the latest backup",
+			retention: dbv1alpha1.BackupRetention{MaxAge: &metav1.Duration{Duration: time.Minute}},
+			remaining: []string{"completed-1", "failed-1", "failed-2"},
+		},
+		{
+			name:      "failed backups history limit",
+			failed:    pointer.Int32(1),
+			remaining: []string{"completed-1", "completed-2", "completed-3", "failed-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := testSchedule(now.Add(-24 * time.Hour))
+			schedule.Spec.Retention = tt.retention
+			schedule.Spec.FailedBackupsHistoryLimit = tt.failed
+			// Scheduled at the last full hour, so no new backup is due
+			schedule.Status.LastScheduleTime = &metav1.Time{Time: now.Truncate(time.Hour)}
+			r := newTestScheduleReconciler(schedule,
+				scheduledBackup(t, schedule, "completed-1", dbv1alpha1.BackupPhaseCompleted, now.Add(-time.Hour), 1),
+				scheduledBackup(t, schedule, "completed-2", dbv1alpha1.BackupPhaseCompleted, now.Add(-2*time.Hour), 2),
+				scheduledBackup(t, schedule, "completed-3", dbv1alpha1.BackupPhaseCompleted, now.Add(-3*time.Hour), 3),
+				scheduledBackup(t, schedule, "failed-1", dbv1alpha1.BackupPhaseFailed, now.Add(-time.Hour), 0),
+				scheduledBackup(t, schedule, "failed-2", dbv1alpha1.BackupPhaseFailed, now.Add(-2*time.Hour), 0))
+
+			_, schedule = reconcileSchedule(t, r)
+			if names := backupNames(t, r.Client); fmt.Sprint(names) != fmt.Sprint(tt.remaining) {
+				t.Errorf("backups = %v, want %v", names, tt.remaining)
+			}
+			if last := schedule.Status.LastSuccessfulBackup; last == nil || last.Name != "completed-1" {
+				t.Errorf("last successful backup = %v, want completed-1", last)
+			}
+			if last := schedule.Status.LastFailedBackup; last == nil || last.Name != "failed-1" {
+				t.Errorf("last failed backup = %v, want failed-1", last)
+			}
+		})
+	}
+}
+
+func TestPrunedBackupsAreRemovedByTheirFinalizer(t *testing.T) {
+	now := time.Now()
+	schedule := testSchedule(now.Add(-24 * time.Hour))
+	schedule.Spec.Retention = dbv1alpha1.BackupRetention{Count: pointer.Int32(2)}
+	schedule.Spec.FailedBackupsHistoryLimit = pointer.Int32(0)
+	schedule.Status.LastScheduleTime = &metav1.Time{Time: now.Truncate(time.Hour)}
+
+	backups := []*dbv1alpha1.HanaExpressBackup{
+		scheduledBackup(t, schedule, "completed-1", dbv1alpha1.BackupPhaseCompleted, now.Add(-time.Hour), 1),
+		scheduledBackup(t, schedule, "completed-2", dbv1alpha1.BackupPhaseCompleted, now.Add(-2*time.Hour), 2),
+		scheduledBackup(t, schedule, "completed-3", dbv1alpha1.BackupPhaseCompleted, now.Add(-3*time.Hour), 3),
+		scheduledBackup(t, schedule, "failed-1", dbv1alpha1.BackupPhaseFailed, now.Add(-time.Hour), 0),
+	}
+	objects := []client.Object{}
+	for _, backup := range backups {
+		backup.Finalizers = []string{hanaExpressFinalizer}
+		objects = append(objects, backup)
+	}
+	// The finalizer of completed-2 is still removing its files
+	backups[1].DeletionTimestamp = &metav1.Time{Time: now.Add(-time.Minute)}
+	r := newTestScheduleReconciler(schedule, objects...)
+
+	reconcileSchedule(t, r)
+	for name, deleting := range map[string]bool{"completed-1": false, "completed-2": true, "completed-3": false, "failed-1": true} {
+		backup := &dbv1alpha1.HanaExpressBackup{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: name, Namespace: testNamespace}, backup); err != nil {
+			t.Fatal(err)
+		}
+		if got := backup.DeletionTimestamp != nil; got != deleting {
+			t.Errorf("%s deleting = %t, want %t", name, got, deleting)
+		}
+	}
+}
@@ -0,0 +1,519 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+// Definitions to manage status conditions
+const (
+	// typeCompletedHanaExpressRestore represents the result of the restore
+	typeCompletedHanaExpressRestore = "Completed"
+)
+
+const (
+	// restoreLeaseAnnotation names the restore that holds the instance. Only the holder may
+	// stop and recover its databases.
+	restoreLeaseAnnotation = "db.sap-redhat.io/restore"
+	// restorePollInterval is how often a running recovery is checked
+	restorePollInterval = 15 * time.Second
+	// defaultRestoreTimeout bounds a restore without spec.timeout, including the connection
+	// that runs RECOVER DATA
+	defaultRestoreTimeout = 6 * time.Hour
+)
+
+// HanaExpressRestoreReconciler reconciles a HanaExpressRestore object
+type HanaExpressRestoreReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	SQL      hdbclient.Connector
+	// APIReader reads the restore lease from the API server instead of the cache
+	APIReader client.Reader
+
+	// recoveries are the running RECOVER DATA statements, by restore
+	recoveries asyncTasks[struct{}]
+}
+
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressrestores,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressrestores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressrestores/finalizers,verbs=update
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpressbackups,verbs=get;list;watch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpresses,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+
+// Reconcile recovers a database of a HanaExpress instance from a backup. Every phase is
+// persisted in the status before the next one starts. A tenant database is recovered over
+// SQL in the background, so an interrupted restore is followed in M_DATABASES instead of
+// being repeated. The SystemDB is recovered offline by a Job while the instance is stopped.
+// A lease annotation on the instance keeps two restores from running at once, and a restore
+// that does not finish within its timeout fails and releases the instance.
+func (r *HanaExpressRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	restore := &dbv1alpha1.HanaExpressRestore{}
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("HanaExpressRestore resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get HanaExpressRestore")
+		return ctrl.Result{}, err
+	}
+
+	// A restore runs only once
+	if restore.Status.Phase == dbv1alpha1.RestorePhaseCompleted || restore.Status.Phase == dbv1alpha1.RestorePhaseFailed {
+		return ctrl.Result{}, nil
+	}
+
+	database := databaseNameForRestore(restore)
+	if deadline := restoreDeadline(restore); deadline != nil && !time.Now().Before(*deadline) {
+		return ctrl.Result{}, r.failRestoreAfterDeadline(ctx, restore, database)
+	}
+
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: restore.Spec.HanaExpressName, Namespace: restore.Namespace}, hanaExpress)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, r.failRestore(ctx, restore, "HanaExpressNotFound",
+				fmt.Sprintf("HanaExpress %s not found", restore.Spec.HanaExpressName))
+		}
+		log.Error(err, "Failed to get HanaExpress")
+		return ctrl.Result{}, err
+	}
+
+	if hanaExpress.GetDeletionTimestamp() != nil {
+		return ctrl.Result{}, r.failRestore(ctx, restore, "HanaExpressDeleting",
+			fmt.Sprintf("HanaExpress %s is being deleted", hanaExpress.Name))
+	}
+
+	switch restore.Status.Phase {
+	case "", dbv1alpha1.RestorePhasePending:
+		source, err := r.sourceForRestore(ctx, restore)
+		if err != nil {
+			return ctrl.Result{}, r.failRestore(ctx, restore, "InvalidSource", err.Error())
+		}
+
+		// The SystemDB is recovered with the instance stopped, so it does not have to be
+		// available. A tenant is recovered over SQL once the SystemDB serves it.
+		if database != "SYSTEMDB" && !systemDBServesSQL(hanaExpress) {
+			if restore.Status.Phase != dbv1alpha1.RestorePhasePending {
+				restore.Status.Phase = dbv1alpha1.RestorePhasePending
+				restore.Status.Message = fmt.Sprintf("Waiting for HanaExpress %s to become available", hanaExpress.Name)
+				if err := r.Status().Update(ctx, restore); err != nil {
+					log.Error(err, "Failed to update HanaExpressRestore status")
+					return ctrl.Result{}, err
+				}
+			}
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+		}
+
+		holder, err := r.acquireRestoreLease(ctx, restore)
+		if err != nil {
+			log.Error(err, "Failed to acquire the restore lease")
+			return ctrl.Result{}, err
+		}
+		if holder != "" {
+			return ctrl.Result{}, r.failRestore(ctx, restore, "AlreadyRestoring",
+				fmt.Sprintf("HanaExpressRestore %s of HanaExpress %s is in progress", holder, hanaExpress.Name))
+		}
+
+		now := metav1.Now()
+		restore.Status.StartTime = &now
+		restore.Status.Source = source
+		if database == "SYSTEMDB" {
+			return r.setRestorePhase(ctx, restore, dbv1alpha1.RestorePhaseStoppingInstance,
+				fmt.Sprintf("Stopping HanaExpress %s to recover the SystemDB", hanaExpress.Name))
+		}
+		return r.setRestorePhase(ctx, restore, dbv1alpha1.RestorePhaseStoppingDatabase,
+			fmt.Sprintf("Stopping database %s", database))
+
+	case dbv1alpha1.RestorePhaseStoppingInstance, dbv1alpha1.RestorePhaseRecoveringSystem,
+		dbv1alpha1.RestorePhaseStartingInstance:
+		return r.reconcileSystemRestore(ctx, hanaExpress, restore)
+	}
+
+	db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to connect to the SystemDB")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	defer db.Close()
+
+	switch restore.Status.Phase {
+	case dbv1alpha1.RestorePhaseStoppingDatabase:
+		active, err := databaseIsActive(ctx, db, database)
+		if err != nil {
+			return ctrl.Result{}, r.failRestore(ctx, restore, "StopFailed", err.Error())
+		}
+		if active {
+			log.Info("Stopping database", "Database", database)
+			if _, err := hdbclient.ExecOnce(ctx, db, "ALTER SYSTEM STOP DATABASE "+hdbclient.QuoteIdentifier(database)); err != nil {
+				return ctrl.Result{}, r.failRestore(ctx, restore, "StopFailed", fmt.Sprintf("Failed to stop database %s: %s", database, err))
+			}
+		}
+
+		// The phase is persisted before the recovery is started, so that it is never started twice
+		now := metav1.Now()
+		restore.Status.RecoveryStartTime = &now
+		if _, err := r.setRestorePhase(ctx, restore, dbv1alpha1.RestorePhaseRecovering,
+			fmt.Sprintf("Recovering database %s from %s", database, restore.Status.Source)); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		log.Info("Recovering database", "Database", database, "Source", restore.Status.Source)
+		stmt := fmt.Sprintf("RECOVER DATA FOR %s USING FILE (%s) CLEAR LOG",
+			hdbclient.QuoteIdentifier(database), hdbclient.QuoteString(restore.Status.Source))
+		instance := hanaExpress.DeepCopy()
+		timeout := restoreTimeout(restore)
+		if deadline := restoreDeadline(restore); deadline != nil {
+			timeout = time.Until(*deadline)
+		}
+		r.recoveries.Start(ctx, restore.UID, timeout, func(ctx context.Context) (struct{}, error) {
+			db, err := openSystemDB(ctx, r.Client, r.SQL, instance)
+			if err != nil {
+				return struct{}{}, fmt.Errorf("%w: %s", errRecoveryNotStarted, err)
+			}
+			defer db.Close()
+
+			_, err = hdbclient.ExecOnce(ctx, db, stmt)
+			return struct{}{}, err
+		})
+		return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+
+	case dbv1alpha1.RestorePhaseRecovering:
+		return r.followRecovery(ctx, db, restore, database)
+
+	case dbv1alpha1.RestorePhaseStartingDatabase:
+		active, err := databaseIsActive(ctx, db, database)
+		if err != nil {
+			return ctrl.Result{}, r.failRestore(ctx, restore, "StartFailed", err.Error())
+		}
+		if !active {
+			log.Info("Starting database", "Database", database)
+			if _, err := hdbclient.ExecOnce(ctx, db, "ALTER SYSTEM START DATABASE "+hdbclient.QuoteIdentifier(database)); err != nil {
+				return ctrl.Result{}, r.failRestore(ctx, restore, "StartFailed",
+					fmt.Sprintf("Failed to start database %s: %s", database, err))
+			}
+		}
+
+		return ctrl.Result{}, r.completeRestore(ctx, restore, database)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// systemDBServesSQL reports whether the SystemDB of the instance accepts SQL. Besides a running
+// instance, that is the case when only the HXE tenant is down, e.g. after the SystemDB was
+// recovered and before the tenant is.
+func systemDBServesSQL(hanaExpress *dbv1alpha1.HanaExpress) bool {
+	available := meta.FindStatusCondition(hanaExpress.Status.Conditions, typeAvailableHanaExpress)
+	return available != nil && (available.Status == metav1.ConditionTrue ||
+		available.Reason == string(hdbclient.HealthReasonTenantDown))
+}
+
+// restoreDeadline returns the time a started restore has to finish by, or nil before it started
+func restoreDeadline(restore *dbv1alpha1.HanaExpressRestore) *time.Time {
+	if restore.Status.StartTime == nil {
+		return nil
+	}
+	deadline := restore.Status.StartTime.Add(restoreTimeout(restore))
+	return &deadline
+}
+
+// restoreTimeout returns spec.timeout or the default timeout
+func restoreTimeout(restore *dbv1alpha1.HanaExpressRestore) time.Duration {
+	if restore.Spec.Timeout == nil || restore.Spec.Timeout.Duration <= 0 {
+		return defaultRestoreTimeout
+	}
+	return restore.Spec.Timeout.Duration
+}
+
+// failRestoreAfterDeadline fails a restore that did not finish within its timeout. The
+// recovery Job of the SystemDB is deleted, so the instance can start again. RECOVER DATA of a
+// tenant was canceled with the deadline, the tenant may be left stopped or half recovered.
+func (r *HanaExpressRestoreReconciler) failRestoreAfterDeadline(ctx context.Context,
+	restore *dbv1alpha1.HanaExpressRestore, database string) error {
+	timeout := restoreTimeout(restore)
+	message := fmt.Sprintf("The restore did not finish within %s. Database %s may be stopped or partially "+
+		"recovered, check it in M_DATABASES and recover it again", timeout, database)
+	if database == "SYSTEMDB" {
+		if err := r.deleteRecoveryJob(ctx, restore); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to delete the recovery Job")
+			return err
+		}
+		message = fmt.Sprintf("The restore did not finish within %s. The recovery Job was stopped and "+
+			"HanaExpress %s is started on the data as the Job left it, check the SystemDB before using it",
+			timeout, restore.Spec.HanaExpressName)
+	}
+	return r.failRestore(ctx, restore, "DeadlineExceeded", message)
+}
+
+// completeRestore releases the instance and records the recovered database
+func (r *HanaExpressRestoreReconciler) completeRestore(ctx context.Context, restore *dbv1alpha1.HanaExpressRestore,
+	database string) error {
+	log := log.FromContext(ctx)
+
+	if err := r.releaseRestoreLease(ctx, restore); err != nil {
+		log.Error(err, "Failed to release the restore lease")
+		return err
+	}
+
+	now := metav1.Now()
+	restore.Status.Phase = dbv1alpha1.RestorePhaseCompleted
+	restore.Status.CompletionTime = &now
+	restore.Status.Message = fmt.Sprintf("Database %s recovered from %s", database, restore.Status.Source)
+	meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{Type: typeCompletedHanaExpressRestore,
+		Status: metav1.ConditionTrue, Reason: "RestoreSucceeded", Message: restore.Status.Message})
+	if err := r.Status().Update(ctx, restore); err != nil {
+		log.Error(err, "Failed to update HanaExpressRestore status")
+		return err
+	}
+
+	r.Recorder.Event(restore, "Normal", "RestoreCompleted", restore.Status.Message)
+	return nil
+}
+
+// databaseNameForRestore returns the database name in the upper case HANA uses in its catalog
+func databaseNameForRestore(restore *dbv1alpha1.HanaExpressRestore) string {
+	if restore.Spec.DatabaseName == "" {
+		return "HXE"
+	}
+	return strings.ToUpper(restore.Spec.DatabaseName)
+}
+
+// sourceForRestore resolves the file name prefix of the backup to recover from
+func (r *HanaExpressRestoreReconciler) sourceForRestore(ctx context.Context, restore *dbv1alpha1.HanaExpressRestore) (string, error) {
+	source := restore.Spec.Source
+	switch {
+	case source.BackupName != "" && source.Path != "":
+		return "", fmt.Errorf("only one of source.backupName and source.path may be set")
+	case source.Path != "":
+		return source.Path, nil
+	case source.BackupName == "":
+		return "", fmt.Errorf("one of source.backupName and source.path must be set")
+	}
+
+	backup := &dbv1alpha1.HanaExpressBackup{}
+	if err := r.Get(ctx, types.NamespacedName{Name: source.BackupName, Namespace: restore.Namespace}, backup); err != nil {
+		return "", fmt.Errorf("failed to get HanaExpressBackup %s: %w", source.BackupName, err)
+	}
+	if backup.Spec.HanaExpressName != restore.Spec.HanaExpressName {
+		return "", fmt.Errorf("HanaExpressBackup %s belongs to HanaExpress %s, its files are not available to %s",
+			backup.Name, backup.Spec.HanaExpressName, restore.Spec.HanaExpressName)
+	}
+	if backup.Status.Phase != dbv1alpha1.BackupPhaseCompleted {
+		return "", fmt.Errorf("HanaExpressBackup %s has not completed", backup.Name)
+	}
+	if backup.Spec.Method == dbv1alpha1.BackupMethodSnapshot {
+		return "", fmt.Errorf("HanaExpressBackup %s is a VolumeSnapshot, it is restored by cloning the instance from it", backup.Name)
+	}
+	if database := databaseNameForBackup(backup); database != databaseNameForRestore(restore) {
+		return "", fmt.Errorf("HanaExpressBackup %s is a backup of database %s, not of %s",
+			backup.Name, database, databaseNameForRestore(restore))
+	}
+	return backup.Status.Destination, nil
+}
+
+// errRecoveryNotStarted is returned by a recovery that failed before RECOVER DATA was sent
+var errRecoveryNotStarted = errors.New("the recovery was not started")
+
+// followRecovery polls the background recovery of the database. Only an error HANA returned
+// for RECOVER DATA, or a recovery that was never sent, fails the restore. When the result
+// is unknown, because the connection broke or the operator restarted, the recovery is not
+// started again: the restore waits for M_DATABASES to report the database as active.
+func (r *HanaExpressRestoreReconciler) followRecovery(ctx context.Context, db *sql.DB,
+	restore *dbv1alpha1.HanaExpressRestore, database string) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	started, done, _, err := r.recoveries.Result(restore.UID)
+	if started && !done {
+		return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+	}
+
+	if done {
+		var hdbErr interface{ Code() int }
+		switch {
+		case err == nil:
+			return r.setRestorePhase(ctx, restore, dbv1alpha1.RestorePhaseStartingDatabase,
+				fmt.Sprintf("Starting database %s", database))
+		case errors.Is(err, errRecoveryNotStarted), errors.As(err, &hdbErr):
+			return ctrl.Result{}, r.failRestore(ctx, restore, "RecoveryFailed",
+				fmt.Sprintf("Failed to recover database %s: %s", database, err))
+		}
+		log.Error(err, "Lost the connection running the recovery, following it in M_DATABASES", "Database", database)
+	}
+
+	active, err := databaseIsActive(ctx, db, database)
+	if err != nil {
+		log.Error(err, "Failed to query the state of the recovered database")
+		return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+	}
+	if active {
+		return r.setRestorePhase(ctx, restore, dbv1alpha1.RestorePhaseStartingDatabase,
+			fmt.Sprintf("Starting database %s", database))
+	}
+
+	message := fmt.Sprintf("The outcome of the recovery of database %s is unknown, waiting for the database to "+
+		"become active. Check the database and delete this restore to release the instance", database)
+	if restore.Status.Message != message {
+		restore.Status.Message = message
+		if err := r.Status().Update(ctx, restore); err != nil {
+			log.Error(err, "Failed to update HanaExpressRestore status")
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+}
+
+// acquireRestoreLease records the restore in the lease annotation of its instance and returns
+// an empty string, or returns the name of the restore that holds the lease. The annotation is
+// read from the API server and written with an optimistic lock, so of two restores acquiring
+// the lease at the same time one fails with a conflict. A lease of a restore that finished
+// or no longer exists is taken over.
+func (r *HanaExpressRestoreReconciler) acquireRestoreLease(ctx context.Context, restore *dbv1alpha1.HanaExpressRestore) (string, error) {
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	err := r.APIReader.Get(ctx, types.NamespacedName{Name: restore.Spec.HanaExpressName, Namespace: restore.Namespace}, hanaExpress)
+	if err != nil {
+		return "", err
+	}
+
+	holder := hanaExpress.Annotations[restoreLeaseAnnotation]
+	if holder == restore.Name {
+		return "", nil
+	}
+	if holder != "" {
+		other := &dbv1alpha1.HanaExpressRestore{}
+		err := r.APIReader.Get(ctx, types.NamespacedName{Name: holder, Namespace: restore.Namespace}, other)
+		switch {
+		case err == nil && other.Status.Phase != dbv1alpha1.RestorePhaseCompleted && other.Status.Phase != dbv1alpha1.RestorePhaseFailed:
+			return holder, nil
+		case err != nil && !apierrors.IsNotFound(err):
+			return "", err
+		}
+	}
+
+	patch := client.MergeFromWithOptions(hanaExpress.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	if hanaExpress.Annotations == nil {
+		hanaExpress.Annotations = map[string]string{}
+	}
+	hanaExpress.Annotations[restoreLeaseAnnotation] = restore.Name
+	return "", r.Patch(ctx, hanaExpress, patch)
+}
+
+// releaseRestoreLease removes the lease annotation from the instance if the restore holds it
+func (r *HanaExpressRestoreReconciler) releaseRestoreLease(ctx context.Context, restore *dbv1alpha1.HanaExpressRestore) error {
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	err := r.APIReader.Get(ctx, types.NamespacedName{Name: restore.Spec.HanaExpressName, Namespace: restore.Namespace}, hanaExpress)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if hanaExpress.Annotations[restoreLeaseAnnotation] != restore.Name {
+		return nil
+	}
+
+	patch := client.MergeFromWithOptions(hanaExpress.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	delete(hanaExpress.Annotations, restoreLeaseAnnotation)
+	return r.Patch(ctx, hanaExpress, patch)
+}
+
+// databaseIsActive reports whether the database is running according to M_DATABASES
+func databaseIsActive(ctx context.Context, db *sql.DB, database string) (bool, error) {
+	var status string
+	err := db.QueryRowContext(ctx, "SELECT ACTIVE_STATUS FROM M_DATABASES WHERE DATABASE_NAME = ?", database).Scan(&status)
+	if err == sql.ErrNoRows {
+		return false, fmt.Errorf("database %s does not exist", database)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to query the status of database %s: %w", database, err)
+	}
+	return status == "YES", nil
+}
+
+// setRestorePhase records the next phase of the restore and requeues it
+func (r *HanaExpressRestoreReconciler) setRestorePhase(ctx context.Context, restore *dbv1alpha1.HanaExpressRestore,
+	phase dbv1alpha1.RestorePhase, message string) (ctrl.Result, error) {
+	restore.Status.Phase = phase
+	restore.Status.Message = message
+	meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{Type: typeCompletedHanaExpressRestore,
+		Status: metav1.ConditionFalse, Reason: string(phase), Message: message})
+
+	if err := r.Status().Update(ctx, restore); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update HanaExpressRestore status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// failRestore records a failed restore with the given reason
+func (r *HanaExpressRestoreReconciler) failRestore(ctx context.Context, restore *dbv1alpha1.HanaExpressRestore,
+	reason, message string) error {
+	if err := r.releaseRestoreLease(ctx, restore); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to release the restore lease")
+		return err
+	}
+
+	now := metav1.Now()
+	restore.Status.Phase = dbv1alpha1.RestorePhaseFailed
+	restore.Status.CompletionTime = &now
+	restore.Status.Message = message
+	meta.SetStatusCondition(&restore.Status.Conditions, metav1.Condition{Type: typeCompletedHanaExpressRestore,
+		Status: metav1.ConditionFalse, Reason: reason, Message: message})
+
+	if err := r.Status().Update(ctx, restore); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update HanaExpressRestore status")
+		return err
+	}
+
+	r.Recorder.Event(restore, "Warning", "RestoreFailed", message)
+	return nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HanaExpressRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1alpha1.HanaExpressRestore{}).
+		Owns(&batchv1.Job{}).
+		Complete(withReconcileTimeout(r))
+}
@@ -0,0 +1,456 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func TestRestoreLease(t *testing.T) {
+	tests := []struct {
+		name   string
+		held   bool
+		holder *dbv1alpha1.HanaExpressRestore
+		lease  string
+		phase  dbv1alpha1.RestorePhase
+	}{
+		{
+			name:  "free instance",
+			lease: "second",
+			phase: dbv1alpha1.RestorePhaseStoppingDatabase,
+		},
+		{
+			name: "instance held by a running restore",
+			held: true,
+			holder: &dbv1alpha1.HanaExpressRestore{ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: testNamespace},
+				Status: dbv1alpha1.HanaExpressRestoreStatus{Phase: dbv1alpha1.RestorePhaseRecovering}},
+			lease: "first",
+			phase: dbv1alpha1.RestorePhaseFailed,
+		},
+		{
+			name: "lease of a finished restore",
+			held: true,
+			holder: &dbv1alpha1.HanaExpressRestore{ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: testNamespace},
+				Status: dbv1alpha1.HanaExpressRestoreStatus{Phase: dbv1alpha1.RestorePhaseCompleted}},
+			lease: "second",
+			phase: dbv1alpha1.RestorePhaseStoppingDatabase,
+		},
+		{
+			name:  "lease of a deleted restore",
+			held:  true,
+			lease: "second",
+			phase: dbv1alpha1.RestorePhaseStoppingDatabase,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, _ := testHanaExpress()
+			objects := []client.Object{testRestore("second")}
+			if tt.holder != nil {
+				objects = append(objects, tt.holder)
+			}
+			if tt.held {
+				hanaExpress.Annotations = map[string]string{restoreLeaseAnnotation: "first"}
+			}
+			r, _ := newTestRestoreReconciler(hanaExpress, objects...)
+
+			_, restore := reconcileRestore(t, r, "second")
+			if restore.Status.Phase != tt.phase {
+				t.Errorf("phase = %s (%s), want %s", restore.Status.Phase, restore.Status.Message, tt.phase)
+			}
+			if holder := restoreLeaseHolder(t, r); holder != tt.lease {
+				t.Errorf("lease held by %q, want %q", holder, tt.lease)
+			}
+		})
+	}
+}
+
+func TestRestoreRecoversOnce(t *testing.T) {
+	hanaExpress, _ := testHanaExpress()
+	hanaExpress.Annotations = map[string]string{restoreLeaseAnnotation: "nightly"}
+	restore := testRestore("nightly")
+	restore.Status = dbv1alpha1.HanaExpressRestoreStatus{Phase: dbv1alpha1.RestorePhaseStoppingDatabase,
+		Source: "/hana/backup/nightly/hxe"}
+	r, connector := newTestRestoreReconciler(hanaExpress, restore)
+
+	mock := connector.expectSQL(t)
+	expectDatabaseStatus(mock, "YES")
+	mock.ExpectExec(`ALTER SYSTEM STOP DATABASE "HXE"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	recovery := connector.expectSQL(t)
+	recovery.ExpectExec(recoverStatement).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	result, restore := reconcileRestore(t, r, "nightly")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseRecovering || restore.Status.RecoveryStartTime == nil {
+		t.Fatalf("phase = %s, recovery started at %v", restore.Status.Phase, restore.Status.RecoveryStartTime)
+	}
+	if result.RequeueAfter != restorePollInterval {
+		t.Errorf("requeue after %s, want %s", result.RequeueAfter, restorePollInterval)
+	}
+
+	// The recovery runs in the background, Reconcile only polls it
+	if err := waitFor(func() bool { return recovery.ExpectationsWereMet() == nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitFor(func() bool { return r.recoveries.finished(restore.UID) }); err != nil {
+		t.Fatal(err)
+	}
+	connector.expectSQL(t)
+	_, restore = reconcileRestore(t, r, "nightly")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseStartingDatabase {
+		t.Fatalf("phase = %s after the recovery, want %s", restore.Status.Phase, dbv1alpha1.RestorePhaseStartingDatabase)
+	}
+
+	mock = connector.expectSQL(t)
+	expectDatabaseStatus(mock, "YES")
+	_, restore = reconcileRestore(t, r, "nightly")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseCompleted {
+		t.Fatalf("phase = %s, want %s", restore.Status.Phase, dbv1alpha1.RestorePhaseCompleted)
+	}
+	if holder := restoreLeaseHolder(t, r); holder != "" {
+		t.Errorf("lease still held by %q", holder)
+	}
+}
+
+func TestFollowRecovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   error
+		finished bool
+		status   string
+		phase    dbv1alpha1.RestorePhase
+	}{
+		{
+			name:   "operator restarted while the database recovers",
+			status: "NO",
+			phase:  dbv1alpha1.RestorePhaseRecovering,
+		},
+		{
+			name:   "operator restarted after the recovery",
+			status: "YES",
+			phase:  dbv1alpha1.RestorePhaseStartingDatabase,
+		},
+		{
+			name:     "connection lost during the recovery",
+			result:   errors.New("read tcp: connection reset by peer"),
+			finished: true,
+			status:   "NO",
+			phase:    dbv1alpha1.RestorePhaseRecovering,
+		},
+		{
+			name:     "recovery rejected by HANA",
+			result:   sqlError{code: 448},
+			finished: true,
+			phase:    dbv1alpha1.RestorePhaseFailed,
+		},
+		{
+			name:     "recovery not sent",
+			result:   fmt.Errorf("%w: connection refused", errRecoveryNotStarted),
+			finished: true,
+			phase:    dbv1alpha1.RestorePhaseFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, _ := testHanaExpress()
+			hanaExpress.Annotations = map[string]string{restoreLeaseAnnotation: "nightly"}
+			start := metav1.Now()
+			restore := testRestore("nightly")
+			restore.Status = dbv1alpha1.HanaExpressRestoreStatus{Phase: dbv1alpha1.RestorePhaseRecovering,
+				Source: "/hana/backup/nightly/hxe", RecoveryStartTime: &start}
+			r, connector := newTestRestoreReconciler(hanaExpress, restore)
+
+			if tt.finished {
+				r.recoveries.Start(context.Background(), restore.UID, defaultRestoreTimeout,
+					func(ctx context.Context) (struct{}, error) { return struct{}{}, tt.result })
+				if err := waitFor(func() bool { return r.recoveries.finished(restore.UID) }); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// RECOVER DATA is never sent again
+			mock := connector.expectSQL(t)
+			if tt.status != "" {
+				expectDatabaseStatus(mock, tt.status)
+			}
+			_, restore = reconcileRestore(t, r, "nightly")
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+			if restore.Status.Phase != tt.phase {
+				t.Errorf("phase = %s (%s), want %s", restore.Status.Phase, restore.Status.Message, tt.phase)
+			}
+			holder := restoreLeaseHolder(t, r)
+			if (tt.phase == dbv1alpha1.RestorePhaseFailed) != (holder == "") {
+				t.Errorf("lease held by %q in phase %s", holder, restore.Status.Phase)
+			}
+		})
+	}
+}
+
+func TestSystemRestore(t *testing.T) {
+	// The SystemDB is recovered on an instance that is not available
+	hanaExpress, _ := testHanaExpress()
+	hanaExpress.Status.Conditions = nil
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "hxe-0", Namespace: testNamespace}}
+	sts := testStatefulSet(testFromImage)
+	r, connector := newTestRestoreReconciler(hanaExpress, testSystemRestore(), pod, sts)
+
+	_, restore := reconcileRestore(t, r, "system")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseStoppingInstance {
+		t.Fatalf("phase = %s (%s), want %s", restore.Status.Phase, restore.Status.Message, dbv1alpha1.RestorePhaseStoppingInstance)
+	}
+	if holder := restoreLeaseHolder(t, r); holder != "system" {
+		t.Fatalf("lease held by %q", holder)
+	}
+
+	// The restore waits for the HanaExpress controller to scale the instance down
+	result, restore := reconcileRestore(t, r, "system")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseStoppingInstance || result.RequeueAfter != restorePollInterval {
+		t.Fatalf("phase = %s, requeue after %s while the pod runs", restore.Status.Phase, result.RequeueAfter)
+	}
+	if err := r.Delete(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+	_, restore = reconcileRestore(t, r, "system")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseRecoveringSystem || restore.Status.RecoveryStartTime == nil {
+		t.Fatalf("phase = %s, recovery started at %v", restore.Status.Phase, restore.Status.RecoveryStartTime)
+	}
+
+	_, restore = reconcileRestore(t, r, "system")
+	job := &batchv1.Job{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: "system-recovery", Namespace: testNamespace}, job); err != nil {
+		t.Fatalf("recovery Job: %v", err)
+	}
+	if !metav1.IsControlledBy(job, restore) {
+		t.Error("the recovery Job is not owned by the restore")
+	}
+	if *job.Spec.BackoffLimit != 0 || job.Spec.ActiveDeadlineSeconds == nil ||
+		*job.Spec.ActiveDeadlineSeconds > int64(defaultRestoreTimeout.Seconds()) {
+		t.Errorf("backoff limit %d, active deadline %v", *job.Spec.BackoffLimit, job.Spec.ActiveDeadlineSeconds)
+	}
+	podSpec := job.Spec.Template.Spec
+	if podSpec.Hostname != "hxe-0" || podSpec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("hostname %q, restart policy %s", podSpec.Hostname, podSpec.RestartPolicy)
+	}
+	container := podSpec.Containers[0]
+	if container.Image != testFromImage {
+		t.Errorf("image = %s, want the image of the StatefulSet", container.Image)
+	}
+	if env := container.Env[0]; env.Name != recoverCommandEnv ||
+		env.Value != `RECOVER DATA USING FILE ('pre-upgrade-20231001') CLEAR LOG` {
+		t.Errorf("env = %v", env)
+	}
+	claims := []string{}
+	for _, volume := range podSpec.Volumes {
+		claims = append(claims, volume.PersistentVolumeClaim.ClaimName)
+	}
+	if strings.Join(claims, ",") != "data-hxe-0,hxe-backup" {
+		t.Errorf("volumes of the claims %v, want the data and the backup PVC", claims)
+	}
+
+	// A second reconcile follows the Job instead of creating another one
+	_, restore = reconcileRestore(t, r, "system")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseRecoveringSystem {
+		t.Fatalf("phase = %s while the Job runs", restore.Status.Phase)
+	}
+	setRecoveryJobCondition(t, r, batchv1.JobComplete)
+	_, restore = reconcileRestore(t, r, "system")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseStartingInstance {
+		t.Fatalf("phase = %s after the Job completed", restore.Status.Phase)
+	}
+
+	connector.err = errors.New("connection refused")
+	_, restore = reconcileRestore(t, r, "system")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseStartingInstance {
+		t.Fatalf("phase = %s before the SystemDB accepts connections", restore.Status.Phase)
+	}
+
+	// The SystemDB runs with the password of the backup
+	connector.err = sqlError{code: 10}
+	_, restore = reconcileRestore(t, r, "system")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseCompleted {
+		t.Fatalf("phase = %s (%s), want %s", restore.Status.Phase, restore.Status.Message, dbv1alpha1.RestorePhaseCompleted)
+	}
+	if holder := restoreLeaseHolder(t, r); holder != "" {
+		t.Errorf("lease still held by %q", holder)
+	}
+}
+
+func TestFailedSystemRecoveryReleasesTheInstance(t *testing.T) {
+	hanaExpress, _ := testHanaExpress()
+	hanaExpress.Annotations = map[string]string{restoreLeaseAnnotation: "system"}
+	start := metav1.Now()
+	restore := testSystemRestore()
+	restore.Status = dbv1alpha1.HanaExpressRestoreStatus{Phase: dbv1alpha1.RestorePhaseRecoveringSystem,
+		Source: "pre-upgrade-20231001", StartTime: &start, RecoveryStartTime: &start}
+	r, _ := newTestRestoreReconciler(hanaExpress, restore, testStatefulSet(testFromImage))
+
+	reconcileRestore(t, r, "system")
+	setRecoveryJobCondition(t, r, batchv1.JobFailed)
+	_, restore = reconcileRestore(t, r, "system")
+	if restore.Status.Phase != dbv1alpha1.RestorePhaseFailed || !strings.Contains(restore.Status.Message, "system-recovery") {
+		t.Errorf("phase = %s (%s), want %s naming the Job", restore.Status.Phase, restore.Status.Message, dbv1alpha1.RestorePhaseFailed)
+	}
+	if holder := restoreLeaseHolder(t, r); holder != "" {
+		t.Errorf("lease still held by %q", holder)
+	}
+}
+
+func TestRestoreDeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		restore  *dbv1alpha1.HanaExpressRestore
+		phase    dbv1alpha1.RestorePhase
+		timeout  *metav1.Duration
+		started  time.Duration
+		exceeded bool
+	}{
+		{
+			name:    "tenant recovery within the default timeout",
+			restore: testRestore("nightly"),
+			phase:   dbv1alpha1.RestorePhaseRecovering,
+			started: 5 * time.Hour,
+		},
+		{
+			name:     "tenant recovery with an unknown outcome after the default timeout",
+			restore:  testRestore("nightly"),
+			phase:    dbv1alpha1.RestorePhaseRecovering,
+			started:  7 * time.Hour,
+			exceeded: true,
+		},
+		{
+			name:     "tenant recovery after spec.timeout",
+			restore:  testRestore("nightly"),
+			phase:    dbv1alpha1.RestorePhaseRecovering,
+			timeout:  &metav1.Duration{Duration: time.Hour},
+			started:  2 * time.Hour,
+			exceeded: true,
+		},
+		{
+			name:     "SystemDB recovery after the default timeout",
+			restore:  testSystemRestore(),
+			phase:    dbv1alpha1.RestorePhaseRecoveringSystem,
+			started:  7 * time.Hour,
+			exceeded: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, _ := testHanaExpress()
+			hanaExpress.Annotations = map[string]string{restoreLeaseAnnotation: tt.restore.Name}
+			start := metav1.NewTime(time.Now().Add(-tt.started))
+			restore := tt.restore
+			restore.Spec.Timeout = tt.timeout
+			restore.Status = dbv1alpha1.HanaExpressRestoreStatus{Phase: tt.phase, Source: "/hana/backup/nightly/hxe",
+				StartTime: &start, RecoveryStartTime: &start}
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: recoveryJobName(restore), Namespace: testNamespace}}
+			r, connector := newTestRestoreReconciler(hanaExpress, restore, job)
+
+			// A recovery that is not over yet
+			mock := connector.expectSQL(t)
+			expectDatabaseStatus(mock, "NO")
+			_, restore = reconcileRestore(t, r, restore.Name)
+
+			if exceeded := restore.Status.Phase == dbv1alpha1.RestorePhaseFailed; exceeded != tt.exceeded {
+				t.Fatalf("phase = %s (%s), deadline exceeded %t", restore.Status.Phase, restore.Status.Message, tt.exceeded)
+			}
+			if !tt.exceeded {
+				return
+			}
+			condition := meta.FindStatusCondition(restore.Status.Conditions, typeCompletedHanaExpressRestore)
+			if condition == nil || condition.Reason != "DeadlineExceeded" {
+				t.Errorf("condition = %v, want the reason DeadlineExceeded", condition)
+			}
+			if holder := restoreLeaseHolder(t, r); holder != "" {
+				t.Errorf("lease still held by %q", holder)
+			}
+			err := r.Get(context.Background(), client.ObjectKeyFromObject(job), &batchv1.Job{})
+			if deleted := apierrors.IsNotFound(err); deleted != (restore.Spec.DatabaseName == "SYSTEMDB") {
+				t.Errorf("recovery Job deleted %t (%v)", deleted, err)
+			}
+		})
+	}
+}
+
+func TestSourceForRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		database string
+		method   dbv1alpha1.BackupMethod
+		err      string
+	}{
+		{
+			name:     "file backup of the restored database",
+			database: "hxe",
+		},
+		{
+			name:     "backup of another database",
+			database: "SYSTEMDB",
+			err:      "is a backup of database SYSTEMDB, not of HXE",
+		},
+		{
+			name:   "snapshot backup",
+			method: dbv1alpha1.BackupMethodSnapshot,
+			err:    "is restored by cloning",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup := &dbv1alpha1.HanaExpressBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: testNamespace},
+				Spec:       dbv1alpha1.HanaExpressBackupSpec{HanaExpressName: "hxe", DatabaseName: tt.database, Method: tt.method},
+				Status: dbv1alpha1.HanaExpressBackupStatus{Phase: dbv1alpha1.BackupPhaseCompleted,
+					Destination: "/hana/backup/nightly/hxe"},
+			}
+			restore := testRestore("nightly")
+			restore.Spec.Source = dbv1alpha1.RestoreSource{BackupName: "nightly"}
+			r, _ := newTestRestoreReconciler(nil, backup)
+
+			source, err := r.sourceForRestore(context.Background(), restore)
+			switch {
+			case tt.err != "":
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("err = %v, want %q", err, tt.err)
+				}
+			case err != nil:
+				t.Fatal(err)
+			case source != backup.Status.Destination:
+				t.Errorf("source = %s, want %s", source, backup.Status.Destination)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+const (
+	// recoverCommandEnv passes the RECOVER DATA statement to the recovery Job, so the backup
+	// prefix is never interpreted by the shell
+	recoverCommandEnv = "RECOVER_COMMAND"
+
+	// systemRecoveryScript starts the SAP start service of the stopped instance, recovers the
+	// SystemDB with recoverSys.py, which starts HANA on the recovered data, and stops HANA
+	// again so the instance starts from a cleanly shut down data volume
+	systemRecoveryScript = `set -e
+cd /usr/sap/HXE/HDB90
+./HDBSettings.sh sapcontrol -nr 90 -function StartService HXE
+./HDBSettings.sh recoverSys.py --command="$` + recoverCommandEnv + `" --wait
+./HDBSettings.sh HDB stop
+`
+)
+
+// recoveryJobName returns the name of the Job that recovers the SystemDB for the restore
+func recoveryJobName(restore *dbv1alpha1.HanaExpressRestore) string {
+	return restore.Name + "-recovery"
+}
+
+// reconcileSystemRestore recovers the SystemDB offline. The HanaExpress controller scales the
+// StatefulSet to zero while the restore holding the lease stops the instance or recovers it,
+// a Job then runs recoverSys.py on the data volume, and the restore completes once the
+// restarted SystemDB accepts connections again. The Job is the persisted record of the
+// recovery, so an operator restart follows it instead of starting it again.
+func (r *HanaExpressRestoreReconciler) reconcileSystemRestore(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	restore *dbv1alpha1.HanaExpressRestore) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	switch restore.Status.Phase {
+	case dbv1alpha1.RestorePhaseStoppingInstance:
+		pod := &corev1.Pod{}
+		err := r.Get(ctx, types.NamespacedName{Name: hanaExpress.Name + "-0", Namespace: hanaExpress.Namespace}, pod)
+		if err == nil {
+			return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+		}
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "Failed to get the HanaExpress pod")
+			return ctrl.Result{}, err
+		}
+
+		// The phase is persisted before the Job is created, so that it is never created twice
+		now := metav1.Now()
+		restore.Status.RecoveryStartTime = &now
+		return r.setRestorePhase(ctx, restore, dbv1alpha1.RestorePhaseRecoveringSystem,
+			fmt.Sprintf("Recovering the SystemDB from %s", restore.Status.Source))
+
+	case dbv1alpha1.RestorePhaseRecoveringSystem:
+		job := &batchv1.Job{}
+		err := r.Get(ctx, types.NamespacedName{Name: recoveryJobName(restore), Namespace: restore.Namespace}, job)
+		if err != nil && apierrors.IsNotFound(err) {
+			job, err := r.recoveryJobForRestore(ctx, hanaExpress, restore)
+			if err != nil {
+				return ctrl.Result{}, r.failRestore(ctx, restore, "RecoveryFailed",
+					fmt.Sprintf("Failed to define the recovery Job: %s", err))
+			}
+
+			log.Info("Recovering the SystemDB", "Job.Name", job.Name, "Source", restore.Status.Source)
+			if err := r.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+				log.Error(err, "Failed to create the recovery Job", "Job.Name", job.Name)
+				return ctrl.Result{}, err
+			}
+			// The Job status triggers the next reconciliation through the Owns watch
+			return ctrl.Result{}, nil
+		} else if err != nil {
+			log.Error(err, "Failed to get the recovery Job")
+			return ctrl.Result{}, err
+		}
+
+		for _, condition := range job.Status.Conditions {
+			if condition.Status != corev1.ConditionTrue {
+				continue
+			}
+			switch condition.Type {
+			case batchv1.JobComplete:
+				return r.setRestorePhase(ctx, restore, dbv1alpha1.RestorePhaseStartingInstance,
+					fmt.Sprintf("Starting HanaExpress %s on the recovered SystemDB", hanaExpress.Name))
+			case batchv1.JobFailed:
+				return ctrl.Result{}, r.failRestore(ctx, restore, "RecoveryFailed",
+					fmt.Sprintf("The recovery of the SystemDB failed: %s. See the logs of Job %s", condition.Message, job.Name))
+			}
+		}
+		return ctrl.Result{}, nil
+
+	case dbv1alpha1.RestorePhaseStartingInstance:
+		// The SystemDB runs with the SYSTEM password of the backup, a rejected logon already
+		// shows that it is up
+		db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+		var hdbErr interface{ Code() int }
+		if err != nil && !errors.As(err, &hdbErr) {
+			log.Info("Waiting for the recovered SystemDB to accept connections", "Error", err.Error())
+			return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+		}
+		if db != nil {
+			db.Close()
+		}
+
+		return ctrl.Result{}, r.completeRestore(ctx, restore, "SYSTEMDB")
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// recoveryJobForRestore returns the Job that recovers the SystemDB. It runs the image of the
+// instance on its data volume, and on its backup volume when it has one, as the HANA user and
+// with the host name of the instance pod, so HANA finds its installation, its data and its
+// host where the instance left them. It is never retried and ends with the restore deadline.
+func (r *HanaExpressRestoreReconciler) recoveryJobForRestore(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	restore *dbv1alpha1.HanaExpressRestore) (*batchv1.Job, error) {
+	sts := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, types.NamespacedName{Name: hanaExpress.Name, Namespace: hanaExpress.Namespace}, sts); err != nil {
+		return nil, fmt.Errorf("failed to get StatefulSet %s: %w", hanaExpress.Name, err)
+	}
+	image := ""
+	for _, container := range sts.Spec.Template.Spec.Containers {
+		if container.Name == hanaExpressContainerName {
+			image = container.Image
+		}
+	}
+	if image == "" {
+		return nil, fmt.Errorf("StatefulSet %s has no %s container", sts.Name, hanaExpressContainerName)
+	}
+
+	deadline := int64(restoreTimeout(restore).Seconds())
+	if until := restoreDeadline(restore); until != nil {
+		deadline = int64(time.Until(*until).Seconds())
+	}
+	if deadline < 1 {
+		deadline = 1
+	}
+
+	podSpec := corev1.PodSpec{
+		RestartPolicy: corev1.RestartPolicyNever,
+		Hostname:      hanaExpress.Name + "-0",
+		SecurityContext: &corev1.PodSecurityContext{
+			FSGroup:             &[]int64{79}[0],
+			FSGroupChangePolicy: &[]corev1.PodFSGroupChangePolicy{corev1.FSGroupChangeOnRootMismatch}[0],
+		},
+		Volumes: []corev1.Volume{
+			{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: dataVolumeClaimName(hanaExpress),
+					},
+				},
+			},
+		},
+		InitContainers: []corev1.Container{
+			{
+				Image:   "registry.access.redhat.com/ubi8/ubi:8.5-239.1651231664",
+				Name:    "set-data-dir-ownership",
+				Command: []string{"sh", "-c", "chown 12000:79 /hana/mounts"},
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "data",
+						MountPath: "/hana/mounts",
+					},
+				},
+			},
+		},
+		Containers: []corev1.Container{
+			{
+				Image:           image,
+				Name:            hanaExpressContainerName,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				SecurityContext: &corev1.SecurityContext{
+					RunAsNonRoot: &[]bool{true}[0],
+					RunAsUser:    &[]int64{12000}[0],
+					RunAsGroup:   &[]int64{79}[0],
+				},
+				Command: []string{"/bin/sh", "-c", systemRecoveryScript},
+				Env: []corev1.EnvVar{
+					{
+						Name: recoverCommandEnv,
+						Value: fmt.Sprintf("RECOVER DATA USING FILE (%s) CLEAR LOG",
+							hdbclient.QuoteString(restore.Status.Source)),
+					},
+				},
+				Resources: hanaExpress.Spec.Resources,
+				VolumeMounts: []corev1.VolumeMount{
+					{
+						Name:      "data",
+						MountPath: "/hana/mounts",
+					},
+				},
+			},
+		},
+	}
+	if hanaExpress.Spec.BackupPVCSize != "" {
+		addBackupVolume(hanaExpress, &podSpec)
+	}
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      recoveryJobName(restore),
+			Namespace: restore.Namespace,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &[]int32{0}[0],
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				Spec: podSpec,
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(restore, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// deleteRecoveryJob stops the recovery Job of the restore and its pod
+func (r *HanaExpressRestoreReconciler) deleteRecoveryJob(ctx context.Context, restore *dbv1alpha1.HanaExpressRestore) error {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: recoveryJobName(restore), Namespace: restore.Namespace}}
+	err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	return client.IgnoreNotFound(err)
+}
+
+// instanceStoppedForRestore returns the name of the restore that stopped the instance to
+// recover its SystemDB, or an empty string. Only the holder of the restore lease counts, and
+// only while it stops the instance or runs the recovery Job.
+func (r *HanaExpressReconciler) instanceStoppedForRestore(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress) (string, error) {
+	holder := hanaExpress.Annotations[restoreLeaseAnnotation]
+	if holder == "" {
+		return "", nil
+	}
+
+	restore := &dbv1alpha1.HanaExpressRestore{}
+	if err := r.Get(ctx, types.NamespacedName{Name: holder, Namespace: hanaExpress.Namespace}, restore); err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	switch restore.Status.Phase {
+	case dbv1alpha1.RestorePhaseStoppingInstance, dbv1alpha1.RestorePhaseRecoveringSystem:
+		return holder, nil
+	}
+	return "", nil
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+// Definitions to manage status conditions
+const (
+	// typeReadyHanaGrant represents whether the privileges of the grantee match the spec
+	typeReadyHanaGrant = "Ready"
+)
+
+// HanaGrantReconciler reconciles a HanaGrant object
+type HanaGrantReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	SQL      hdbclient.Connector
+}
+
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanagrants,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanagrants/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanagrants/finalizers,verbs=update
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanatenantdatabases,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile grants the roles and privileges described by a HanaGrant to its grantee, revokes
+// the ones it granted before that are no longer listed, and revokes all of them when the
+// resource is deleted. Privileges granted to the grantee in other ways are left alone.
+func (r *HanaGrantReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	grant := &dbv1alpha1.HanaGrant{}
+	if err := r.Get(ctx, req.NamespacedName, grant); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("HanaGrant resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get HanaGrant")
+		return ctrl.Result{}, err
+	}
+
+	grantee := strings.ToUpper(grant.Spec.Grantee)
+	database := strings.ToUpper(grant.Spec.DatabaseName)
+	if database == "" {
+		database = "HXE"
+	}
+
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: grant.Spec.HanaExpressName, Namespace: grant.Namespace}, hanaExpress)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get HanaExpress")
+		return ctrl.Result{}, err
+	}
+	hanaExpressGone := apierrors.IsNotFound(err) || hanaExpress.GetDeletionTimestamp() != nil
+
+	if grant.GetDeletionTimestamp() != nil {
+		if !controllerutil.ContainsFinalizer(grant, hanaExpressFinalizer) {
+			return ctrl.Result{}, nil
+		}
+
+		if !hanaExpressGone && len(grant.Status.Applied) > 0 {
+			if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+				log.Info("Waiting for HanaExpress to become available to revoke the privileges")
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+			if result, err := r.revokeAll(ctx, hanaExpress, grant); err != nil || !result.IsZero() {
+				return result, err
+			}
+		}
+
+		log.Info("Removing Finalizer for HanaGrant")
+		controllerutil.RemoveFinalizer(grant, hanaExpressFinalizer)
+		if err := r.Update(ctx, grant); err != nil {
+			log.Error(err, "Failed to remove finalizer for HanaGrant")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(grant, hanaExpressFinalizer) {
+		log.Info("Adding Finalizer for HanaGrant")
+		controllerutil.AddFinalizer(grant, hanaExpressFinalizer)
+		if err := r.Update(ctx, grant); err != nil {
+			log.Error(err, "Failed to update custom resource to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if grant.Status.Grantee != "" && (grant.Status.Grantee != grantee || grant.Status.DatabaseName != database) {
+		return r.setGrantStatus(ctx, grant, metav1.ConditionFalse, "GranteeChanged",
+			fmt.Sprintf("grantee and databaseName cannot be changed from %s in %s, create a new HanaGrant instead",
+				grant.Status.Grantee, grant.Status.DatabaseName), 0)
+	}
+
+	if hanaExpressGone {
+		return r.setGrantStatus(ctx, grant, metav1.ConditionFalse, "HanaExpressNotFound",
+			fmt.Sprintf("HanaExpress %s not found", grant.Spec.HanaExpressName), 30*time.Second)
+	}
+
+	if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+		return r.setGrantStatus(ctx, grant, metav1.ConditionFalse, "HanaExpressUnavailable",
+			fmt.Sprintf("Waiting for HanaExpress %s to become available", hanaExpress.Name), 30*time.Second)
+	}
+
+	desired, err := desiredPrivilegeGrants(grant.Spec.Privileges)
+	if err != nil {
+		return r.setGrantStatus(ctx, grant, metav1.ConditionFalse, "InvalidPrivilege", err.Error(), 0)
+	}
+
+	db, err := openDatabase(ctx, r.Client, r.SQL, hanaExpress, database)
+	if err != nil {
+		log.Error(err, "Failed to connect to the database", "Database", database)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	defer db.Close()
+
+	result, err := syncPrivileges(ctx, db, grantee, desired, appliedBy(grant.Status.Applied))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	result.LastSyncTime = &now
+	grant.Status.Grantee = grantee
+	grant.Status.DatabaseName = database
+	grant.Status.PrivilegeSyncStatus = result
+	condition := privilegeSyncCondition(typeReadyHanaGrant, result)
+	if condition.Status == metav1.ConditionFalse {
+		return r.setGrantStatus(ctx, grant, condition.Status, condition.Reason, condition.Message, time.Minute)
+	}
+	// Compare with the catalog again later in case privileges were granted or revoked in SQL
+	return r.setGrantStatus(ctx, grant, condition.Status, condition.Reason, condition.Message, 5*time.Minute)
+}
+
+// revokeAll revokes the privileges the HanaGrant granted and requeues while a revoke fails
+func (r *HanaGrantReconciler) revokeAll(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	grant *dbv1alpha1.HanaGrant) (ctrl.Result, error) {
+	db, err := openDatabase(ctx, r.Client, r.SQL, hanaExpress, grant.Status.DatabaseName)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to connect to the database", "Database", grant.Status.DatabaseName)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	defer db.Close()
+
+	result, err := syncPrivileges(ctx, db, grant.Status.Grantee, nil, appliedBy(grant.Status.Applied))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	grant.Status.PrivilegeSyncStatus = result
+	if len(result.FailedStatements) > 0 {
+		condition := privilegeSyncCondition(typeReadyHanaGrant, result)
+		return r.setGrantStatus(ctx, grant, condition.Status, condition.Reason, condition.Message, 30*time.Second)
+	}
+
+	r.Recorder.Event(grant, "Normal", "Revoked", fmt.Sprintf("Revoked the privileges of %s", grant.Status.Grantee))
+	return ctrl.Result{}, nil
+}
+
+// appliedBy returns whether a privilege is one the resource granted
+func appliedBy(applied []string) func(key string) bool {
+	return func(key string) bool {
+		for _, a := range applied {
+			if a == key {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// setGrantStatus records the Ready condition and requeues after the given delay
+func (r *HanaGrantReconciler) setGrantStatus(ctx context.Context, grant *dbv1alpha1.HanaGrant,
+	status metav1.ConditionStatus, reason, message string, requeueAfter time.Duration) (ctrl.Result, error) {
+	ready := meta.FindStatusCondition(grant.Status.Conditions, typeReadyHanaGrant)
+	if status == metav1.ConditionFalse && (ready == nil || ready.Reason != reason) &&
+		reason != "HanaExpressUnavailable" {
+		r.Recorder.Event(grant, "Warning", reason, message)
+	}
+
+	meta.SetStatusCondition(&grant.Status.Conditions, metav1.Condition{Type: typeReadyHanaGrant,
+		Status: status, Reason: reason, Message: message})
+	if err := r.Status().Update(ctx, grant); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update HanaGrant status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HanaGrantReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1alpha1.HanaGrant{}).
+		Complete(withReconcileTimeout(r))
+}
@@ -0,0 +1,251 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+// Definitions to manage status conditions
+const (
+	// typeReadyHanaRole represents whether the role and its privileges match the spec
+	typeReadyHanaRole = "Ready"
+)
+
+// HanaRoleReconciler reconciles a HanaRole object
+type HanaRoleReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	SQL      hdbclient.Connector
+}
+
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaroles,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaroles/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaroles/finalizers,verbs=update
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanatenantdatabases,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile creates the role described by a HanaRole, keeps the privileges granted to it in
+// sync with the spec, and drops it when the resource is deleted.
+func (r *HanaRoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	role := &dbv1alpha1.HanaRole{}
+	if err := r.Get(ctx, req.NamespacedName, role); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("HanaRole resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get HanaRole")
+		return ctrl.Result{}, err
+	}
+
+	roleName := strings.ToUpper(role.Spec.RoleName)
+	database := strings.ToUpper(role.Spec.DatabaseName)
+	if database == "" {
+		database = "HXE"
+	}
+
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: role.Spec.HanaExpressName, Namespace: role.Namespace}, hanaExpress)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get HanaExpress")
+		return ctrl.Result{}, err
+	}
+	hanaExpressGone := apierrors.IsNotFound(err) || hanaExpress.GetDeletionTimestamp() != nil
+
+	if role.GetDeletionTimestamp() != nil {
+		if !controllerutil.ContainsFinalizer(role, hanaExpressFinalizer) {
+			return ctrl.Result{}, nil
+		}
+
+		// Roles that were never created by this resource are left alone
+		if !hanaExpressGone && role.Status.RoleName != "" {
+			if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+				log.Info("Waiting for HanaExpress to become available to drop the role")
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+			if err := r.dropRole(ctx, hanaExpress, role); err != nil {
+				log.Error(err, "Failed to drop the role")
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+		}
+
+		log.Info("Removing Finalizer for HanaRole")
+		controllerutil.RemoveFinalizer(role, hanaExpressFinalizer)
+		if err := r.Update(ctx, role); err != nil {
+			log.Error(err, "Failed to remove finalizer for HanaRole")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(role, hanaExpressFinalizer) {
+		log.Info("Adding Finalizer for HanaRole")
+		controllerutil.AddFinalizer(role, hanaExpressFinalizer)
+		if err := r.Update(ctx, role); err != nil {
+			log.Error(err, "Failed to update custom resource to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if role.Status.RoleName != "" && (role.Status.RoleName != roleName || role.Status.DatabaseName != database) {
+		return r.setRoleStatus(ctx, role, metav1.ConditionFalse, "RoleNameChanged",
+			fmt.Sprintf("roleName and databaseName cannot be changed from %s in %s, create a new HanaRole instead",
+				role.Status.RoleName, role.Status.DatabaseName), 0)
+	}
+
+	if hanaExpressGone {
+		return r.setRoleStatus(ctx, role, metav1.ConditionFalse, "HanaExpressNotFound",
+			fmt.Sprintf("HanaExpress %s not found", role.Spec.HanaExpressName), 30*time.Second)
+	}
+
+	if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+		return r.setRoleStatus(ctx, role, metav1.ConditionFalse, "HanaExpressUnavailable",
+			fmt.Sprintf("Waiting for HanaExpress %s to become available", hanaExpress.Name), 30*time.Second)
+	}
+
+	desired, err := desiredPrivilegeGrants(role.Spec.Privileges)
+	if err != nil {
+		return r.setRoleStatus(ctx, role, metav1.ConditionFalse, "InvalidPrivilege", err.Error(), 0)
+	}
+
+	db, err := openDatabase(ctx, r.Client, r.SQL, hanaExpress, database)
+	if err != nil {
+		log.Error(err, "Failed to connect to the database", "Database", database)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	defer db.Close()
+
+	exists, err := roleExists(ctx, db, roleName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !exists {
+		if role.Status.RoleName == "" {
+			log.Info("Creating role", "Role", roleName, "Database", database)
+		} else {
+			log.Info("Role is missing, creating it again", "Role", roleName, "Database", database)
+		}
+		if _, err := hdbclient.ExecOnce(ctx, db, "CREATE ROLE "+hdbclient.QuoteIdentifier(roleName)); err != nil {
+			return r.setRoleStatus(ctx, role, metav1.ConditionFalse, "CreateFailed",
+				fmt.Sprintf("Failed to create role %s: %s", roleName, err), time.Minute)
+		}
+		role.Status.RoleName = roleName
+		role.Status.DatabaseName = database
+		r.Recorder.Event(role, "Normal", "Created", fmt.Sprintf("Created role %s in database %s", roleName, database))
+	} else if role.Status.RoleName == "" {
+		// A role that existed before this resource is never taken over, it would be dropped with it
+		return r.setRoleStatus(ctx, role, metav1.ConditionFalse, "AlreadyExists",
+			fmt.Sprintf("Role %s already exists in database %s and is not managed by this resource", roleName, database), 0)
+	}
+
+	// The role belongs to this resource, so every privilege SYSTEM granted to it is managed
+	result, err := syncPrivileges(ctx, db, roleName, desired, func(string) bool { return true })
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	now := metav1.Now()
+	result.LastSyncTime = &now
+	role.Status.PrivilegeSyncStatus = result
+	condition := privilegeSyncCondition(typeReadyHanaRole, result)
+	if condition.Status == metav1.ConditionFalse {
+		return r.setRoleStatus(ctx, role, condition.Status, condition.Reason, condition.Message, time.Minute)
+	}
+	// Compare with the catalog again later in case privileges were granted or revoked in SQL
+	return r.setRoleStatus(ctx, role, condition.Status, condition.Reason, condition.Message, 5*time.Minute)
+}
+
+// dropRole drops the role created by the HanaRole, which revokes it from all grantees
+func (r *HanaRoleReconciler) dropRole(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress, role *dbv1alpha1.HanaRole) error {
+	db, err := openDatabase(ctx, r.Client, r.SQL, hanaExpress, role.Status.DatabaseName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	exists, err := roleExists(ctx, db, role.Status.RoleName)
+	if err != nil || !exists {
+		return err
+	}
+
+	log.FromContext(ctx).Info("Dropping role", "Role", role.Status.RoleName, "Database", role.Status.DatabaseName)
+	if _, err := hdbclient.ExecOnce(ctx, db, "DROP ROLE "+hdbclient.QuoteIdentifier(role.Status.RoleName)); err != nil {
+		return fmt.Errorf("failed to drop role %s: %w", role.Status.RoleName, err)
+	}
+	r.Recorder.Event(role, "Normal", "Dropped",
+		fmt.Sprintf("Dropped role %s in database %s", role.Status.RoleName, role.Status.DatabaseName))
+	return nil
+}
+
+// roleExists reports whether the global role exists
+func roleExists(ctx context.Context, db *sql.DB, roleName string) (bool, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM SYS.ROLES WHERE ROLE_NAME = ? AND ROLE_SCHEMA_NAME IS NULL",
+		roleName).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to query role %s: %w", roleName, err)
+	}
+	return count > 0, nil
+}
+
+// setRoleStatus records the Ready condition and requeues after the given delay
+func (r *HanaRoleReconciler) setRoleStatus(ctx context.Context, role *dbv1alpha1.HanaRole,
+	status metav1.ConditionStatus, reason, message string, requeueAfter time.Duration) (ctrl.Result, error) {
+	ready := meta.FindStatusCondition(role.Status.Conditions, typeReadyHanaRole)
+	if status == metav1.ConditionFalse && (ready == nil || ready.Reason != reason) &&
+		reason != "HanaExpressUnavailable" {
+		r.Recorder.Event(role, "Warning", reason, message)
+	}
+
+	meta.SetStatusCondition(&role.Status.Conditions, metav1.Condition{Type: typeReadyHanaRole,
+		Status: status, Reason: reason, Message: message})
+	if err := r.Status().Update(ctx, role); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update HanaRole status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HanaRoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1alpha1.HanaRole{}).
+		Complete(withReconcileTimeout(r))
+}
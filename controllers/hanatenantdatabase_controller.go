@@ -0,0 +1,354 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+// Definitions to manage status conditions
+const (
+	// typeReadyHanaTenantDatabase represents whether the tenant database is running
+	typeReadyHanaTenantDatabase = "Ready"
+)
+
+// HanaTenantDatabaseReconciler reconciles a HanaTenantDatabase object
+type HanaTenantDatabaseReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	SQL      hdbclient.Connector
+}
+
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanatenantdatabases,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanatenantdatabases/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanatenantdatabases/finalizers,verbs=update
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile creates the tenant database described by a HanaTenantDatabase through the
+// SystemDB, keeps it running with the SYSTEM password of its Secret, and drops it when
+// the resource is deleted.
+func (r *HanaTenantDatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	tenant := &dbv1alpha1.HanaTenantDatabase{}
+	if err := r.Get(ctx, req.NamespacedName, tenant); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("HanaTenantDatabase resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get HanaTenantDatabase")
+		return ctrl.Result{}, err
+	}
+
+	database := strings.ToUpper(tenant.Spec.DatabaseName)
+
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: tenant.Spec.HanaExpressName, Namespace: tenant.Namespace}, hanaExpress)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get HanaExpress")
+		return ctrl.Result{}, err
+	}
+	hanaExpressGone := apierrors.IsNotFound(err) || hanaExpress.GetDeletionTimestamp() != nil
+
+	if tenant.GetDeletionTimestamp() != nil {
+		if !controllerutil.ContainsFinalizer(tenant, hanaExpressFinalizer) {
+			return ctrl.Result{}, nil
+		}
+
+		// The tenant disappears together with an instance that is deleted, and a tenant that
+		// was never created by this resource is left alone
+		if tenant.Spec.DeletionPolicy != dbv1alpha1.TenantDeletionPolicyRetain && !hanaExpressGone &&
+			tenant.Status.DatabaseName != "" {
+			if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+				log.Info("Waiting for HanaExpress to become available to drop the tenant database")
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+			if result, err := r.dropTenantDatabase(ctx, hanaExpress, tenant, tenant.Status.DatabaseName); err != nil || !result.IsZero() {
+				return result, err
+			}
+		}
+
+		log.Info("Removing Finalizer for HanaTenantDatabase")
+		controllerutil.RemoveFinalizer(tenant, hanaExpressFinalizer)
+		if err := r.Update(ctx, tenant); err != nil {
+			log.Error(err, "Failed to remove finalizer for HanaTenantDatabase")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(tenant, hanaExpressFinalizer) {
+		log.Info("Adding Finalizer for HanaTenantDatabase")
+		controllerutil.AddFinalizer(tenant, hanaExpressFinalizer)
+		if err := r.Update(ctx, tenant); err != nil {
+			log.Error(err, "Failed to update custom resource to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if database == "SYSTEMDB" {
+		return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhaseFailed, "InvalidName",
+			"SYSTEMDB is not a tenant database", 0)
+	}
+
+	if tenant.Status.DatabaseName != "" && tenant.Status.DatabaseName != database {
+		return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhaseFailed, "DatabaseNameChanged",
+			fmt.Sprintf("databaseName cannot be changed from %s, create a new HanaTenantDatabase instead", tenant.Status.DatabaseName), 0)
+	}
+
+	if hanaExpressGone {
+		return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhasePending, "HanaExpressNotFound",
+			fmt.Sprintf("HanaExpress %s not found", tenant.Spec.HanaExpressName), 30*time.Second)
+	}
+
+	if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+		phase := tenant.Status.Phase
+		if phase == "" {
+			phase = dbv1alpha1.TenantPhasePending
+		}
+		return r.setTenantStatus(ctx, tenant, phase, "HanaExpressUnavailable",
+			fmt.Sprintf("Waiting for HanaExpress %s to become available", hanaExpress.Name), 30*time.Second)
+	}
+
+	secret := &corev1.Secret{}
+	err = r.Get(ctx, types.NamespacedName{Name: tenant.Spec.Credential.SecretKeyRef.Name, Namespace: tenant.Namespace}, secret)
+	if err != nil {
+		return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhaseFailed, "SecretNotFound",
+			fmt.Sprintf("Failed to get the credential Secret: %s", err), 30*time.Second)
+	}
+	password, err := passwordFromCredential(ctx, r.Client, tenant.Namespace, tenant.Spec.Credential)
+	if err != nil {
+		return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhaseFailed, "InvalidCredential", err.Error(), 30*time.Second)
+	}
+
+	db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to connect to the SystemDB")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	defer db.Close()
+
+	active, exists, err := tenantDatabaseState(ctx, db, database)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	switch {
+	case !exists:
+		if tenant.Status.DatabaseName != "" {
+			log.Info("Tenant database is missing, creating it again", "Database", database)
+		}
+		tenant.Status.Phase = dbv1alpha1.TenantPhaseCreating
+		tenant.Status.DatabaseName = database
+		meta.SetStatusCondition(&tenant.Status.Conditions, metav1.Condition{Type: typeReadyHanaTenantDatabase,
+			Status: metav1.ConditionFalse, Reason: "Creating", Message: fmt.Sprintf("Creating tenant database %s", database)})
+		if err := r.Status().Update(ctx, tenant); err != nil {
+			log.Error(err, "Failed to update HanaTenantDatabase status")
+			return ctrl.Result{}, err
+		}
+
+		log.Info("Creating tenant database", "Database", database)
+		stmt := fmt.Sprintf("CREATE DATABASE %s SYSTEM USER PASSWORD %s",
+			hdbclient.QuoteIdentifier(database), hdbclient.QuoteIdentifier(password))
+		if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+			return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhaseFailed, "CreateFailed",
+				fmt.Sprintf("Failed to create tenant database %s: %s", database, err), time.Minute)
+		}
+		tenant.Status.CredentialVersion = secret.ResourceVersion
+		r.Recorder.Event(tenant, "Normal", "Created", fmt.Sprintf("Created tenant database %s", database))
+
+	case tenant.Status.DatabaseName == "":
+		// A tenant that existed before this resource is never taken over, it would be dropped with it
+		return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhaseFailed, "AlreadyExists",
+			fmt.Sprintf("Tenant database %s already exists and is not managed by this resource", database), 0)
+
+	case !active:
+		log.Info("Starting tenant database", "Database", database)
+		if _, err := hdbclient.ExecOnce(ctx, db, "ALTER SYSTEM START DATABASE "+hdbclient.QuoteIdentifier(database)); err != nil {
+			return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhaseFailed, "StartFailed",
+				fmt.Sprintf("Failed to start tenant database %s: %s", database, err), time.Minute)
+		}
+	}
+
+	port, err := tenantSQLPort(ctx, db, database)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	tenant.Status.SQLPort = port
+	tenant.Status.Host = hostForHanaExpress(hanaExpress)
+	tenant.Status.DatabaseName = database
+
+	if tenant.Status.CredentialVersion != secret.ResourceVersion {
+		if err := setTenantSystemPassword(ctx, r.SQL, db, hanaExpress, database, port, password); err != nil {
+			return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhaseFailed, "PasswordChangeFailed", err.Error(), time.Minute)
+		}
+		tenant.Status.CredentialVersion = secret.ResourceVersion
+	}
+
+	// Check the tenant again later in case it was stopped or dropped in SQL
+	return r.setTenantStatus(ctx, tenant, dbv1alpha1.TenantPhaseReady, "Running",
+		fmt.Sprintf("Tenant database %s is running on port %d", database, port), 5*time.Minute)
+}
+
+// setTenantSystemPassword sets the SYSTEM password of a tenant database through the SystemDB
+// unless the tenant already accepts it. The SystemDB can only set it while the tenant is
+// stopped, so a running tenant, which is passed with its SQL port, is restarted.
+func setTenantSystemPassword(ctx context.Context, connector hdbclient.Connector, db *sql.DB,
+	hanaExpress *dbv1alpha1.HanaExpress, database string, port int32, password string) error {
+	quoted := hdbclient.QuoteIdentifier(database)
+	statements := []string{fmt.Sprintf("ALTER DATABASE %s SYSTEM USER PASSWORD %s", quoted, hdbclient.QuoteIdentifier(password))}
+	if port != 0 {
+		tenantDB, err := connector.Open(ctx, hdbclient.Endpoint{
+			Host:     hostForHanaExpress(hanaExpress),
+			Port:     port,
+			User:     hanaSystemUser,
+			Password: password,
+		})
+		if err == nil {
+			tenantDB.Close()
+			return nil
+		}
+		statements = []string{"ALTER SYSTEM STOP DATABASE " + quoted, statements[0], "ALTER SYSTEM START DATABASE " + quoted}
+	}
+
+	log.FromContext(ctx).Info("Changing the SYSTEM password of the tenant database", "Database", database)
+	for _, stmt := range statements {
+		if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+			return fmt.Errorf("failed to change the SYSTEM password of tenant database %s: %w", database, err)
+		}
+	}
+	return nil
+}
+
+// dropTenantDatabase stops and drops the tenant database if it exists
+func (r *HanaTenantDatabaseReconciler) dropTenantDatabase(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress,
+	tenant *dbv1alpha1.HanaTenantDatabase, database string) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	if tenant.Status.Phase != dbv1alpha1.TenantPhaseDeleting {
+		tenant.Status.Phase = dbv1alpha1.TenantPhaseDeleting
+		meta.SetStatusCondition(&tenant.Status.Conditions, metav1.Condition{Type: typeReadyHanaTenantDatabase,
+			Status: metav1.ConditionFalse, Reason: "Deleting", Message: fmt.Sprintf("Dropping tenant database %s", database)})
+		if err := r.Status().Update(ctx, tenant); err != nil {
+			log.Error(err, "Failed to update HanaTenantDatabase status")
+			return ctrl.Result{}, err
+		}
+	}
+
+	db, err := openSystemDB(ctx, r.Client, r.SQL, hanaExpress)
+	if err != nil {
+		log.Error(err, "Failed to connect to the SystemDB")
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	defer db.Close()
+
+	active, exists, err := tenantDatabaseState(ctx, db, database)
+	if err != nil || !exists {
+		return ctrl.Result{}, err
+	}
+
+	quoted := hdbclient.QuoteIdentifier(database)
+	if active {
+		log.Info("Stopping tenant database", "Database", database)
+		if _, err := hdbclient.ExecOnce(ctx, db, "ALTER SYSTEM STOP DATABASE "+quoted); err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to stop tenant database %s: %w", database, err)
+		}
+	}
+	log.Info("Dropping tenant database", "Database", database)
+	if _, err := hdbclient.ExecOnce(ctx, db, "DROP DATABASE "+quoted); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to drop tenant database %s: %w", database, err)
+	}
+
+	r.Recorder.Event(tenant, "Normal", "Dropped", fmt.Sprintf("Dropped tenant database %s", database))
+	return ctrl.Result{}, nil
+}
+
+// tenantDatabaseState reports whether the tenant database exists and is running
+func tenantDatabaseState(ctx context.Context, db *sql.DB, database string) (active bool, exists bool, err error) {
+	var status string
+	err = db.QueryRowContext(ctx, "SELECT ACTIVE_STATUS FROM M_DATABASES WHERE DATABASE_NAME = ?", database).Scan(&status)
+	if err == sql.ErrNoRows {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, fmt.Errorf("failed to query the status of database %s: %w", database, err)
+	}
+	return status == "YES", true, nil
+}
+
+// tenantSQLPort returns the SQL port of the index server of the tenant database
+func tenantSQLPort(ctx context.Context, db *sql.DB, database string) (int32, error) {
+	var port int32
+	err := db.QueryRowContext(ctx, `SELECT SQL_PORT FROM SYS_DATABASES.M_SERVICES
+		WHERE DATABASE_NAME = ? AND SERVICE_NAME = 'indexserver' AND SQL_PORT > 0`, database).Scan(&port)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to query the SQL port of database %s: %w", database, err)
+	}
+	return port, nil
+}
+
+// setTenantStatus records the phase and Ready condition and requeues after the given delay
+func (r *HanaTenantDatabaseReconciler) setTenantStatus(ctx context.Context, tenant *dbv1alpha1.HanaTenantDatabase,
+	phase dbv1alpha1.TenantPhase, reason, message string, requeueAfter time.Duration) (ctrl.Result, error) {
+	status := metav1.ConditionFalse
+	if phase == dbv1alpha1.TenantPhaseReady {
+		status = metav1.ConditionTrue
+	} else if phase == dbv1alpha1.TenantPhaseFailed {
+		r.Recorder.Event(tenant, "Warning", reason, message)
+	}
+
+	tenant.Status.Phase = phase
+	meta.SetStatusCondition(&tenant.Status.Conditions, metav1.Condition{Type: typeReadyHanaTenantDatabase,
+		Status: status, Reason: reason, Message: message})
+	if err := r.Status().Update(ctx, tenant); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update HanaTenantDatabase status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HanaTenantDatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1alpha1.HanaTenantDatabase{}).
+		Complete(withReconcileTimeout(r))
+}
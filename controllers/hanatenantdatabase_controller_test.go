@@ -0,0 +1,292 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+)
+
+func TestTenantIsCreatedOnce(t *testing.T) {
+	hanaExpress, masterSecret := testHanaExpress()
+	tenant, secret := testTenant()
+	r, connector := newTestTenantReconciler(hanaExpress, masterSecret, tenant, secret)
+
+	mock := connector.expectSQL(t)
+	expectTenantState(mock, "")
+	expectTenantExec(mock, `CREATE DATABASE "SALES" SYSTEM USER PASSWORD "Tenant1"`)
+	expectTenantPort(mock)
+	result, stored := reconcileTenant(t, r, mock)
+	if stored.Status.Phase != dbv1alpha1.TenantPhaseReady || stored.Status.SQLPort != 30041 {
+		t.Fatalf("phase = %s, port = %d after the creation", stored.Status.Phase, stored.Status.SQLPort)
+	}
+	if stored.Status.DatabaseName != "SALES" || stored.Status.Host != "hxe.default.svc" {
+		t.Errorf("database %s on %s, want SALES on hxe.default.svc", stored.Status.DatabaseName, stored.Status.Host)
+	}
+	if !controllerutil.ContainsFinalizer(stored, hanaExpressFinalizer) {
+		t.Error("finalizer not added")
+	}
+	if result.RequeueAfter != 5*time.Minute {
+		t.Errorf("requeue after %s, want the periodic check", result.RequeueAfter)
+	}
+	if !containsEvent(drainEvents(r.Recorder.(*record.FakeRecorder)), "Created") {
+		t.Error("no Created event")
+	}
+
+	// A running tenant is only checked, its password is not set again
+	mock = connector.expectSQL(t)
+	expectTenantState(mock, "YES")
+	expectTenantPort(mock)
+	_, stored = reconcileTenant(t, r, mock)
+	if stored.Status.Phase != dbv1alpha1.TenantPhaseReady {
+		t.Errorf("phase = %s for the running tenant", stored.Status.Phase)
+	}
+}
+
+func TestTenantIsStartedAgain(t *testing.T) {
+	hanaExpress, masterSecret := testHanaExpress()
+	tenant, secret := testTenant()
+	managedTenant(tenant, secret)
+	r, connector := newTestTenantReconciler(hanaExpress, masterSecret, tenant, secret)
+
+	mock := connector.expectSQL(t)
+	expectTenantState(mock, "NO")
+	expectTenantExec(mock, `ALTER SYSTEM START DATABASE "SALES"`)
+	expectTenantPort(mock)
+	_, stored := reconcileTenant(t, r, mock)
+	if stored.Status.Phase != dbv1alpha1.TenantPhaseReady {
+		t.Errorf("phase = %s after the start", stored.Status.Phase)
+	}
+}
+
+func TestTenantPasswordChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted bool
+	}{
+		{name: "password already set in the tenant", accepted: true},
+		{name: "password changed through the SystemDB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, masterSecret := testHanaExpress()
+			tenant, secret := testTenant()
+			managedTenant(tenant, secret)
+			tenant.Status.CredentialVersion = "previous"
+			r, connector := newTestTenantReconciler(hanaExpress, masterSecret, tenant, secret)
+
+			mock := connector.expectSQL(t)
+			expectTenantState(mock, "YES")
+			expectTenantPort(mock)
+			if tt.accepted {
+				connector.expectSQL(t)
+			} else {
+				connector.rejected = []string{testTenantPassword}
+				expectTenantExec(mock, `ALTER SYSTEM STOP DATABASE "SALES"`)
+				expectTenantExec(mock, `ALTER DATABASE "SALES" SYSTEM USER PASSWORD "Tenant1"`)
+				expectTenantExec(mock, `ALTER SYSTEM START DATABASE "SALES"`)
+			}
+			_, stored := reconcileTenant(t, r, mock)
+			if stored.Status.Phase != dbv1alpha1.TenantPhaseReady {
+				t.Fatalf("phase = %s after the password change", stored.Status.Phase)
+			}
+			if stored.Status.CredentialVersion == "previous" {
+				t.Error("credential version not recorded")
+			}
+		})
+	}
+}
+
+func TestTenantIsNotReconciled(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase)
+		expect func(mock sqlmock.Sqlmock)
+		phase  dbv1alpha1.TenantPhase
+		reason string
+	}{
+		{
+			name: "existing tenant not created by the resource",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTenantState(mock, "YES")
+			},
+			phase:  dbv1alpha1.TenantPhaseFailed,
+			reason: "AlreadyExists",
+		},
+		{
+			name: "SystemDB",
+			modify: func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase) {
+				tenant.Spec.DatabaseName = "systemdb"
+			},
+			phase:  dbv1alpha1.TenantPhaseFailed,
+			reason: "InvalidName",
+		},
+		{
+			name: "renamed tenant",
+			modify: func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase) {
+				tenant.Status.DatabaseName = "MARKETING"
+			},
+			phase:  dbv1alpha1.TenantPhaseFailed,
+			reason: "DatabaseNameChanged",
+		},
+		{
+			name: "missing instance",
+			modify: func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase) {
+				tenant.Spec.HanaExpressName = "other"
+			},
+			phase:  dbv1alpha1.TenantPhasePending,
+			reason: "HanaExpressNotFound",
+		},
+		{
+			name: "unavailable instance",
+			modify: func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase) {
+				meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
+					Status: metav1.ConditionFalse, Reason: "Starting"})
+			},
+			phase:  dbv1alpha1.TenantPhasePending,
+			reason: "HanaExpressUnavailable",
+		},
+		{
+			name: "missing credential key",
+			modify: func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase) {
+				tenant.Spec.Credential.SecretKeyRef.Key = "other"
+			},
+			phase:  dbv1alpha1.TenantPhaseFailed,
+			reason: "InvalidCredential",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, masterSecret := testHanaExpress()
+			tenant, secret := testTenant()
+			if tt.modify != nil {
+				tt.modify(hanaExpress, tenant)
+			}
+			r, connector := newTestTenantReconciler(hanaExpress, masterSecret, tenant, secret)
+			mock := connector.expectSQL(t)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			_, stored := reconcileTenant(t, r, mock)
+			if stored.Status.Phase != tt.phase {
+				t.Errorf("phase = %s, want %s", stored.Status.Phase, tt.phase)
+			}
+			if ready := tenantReady(stored); ready == nil || ready.Reason != tt.reason {
+				t.Errorf("Ready condition = %v, want reason %s", ready, tt.reason)
+			}
+		})
+	}
+}
+
+func TestTenantDeletion(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase)
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "running tenant",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTenantState(mock, "YES")
+				expectTenantExec(mock, `ALTER SYSTEM STOP DATABASE "SALES"`)
+				expectTenantExec(mock, `DROP DATABASE "SALES"`)
+			},
+		},
+		{
+			name: "stopped tenant",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTenantState(mock, "NO")
+				expectTenantExec(mock, `DROP DATABASE "SALES"`)
+			},
+		},
+		{
+			name: "tenant already dropped",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectTenantState(mock, "")
+			},
+		},
+		{
+			name: "retained tenant",
+			modify: func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase) {
+				tenant.Spec.DeletionPolicy = dbv1alpha1.TenantDeletionPolicyRetain
+			},
+		},
+		{
+			name: "tenant never created",
+			modify: func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase) {
+				tenant.Status.DatabaseName = ""
+			},
+		},
+		{
+			name: "instance deleted",
+			modify: func(hanaExpress *dbv1alpha1.HanaExpress, tenant *dbv1alpha1.HanaTenantDatabase) {
+				tenant.Spec.HanaExpressName = "other"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, masterSecret := testHanaExpress()
+			tenant, secret := testTenant()
+			managedTenant(tenant, secret)
+			tenant.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			if tt.modify != nil {
+				tt.modify(hanaExpress, tenant)
+			}
+			r, connector := newTestTenantReconciler(hanaExpress, masterSecret, tenant, secret)
+			mock := connector.expectSQL(t)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			if _, stored := reconcileTenant(t, r, mock); stored != nil {
+				t.Errorf("finalizers = %v, want the resource to be gone", stored.Finalizers)
+			}
+		})
+	}
+}
+
+func TestTenantDeletionWaitsForTheInstance(t *testing.T) {
+	hanaExpress, masterSecret := testHanaExpress()
+	meta.SetStatusCondition(&hanaExpress.Status.Conditions, metav1.Condition{Type: typeAvailableHanaExpress,
+		Status: metav1.ConditionFalse, Reason: "Starting"})
+	tenant, secret := testTenant()
+	managedTenant(tenant, secret)
+	tenant.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	r, connector := newTestTenantReconciler(hanaExpress, masterSecret, tenant, secret)
+	mock := connector.expectSQL(t)
+
+	result, stored := reconcileTenant(t, r, mock)
+	if stored == nil || len(stored.Finalizers) == 0 {
+		t.Fatal("finalizer removed before the tenant was dropped")
+	}
+	if result.RequeueAfter == 0 {
+		t.Error("deletion not retried")
+	}
+}
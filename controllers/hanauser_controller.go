@@ -0,0 +1,443 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+// Definitions to manage status conditions
+const (
+	// typeReadyHanaUser represents whether the database user matches the spec
+	typeReadyHanaUser = "Ready"
+)
+
+// hanaUserState is the part of SYS.USERS the operator reconciles
+type hanaUserState struct {
+	deactivated           bool
+	passwordLifetimeCheck bool
+	usergroup             string
+}
+
+// HanaUserReconciler reconciles a HanaUser object
+type HanaUserReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	SQL      hdbclient.Connector
+}
+
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanausers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanausers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanausers/finalizers,verbs=update
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanaexpresses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=db.sap-redhat.io,resources=hanatenantdatabases,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile creates the database user described by a HanaUser with a generated password,
+// corrects drift of its password, lifetime check, activation and usergroup, and drops it
+// when the resource is deleted.
+func (r *HanaUserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	user := &dbv1alpha1.HanaUser{}
+	if err := r.Get(ctx, req.NamespacedName, user); err != nil {
+		if apierrors.IsNotFound(err) {
+			log.Info("HanaUser resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get HanaUser")
+		return ctrl.Result{}, err
+	}
+
+	userName := strings.ToUpper(user.Spec.UserName)
+	database := strings.ToUpper(user.Spec.DatabaseName)
+	if database == "" {
+		database = "HXE"
+	}
+
+	hanaExpress := &dbv1alpha1.HanaExpress{}
+	err := r.Get(ctx, types.NamespacedName{Name: user.Spec.HanaExpressName, Namespace: user.Namespace}, hanaExpress)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to get HanaExpress")
+		return ctrl.Result{}, err
+	}
+	hanaExpressGone := apierrors.IsNotFound(err) || hanaExpress.GetDeletionTimestamp() != nil
+
+	if user.GetDeletionTimestamp() != nil {
+		if !controllerutil.ContainsFinalizer(user, hanaExpressFinalizer) {
+			return ctrl.Result{}, nil
+		}
+
+		// Users that were never created by this resource are left alone
+		if !hanaExpressGone && user.Status.UserName != "" {
+			if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+				log.Info("Waiting for HanaExpress to become available to drop the user")
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+			if err := r.dropUser(ctx, hanaExpress, user); err != nil {
+				log.Error(err, "Failed to drop the database user")
+				return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+			}
+		}
+
+		log.Info("Removing Finalizer for HanaUser")
+		controllerutil.RemoveFinalizer(user, hanaExpressFinalizer)
+		if err := r.Update(ctx, user); err != nil {
+			log.Error(err, "Failed to remove finalizer for HanaUser")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !controllerutil.ContainsFinalizer(user, hanaExpressFinalizer) {
+		log.Info("Adding Finalizer for HanaUser")
+		controllerutil.AddFinalizer(user, hanaExpressFinalizer)
+		if err := r.Update(ctx, user); err != nil {
+			log.Error(err, "Failed to update custom resource to add finalizer")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if user.Status.UserName != "" && (user.Status.UserName != userName || user.Status.DatabaseName != database) {
+		return r.setUserStatus(ctx, user, metav1.ConditionFalse, "UserNameChanged",
+			fmt.Sprintf("userName and databaseName cannot be changed from %s in %s, create a new HanaUser instead",
+				user.Status.UserName, user.Status.DatabaseName), 0)
+	}
+
+	if hanaExpressGone {
+		return r.setUserStatus(ctx, user, metav1.ConditionFalse, "HanaExpressNotFound",
+			fmt.Sprintf("HanaExpress %s not found", user.Spec.HanaExpressName), 30*time.Second)
+	}
+
+	if !meta.IsStatusConditionTrue(hanaExpress.Status.Conditions, typeAvailableHanaExpress) {
+		return r.setUserStatus(ctx, user, metav1.ConditionFalse, "HanaExpressUnavailable",
+			fmt.Sprintf("Waiting for HanaExpress %s to become available", hanaExpress.Name), 30*time.Second)
+	}
+
+	endpoint, err := endpointForDatabase(ctx, r.Client, r.SQL, hanaExpress, database)
+	if err != nil {
+		return r.setUserStatus(ctx, user, metav1.ConditionFalse, "DatabaseUnavailable",
+			fmt.Sprintf("Failed to resolve database %s: %s", database, err), 30*time.Second)
+	}
+
+	password, err := r.reconcileUserSecret(ctx, user, endpoint, database, userName)
+	if err != nil {
+		return r.setUserStatus(ctx, user, metav1.ConditionFalse, "InvalidSecret", err.Error(), time.Minute)
+	}
+	user.Status.SecretName = secretNameForHanaUser(user)
+
+	db, err := r.SQL.Open(ctx, endpoint)
+	if err != nil {
+		log.Error(err, "Failed to connect to the database", "Database", database)
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+	defer db.Close()
+
+	state, err := hanaUserStateFor(ctx, db, userName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	created := false
+	if state == nil {
+		if user.Status.UserName == "" {
+			log.Info("Creating database user", "User", userName, "Database", database)
+		} else {
+			log.Info("Database user is missing, creating it again", "User", userName, "Database", database)
+		}
+		stmt := fmt.Sprintf("CREATE USER %s PASSWORD %s NO FORCE_FIRST_PASSWORD_CHANGE",
+			hdbclient.QuoteIdentifier(userName), hdbclient.QuoteIdentifier(password))
+		if _, err := hdbclient.ExecOnce(ctx, db, stmt); err != nil {
+			return r.setUserStatus(ctx, user, metav1.ConditionFalse, "CreateFailed",
+				fmt.Sprintf("Failed to create user %s: %s", userName, err), time.Minute)
+		}
+		created = true
+		user.Status.UserName = userName
+		user.Status.DatabaseName = database
+		r.Recorder.Event(user, "Normal", "Created", fmt.Sprintf("Created user %s in database %s", userName, database))
+
+		if state, err = hanaUserStateFor(ctx, db, userName); err != nil || state == nil {
+			return ctrl.Result{RequeueAfter: 30 * time.Second}, err
+		}
+	} else if user.Status.UserName == "" {
+		// A user that existed before this resource is never taken over, it would be dropped with it
+		return r.setUserStatus(ctx, user, metav1.ConditionFalse, "AlreadyExists",
+			fmt.Sprintf("User %s already exists in database %s and is not managed by this resource", userName, database), 0)
+	}
+
+	statements := r.userDriftStatements(ctx, user, endpoint, userName, password, state, created)
+	for _, stmt := range statements {
+		if _, err := hdbclient.ExecOnce(ctx, db, stmt.sql); err != nil {
+			return r.setUserStatus(ctx, user, metav1.ConditionFalse, "AlterFailed",
+				fmt.Sprintf("Failed to alter user %s to %s: %s", userName, stmt.description, err), time.Minute)
+		}
+	}
+	if len(statements) > 0 && !created {
+		descriptions := make([]string, 0, len(statements))
+		for _, stmt := range statements {
+			descriptions = append(descriptions, stmt.description)
+		}
+		r.Recorder.Event(user, "Normal", "DriftCorrected",
+			fmt.Sprintf("Corrected user %s: %s", userName, strings.Join(descriptions, ", ")))
+	}
+
+	// Check the user again later in case it was altered or dropped in SQL
+	return r.setUserStatus(ctx, user, metav1.ConditionTrue, "Reconciled",
+		fmt.Sprintf("User %s exists in database %s", userName, database), 5*time.Minute)
+}
+
+// userStatement is an ALTER USER statement with a description for events and messages
+type userStatement struct {
+	sql         string
+	description string
+}
+
+// userDriftStatements returns the statements that bring the user back to the spec. The
+// password is only verified by logging on while the user is active, HANA refuses the logon
+// of a deactivated user whatever its password.
+func (r *HanaUserReconciler) userDriftStatements(ctx context.Context, user *dbv1alpha1.HanaUser,
+	endpoint hdbclient.Endpoint, userName, password string, state *hanaUserState, created bool) []userStatement {
+	quoted := hdbclient.QuoteIdentifier(userName)
+	statements := []userStatement{}
+
+	if !created && !state.deactivated && !r.passwordMatches(ctx, endpoint, userName, password) {
+		statements = append(statements, userStatement{
+			sql: fmt.Sprintf("ALTER USER %s PASSWORD %s NO FORCE_FIRST_PASSWORD_CHANGE",
+				quoted, hdbclient.QuoteIdentifier(password)),
+			description: "reset the password",
+		})
+	}
+
+	if user.Spec.PasswordNeverExpires && state.passwordLifetimeCheck {
+		statements = append(statements, userStatement{
+			sql:         fmt.Sprintf("ALTER USER %s DISABLE PASSWORD LIFETIME", quoted),
+			description: "disable the password lifetime",
+		})
+	} else if !user.Spec.PasswordNeverExpires && !state.passwordLifetimeCheck {
+		statements = append(statements, userStatement{
+			sql:         fmt.Sprintf("ALTER USER %s ENABLE PASSWORD LIFETIME", quoted),
+			description: "enable the password lifetime",
+		})
+	}
+
+	if user.Spec.Usergroup != state.usergroup {
+		if user.Spec.Usergroup == "" {
+			statements = append(statements, userStatement{
+				sql:         fmt.Sprintf("ALTER USER %s UNSET USERGROUP", quoted),
+				description: "unset the usergroup",
+			})
+		} else {
+			statements = append(statements, userStatement{
+				sql:         fmt.Sprintf("ALTER USER %s SET USERGROUP %s", quoted, hdbclient.QuoteIdentifier(user.Spec.Usergroup)),
+				description: "set the usergroup",
+			})
+		}
+	}
+
+	// Activation goes last so a user that is enabled again gets its password reset on the next pass
+	if user.Spec.Disabled && !state.deactivated {
+		statements = append(statements, userStatement{
+			sql:         fmt.Sprintf("ALTER USER %s DEACTIVATE USER NOW", quoted),
+			description: "deactivate the user",
+		})
+	} else if !user.Spec.Disabled && state.deactivated {
+		statements = append(statements, userStatement{
+			sql:         fmt.Sprintf("ALTER USER %s ACTIVATE USER NOW", quoted),
+			description: "activate the user",
+		})
+	}
+	return statements
+}
+
+// passwordMatches reports whether the user can log on to the database with the password
+func (r *HanaUserReconciler) passwordMatches(ctx context.Context, endpoint hdbclient.Endpoint, userName, password string) bool {
+	endpoint.User = userName
+	endpoint.Password = password
+	db, err := r.SQL.Open(ctx, endpoint)
+	if err != nil {
+		return false
+	}
+	db.Close()
+	return true
+}
+
+// reconcileUserSecret returns the password of the Secret owned by the HanaUser, generating the
+// Secret with a new password when it does not exist. A Secret with the same name that is not
+// owned by the HanaUser is never overwritten.
+func (r *HanaUserReconciler) reconcileUserSecret(ctx context.Context, user *dbv1alpha1.HanaUser,
+	endpoint hdbclient.Endpoint, database, userName string) (string, error) {
+	name := secretNameForHanaUser(user)
+	data := map[string]string{
+		"username": userName,
+		"host":     endpoint.Host,
+		"port":     strconv.Itoa(int(endpoint.Port)),
+		"database": database,
+	}
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: user.Namespace}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	if apierrors.IsNotFound(err) {
+		password, err := generatePassword()
+		if err != nil {
+			return "", err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: user.Namespace,
+			},
+			Type:       corev1.SecretTypeOpaque,
+			StringData: data,
+		}
+		secret.StringData["password"] = password
+		if err := ctrl.SetControllerReference(user, secret, r.Scheme); err != nil {
+			return "", err
+		}
+		log.FromContext(ctx).Info("Creating the credentials Secret", "Secret.Name", name)
+		if err := r.Create(ctx, secret); err != nil {
+			return "", err
+		}
+		return password, nil
+	}
+
+	if !metav1.IsControlledBy(secret, user) {
+		return "", fmt.Errorf("Secret %s already exists and is not owned by this HanaUser", name)
+	}
+	password := string(secret.Data["password"])
+	if password == "" {
+		return "", fmt.Errorf("Secret %s has no password, delete it to generate a new one", name)
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	changed := false
+	for key, value := range data {
+		if string(secret.Data[key]) != value {
+			secret.Data[key] = []byte(value)
+			changed = true
+		}
+	}
+	if changed {
+		if err := r.Update(ctx, secret); err != nil {
+			return "", err
+		}
+	}
+	return password, nil
+}
+
+// dropUser drops the user created by the HanaUser together with the objects it owns
+func (r *HanaUserReconciler) dropUser(ctx context.Context, hanaExpress *dbv1alpha1.HanaExpress, user *dbv1alpha1.HanaUser) error {
+	db, err := openDatabase(ctx, r.Client, r.SQL, hanaExpress, user.Status.DatabaseName)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	state, err := hanaUserStateFor(ctx, db, user.Status.UserName)
+	if err != nil || state == nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("Dropping database user", "User", user.Status.UserName, "Database", user.Status.DatabaseName)
+	if _, err := hdbclient.ExecOnce(ctx, db, fmt.Sprintf("DROP USER %s CASCADE", hdbclient.QuoteIdentifier(user.Status.UserName))); err != nil {
+		return fmt.Errorf("failed to drop user %s: %w", user.Status.UserName, err)
+	}
+	r.Recorder.Event(user, "Normal", "Dropped",
+		fmt.Sprintf("Dropped user %s in database %s", user.Status.UserName, user.Status.DatabaseName))
+	return nil
+}
+
+// hanaUserStateFor returns the state of the user, nil if it does not exist
+func hanaUserStateFor(ctx context.Context, db *sql.DB, userName string) (*hanaUserState, error) {
+	var deactivated, lifetimeCheck string
+	var usergroup sql.NullString
+	err := db.QueryRowContext(ctx, `SELECT USER_DEACTIVATED, IS_PASSWORD_LIFETIME_CHECK_ENABLED, USERGROUP_NAME
+		FROM SYS.USERS WHERE USER_NAME = ?`, userName).Scan(&deactivated, &lifetimeCheck, &usergroup)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user %s: %w", userName, err)
+	}
+	return &hanaUserState{
+		deactivated:           deactivated == "TRUE",
+		passwordLifetimeCheck: lifetimeCheck == "TRUE",
+		usergroup:             usergroup.String,
+	}, nil
+}
+
+// secretNameForHanaUser returns the name of the Secret holding the credentials of the user
+func secretNameForHanaUser(user *dbv1alpha1.HanaUser) string {
+	if user.Spec.SecretName != "" {
+		return user.Spec.SecretName
+	}
+	return user.Name + "-credentials"
+}
+
+// setUserStatus records the Ready condition and requeues after the given delay
+func (r *HanaUserReconciler) setUserStatus(ctx context.Context, user *dbv1alpha1.HanaUser,
+	status metav1.ConditionStatus, reason, message string, requeueAfter time.Duration) (ctrl.Result, error) {
+	ready := meta.FindStatusCondition(user.Status.Conditions, typeReadyHanaUser)
+	if status == metav1.ConditionFalse && (ready == nil || ready.Reason != reason) &&
+		reason != "HanaExpressUnavailable" {
+		r.Recorder.Event(user, "Warning", reason, message)
+	}
+
+	meta.SetStatusCondition(&user.Status.Conditions, metav1.Condition{Type: typeReadyHanaUser,
+		Status: status, Reason: reason, Message: message})
+	if err := r.Status().Update(ctx, user); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update HanaUser status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *HanaUserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&dbv1alpha1.HanaUser{}).
+		Owns(&corev1.Secret{}, builder.OnlyMetadata).
+		Complete(withReconcileTimeout(r))
+}
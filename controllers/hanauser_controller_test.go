@@ -0,0 +1,354 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+
+	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+)
+
+func TestUserIsCreated(t *testing.T) {
+	hanaExpress, masterSecret := testHanaExpress()
+	user, secret := testUser(t)
+	r, connector := newTestUserReconciler(hanaExpress, masterSecret, user, secret)
+
+	mock := connector.expectSQL(t)
+	expectUserState(mock, nil)
+	mock.ExpectExec(`CREATE USER "APP" PASSWORD "Generated1" NO FORCE_FIRST_PASSWORD_CHANGE`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectUserState(mock, &hanaUserState{passwordLifetimeCheck: true})
+	result, stored := reconcileUser(t, r, mock)
+
+	if ready := userReady(stored); ready == nil || ready.Status != metav1.ConditionTrue {
+		t.Fatalf("Ready condition = %v after the creation", ready)
+	}
+	if stored.Status.UserName != "APP" || stored.Status.DatabaseName != "HXE" || stored.Status.SecretName != secret.Name {
+		t.Errorf("status = %+v, want APP in HXE with Secret %s", stored.Status, secret.Name)
+	}
+	if len(stored.Finalizers) == 0 {
+		t.Error("finalizer not added")
+	}
+	if result.RequeueAfter != 5*time.Minute {
+		t.Errorf("requeue after %s, want the periodic check", result.RequeueAfter)
+	}
+	events := drainEvents(r.Recorder.(*record.FakeRecorder))
+	if !containsEvent(events, "Created") || containsEvent(events, "DriftCorrected") {
+		t.Errorf("events = %v, want only Created", events)
+	}
+}
+
+func TestUserSecret(t *testing.T) {
+	endpoint := hdbclient.Endpoint{Host: "hxe.default.svc", Port: hanaTenantSQLPort}
+
+	t.Run("generated", func(t *testing.T) {
+		user, _ := testUser(t)
+		r, _ := newTestUserReconciler(user)
+		password, err := r.reconcileUserSecret(context.Background(), user, endpoint, "HXE", "APP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		secret := &corev1.Secret{}
+		if err := r.Get(context.Background(), types.NamespacedName{Name: "app-credentials", Namespace: testNamespace}, secret); err != nil {
+			t.Fatal(err)
+		}
+		if password == "" || secret.StringData["password"] != password {
+			t.Errorf("Secret holds password %q, want the generated %q", secret.StringData["password"], password)
+		}
+		if secret.StringData["username"] != "APP" || secret.StringData["port"] != "39017" {
+			t.Errorf("Secret holds %v, want the connection details of APP", secret.StringData)
+		}
+		if !metav1.IsControlledBy(secret, user) {
+			t.Error("Secret not owned by the HanaUser")
+		}
+	})
+
+	t.Run("kept", func(t *testing.T) {
+		user, secret := testUser(t)
+		r, _ := newTestUserReconciler(user, secret)
+		password, err := r.reconcileUserSecret(context.Background(), user, endpoint, "HXE", "APP")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if password != testUserPassword {
+			t.Errorf("password = %q, want the one of the Secret", password)
+		}
+	})
+
+	t.Run("not owned", func(t *testing.T) {
+		user, secret := testUser(t)
+		secret.OwnerReferences = nil
+		r, _ := newTestUserReconciler(user, secret)
+		if _, err := r.reconcileUserSecret(context.Background(), user, endpoint, "HXE", "APP"); err == nil {
+			t.Error("Secret not owned by the HanaUser was taken over")
+		}
+	})
+}
+
+func TestUserIsNotReconciled(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(user *dbv1alpha1.HanaUser)
+		expect func(mock sqlmock.Sqlmock)
+		reason string
+	}{
+		{
+			name: "existing user not created by the resource",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectUserState(mock, &hanaUserState{passwordLifetimeCheck: true})
+			},
+			reason: "AlreadyExists",
+		},
+		{
+			name: "renamed user",
+			modify: func(user *dbv1alpha1.HanaUser) {
+				managedUser(user)
+				user.Spec.UserName = "other"
+			},
+			reason: "UserNameChanged",
+		},
+		{
+			name: "missing instance",
+			modify: func(user *dbv1alpha1.HanaUser) {
+				user.Spec.HanaExpressName = "other"
+			},
+			reason: "HanaExpressNotFound",
+		},
+		{
+			name: "tenant that is not running",
+			modify: func(user *dbv1alpha1.HanaUser) {
+				user.Spec.DatabaseName = "sales"
+			},
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT SQL_PORT FROM SYS_DATABASES.M_SERVICES
+					WHERE DATABASE_NAME = ? AND SERVICE_NAME = 'indexserver' AND SQL_PORT > 0`).
+					WithArgs("SALES").
+					WillReturnRows(sqlmock.NewRows([]string{"SQL_PORT"}))
+			},
+			reason: "DatabaseUnavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, masterSecret := testHanaExpress()
+			user, secret := testUser(t)
+			if tt.modify != nil {
+				tt.modify(user)
+			}
+			r, connector := newTestUserReconciler(hanaExpress, masterSecret, user, secret)
+			mock := connector.expectSQL(t)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			_, stored := reconcileUser(t, r, mock)
+			if ready := userReady(stored); ready == nil || ready.Status != metav1.ConditionFalse || ready.Reason != tt.reason {
+				t.Errorf("Ready condition = %v, want reason %s", ready, tt.reason)
+			}
+			if !containsEvent(drainEvents(r.Recorder.(*record.FakeRecorder)), tt.reason) {
+				t.Errorf("no %s event", tt.reason)
+			}
+		})
+	}
+}
+
+func TestUserDriftIsCorrected(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       func(spec *dbv1alpha1.HanaUserSpec)
+		state      hanaUserState
+		rejected   bool
+		statements []string
+	}{
+		{
+			name:  "user in sync",
+			state: hanaUserState{passwordLifetimeCheck: true},
+		},
+		{
+			name:       "password changed in SQL",
+			state:      hanaUserState{passwordLifetimeCheck: true},
+			rejected:   true,
+			statements: []string{`ALTER USER "APP" PASSWORD "Generated1" NO FORCE_FIRST_PASSWORD_CHANGE`},
+		},
+		{
+			name:       "password lifetime disabled",
+			spec:       func(spec *dbv1alpha1.HanaUserSpec) { spec.PasswordNeverExpires = true },
+			state:      hanaUserState{passwordLifetimeCheck: true},
+			statements: []string{`ALTER USER "APP" DISABLE PASSWORD LIFETIME`},
+		},
+		{
+			name:       "password lifetime enabled",
+			state:      hanaUserState{},
+			statements: []string{`ALTER USER "APP" ENABLE PASSWORD LIFETIME`},
+		},
+		{
+			name:       "usergroup set",
+			spec:       func(spec *dbv1alpha1.HanaUserSpec) { spec.Usergroup = "APPS" },
+			state:      hanaUserState{passwordLifetimeCheck: true, usergroup: "DEFAULT"},
+			statements: []string{`ALTER USER "APP" SET USERGROUP "APPS"`},
+		},
+		{
+			name:       "usergroup unset",
+			state:      hanaUserState{passwordLifetimeCheck: true, usergroup: "APPS"},
+			statements: []string{`ALTER USER "APP" UNSET USERGROUP`},
+		},
+		{
+			name:       "user disabled",
+			spec:       func(spec *dbv1alpha1.HanaUserSpec) { spec.Disabled = true },
+			state:      hanaUserState{passwordLifetimeCheck: true},
+			statements: []string{`ALTER USER "APP" DEACTIVATE USER NOW`},
+		},
+		{
+			// The password of a deactivated user cannot be verified
+			name:       "user enabled",
+			state:      hanaUserState{deactivated: true, passwordLifetimeCheck: true},
+			rejected:   true,
+			statements: []string{`ALTER USER "APP" ACTIVATE USER NOW`},
+		},
+		{
+			name: "several changes",
+			spec: func(spec *dbv1alpha1.HanaUserSpec) {
+				spec.PasswordNeverExpires = true
+				spec.Disabled = true
+			},
+			state:    hanaUserState{passwordLifetimeCheck: true},
+			rejected: true,
+			statements: []string{
+				`ALTER USER "APP" PASSWORD "Generated1" NO FORCE_FIRST_PASSWORD_CHANGE`,
+				`ALTER USER "APP" DISABLE PASSWORD LIFETIME`,
+				`ALTER USER "APP" DEACTIVATE USER NOW`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, masterSecret := testHanaExpress()
+			user, secret := testUser(t)
+			managedUser(user)
+			if tt.spec != nil {
+				tt.spec(&user.Spec)
+			}
+			r, connector := newTestUserReconciler(hanaExpress, masterSecret, user, secret)
+
+			mock := connector.expectSQL(t)
+			state := tt.state
+			expectUserState(mock, &state)
+			if tt.rejected {
+				connector.rejected = []string{testUserPassword}
+			} else if !tt.state.deactivated {
+				// The logon that verifies the password
+				connector.expectSQL(t)
+			}
+			for _, stmt := range tt.statements {
+				mock.ExpectExec(stmt).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			_, stored := reconcileUser(t, r, mock)
+
+			if ready := userReady(stored); ready == nil || ready.Status != metav1.ConditionTrue {
+				t.Errorf("Ready condition = %v", ready)
+			}
+			if corrected := containsEvent(drainEvents(r.Recorder.(*record.FakeRecorder)), "DriftCorrected"); corrected != (len(tt.statements) > 0) {
+				t.Errorf("DriftCorrected event = %t for %d statements", corrected, len(tt.statements))
+			}
+		})
+	}
+}
+
+func TestUserDeletion(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(user *dbv1alpha1.HanaUser)
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name: "existing user",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectUserState(mock, &hanaUserState{passwordLifetimeCheck: true})
+				mock.ExpectExec(`DROP USER "APP" CASCADE`).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
+		{
+			name: "user already dropped",
+			expect: func(mock sqlmock.Sqlmock) {
+				expectUserState(mock, nil)
+			},
+		},
+		{
+			name: "user never created",
+			modify: func(user *dbv1alpha1.HanaUser) {
+				user.Status.UserName = ""
+			},
+		},
+		{
+			name: "instance deleted",
+			modify: func(user *dbv1alpha1.HanaUser) {
+				user.Spec.HanaExpressName = "other"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hanaExpress, masterSecret := testHanaExpress()
+			user, secret := testUser(t)
+			managedUser(user)
+			user.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+			if tt.modify != nil {
+				tt.modify(user)
+			}
+			r, connector := newTestUserReconciler(hanaExpress, masterSecret, user, secret)
+			mock := connector.expectSQL(t)
+			if tt.expect != nil {
+				tt.expect(mock)
+			}
+
+			if _, stored := reconcileUser(t, r, mock); stored != nil {
+				t.Errorf("finalizers = %v, want the resource to be gone", stored.Finalizers)
+			}
+		})
+	}
+}
+
+func TestUserDeletionIsRetried(t *testing.T) {
+	hanaExpress, masterSecret := testHanaExpress()
+	user, secret := testUser(t)
+	managedUser(user)
+	user.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	r, connector := newTestUserReconciler(hanaExpress, masterSecret, user, secret)
+	mock := connector.expectSQL(t)
+	expectUserState(mock, &hanaUserState{passwordLifetimeCheck: true})
+	mock.ExpectExec(`DROP USER "APP" CASCADE`).WillReturnError(sqlError{code: 258})
+
+	result, stored := reconcileUser(t, r, mock)
+	if stored == nil || len(stored.Finalizers) == 0 {
+		t.Fatal("finalizer removed although the user was not dropped")
+	}
+	if result.RequeueAfter == 0 {
+		t.Error("deletion not retried")
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+const (
+	// generatedPasswordLength is the length of the passwords the operator generates
+	generatedPasswordLength = 24
+
+	passwordUpper  = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+	passwordLower  = "abcdefghijkmnopqrstuvwxyz"
+	passwordDigits = "23456789"
+)
+
+// generatePassword returns a random password that satisfies the default HANA password
+// policy: at least 8 characters with upper case letters, lower case letters and digits.
+// Only letters and digits are used so the password needs no escaping in clients or URLs.
+func generatePassword() (string, error) {
+	classes := []string{passwordUpper, passwordLower, passwordDigits}
+	all := passwordUpper + passwordLower + passwordDigits
+
+	password := make([]byte, generatedPasswordLength)
+	for i := range password {
+		// The first characters cover every required class, the rest is drawn from all of them
+		charset := all
+		if i < len(classes) {
+			charset = classes[i]
+		}
+		c, err := randomChar(charset)
+		if err != nil {
+			return "", err
+		}
+		password[i] = c
+	}
+
+	// Shuffle so the required classes are not always at the start
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	return string(password), nil
+}
+
+// randomChar returns a uniformly chosen character of the charset
+func randomChar(charset string) (byte, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+	if err != nil {
+		return 0, err
+	}
+	return charset[n.Int64()], nil
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratePassword(t *testing.T) {
+	const runs = 1000
+	classes := []string{passwordUpper, passwordLower, passwordDigits}
+	all := passwordUpper + passwordLower + passwordDigits
+
+	unshuffled := 0
+	seen := map[string]bool{}
+	for i := 0; i < runs; i++ {
+		password, err := generatePassword()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(password) != generatedPasswordLength {
+			t.Fatalf("password %q has %d characters, want %d", password, len(password), generatedPasswordLength)
+		}
+		if i := strings.IndexFunc(password, func(c rune) bool { return !strings.ContainsRune(all, c) }); i >= 0 {
+			t.Fatalf("password %q contains %q, want letters and digits only", password, password[i])
+		}
+		for _, class := range classes {
+			if !strings.ContainsAny(password, class) {
+				t.Fatalf("password %q has no character of %q", password, class)
+			}
+		}
+		if seen[password] {
+			t.Fatalf("password %q was generated twice", password)
+		}
+		seen[password] = true
+
+		inOrder := true
+		for j, class := range classes {
+			inOrder = inOrder && strings.IndexByte(class, password[j]) >= 0
+		}
+		if inOrder {
+			unshuffled++
+		}
+	}
+
+	// About 3% of the shuffled passwords start with an upper case letter, a lower case letter and a digit
+	if unshuffled > runs/10 {
+		t.Errorf("%d of %d passwords start with the required classes, want them shuffled", unshuffled, runs)
+	}
+}
@@ -19,20 +19,29 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	snapshotv1 "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	dbv1alpha1 "github.com/redhat-sap/sap-hana-express-operator/api/v1alpha1"
+	dbv1beta1 "github.com/redhat-sap/sap-hana-express-operator/api/v1beta1"
 	"github.com/redhat-sap/sap-hana-express-operator/controllers"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/hdbclient"
+	"github.com/redhat-sap/sap-hana-express-operator/pkg/podexec"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,6 +54,8 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(dbv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(snapshotv1.AddToScheme(scheme))
+	utilruntime.Must(dbv1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -72,6 +83,13 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "58327bdb.sap-redhat.io",
+		// Only the pods of the HanaExpress instances are cached
+		Cache: cache.Options{ByObject: map[client.Object]cache.ByObject{
+			&corev1.Pod{}: {Label: labels.SelectorFromSet(labels.Set{"app.kubernetes.io/name": "HanaExpress"})},
+		}},
+		// Secrets are read from the API server and only watched by their metadata, so the
+		// cache never holds the data of the Secrets in the cluster
+		Client: client.Options{Cache: &client.CacheOptions{DisableFor: []client.Object{&corev1.Secret{}}}},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -93,10 +111,92 @@ func main() {
 		Client:   mgr.GetClient(),
 		Scheme:   mgr.GetScheme(),
 		Recorder: mgr.GetEventRecorderFor("hana-express-operator"),
+		SQL:      hdbclient.HDBConnector{},
+		Health:   hdbclient.SQLHealthChecker{Connector: hdbclient.HDBConnector{IOTimeout: 10 * time.Second}},
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HanaExpress")
 		os.Exit(1)
 	}
+	executor, err := podexec.NewRemoteExecutor(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create pod executor")
+		os.Exit(1)
+	}
+	if err = (&controllers.HanaExpressBackupReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hana-express-backup-controller"),
+		SQL:      hdbclient.HDBConnector{},
+		Exec:     executor,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HanaExpressBackup")
+		os.Exit(1)
+	}
+	if err = (&controllers.HanaExpressRestoreReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor("hana-express-restore-controller"),
+		SQL:       hdbclient.HDBConnector{},
+		APIReader: mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HanaExpressRestore")
+		os.Exit(1)
+	}
+	if err = (&controllers.HanaExpressBackupScheduleReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hana-express-backup-schedule-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HanaExpressBackupSchedule")
+		os.Exit(1)
+	}
+	if err = (&controllers.HanaTenantDatabaseReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hana-tenant-database-controller"),
+		SQL:      hdbclient.HDBConnector{},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HanaTenantDatabase")
+		os.Exit(1)
+	}
+	if err = (&controllers.HanaUserReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hana-user-controller"),
+		SQL:      hdbclient.HDBConnector{},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HanaUser")
+		os.Exit(1)
+	}
+	if err = (&controllers.HanaRoleReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hana-role-controller"),
+		SQL:      hdbclient.HDBConnector{},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HanaRole")
+		os.Exit(1)
+	}
+	if err = (&controllers.HanaGrantReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hana-grant-controller"),
+		SQL:      hdbclient.HDBConnector{},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HanaGrant")
+		os.Exit(1)
+	}
+	// Webhooks need serving certificates, set ENABLE_WEBHOOKS=false to run the manager locally without them
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&dbv1alpha1.HanaExpress{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "HanaExpress")
+			os.Exit(1)
+		}
+		if err = (&dbv1beta1.HanaExpress{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "HanaExpress")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,121 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hdbclient provides the SQL access the operator uses to administer
+// SAP HANA Express databases.
+package hdbclient
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SAP/go-hdb/driver"
+)
+
+// Endpoint describes a HANA SQL port and the user to log on with
+type Endpoint struct {
+	Host     string
+	Port     int32
+	User     string
+	Password string
+}
+
+// Address returns the host:port form of the endpoint
+func (e Endpoint) Address() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(int(e.Port)))
+}
+
+// Connector opens SQL connections to a HANA database. Reconcilers depend on this
+// interface rather than on the driver so that they can run against a fake SQL
+// endpoint in tests.
+type Connector interface {
+	Open(ctx context.Context, endpoint Endpoint) (*sql.DB, error)
+}
+
+const (
+	// defaultConnectTimeout bounds the logon when HDBConnector.ConnectTimeout is not set
+	defaultConnectTimeout = 10 * time.Second
+	// defaultIOTimeout is the read and write deadline when HDBConnector.IOTimeout is not set.
+	// BACKUP DATA and RECOVER DATA answer only when they are done, so it has to outlast them.
+	defaultIOTimeout = 24 * time.Hour
+)
+
+// HDBConnector is the Connector backed by the go-hdb driver
+type HDBConnector struct {
+	// ConnectTimeout bounds establishing the connection and the logon. Defaults to 10 seconds.
+	ConnectTimeout time.Duration
+	// IOTimeout is the deadline the driver sets on every read and write of the connection.
+	// A statement that does not answer within it fails with driver.ErrBadConn, which makes
+	// database/sql run it again on a new connection. Defaults to 24 hours, only connections
+	// that never run long statements, like health checks, should set it short.
+	IOTimeout time.Duration
+}
+
+// Open connects to the endpoint and verifies the logon with a ping
+func (c HDBConnector) Open(ctx context.Context, endpoint Endpoint) (*sql.DB, error) {
+	connector := driver.NewBasicAuthConnector(endpoint.Address(), endpoint.User, endpoint.Password)
+
+	ioTimeout := c.IOTimeout
+	if ioTimeout == 0 {
+		ioTimeout = defaultIOTimeout
+	}
+	if err := connector.SetTimeout(int(ioTimeout.Seconds())); err != nil {
+		return nil, err
+	}
+
+	connectTimeout := c.ConnectTimeout
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+	defer cancel()
+
+	db := sql.OpenDB(connector)
+	if err := db.PingContext(pingCtx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s as %s: %w", endpoint.Address(), endpoint.User, err)
+	}
+	return db, nil
+}
+
+// ExecOnce runs a statement on a dedicated connection of the pool. Unlike
+// sql.DB.ExecContext, which runs a statement again on another connection when the driver
+// reports driver.ErrBadConn, the statement is sent at most once. Statements that must not
+// be repeated, like BACKUP DATA, RECOVER DATA or CREATE DATABASE, use it.
+func ExecOnce(ctx context.Context, db *sql.DB, query string, args ...any) (sql.Result, error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ExecContext(ctx, query, args...)
+}
+
+// QuoteIdentifier returns name as a delimited SQL identifier. HANA also expects
+// passwords in ALTER USER and CREATE USER statements to be quoted this way.
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QuoteString returns s as a SQL string literal
+func QuoteString(s string) string {
+	return `'` + strings.ReplaceAll(s, `'`, `''`) + `'`
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hdbclient
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const backupStatement = "BACKUP DATA FOR FULL SYSTEM USING FILE ('nightly')"
+
+func TestExecOnceDoesNotRetryBadConnections(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// database/sql would run the statement again on another connection after ErrBadConn,
+	// sqlmock then fails the second run as unexpected
+	mock.ExpectExec(backupStatement).WillReturnError(driver.ErrBadConn)
+
+	_, err = ExecOnce(context.Background(), db, backupStatement)
+	if !errors.Is(err, driver.ErrBadConn) {
+		t.Errorf("err = %v, want %v", err, driver.ErrBadConn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExecOnce(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("CREATE DATABASE \"DEV\" SYSTEM USER PASSWORD \"secret\"").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if _, err := ExecOnce(context.Background(), db, "CREATE DATABASE \"DEV\" SYSTEM USER PASSWORD \"secret\""); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got, want := QuoteIdentifier(`a"b`), `"a""b"`; got != want {
+		t.Errorf("QuoteIdentifier = %s, want %s", got, want)
+	}
+	if got, want := QuoteString(`it's`), `'it''s'`; got != want {
+		t.Errorf("QuoteString = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hdbclient
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// healthCheckTimeout bounds a health check including the logon
+const healthCheckTimeout = 30 * time.Second
+
+// HealthReason classifies the result of a health check
+type HealthReason string
+
+const (
+	// HealthReasonHealthy means the SystemDB and the tenant serve SQL
+	HealthReasonHealthy HealthReason = "Healthy"
+	// HealthReasonStartingUp means a required service or the tenant is still starting
+	HealthReasonStartingUp HealthReason = "StartingUp"
+	// HealthReasonServiceDown means the nameserver or the indexserver of the tenant is not active
+	HealthReasonServiceDown HealthReason = "ServiceDown"
+	// HealthReasonTenantDown means the tenant database is stopped or does not exist
+	HealthReasonTenantDown HealthReason = "TenantDown"
+	// HealthReasonAuthenticationFailed means the SystemDB rejected the logon
+	HealthReasonAuthenticationFailed HealthReason = "AuthenticationFailed"
+	// HealthReasonUnreachable means no SQL connection to the SystemDB could be established
+	HealthReasonUnreachable HealthReason = "Unreachable"
+	// HealthReasonCheckFailed means the monitoring views could not be queried
+	HealthReasonCheckFailed HealthReason = "CheckFailed"
+)
+
+// authenticationErrorCodes are the HANA error codes of rejected logons: authentication
+// failed, user is forced to change password and user is locked
+var authenticationErrorCodes = map[int]bool{10: true, 414: true, 416: true}
+
+// Health is the result of a health check
+type Health struct {
+	// Reason classifies the health of the system
+	Reason HealthReason
+	// Message describes the health for humans
+	Message string
+	// Version is the version reported by the SystemDB. It is only set for a healthy system
+	Version string
+}
+
+// Healthy reports whether the SystemDB and the tenant serve SQL
+func (h Health) Healthy() bool {
+	return h.Reason == HealthReasonHealthy
+}
+
+// HealthChecker checks whether a HANA system and one of its tenants serve SQL. Reconcilers
+// depend on this interface so that the check can be replaced in tests.
+type HealthChecker interface {
+	Check(ctx context.Context, endpoint Endpoint, tenant string) Health
+}
+
+// SQLHealthChecker is the HealthChecker that logs on to the SystemDB and reads the state of
+// the services and the tenant from the monitoring views
+type SQLHealthChecker struct {
+	Connector Connector
+}
+
+// Check logs on to the SystemDB endpoint and checks that its nameserver, the tenant and the
+// indexserver of the tenant are active
+func (c SQLHealthChecker) Check(ctx context.Context, endpoint Endpoint, tenant string) Health {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	db, err := c.Connector.Open(ctx, endpoint)
+	if err != nil {
+		var hdbErr interface{ Code() int }
+		if errors.As(err, &hdbErr) && authenticationErrorCodes[hdbErr.Code()] {
+			return Health{Reason: HealthReasonAuthenticationFailed,
+				Message: fmt.Sprintf("The SystemDB rejected the logon of %s: %s", endpoint.User, err)}
+		}
+		return Health{Reason: HealthReasonUnreachable, Message: err.Error()}
+	}
+	defer db.Close()
+
+	return checkHealth(ctx, db, tenant)
+}
+
+// checkHealth reads the state of the services and of the tenant through a SystemDB connection
+func checkHealth(ctx context.Context, db *sql.DB, tenant string) Health {
+	services, err := activeStatusOfServices(ctx, db)
+	if err != nil {
+		return Health{Reason: HealthReasonCheckFailed, Message: err.Error()}
+	}
+	if health, ok := serviceHealth(services, "SYSTEMDB", "nameserver"); !ok {
+		return health
+	}
+
+	var tenantStatus string
+	err = db.QueryRowContext(ctx, "SELECT ACTIVE_STATUS FROM SYS.M_DATABASES WHERE DATABASE_NAME = ?",
+		tenant).Scan(&tenantStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Health{Reason: HealthReasonTenantDown, Message: fmt.Sprintf("Tenant %s does not exist", tenant)}
+	}
+	if err != nil {
+		return Health{Reason: HealthReasonCheckFailed, Message: fmt.Sprintf("failed to query the state of tenant %s: %s", tenant, err)}
+	}
+	switch tenantStatus {
+	case "YES":
+	case "STARTING":
+		return Health{Reason: HealthReasonStartingUp, Message: fmt.Sprintf("Tenant %s is starting", tenant)}
+	default:
+		return Health{Reason: HealthReasonTenantDown,
+			Message: fmt.Sprintf("Tenant %s is not active, its status is %s", tenant, tenantStatus)}
+	}
+	if health, ok := serviceHealth(services, tenant, "indexserver"); !ok {
+		return health
+	}
+
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT VERSION FROM SYS.M_DATABASE").Scan(&version); err != nil {
+		return Health{Reason: HealthReasonCheckFailed, Message: fmt.Sprintf("failed to query the database version: %s", err)}
+	}
+	return Health{Reason: HealthReasonHealthy, Message: fmt.Sprintf("Tenant %s is online", tenant), Version: version}
+}
+
+// activeStatusOfServices returns the ACTIVE_STATUS of the nameservers and indexservers of all
+// databases, keyed by database and service name
+func activeStatusOfServices(ctx context.Context, db *sql.DB) (map[[2]string]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT DATABASE_NAME, SERVICE_NAME, ACTIVE_STATUS FROM SYS_DATABASES.M_SERVICES "+
+		"WHERE SERVICE_NAME IN ('nameserver', 'indexserver')")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the services: %w", err)
+	}
+	defer rows.Close()
+
+	services := map[[2]string]string{}
+	for rows.Next() {
+		var database, service, status string
+		if err := rows.Scan(&database, &service, &status); err != nil {
+			return nil, fmt.Errorf("failed to read the services: %w", err)
+		}
+		services[[2]string{database, service}] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the services: %w", err)
+	}
+	return services, nil
+}
+
+// serviceHealth returns the health of a system whose service of the database is not active
+// and false, or true when it is active
+func serviceHealth(services map[[2]string]string, database, service string) (Health, bool) {
+	switch status, ok := services[[2]string{database, service}]; {
+	case status == "YES":
+		return Health{}, true
+	case status == "STARTING":
+		return Health{Reason: HealthReasonStartingUp, Message: fmt.Sprintf("The %s of %s is starting", service, database)}, false
+	case !ok:
+		return Health{Reason: HealthReasonServiceDown, Message: fmt.Sprintf("The %s of %s is not running", service, database)}, false
+	default:
+		return Health{Reason: HealthReasonServiceDown,
+			Message: fmt.Sprintf("The %s of %s is not active, its status is %s", service, database, status)}, false
+	}
+}
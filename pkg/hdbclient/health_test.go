@@ -0,0 +1,189 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hdbclient
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	servicesQuery = "SELECT DATABASE_NAME, SERVICE_NAME, ACTIVE_STATUS FROM SYS_DATABASES.M_SERVICES " +
+		"WHERE SERVICE_NAME IN ('nameserver', 'indexserver')"
+	tenantQuery  = "SELECT ACTIVE_STATUS FROM SYS.M_DATABASES WHERE DATABASE_NAME = ?"
+	versionQuery = "SELECT VERSION FROM SYS.M_DATABASE"
+)
+
+// fakeConnector hands out a sqlmock connection or fails with err
+type fakeConnector struct {
+	db  *sql.DB
+	err error
+}
+
+func (c fakeConnector) Open(ctx context.Context, endpoint Endpoint) (*sql.DB, error) {
+	return c.db, c.err
+}
+
+// hdbError mimics the errors of the go-hdb driver carrying a HANA error code
+type hdbError struct {
+	code int
+}
+
+func (e hdbError) Error() string { return fmt.Sprintf("SQL Error %d", e.code) }
+func (e hdbError) Code() int     { return e.code }
+
+func services(nameserver, indexserver string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"DATABASE_NAME", "SERVICE_NAME", "ACTIVE_STATUS"}).
+		AddRow("SYSTEMDB", "nameserver", nameserver)
+	if indexserver != "" {
+		rows.AddRow("HXE", "indexserver", indexserver)
+	}
+	return rows
+}
+
+func TestSQLHealthChecker(t *testing.T) {
+	tests := []struct {
+		name    string
+		openErr error
+		expect  func(mock sqlmock.Sqlmock)
+		reason  HealthReason
+		version string
+	}{
+		{
+			name: "healthy",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(servicesQuery).WillReturnRows(services("YES", "YES"))
+				mock.ExpectQuery(tenantQuery).WithArgs("HXE").
+					WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}).AddRow("YES"))
+				mock.ExpectQuery(versionQuery).
+					WillReturnRows(sqlmock.NewRows([]string{"VERSION"}).AddRow("2.00.072.00.1690304772"))
+			},
+			reason:  HealthReasonHealthy,
+			version: "2.00.072.00.1690304772",
+		},
+		{
+			name: "nameserver starting",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(servicesQuery).WillReturnRows(services("STARTING", ""))
+			},
+			reason: HealthReasonStartingUp,
+		},
+		{
+			name: "nameserver stopped",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(servicesQuery).WillReturnRows(services("NO", ""))
+			},
+			reason: HealthReasonServiceDown,
+		},
+		{
+			name: "tenant starting",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(servicesQuery).WillReturnRows(services("YES", "STARTING"))
+				mock.ExpectQuery(tenantQuery).WithArgs("HXE").
+					WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}).AddRow("STARTING"))
+			},
+			reason: HealthReasonStartingUp,
+		},
+		{
+			name: "tenant stopped",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(servicesQuery).WillReturnRows(services("YES", ""))
+				mock.ExpectQuery(tenantQuery).WithArgs("HXE").
+					WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}).AddRow("NO"))
+			},
+			reason: HealthReasonTenantDown,
+		},
+		{
+			name: "tenant missing",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(servicesQuery).WillReturnRows(services("YES", ""))
+				mock.ExpectQuery(tenantQuery).WithArgs("HXE").
+					WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}))
+			},
+			reason: HealthReasonTenantDown,
+		},
+		{
+			name: "indexserver of the tenant stopped",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(servicesQuery).WillReturnRows(services("YES", "NO"))
+				mock.ExpectQuery(tenantQuery).WithArgs("HXE").
+					WillReturnRows(sqlmock.NewRows([]string{"ACTIVE_STATUS"}).AddRow("YES"))
+			},
+			reason: HealthReasonServiceDown,
+		},
+		{
+			name: "monitoring view not readable",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(servicesQuery).WillReturnError(errors.New("insufficient privilege"))
+			},
+			reason: HealthReasonCheckFailed,
+		},
+		{
+			name:    "authentication failed",
+			openErr: fmt.Errorf("failed to connect to hxe:39013 as SYSTEM: %w", hdbError{code: 10}),
+			reason:  HealthReasonAuthenticationFailed,
+		},
+		{
+			name:    "user locked",
+			openErr: fmt.Errorf("failed to connect to hxe:39013 as SYSTEM: %w", hdbError{code: 416}),
+			reason:  HealthReasonAuthenticationFailed,
+		},
+		{
+			name:    "connection refused",
+			openErr: errors.New("dial tcp 10.0.0.1:39013: connect: connection refused"),
+			reason:  HealthReasonUnreachable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			connector := fakeConnector{err: tt.openErr}
+			var mock sqlmock.Sqlmock
+			if tt.openErr == nil {
+				db, m, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+				if err != nil {
+					t.Fatal(err)
+				}
+				connector.db, mock = db, m
+				tt.expect(mock)
+				mock.ExpectClose()
+			}
+
+			health := SQLHealthChecker{Connector: connector}.Check(context.Background(),
+				Endpoint{Host: "hxe", Port: 39013, User: "SYSTEM", Password: "secret"}, "HXE")
+			if health.Reason != tt.reason {
+				t.Errorf("reason = %s (%s), want %s", health.Reason, health.Message, tt.reason)
+			}
+			if health.Version != tt.version {
+				t.Errorf("version = %q, want %q", health.Version, tt.version)
+			}
+			if health.Healthy() != (tt.reason == HealthReasonHealthy) {
+				t.Errorf("healthy = %t for reason %s", health.Healthy(), health.Reason)
+			}
+			if mock != nil {
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}